@@ -7,6 +7,7 @@ import (
 	"gh-webhook/pkg/core"
 	"gh-webhook/pkg/model"
 	"gh-webhook/pkg/route"
+	"gh-webhook/pkg/secret"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/driver/sqlite"
@@ -70,6 +71,15 @@ func main() {
 		log.Panic(err)
 	}
 
+	keyring, err := secret.NewKeyringFromConfig(&cfg.Secret)
+	if err != nil {
+		log.Panic(err)
+	}
+	if keyring == nil {
+		log.Warning("secret keys are not configured, receiver secrets are stored in plaintext")
+	}
+	secret.SetKeyring(keyring)
+
 	db, err := gorm.Open(sqlite.Open(cfg.DBDsn), &gorm.Config{})
 	if err != nil {
 		log.Panic("failed to connect database")
@@ -80,9 +90,10 @@ func main() {
 	}
 	r := gin.Default()
 	ctx := &core.GHPRContext{
-		Gin: r,
-		Db:  db,
-		Cfg: cfg,
+		Gin:     r,
+		Db:      db,
+		Cfg:     cfg,
+		Secrets: secret.NewResolverFromConfig(&cfg.Secret),
 	}
 
 	err = route.Init(ctx)
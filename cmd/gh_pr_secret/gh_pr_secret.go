@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gh-webhook/pkg/config"
+	"gh-webhook/pkg/model"
+	"gh-webhook/pkg/secret"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"strings"
+)
+
+// re-encrypt the receiver secrets by the primary key after key rotation, secret values are never printed
+func main() {
+	var configFile = flag.String("c", "", "The config file")
+	var dryRun = flag.Bool("dry-run", false, "Only report the secrets to re-encrypt")
+	flag.Parse()
+
+	cfg, err := config.Init(*configFile)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	keyring, err := secret.NewKeyringFromConfig(&cfg.Secret)
+	if err != nil {
+		log.Panic(err)
+	}
+	if keyring == nil {
+		log.Panic("secret keys are not configured")
+	}
+	secret.SetKeyring(keyring)
+
+	db, err := gorm.Open(sqlite.Open(cfg.DBDsn), &gorm.Config{})
+	if err != nil {
+		log.Panic("failed to connect database")
+	}
+
+	// the schema is migrated by gh_pr, this tool never changes it, not even with --dry-run
+	if err = model.CheckSchema(db); err != nil {
+		log.Panicf("%v, start gh_pr to migrate the db first", err)
+	}
+
+	results, err := model.ReEncryptReceiverSecrets(db, keyring, *dryRun)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	action := "re-encrypted"
+	if *dryRun {
+		action = "to re-encrypt"
+	}
+	for _, result := range results {
+		fmt.Printf("receiver %d: %s %s from %s to %s\n", result.ReceiverID, action,
+			strings.Join(result.Fields, ","), strings.Join(result.FromKeys, ","), result.ToKey)
+	}
+	fmt.Printf("%d receivers %s with key %s\n", len(results), action, keyring.Primary())
+}
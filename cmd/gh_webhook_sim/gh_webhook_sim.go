@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gh-webhook/pkg/fixture"
+	"gh-webhook/pkg/sim"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// send GitHub webhook requests to a running gh-webhook, the bundled fixtures or the exported events are replayed
+// once, or sent in turn at a rate for load testing, e.g.
+//
+//	gh_webhook_sim -fixtures push,pull_request -rate 50 -count 3000
+//	gh_webhook_sim -export events.json -speed 60
+func main() {
+	var url = flag.String("url", "http://localhost:8080/api/gh-webhook", "The webhook url of gh-webhook")
+	var host = flag.String("host", "api.github.com", "The Host header, the API of the GitHub server")
+	var secret = flag.String("secret", "", "The webhook secret to sign X-Hub-Signature-256, not signed if empty")
+	var hookId = flag.String("hook-id", "1", "The X-GitHub-Hook-ID of the deliveries without one")
+	var fixtures = flag.String("fixtures", "", fmt.Sprintf("Comma separated fixtures to send, any of %s",
+		strings.Join(fixture.Names(), ",")))
+	var export = flag.String("export", "", "The events exported by GET gh-webhook-event to replay")
+	var speed = flag.Float64("speed", 1, "Replay at the original timing accelerated by speed, 0 as fast as possible")
+	var rate = flag.Float64("rate", 0, "Send count events per second in turn instead of replaying them once")
+	var count = flag.Int("count", 100, "The number of events sent at rate")
+	var workers = flag.Int("workers", 0, "The concurrent requests, default 1 for replay to keep the order, 16 at rate")
+	var timeout = flag.Duration("timeout", 10*time.Second, "The request timeout")
+	flag.Parse()
+
+	if (len(*fixtures) > 0) == (len(*export) > 0) {
+		log.Fatal("either -fixtures or -export is required")
+	}
+	var deliveries []sim.Delivery
+	var err error
+	if len(*fixtures) > 0 {
+		deliveries, err = sim.LoadFixtures(strings.Split(*fixtures, ","))
+	} else {
+		deliveries, err = sim.LoadExport(*export)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(deliveries) == 0 {
+		log.Fatal("no event to send")
+	}
+
+	sender := &sim.Sender{
+		URL:    *url,
+		Host:   *host,
+		Secret: *secret,
+		HookId: *hookId,
+		Client: &http.Client{Timeout: *timeout},
+	}
+	var stats *sim.Stats
+	if *rate > 0 {
+		if *workers <= 0 {
+			*workers = 16
+		}
+		log.Infof("sending %d events at %.2f/s to %s", *count, *rate, *url)
+		stats = sim.Load(sender, deliveries, *rate, *count, *workers)
+	} else {
+		log.Infof("replaying %d events at speed %.2f to %s", len(deliveries), *speed, *url)
+		stats = sim.Replay(sender, deliveries, *speed, *workers)
+	}
+	fmt.Println(stats)
+}
@@ -5,14 +5,74 @@ import (
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
 	"os"
+	"time"
 )
 
 type Config struct {
-	DBType     string `yaml:"db-type"`
-	DBDsn      string `yaml:"db-dsn"`
-	ListenAddr string `yaml:"listen-addr"`
-	APIUrl     string `yaml:"api-url"`
-	APIPrefix  string `yaml:"api-prefix"`
+	DBType     string       `yaml:"db-type"`
+	DBDsn      string       `yaml:"db-dsn"`
+	ListenAddr string       `yaml:"listen-addr"`
+	APIUrl     string       `yaml:"api-url"`
+	APIPrefix  string       `yaml:"api-prefix"`
+	Secret     SecretConfig `yaml:"secret"`
+
+	// how long the match decisions of the subscribes are kept, default 7 days
+	MatchRetention time.Duration `yaml:"match-retention"`
+}
+
+const defaultMatchRetention = 7 * 24 * time.Hour
+
+func (c *Config) GetMatchRetention() time.Duration {
+	if c.MatchRetention <= 0 {
+		return defaultMatchRetention
+	}
+	return c.MatchRetention
+}
+
+// SecretConfig keys to encrypt receiver secrets, keys are base64 encoded 32 bytes AES keys
+type SecretConfig struct {
+	Primary  string            `yaml:"primary"`   // key id to encrypt new secrets
+	Keys     map[string]string `yaml:"keys"`      // key id to key, old keys are kept to decrypt
+	KeyFile  string            `yaml:"key-file"`  // optional yaml file with primary and keys
+	CacheTTL time.Duration     `yaml:"cache-ttl"` // how long resolved secret references are cached
+	EnvAllow []string          `yaml:"env-allow"` // env var patterns env: references can read, empty disables env:
+	FileDirs []string          `yaml:"file-dirs"` // dirs file: references can read, empty disables file:
+	Vault    VaultConfig       `yaml:"vault"`     // empty addr disables vault:
+}
+
+// VaultConfig HashiCorp Vault server to resolve vault: references from KV v2 engines
+type VaultConfig struct {
+	Addr      string `yaml:"addr"`
+	Token     string `yaml:"token"`
+	TokenFile string `yaml:"token-file"` // read on every request, so the token can be renewed outside
+	Namespace string `yaml:"namespace"`
+}
+
+// LoadKeyFile merge the primary and keys from the key file
+func (c *SecretConfig) LoadKeyFile() error {
+	if len(c.KeyFile) == 0 {
+		return nil
+	}
+	fi, err := os.Open(c.KeyFile)
+	if err != nil {
+		return err
+	}
+	defer fi.Close()
+
+	keyFile := SecretConfig{}
+	if err = yaml.NewDecoder(fi).Decode(&keyFile); err != nil {
+		return fmt.Errorf("failed to parse key file %s: %v", c.KeyFile, err)
+	}
+	if len(keyFile.Primary) > 0 {
+		c.Primary = keyFile.Primary
+	}
+	if c.Keys == nil {
+		c.Keys = map[string]string{}
+	}
+	for id, key := range keyFile.Keys {
+		c.Keys[id] = key
+	}
+	return nil
 }
 
 func Init(file string) (*Config, error) {
@@ -33,6 +93,9 @@ func Init(file string) (*Config, error) {
 	if config.DBType != "sqlite3" {
 		return nil, fmt.Errorf("unsupported db type: %s", config.DBType)
 	}
+	if err = config.Secret.LoadKeyFile(); err != nil {
+		return nil, err
+	}
 
 	return &config, nil
 }
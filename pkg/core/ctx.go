@@ -2,12 +2,14 @@ package core
 
 import (
 	"gh-webhook/pkg/config"
+	"gh-webhook/pkg/secret"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type GHPRContext struct {
-	Gin *gin.Engine
-	Db  *gorm.DB
-	Cfg *config.Config
+	Gin     *gin.Engine
+	Db      *gorm.DB
+	Cfg     *config.Config
+	Secrets *secret.Resolver
 }
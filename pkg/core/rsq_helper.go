@@ -6,11 +6,13 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/rbicker/go-rsql"
 	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm/schema"
 	"net/url"
 	"reflect"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var OperationMap = map[string]string{
@@ -28,8 +30,12 @@ type FieldQuery struct {
 	AllowSort   bool
 	AllowFilter bool
 	Kind        reflect.Kind
+	Column      string // db column of the field
+	IsTime      bool   // time.Time field, the value is RFC 3339
 }
 
+var timeType = reflect.TypeOf(time.Time{})
+
 type RSQLHelper struct {
 	FilterSQL   string
 	Arguments   []interface{}
@@ -100,6 +106,12 @@ func (r *RSQLHelper) parseRSQL(query map[string]interface{}) (sql string, args [
 		return "", nil, err
 	} else {
 		q := strings.Join(sqls, " ")
+		if _, isGroup := query["$and"]; !isGroup {
+			if _, isGroup = query["$or"]; !isGroup {
+				// a single condition isn't in parentheses
+				return q, args, nil
+			}
+		}
 
 		return q[1 : len(q)-1], args, nil
 	}
@@ -150,12 +162,12 @@ func (r *RSQLHelper) _parseRSQL(query map[string]interface{}) ([]string, []inter
 		return []string{fmt.Sprintf("(%s)", strings.Join(sqls, op))}, args, nil
 
 	} else {
-		sqls := []string{key}
 		if !r.allowFilterField(key) {
 			return nil, nil, fmt.Errorf("field %s is not allowed to filter", key)
 		}
 
 		fieldDef := r.FieldsQuery[key]
+		sqls := []string{fieldDef.Column}
 		switch v := q.(type) {
 		case map[string]interface{}:
 			opVal := reflect.ValueOf(v).MapKeys()
@@ -216,9 +228,9 @@ func (r *RSQLHelper) parseSort(sort string) error {
 			if dir != "asc" && dir != "desc" {
 				return fmt.Errorf("rsql: invalid sort %s", v)
 			}
-			orders = append(orders, fmt.Sprintf("%s %s", field, dir))
+			orders = append(orders, fmt.Sprintf("%s %s", r.FieldsQuery[field].Column, dir))
 		} else {
-			orders = append(orders, field)
+			orders = append(orders, r.FieldsQuery[field].Column)
 		}
 	}
 	if len(orders) == 0 {
@@ -240,10 +252,22 @@ func (r *RSQLHelper) parseFieldsQuery(queryType any) error {
 		if qName := fld.Tag.Get("rsql"); qName != "" {
 			tags := strings.Split(qName, ",")
 			name := strings.TrimSpace(tags[0])
+			fldType := fld.Type
+			if fldType.Kind() == reflect.Pointer {
+				fldType = fldType.Elem()
+			}
+			// the column is the gorm column of the field, e.g. gorm:"column:gh_webhook_receiver_id" if the field name
+			// isn't the one of the model
+			column := schema.ParseTagSetting(fld.Tag.Get("gorm"), ";")["COLUMN"]
+			if len(column) == 0 {
+				column = schema.NamingStrategy{}.ColumnName("", fld.Name)
+			}
 			r.FieldsQuery[name] = &FieldQuery{
 				AllowFilter: false,
 				AllowSort:   false,
-				Kind:        fld.Type.Kind(),
+				Kind:        fldType.Kind(),
+				Column:      column,
+				IsTime:      fldType == timeType,
 			}
 
 			for j := 1; j < len(tags); j++ {
@@ -417,6 +441,9 @@ func (r *RSQLHelper) convertBool(b bool, def *FieldQuery) (val interface{}, err
 }
 
 func (r *RSQLHelper) convertStr(s string, def *FieldQuery) (val interface{}, err error) {
+	if def.IsTime {
+		return time.Parse(time.RFC3339, s)
+	}
 	switch def.Kind {
 	case reflect.Int64, reflect.Int32, reflect.Int16, reflect.Int8, reflect.Int:
 		val, err = strconv.ParseInt(s, 10, 64)
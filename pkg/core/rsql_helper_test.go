@@ -112,3 +112,62 @@ func TestRSQLHelper_ParseSort(t *testing.T) {
 		t.Fatalf("expected sql (%s) != actual sql (%s)", "name asc, id desc", helper.SortSQL)
 	}
 }
+
+func TestRSQLHelper_ParseFilterColumn(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	type TestColumnDTO struct {
+		CreatedAt     time.Time `json:"createdAt" rsql:"createdAt,filter,sort"`
+		GHWebhookName string    `json:"ghWebhookName" rsql:"ghWebhookName,filter,sort"`
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET",
+		"?filter=ghWebhookName==\"jun\";createdAt=ge=\"2024-05-12T00:00:00Z\"&sort=createdAt,desc", nil)
+
+	helper := NewRSQLHelper()
+
+	err := helper.ParseFilter(TestColumnDTO{}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedSQL := "gh_webhook_name = ? and created_at >= ?"
+	if helper.FilterSQL != expectedSQL {
+		t.Fatalf("expected sql (%s) != actual sql (%s)", expectedSQL, helper.FilterSQL)
+	}
+	expectedArgs := []interface{}{"jun", time.Date(2024, 5, 12, 0, 0, 0, 0, time.UTC)}
+	if !slices.Equal(helper.Arguments, expectedArgs) {
+		t.Fatalf("expected args (%v) != actual args (%v)", expectedArgs, helper.Arguments)
+	}
+	if helper.SortSQL != "created_at desc" {
+		t.Fatalf("expected sql (%s) != actual sql (%s)", "created_at desc", helper.SortSQL)
+	}
+}
+
+func TestRSQLHelper_ParseFilterSingle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	type TestReceiverDTO struct {
+		GHWebHookReceiverID uint `json:"recieverId" rsql:"recieverId,filter,sort" gorm:"column:gh_webhook_receiver_id"`
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "?filter=recieverId==2", nil)
+
+	helper := NewRSQLHelper()
+
+	err := helper.ParseFilter(TestReceiverDTO{}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedSQL := "gh_webhook_receiver_id = ?"
+	if helper.FilterSQL != expectedSQL {
+		t.Fatalf("expected sql (%s) != actual sql (%s)", expectedSQL, helper.FilterSQL)
+	}
+	expectedArgs := []interface{}{uint64(2)}
+	if !slices.Equal(helper.Arguments, expectedArgs) {
+		t.Fatalf("expected args (%v) != actual args (%v)", expectedArgs, helper.Arguments)
+	}
+}
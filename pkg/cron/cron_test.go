@@ -0,0 +1,61 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse_Invalid(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *",
+		"* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("%s should be invalid", spec)
+		}
+	}
+}
+
+func TestSchedule_Next(t *testing.T) {
+	start := time.Date(2024, 3, 15, 10, 7, 30, 0, time.UTC) // friday
+	tests := map[string]time.Time{
+		"*/15 * * * *":        time.Date(2024, 3, 15, 10, 15, 0, 0, time.UTC),
+		"0 9-17 * * mon-fri":  time.Date(2024, 3, 15, 11, 0, 0, 0, time.UTC),
+		"30 2 * * sat,sun":    time.Date(2024, 3, 16, 2, 30, 0, 0, time.UTC),
+		"0 0 1 jan *":         time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		"@daily":              time.Date(2024, 3, 16, 0, 0, 0, 0, time.UTC),
+		"0 0 29 2 *":          time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+		"0 12 1 * 1":          time.Date(2024, 3, 18, 12, 0, 0, 0, time.UTC), // monday or the 1st
+		"0 12 * * 7":          time.Date(2024, 3, 17, 12, 0, 0, 0, time.UTC),
+		"5/20 10 15 3 *":      time.Date(2024, 3, 15, 10, 25, 0, 0, time.UTC),
+		"0 0 31 2 *":          {},
+		"7 10 15 3 *":         time.Date(2025, 3, 15, 10, 7, 0, 0, time.UTC),
+		"0-10/5 10 * * *":     time.Date(2024, 3, 15, 10, 10, 0, 0, time.UTC),
+		"59 23 31 12 *":       time.Date(2024, 12, 31, 23, 59, 0, 0, time.UTC),
+		"* * * * *":           time.Date(2024, 3, 15, 10, 8, 0, 0, time.UTC),
+		"@hourly":             time.Date(2024, 3, 15, 11, 0, 0, 0, time.UTC),
+		"0 0 * * 0":           time.Date(2024, 3, 17, 0, 0, 0, 0, time.UTC),
+		"0 0 1,15 * *":        time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+		"15 10 * mar-apr fri": time.Date(2024, 3, 15, 10, 15, 0, 0, time.UTC),
+	}
+	for spec, expected := range tests {
+		schedule, err := Parse(spec)
+		if err != nil {
+			t.Fatalf("%s: %v", spec, err)
+		}
+		if next := schedule.Next(start); !next.Equal(expected) {
+			t.Errorf("%s: expected %v, got %v", spec, expected, next)
+		}
+	}
+}
+
+func TestSchedule_Matches(t *testing.T) {
+	schedule, err := Parse("0 22 * * 5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !schedule.Matches(time.Date(2024, 3, 15, 22, 0, 0, 0, time.UTC)) {
+		t.Fatal("should match friday 22:00")
+	}
+	if schedule.Matches(time.Date(2024, 3, 15, 22, 1, 0, 0, time.UTC)) {
+		t.Fatal("should not match 22:01")
+	}
+}
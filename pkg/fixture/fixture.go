@@ -0,0 +1,72 @@
+package fixture
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// the canonical payloads of the GitHub events, the file name is the event, e.g. push.json
+//
+//go:embed payloads/*.json
+var payloads embed.FS
+
+// Names the events of the bundled payloads
+func Names() []string {
+	entries, _ := payloads.ReadDir("payloads")
+	var names []string
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".json"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Load a copy of the bundled payload of the event
+func Load(event string) (map[string]interface{}, error) {
+	data, err := payloads.ReadFile(path.Join("payloads", event+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("fixture %s not found, it should be one of %v", event, Names())
+	}
+	var payload map[string]interface{}
+	if err = json.Unmarshal(data, &payload); err != nil {
+		return nil, fmt.Errorf("invalid fixture %s: %v", event, err)
+	}
+	return payload, nil
+}
+
+// Override set the values of the dotted paths of the payload, e.g. {"pull_request.base.ref": "release/1.2"}, the
+// missing objects of a path are created
+func Override(payload map[string]interface{}, overrides map[string]interface{}) error {
+	keys := make([]string, 0, len(overrides))
+	for key := range overrides {
+		keys = append(keys, key)
+	}
+	// the parents first, so a path can override a field of the object set by its parent
+	sort.Strings(keys)
+	for _, key := range keys {
+		fields := strings.Split(key, ".")
+		obj := payload
+		for i, field := range fields[:len(fields)-1] {
+			if len(field) == 0 {
+				return fmt.Errorf("invalid override %s", key)
+			}
+			child, ok := obj[field]
+			if !ok || child == nil {
+				child = map[string]interface{}{}
+				obj[field] = child
+			}
+			if obj, ok = child.(map[string]interface{}); !ok {
+				return fmt.Errorf("invalid override %s, %s isn't an object", key, strings.Join(fields[:i+1], "."))
+			}
+		}
+		if len(fields[len(fields)-1]) == 0 {
+			return fmt.Errorf("invalid override %s", key)
+		}
+		obj[fields[len(fields)-1]] = overrides[key]
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package fixture
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	for _, name := range Names() {
+		if _, err := Load(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !slices.Contains(Names(), "push") {
+		t.Fatalf("push should be bundled, got %v", Names())
+	}
+	if _, err := Load("deployment_status"); err == nil {
+		t.Fatal("unknown fixture should fail")
+	}
+}
+
+func TestOverride(t *testing.T) {
+	payload, err := Load("pull_request")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Override(payload, map[string]interface{}{
+		"pull_request.base":     map[string]interface{}{"ref": "main"},
+		"pull_request.base.ref": "release/1.2",
+		"pull_request.number":   7,
+		"installation.id":       99,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr := payload["pull_request"].(map[string]interface{})
+	if pr["number"] != 7 || pr["base"].(map[string]interface{})["ref"] != "release/1.2" {
+		t.Fatalf("unexpected pull request %v", pr)
+	}
+	if payload["installation"].(map[string]interface{})["id"] != 99 {
+		t.Fatalf("missing objects should be created, got %v", payload["installation"])
+	}
+
+	// the bundled payload isn't changed
+	if payload, _ = Load("pull_request"); payload["pull_request"].(map[string]interface{})["number"] != float64(42) {
+		t.Fatal("the override should not change the bundled payload")
+	}
+
+	for _, key := range []string{"pull_request.title.text", "pull_request..number", "action."} {
+		if err = Override(payload, map[string]interface{}{key: 1}); err == nil {
+			t.Fatalf("override %s should be invalid", key)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package github
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gh-webhook/pkg/model"
+	"gh-webhook/pkg/secret"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	requestTimeout = 30 * time.Second
+	filesPerPage   = 100
+	maxFilesPages  = 30 // GitHub lists at most 3000 files of a pull request
+	maxPushCommits = 20 // GitHub lists at most 20 commits in the push payload
+	cacheTTL       = 10 * time.Minute
+	stateCacheTTL  = time.Minute // the pull request and commit status change often
+	maxCached      = 1000        // cached pull request files and team memberships
+)
+
+type cached struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+// StatusError the API returned an unexpected status
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("GET %s returned %d: %s", e.URL, e.StatusCode, e.Body)
+}
+
+// Client GitHub API client of the configured GitHub servers, the pull request files are cached by head commit and
+// the team memberships for a while
+type Client struct {
+	httpClient *http.Client
+	resolver   *secret.Resolver
+	now        func() time.Time
+	mutex      sync.Mutex
+	cache      map[string]cached
+}
+
+func NewClient(resolver *secret.Resolver) *Client {
+	return &Client{
+		httpClient: &http.Client{Timeout: requestTimeout},
+		resolver:   resolver,
+		now:        time.Now,
+		cache:      map[string]cached{},
+	}
+}
+
+func (c *Client) cached(key string) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.cache[key]
+	if !ok || !c.now().Before(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// store the value unless the cache is still full after the expired values are removed
+func (c *Client) store(key string, value interface{}, ttl time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := c.now()
+	if len(c.cache) >= maxCached {
+		for k, v := range c.cache {
+			if !now.Before(v.expiresAt) {
+				delete(c.cache, k)
+			}
+		}
+	}
+	if len(c.cache) < maxCached {
+		c.cache[key] = cached{value: value, expiresAt: now.Add(ttl)}
+	}
+}
+
+// Get the API resource into result, apiPath is relative to the API url of the GitHub server unless it's a full
+// url, the url of the next page is returned if there is one
+func (c *Client) Get(gh model.GitHub, apiPath string, result interface{}) (string, error) {
+	apiURL := apiPath
+	if !strings.HasPrefix(apiPath, "http://") && !strings.HasPrefix(apiPath, "https://") {
+		apiURL = gh.GetAPIURL() + "/" + strings.TrimPrefix(apiPath, "/")
+	}
+	req, err := http.NewRequest(http.MethodGet, apiURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if len(gh.Token) > 0 {
+		token, err := c.resolver.Resolve(gh.Token)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve token of github %s: %v", gh.Name, err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", &StatusError{URL: apiURL, StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+	if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return "", fmt.Errorf("failed to parse response of GET %s: %v", apiURL, err)
+	}
+	return nextPage(resp.Header.Get("Link")), nil
+}
+
+// nextPage the next url of the Link header, e.g. <https://api.github.com/...?page=2>; rel="next"
+func nextPage(link string) string {
+	for _, part := range strings.Split(link, ",") {
+		target, params, found := strings.Cut(strings.TrimSpace(part), ";")
+		if !found || !strings.Contains(params, `rel="next"`) {
+			continue
+		}
+		return strings.Trim(strings.TrimSpace(target), "<>")
+	}
+	return ""
+}
+
+// PullRequestFiles the files changed by the pull request at the head commit, renamed files have their previous
+// name as well
+func (c *Client) PullRequestFiles(gh model.GitHub, repo string, number int, headSha string) ([]string, error) {
+	key := fmt.Sprintf("files:%d/%s#%d@%s", gh.ID, repo, number, headSha)
+	if files, ok := c.cached(key); ok {
+		return files.([]string), nil
+	}
+
+	var files []string
+	next := fmt.Sprintf("repos/%s/pulls/%d/files?per_page=%d", repo, number, filesPerPage)
+	for page := 0; len(next) > 0 && page < maxFilesPages; page++ {
+		var items []struct {
+			Filename         string `json:"filename"`
+			PreviousFilename string `json:"previous_filename"`
+		}
+		var err error
+		if next, err = c.Get(gh, next, &items); err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			files = append(files, item.Filename)
+			if len(item.PreviousFilename) > 0 {
+				files = append(files, item.PreviousFilename)
+			}
+		}
+	}
+	c.store(key, files, cacheTTL)
+	return files, nil
+}
+
+// TeamMember whether the user is an active member of the org team, a pending invitation isn't a membership
+func (c *Client) TeamMember(gh model.GitHub, org string, team string, login string) (bool, error) {
+	key := fmt.Sprintf("team:%d/%s/%s@%s", gh.ID, org, team, strings.ToLower(login))
+	if member, ok := c.cached(key); ok {
+		return member.(bool), nil
+	}
+
+	var membership struct {
+		State string `json:"state"`
+	}
+	_, err := c.Get(gh, fmt.Sprintf("orgs/%s/teams/%s/memberships/%s", url.PathEscape(org), url.PathEscape(team),
+		url.PathEscape(login)), &membership)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		membership.State = ""
+	} else if err != nil {
+		return false, err
+	}
+	member := membership.State == "active"
+	c.store(key, member, cacheTTL)
+	return member, nil
+}
+
+// ChangedFiles the files changed by the push or pull request event, the ones of a push are listed by its commits
+// unless the commits are truncated, the others are got from the API of the GitHub server
+func (c *Client) ChangedFiles(gh model.GitHub, ghEvent model.GHWebhookEvent,
+	payload map[string]interface{}) ([]string, error) {
+	if ghEvent.Event == "push" {
+		repository, _ := payload["repository"].(map[string]interface{})
+		repo, _ := repository["full_name"].(string)
+		before, _ := payload["before"].(string)
+		after, _ := payload["after"].(string)
+		if !pushTruncated(payload) || len(repo) == 0 || isZeroSha(before) || isZeroSha(after) {
+			// the commits of a new branch can't be compared, they are the best we have
+			return pushFiles(payload), nil
+		}
+		return c.CompareFiles(gh, repo, before, after)
+	}
+
+	pr, _ := payload["pull_request"].(map[string]interface{})
+	repository, _ := payload["repository"].(map[string]interface{})
+	number, _ := pr["number"].(float64)
+	repo, _ := repository["full_name"].(string)
+	if number <= 0 || len(repo) == 0 {
+		return nil, fmt.Errorf("changed files are not supported by %s event", ghEvent.Event)
+	}
+	head, _ := pr["head"].(map[string]interface{})
+	headSha, _ := head["sha"].(string)
+	return c.PullRequestFiles(gh, repo, int(number), headSha)
+}
+
+// pushTruncated whether the push has more commits than its payload lists
+func pushTruncated(payload map[string]interface{}) bool {
+	commits, _ := payload["commits"].([]interface{})
+	size, _ := payload["size"].(float64)
+	distinctSize, _ := payload["distinct_size"].(float64)
+	return len(commits) >= maxPushCommits || int(size) > len(commits) || int(distinctSize) > len(commits)
+}
+
+func isZeroSha(sha string) bool {
+	return len(strings.Trim(sha, "0")) == 0
+}
+
+// CompareFiles the files changed between the commits, renamed files have their previous name as well
+func (c *Client) CompareFiles(gh model.GitHub, repo string, base string, head string) ([]string, error) {
+	key := fmt.Sprintf("compare:%d/%s@%s...%s", gh.ID, repo, base, head)
+	if files, ok := c.cached(key); ok {
+		return files.([]string), nil
+	}
+
+	var files []string
+	seen := map[string]bool{}
+	next := fmt.Sprintf("repos/%s/compare/%s...%s?per_page=%d", repo, url.PathEscape(base), url.PathEscape(head),
+		filesPerPage)
+	for page := 0; len(next) > 0 && page < maxFilesPages; page++ {
+		var comparison struct {
+			Files []struct {
+				Filename         string `json:"filename"`
+				PreviousFilename string `json:"previous_filename"`
+			} `json:"files"`
+		}
+		var err error
+		if next, err = c.Get(gh, next, &comparison); err != nil {
+			return nil, err
+		}
+		// the pages list the commits, the files may only be on the first one
+		for _, file := range comparison.Files {
+			for _, name := range []string{file.Filename, file.PreviousFilename} {
+				if len(name) > 0 && !seen[name] {
+					seen[name] = true
+					files = append(files, name)
+				}
+			}
+		}
+	}
+	c.store(key, files, cacheTTL)
+	return files, nil
+}
+
+func pushFiles(payload map[string]interface{}) []string {
+	var files []string
+	seen := map[string]bool{}
+	commits, _ := payload["commits"].([]interface{})
+	for _, commit := range commits {
+		commitMap, _ := commit.(map[string]interface{})
+		for _, change := range []string{"added", "modified", "removed"} {
+			changed, _ := commitMap[change].([]interface{})
+			for _, file := range changed {
+				if name, ok := file.(string); ok && !seen[name] {
+					seen[name] = true
+					files = append(files, name)
+				}
+			}
+		}
+	}
+	return files
+}
+
+// PullRequest the pull request, it isn't cached until GitHub has computed whether it's mergeable
+func (c *Client) PullRequest(gh model.GitHub, repo string, number int) (map[string]interface{}, error) {
+	key := fmt.Sprintf("pull:%d/%s#%d", gh.ID, repo, number)
+	if pr, ok := c.cached(key); ok {
+		return pr.(map[string]interface{}), nil
+	}
+	var pr map[string]interface{}
+	if _, err := c.Get(gh, fmt.Sprintf("repos/%s/pulls/%d", repo, number), &pr); err != nil {
+		return nil, err
+	}
+	if pr["mergeable"] != nil {
+		c.store(key, pr, stateCacheTTL)
+	}
+	return pr, nil
+}
+
+// CombinedStatus the combined status of the commit
+func (c *Client) CombinedStatus(gh model.GitHub, repo string, sha string) (map[string]interface{}, error) {
+	if isZeroSha(sha) {
+		return nil, fmt.Errorf("no status of the commit %s", sha)
+	}
+	key := fmt.Sprintf("status:%d/%s@%s", gh.ID, repo, sha)
+	if status, ok := c.cached(key); ok {
+		return status.(map[string]interface{}), nil
+	}
+	var status map[string]interface{}
+	if _, err := c.Get(gh, fmt.Sprintf("repos/%s/commits/%s/status", repo, url.PathEscape(sha)), &status); err != nil {
+		return nil, err
+	}
+	c.store(key, status, stateCacheTTL)
+	return status, nil
+}
+
+// CodeOwners the CODEOWNERS of the ref, the first file found of model.CodeOwnersPaths, empty if there is none
+func (c *Client) CodeOwners(gh model.GitHub, repo string, ref string) (*model.CodeOwners, error) {
+	key := fmt.Sprintf("codeowners:%d/%s@%s", gh.ID, repo, ref)
+	if codeOwners, ok := c.cached(key); ok {
+		return codeOwners.(*model.CodeOwners), nil
+	}
+	codeOwners := &model.CodeOwners{}
+	for _, filePath := range model.CodeOwnersPaths {
+		var file struct {
+			Content  string `json:"content"`
+			Encoding string `json:"encoding"`
+		}
+		_, err := c.Get(gh, fmt.Sprintf("repos/%s/contents/%s?ref=%s", repo, filePath, url.QueryEscape(ref)), &file)
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if file.Encoding != "base64" {
+			return nil, fmt.Errorf("unsupported encoding %s of %s", file.Encoding, filePath)
+		}
+		content, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(file.Content, "\n", ""))
+		if err != nil {
+			return nil, fmt.Errorf("invalid content of %s: %v", filePath, err)
+		}
+		if codeOwners, err = model.ParseCodeOwners(string(content)); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", filePath, err)
+		}
+		break
+	}
+	c.store(key, codeOwners, cacheTTL)
+	return codeOwners, nil
+}
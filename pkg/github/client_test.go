@@ -0,0 +1,208 @@
+package github
+
+import (
+	"fmt"
+	"gh-webhook/pkg/model"
+	"gh-webhook/pkg/secret"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_nextPage(t *testing.T) {
+	tests := []struct {
+		link string
+		next string
+	}{
+		{"", ""},
+		{`<https://api.github.com/repositories/1/pulls/2/files?page=2>; rel="next", ` +
+			`<https://api.github.com/repositories/1/pulls/2/files?page=3>; rel="last"`,
+			"https://api.github.com/repositories/1/pulls/2/files?page=2"},
+		{`<https://api.github.com/repositories/1/pulls/2/files?page=1>; rel="prev"`, ""},
+	}
+	for _, test := range tests {
+		if next := nextPage(test.link); next != test.next {
+			t.Errorf("next page of %s should be %s, got %s", test.link, test.next, next)
+		}
+	}
+}
+
+func TestClient_PullRequestFiles(t *testing.T) {
+	t.Setenv("GH_WEBHOOK_TEST_TOKEN", "s3cr3t")
+	requests := 0
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requests++
+		if request.Header.Get("Authorization") != "Bearer s3cr3t" {
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if request.URL.Path != "/repos/octo/mono/pulls/7/files" {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if request.URL.Query().Get("page") == "2" {
+			fmt.Fprint(writer, `[{"filename": "docs/new.md", "previous_filename": "docs/old.md"}]`)
+			return
+		}
+		writer.Header().Set("Link", fmt.Sprintf(`<%s/repos/octo/mono/pulls/7/files?per_page=100&page=2>; rel="next"`,
+			ts.URL))
+		fmt.Fprint(writer, `[{"filename": "services/api/main.go"}, {"filename": "go.mod"}]`)
+	}))
+	t.Cleanup(ts.Close)
+
+	resolver := secret.NewResolver(time.Minute)
+	resolver.Register(secret.EnvScheme, &secret.EnvProvider{Allow: []string{"GH_WEBHOOK_TEST_*"}})
+	client := NewClient(resolver)
+	now := time.Now()
+	client.now = func() time.Time { return now }
+	gh := model.GitHub{Name: "test", API: ts.URL, Token: "env:GH_WEBHOOK_TEST_TOKEN"}
+	gh.ID = 1
+
+	expected := []string{"services/api/main.go", "go.mod", "docs/new.md", "docs/old.md"}
+	files, err := client.PullRequestFiles(gh, "octo/mono", 7, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(files, expected) || requests != 2 {
+		t.Fatalf("should get %v with 2 requests, got %v with %d", expected, files, requests)
+	}
+
+	if files, err = client.PullRequestFiles(gh, "octo/mono", 7, "abc"); err != nil || requests != 2 {
+		t.Fatalf("the files should be cached, got %d requests: %v", requests, err)
+	}
+	if _, err = client.PullRequestFiles(gh, "octo/mono", 7, "def"); err != nil || requests != 4 {
+		t.Fatalf("a new head commit should get the files again, got %d requests: %v", requests, err)
+	}
+	now = now.Add(cacheTTL)
+	if _, err = client.PullRequestFiles(gh, "octo/mono", 7, "abc"); err != nil || requests != 6 {
+		t.Fatalf("the expired files should be got again, got %d requests: %v", requests, err)
+	}
+
+	gh.Token = "plain-wrong-token"
+	if _, err = client.PullRequestFiles(gh, "octo/mono", 8, "abc"); err == nil {
+		t.Fatal("unauthorized request should fail")
+	}
+}
+
+func TestClient_ChangedFiles(t *testing.T) {
+	client := NewClient(secret.NewResolver(time.Minute))
+	payload := map[string]interface{}{"commits": []interface{}{
+		map[string]interface{}{"added": []interface{}{"a.go"}, "modified": []interface{}{"b.go"}},
+		map[string]interface{}{"modified": []interface{}{"a.go"}, "removed": []interface{}{"c.go"}},
+	}}
+	files, err := client.ChangedFiles(model.GitHub{}, model.GHWebhookEvent{Event: "push"}, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"a.go", "b.go", "c.go"}; !reflect.DeepEqual(files, expected) {
+		t.Fatalf("push should change %v, got %v", expected, files)
+	}
+
+	if _, err = client.ChangedFiles(model.GitHub{}, model.GHWebhookEvent{Event: "issues"},
+		map[string]interface{}{}); err == nil {
+		t.Fatal("issues event has no changed files")
+	}
+}
+
+func TestClient_ChangedFilesCompare(t *testing.T) {
+	requests := 0
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requests++
+		if request.URL.Path != "/repos/octo/mono/compare/aaa...bbb" {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if request.URL.Query().Get("page") == "2" {
+			fmt.Fprint(writer, `{"commits": [{"sha": "bbb"}]}`)
+			return
+		}
+		writer.Header().Set("Link", fmt.Sprintf(`<%s/repos/octo/mono/compare/aaa...bbb?per_page=100&page=2>; rel="next"`,
+			ts.URL))
+		fmt.Fprint(writer, `{"commits": [{"sha": "aab"}], "files": [{"filename": "a.go"},
+{"filename": "docs/new.md", "previous_filename": "docs/old.md"}, {"filename": "deep/late.go"}]}`)
+	}))
+	t.Cleanup(ts.Close)
+
+	client := NewClient(secret.NewResolver(time.Minute))
+	gh := model.GitHub{Name: "test", API: ts.URL}
+	gh.ID = 1
+	var commits []interface{}
+	for i := 0; i < maxPushCommits; i++ {
+		commits = append(commits, map[string]interface{}{"modified": []interface{}{"a.go"}})
+	}
+	payload := map[string]interface{}{
+		"before":     "aaa",
+		"after":      "bbb",
+		"repository": map[string]interface{}{"full_name": "octo/mono"},
+		"commits":    commits,
+	}
+	push := model.GHWebhookEvent{Event: "push"}
+
+	// the truncated commits are compared instead
+	expected := []string{"a.go", "docs/new.md", "docs/old.md", "deep/late.go"}
+	files, err := client.ChangedFiles(gh, push, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(files, expected) || requests != 2 {
+		t.Fatalf("should get %v with 2 requests, got %v with %d", expected, files, requests)
+	}
+
+	// the distinct commits beyond the listed ones are truncated too
+	payload["commits"] = commits[:2]
+	payload["distinct_size"] = float64(25)
+	if files, err = client.ChangedFiles(gh, push, payload); err != nil || !reflect.DeepEqual(files, expected) {
+		t.Fatalf("should compare the push of 25 commits, got %v: %v", files, err)
+	}
+
+	// a new branch can't be compared
+	payload["before"] = "0000000000000000000000000000000000000000"
+	if files, err = client.ChangedFiles(gh, push, payload); err != nil || !reflect.DeepEqual(files, []string{"a.go"}) {
+		t.Fatalf("should list the files of the commits, got %v: %v", files, err)
+	}
+}
+
+func TestClient_TeamMember(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requests++
+		switch request.URL.Path {
+		case "/orgs/octo/teams/maintainers/memberships/octocat":
+			fmt.Fprint(writer, `{"state": "active", "role": "member"}`)
+		case "/orgs/octo/teams/maintainers/memberships/invited":
+			fmt.Fprint(writer, `{"state": "pending", "role": "member"}`)
+		case "/orgs/octo/teams/maintainers/memberships/hubot":
+			writer.WriteHeader(http.StatusNotFound)
+		default:
+			writer.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	client := NewClient(secret.NewResolver(time.Minute))
+	gh := model.GitHub{Name: "test", API: ts.URL}
+	tests := []struct {
+		login  string
+		member bool
+	}{
+		{"octocat", true},
+		{"invited", false},
+		{"hubot", false},
+	}
+	for _, test := range tests {
+		member, err := client.TeamMember(gh, "octo", "maintainers", test.login)
+		if err != nil || member != test.member {
+			t.Fatalf("%s should be member %v, got %v: %v", test.login, test.member, member, err)
+		}
+	}
+	if _, err := client.TeamMember(gh, "octo", "maintainers", "OctoCat"); err != nil || requests != 3 {
+		t.Fatalf("the membership should be cached, got %d requests: %v", requests, err)
+	}
+	if _, err := client.TeamMember(gh, "octo", "admins", "octocat"); err == nil {
+		t.Fatal("forbidden request should fail")
+	}
+}
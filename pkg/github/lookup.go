@@ -0,0 +1,210 @@
+package github
+
+import (
+	"fmt"
+	"gh-webhook/pkg/model"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// EventLookup the GitHub API lookups of an event for its subscribes, the GitHub server is loaded and the changed
+// files are got at most once
+type EventLookup struct {
+	client  *Client
+	load    func() (model.GitHub, error)
+	ghEvent model.GHWebhookEvent
+	payload map[string]interface{}
+
+	ghOnce    sync.Once
+	gh        model.GitHub
+	ghErr     error
+	filesOnce sync.Once
+	files     []string
+	filesErr  error
+
+	enrichMutex sync.Mutex
+	enriched    map[string]interface{}
+	enrichErrs  map[string]string
+}
+
+// NewEventLookup load is called on the first lookup to get the GitHub server of the event
+func (c *Client) NewEventLookup(load func() (model.GitHub, error), ghEvent model.GHWebhookEvent,
+	payload map[string]interface{}) *EventLookup {
+	return &EventLookup{client: c, load: load, ghEvent: ghEvent, payload: payload}
+}
+
+func (l *EventLookup) github() (model.GitHub, error) {
+	l.ghOnce.Do(func() {
+		l.gh, l.ghErr = l.load()
+	})
+	return l.gh, l.ghErr
+}
+
+func (l *EventLookup) ChangedFiles() ([]string, error) {
+	l.filesOnce.Do(func() {
+		var gh model.GitHub
+		if gh, l.filesErr = l.github(); l.filesErr == nil {
+			l.files, l.filesErr = l.client.ChangedFiles(gh, l.ghEvent, l.payload)
+		}
+	})
+	return l.files, l.filesErr
+}
+
+func (l *EventLookup) TeamMember(org string, team string, login string) (bool, error) {
+	gh, err := l.github()
+	if err != nil {
+		return false, err
+	}
+	return l.client.TeamMember(gh, org, team, login)
+}
+
+// Enrich the enrichments of the event, each of them is got at most once for all receivers of the event, the failed
+// ones are missing and their errors are under errors
+func (l *EventLookup) Enrich(enrichments []string) map[string]interface{} {
+	l.enrichMutex.Lock()
+	defer l.enrichMutex.Unlock()
+	if l.enriched == nil {
+		l.enriched = map[string]interface{}{}
+		l.enrichErrs = map[string]string{}
+	}
+
+	result := map[string]interface{}{}
+	errs := map[string]interface{}{}
+	for _, enrichment := range enrichments {
+		_, done := l.enriched[enrichment]
+		if _, failed := l.enrichErrs[enrichment]; !done && !failed {
+			if value, err := l.enrich(enrichment); err != nil {
+				l.enrichErrs[enrichment] = err.Error()
+			} else {
+				l.enriched[enrichment] = value
+			}
+		}
+		if value, ok := l.enriched[enrichment]; ok {
+			result[enrichment] = value
+		} else {
+			errs[enrichment] = l.enrichErrs[enrichment]
+		}
+	}
+	if len(errs) > 0 {
+		result["errors"] = errs
+	}
+	return result
+}
+
+func (l *EventLookup) enrich(enrichment string) (interface{}, error) {
+	gh, err := l.github()
+	if err != nil {
+		return nil, err
+	}
+	repository, _ := l.payload["repository"].(map[string]interface{})
+	repo, _ := repository["full_name"].(string)
+	if len(repo) == 0 {
+		return nil, fmt.Errorf("repository not found in payload")
+	}
+
+	switch enrichment {
+	case model.EnrichPullRequest:
+		return l.pullRequest(gh, repo)
+	case model.EnrichCombinedStatus:
+		sha, err := l.headSha(gh, repo)
+		if err != nil {
+			return nil, err
+		}
+		return l.client.CombinedStatus(gh, repo, sha)
+	case model.EnrichCodeOwners:
+		return l.codeOwners(gh, repo)
+	}
+	return nil, fmt.Errorf("unsupported enrichment %s", enrichment)
+}
+
+// pullRequestNumber the number of the pull request, or of the issue if the issue is a pull request, e.g.
+// issue_comment on a pull request
+func (l *EventLookup) pullRequestNumber() int {
+	if pr, ok := l.payload["pull_request"].(map[string]interface{}); ok {
+		number, _ := pr["number"].(float64)
+		return int(number)
+	}
+	if issue, ok := l.payload["issue"].(map[string]interface{}); ok && issue["pull_request"] != nil {
+		number, _ := issue["number"].(float64)
+		return int(number)
+	}
+	return 0
+}
+
+func (l *EventLookup) pullRequest(gh model.GitHub, repo string) (map[string]interface{}, error) {
+	number := l.pullRequestNumber()
+	if number <= 0 {
+		return nil, fmt.Errorf("pull request not found in %s event", l.ghEvent.Event)
+	}
+	return l.client.PullRequest(gh, repo, number)
+}
+
+// headSha the head commit of the pull request or the push
+func (l *EventLookup) headSha(gh model.GitHub, repo string) (string, error) {
+	if pr, ok := l.payload["pull_request"].(map[string]interface{}); ok {
+		head, _ := pr["head"].(map[string]interface{})
+		if sha, ok := head["sha"].(string); ok {
+			return sha, nil
+		}
+	}
+	if sha, ok := l.payload["after"].(string); ok && l.ghEvent.Event == "push" {
+		// the push deleting a branch or tag has no head commit
+		if isZeroSha(sha) {
+			return "", fmt.Errorf("head commit not found: the pushed ref is deleted")
+		}
+		return sha, nil
+	}
+	pr, err := l.pullRequest(gh, repo)
+	if err != nil {
+		return "", fmt.Errorf("head commit not found: %v", err)
+	}
+	head, _ := pr["head"].(map[string]interface{})
+	sha, _ := head["sha"].(string)
+	return sha, nil
+}
+
+// codeOwners the owners of the changed files by the CODEOWNERS of the pull request base, or of the pushed commit
+func (l *EventLookup) codeOwners(gh model.GitHub, repo string) (map[string]interface{}, error) {
+	var ref string
+	if pr, ok := l.payload["pull_request"].(map[string]interface{}); ok {
+		base, _ := pr["base"].(map[string]interface{})
+		ref, _ = base["ref"].(string)
+	} else if l.ghEvent.Event == "push" {
+		ref, _ = l.payload["after"].(string)
+		if len(ref) > 0 && isZeroSha(ref) {
+			return nil, fmt.Errorf("codeowners not found: the pushed ref is deleted")
+		}
+	}
+	if len(ref) == 0 {
+		return nil, fmt.Errorf("codeowners are not supported by %s event", l.ghEvent.Event)
+	}
+	codeOwners, err := l.client.CodeOwners(gh, repo, ref)
+	if err != nil {
+		return nil, err
+	}
+	files, err := l.ChangedFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	// like a json payload, so the jsonpath and expr filters work on them
+	owners := []string{}
+	fileOwners := map[string]interface{}{}
+	for _, file := range files {
+		var ownersOfFile []interface{}
+		for _, owner := range codeOwners.Owners(file) {
+			ownersOfFile = append(ownersOfFile, owner)
+			if !slices.Contains(owners, owner) {
+				owners = append(owners, owner)
+			}
+		}
+		fileOwners[file] = ownersOfFile
+	}
+	sort.Strings(owners)
+	allOwners := make([]interface{}, 0, len(owners))
+	for _, owner := range owners {
+		allOwners = append(allOwners, owner)
+	}
+	return map[string]interface{}{"owners": allOwners, "files": fileOwners}, nil
+}
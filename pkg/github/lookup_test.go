@@ -0,0 +1,101 @@
+package github
+
+import (
+	"encoding/base64"
+	"fmt"
+	"gh-webhook/pkg/model"
+	"gh-webhook/pkg/secret"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEventLookup_Enrich(t *testing.T) {
+	requests := map[string]int{}
+	codeOwners := base64.StdEncoding.EncodeToString([]byte("* @octo/core\nservices/api/ @octo/api\n"))
+	ts := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requests[request.URL.Path]++
+		switch request.URL.Path {
+		case "/repos/octo/mono/pulls/7":
+			fmt.Fprint(writer, `{"number": 7, "mergeable": true, "mergeable_state": "clean", "head": {"sha": "abc"}}`)
+		case "/repos/octo/mono/commits/abc/status":
+			fmt.Fprint(writer, `{"state": "success", "total_count": 2}`)
+		case "/repos/octo/mono/contents/CODEOWNERS":
+			if request.URL.Query().Get("ref") != "main" {
+				writer.WriteHeader(http.StatusNotFound)
+				return
+			}
+			fmt.Fprintf(writer, `{"encoding": "base64", "content": "%s"}`, codeOwners)
+		case "/repos/octo/mono/pulls/7/files":
+			fmt.Fprint(writer, `[{"filename": "services/api/main.go"}, {"filename": "go.mod"}]`)
+		default:
+			writer.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	client := NewClient(secret.NewResolver(time.Minute))
+	gh := model.GitHub{Name: "test", API: ts.URL}
+	loads := 0
+	load := func() (model.GitHub, error) {
+		loads++
+		return gh, nil
+	}
+	payload := map[string]interface{}{
+		"repository": map[string]interface{}{"full_name": "octo/mono"},
+		"pull_request": map[string]interface{}{"number": float64(7), "head": map[string]interface{}{"sha": "abc"},
+			"base": map[string]interface{}{"ref": "main"}},
+	}
+	lookup := client.NewEventLookup(load, model.GHWebhookEvent{Event: "pull_request"}, payload)
+
+	enriched := lookup.Enrich(model.Enrichments)
+	pr, _ := enriched[model.EnrichPullRequest].(map[string]interface{})
+	status, _ := enriched[model.EnrichCombinedStatus].(map[string]interface{})
+	if pr["mergeable_state"] != "clean" || status["state"] != "success" || enriched["errors"] != nil {
+		t.Fatalf("unexpected enrichments %+v", enriched)
+	}
+	owners := enriched[model.EnrichCodeOwners].(map[string]interface{})
+	if expected := []interface{}{"@octo/api", "@octo/core"}; !reflect.DeepEqual(owners["owners"], expected) {
+		t.Fatalf("code owners should be %v, got %v", expected, owners)
+	}
+
+	// the enrichments are got once for the receivers of the event
+	lookup.Enrich([]string{model.EnrichPullRequest, model.EnrichCodeOwners})
+	if loads != 1 || requests["/repos/octo/mono/pulls/7"] != 1 || requests["/repos/octo/mono/contents/CODEOWNERS"] != 1 ||
+		requests["/repos/octo/mono/contents/.github/CODEOWNERS"] != 1 {
+		t.Fatalf("the enrichments should be got once, got %v", requests)
+	}
+	// and cached by the client for the next events
+	client.NewEventLookup(load, model.GHWebhookEvent{Event: "pull_request"}, payload).Enrich(model.Enrichments)
+	if requests["/repos/octo/mono/pulls/7"] != 1 || requests["/repos/octo/mono/commits/abc/status"] != 1 {
+		t.Fatalf("the enrichments should be cached, got %v", requests)
+	}
+
+	// the push deleting a branch has no head commit, so GitHub isn't asked for its status and codeowners
+	zeroSha := "0000000000000000000000000000000000000000"
+	deleted := client.NewEventLookup(load, model.GHWebhookEvent{Event: "push"}, map[string]interface{}{
+		"repository": map[string]interface{}{"full_name": "octo/mono"}, "after": zeroSha, "deleted": true})
+	enriched = deleted.Enrich([]string{model.EnrichCombinedStatus, model.EnrichCodeOwners})
+	errs, _ := enriched["errors"].(map[string]interface{})
+	if len(errs) != 2 || enriched[model.EnrichCombinedStatus] != nil || enriched[model.EnrichCodeOwners] != nil {
+		t.Fatalf("the enrichments of a deleted branch should be skipped, got %+v", enriched)
+	}
+	if requests["/repos/octo/mono/commits/"+zeroSha+"/status"] != 0 ||
+		requests["/repos/octo/mono/contents/CODEOWNERS"] != 1 {
+		t.Fatalf("GitHub should not be called for the zero sha, got %v", requests)
+	}
+	if _, err := client.CombinedStatus(gh, "octo/mono", zeroSha); err == nil {
+		t.Fatal("the zero sha should have no status")
+	}
+
+	// a failed enrichment is reported under errors
+	issues := client.NewEventLookup(load, model.GHWebhookEvent{Event: "issues"},
+		map[string]interface{}{"repository": map[string]interface{}{"full_name": "octo/mono"}})
+	enriched = issues.Enrich([]string{model.EnrichPullRequest})
+	if errs, _ := enriched["errors"].(map[string]interface{}); errs[model.EnrichPullRequest] == nil ||
+		enriched[model.EnrichPullRequest] != nil {
+		t.Fatalf("the pull request enrichment of an issue should fail, got %+v", enriched)
+	}
+}
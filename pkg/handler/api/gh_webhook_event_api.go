@@ -9,6 +9,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 	"net/http"
+	"time"
 )
 
 type GHWebhookEventAPIHandler struct {
@@ -22,12 +23,20 @@ type GHWebhookEventSearchDTO struct {
 	Event     string            `json:"event" rsql:"event,filter,sort"`
 	Action    string            `json:"action" rsql:"action,filter,sort"`
 	OrgRepo   string            `json:"orgRepo" rsql:"orgRepo,filter,sort"`
+	Org       string            `json:"org" rsql:"org,filter,sort"`
+	Repo      string            `json:"repo" rsql:"repo,filter,sort"`
 	HookId    string            `json:"hookId" rsql:"hookId,filter,sort"`
 	PayloadId string            `json:"payloadId" rsql:"payloadId,filter,sort"`
 	GitHubId  uint              `json:"githubId" rsql:"githubId,filter,sort"`
+
+	Synthetic  bool `json:"synthetic" rsql:"synthetic,filter,sort"` // created by a schedule instead of GitHub
+	ScheduleId uint `json:"scheduleId" rsql:"scheduleId,filter,sort"`
+
+	CreatedAt time.Time `json:"createdAt" rsql:"createdAt,filter,sort"` // when it was received
 }
 
 func (h *GHWebhookEventAPIHandler) Register(c *core.GHPRContext) error {
+	h.db = c.Db
 	c.Gin.GET(fmt.Sprintf("%s/gh-webhook-event/:id", c.Cfg.APIPrefix), h.Get)
 	c.Gin.GET(fmt.Sprintf("%s/gh-webhook-event/", c.Cfg.APIPrefix), h.List)
 	return nil
@@ -58,18 +67,18 @@ func (h *GHWebhookEventAPIHandler) Get(c *gin.Context) {
 
 func (h *GHWebhookEventAPIHandler) List(c *gin.Context) {
 
-	var subs []model.GHWebhookReceiver
-	if !core.SearchModel(c, h.db, GHWebhookEventSearchDTO{}, &subs) {
+	var events []model.GHWebhookEvent
+	if !core.SearchModel(c, h.db, GHWebhookEventSearchDTO{}, &events) {
 		return
 	}
-	var receiverDTOs []GHWebhookReceiverSearchDTO
+	var eventDTOs []GHWebhookEventSearchDTO
 	mapper := dto.Mapper{}
-	err := mapper.Map(&receiverDTOs, subs)
+	err := mapper.Map(&eventDTOs, events)
 	if err != nil {
 		log.Errorf("failed to map: %v", err)
 		c.JSON(http.StatusInternalServerError, model.NewErrorMsgDTOFromErr(err))
 		return
 	}
 
-	c.JSON(http.StatusOK, model.NewListResponse(receiverDTOs))
+	c.JSON(http.StatusOK, model.NewListResponse(eventDTOs))
 }
@@ -26,6 +26,7 @@ type GHWebhookEventDeliverSearchDTO struct {
 type GHWebhookReceiversSearchDTO struct {
 	GHWebhookReceiverId uint   `json:"ghWebhookReceiverId"`
 	Delivered           bool   `json:"delivered"`
+	Status              string `json:"status"`
 	Error               string `json:"error"`
 }
 
@@ -37,20 +38,20 @@ func (h *GHWebhookEventDeliverAPIHandler) Register(c *core.GHPRContext) error {
 }
 
 func (h *GHWebhookEventDeliverAPIHandler) List(c *gin.Context) {
-	var subs []model.GHWebhookEventDeliver
-	if !core.SearchModel(c, h.db, GHWebhookEventSearchDTO{}, &subs) {
+	var delivers []model.GHWebhookEventDeliver
+	if !core.SearchModel(c, h.db.Preload("GHWebhookReceivers"), GHWebhookEventDeliverSearchDTO{}, &delivers) {
 		return
 	}
-	var receiverDTOs []GHWebhookReceiverSearchDTO
+	var deliverDTOs []GHWebhookEventDeliverSearchDTO
 	mapper := dto.Mapper{}
-	err := mapper.Map(&receiverDTOs, subs)
+	err := mapper.Map(&deliverDTOs, delivers)
 	if err != nil {
 		log.Errorf("failed to map: %v", err)
 		c.JSON(http.StatusInternalServerError, model.NewErrorMsgDTOFromErr(err))
 		return
 	}
 
-	c.JSON(http.StatusOK, model.NewListResponse(receiverDTOs))
+	c.JSON(http.StatusOK, model.NewListResponse(deliverDTOs))
 }
 
 func (h *GHWebhookEventDeliverAPIHandler) Get(c *gin.Context) {
@@ -59,7 +60,7 @@ func (h *GHWebhookEventDeliverAPIHandler) Get(c *gin.Context) {
 		return
 	}
 	deliver := model.GHWebhookEventDeliver{}
-	if !core.GetModel(c, h.db, &deliver, "id = ?", *id) {
+	if !core.GetModel(c, h.db.Preload("GHWebhookReceivers"), &deliver, "id = ?", *id) {
 		return
 	}
 	mapper := dto.Mapper{}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"fmt"
+	"gh-webhook/pkg/model"
+	"net/http"
+	"testing"
+)
+
+func Test_EventDeliverAPI(t *testing.T) {
+	ctx := newTestContext(t)
+	if err := (&GHWebhookEventDeliverAPIHandler{}).Register(ctx); err != nil {
+		t.Fatal(err)
+	}
+	receiver := newTestGitHubReceiver(t, ctx.Db, "test")
+	event := model.GHWebhookEvent{Event: "push", Payload: "{}", GitHubId: receiver.GitHubId}
+	ctx.Db.Omit("GitHub").Create(&event)
+	deliver := model.GHWebhookEventDeliver{
+		GHWebhookEventId: event.ID,
+		Delivered:        true,
+		GHWebhookReceivers: []model.GHWebhookEventReceiverDeliver{
+			{GHWebhookReceiverId: receiver.ID, Status: model.DeliverStatusPaused},
+		},
+	}
+	ctx.Db.Omit("GHWebhookEvent").Create(&deliver)
+
+	var list model.ListResponse[GHWebhookEventDeliverSearchDTO]
+	path := fmt.Sprintf("/gh-webhook-event-deliver?filter=ghWebhookEventId==%d", event.ID)
+	if code := serve(t, ctx, http.MethodGet, path, "", &list); code != http.StatusOK || len(list.Entries) != 1 {
+		t.Fatalf("should list the deliver of the event, got %d %+v", code, list)
+	}
+
+	var got GHWebhookEventDeliverSearchDTO
+	path = fmt.Sprintf("/gh-webhook-event-deliver/%d", deliver.ID)
+	if code := serve(t, ctx, http.MethodGet, path, "", &got); code != http.StatusOK {
+		t.Fatalf("should get the deliver, got %d", code)
+	}
+	if len(got.GHWebhookReceivers) != 1 || got.GHWebhookReceivers[0].Status != model.DeliverStatusPaused ||
+		got.GHWebhookReceivers[0].Delivered {
+		t.Fatalf("should have the paused delivery of the receiver, got %+v", got)
+	}
+	if code := serve(t, ctx, http.MethodGet, "/gh-webhook-event-deliver/9", "", nil); code != http.StatusNotFound {
+		t.Fatalf("should not find the deliver, got %d", code)
+	}
+}
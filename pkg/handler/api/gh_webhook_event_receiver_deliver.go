@@ -9,6 +9,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 	"net/http"
+	"time"
 )
 
 type GHWebhookEventReceiverDeliverAPIHandler struct {
@@ -16,12 +17,21 @@ type GHWebhookEventReceiverDeliverAPIHandler struct {
 }
 
 type GHWebhookEventReceiverDeliverSearchDTO struct {
-	ID                      uint   `json:"id" rsql:"id,filter,sort"`
-	GHWebhookReceiverId     uint   `json:"GHWebhookReceiverId" rsql:"ghWebhookReceiverId,filter,sort"`
-	GHWebhookEventDeliverID uint   `json:"ghWebhookEventDeliverId" rsql:"ghWebhookEventDeliverId,filter,sort"`
-	Delivered               bool   `json:"delivered" rsql:"delivered,filter,sort"`
-	Error                   string `json:"error" rsql:"error,filter,sort"`
-	Ack                     string `json:"ack" rsql:"ack,filter,sort"`
+	ID                      uint           `json:"id" rsql:"id,filter,sort"`
+	GHWebhookReceiverId     uint           `json:"GHWebhookReceiverId" rsql:"ghWebhookReceiverId,filter,sort"`
+	GHWebhookEventDeliverID uint           `json:"ghWebhookEventDeliverId" rsql:"ghWebhookEventDeliverId,filter,sort"`
+	Delivered               bool           `json:"delivered" rsql:"delivered,filter,sort"`
+	Status                  string         `json:"status" rsql:"status,filter,sort"`
+	ThrottleWait            model.Duration `json:"throttleWait"`
+	CoalesceKey             string         `json:"coalesceKey" rsql:"coalesceKey,filter,sort"`
+	DueAt                   *time.Time     `json:"dueAt"`
+	CoalescedIntoID         uint           `json:"coalescedIntoId" rsql:"coalescedIntoId,filter,sort"`
+	ConcurrencyGroup        string         `json:"concurrencyGroup" rsql:"concurrencyGroup,filter,sort"`
+	CancelledByID           uint           `json:"cancelledById" rsql:"cancelledById,filter,sort"`
+	ReceiverRef             string         `json:"receiverRef"`
+	PayloadSize             int            `json:"payloadSize"`
+	Error                   string         `json:"error" rsql:"error,filter,sort"`
+	Ack                     string         `json:"ack" rsql:"ack,filter,sort"`
 }
 
 type GHWebhookEventReceiverDeliverAckCreateDTO struct {
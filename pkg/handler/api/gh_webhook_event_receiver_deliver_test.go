@@ -0,0 +1,52 @@
+package api
+
+import (
+	"fmt"
+	"gh-webhook/pkg/model"
+	"net/http"
+	"testing"
+)
+
+func Test_EventReceiverDeliverAPI(t *testing.T) {
+	ctx := newTestContext(t)
+	if err := (&GHWebhookEventReceiverDeliverAPIHandler{}).Register(ctx); err != nil {
+		t.Fatal(err)
+	}
+	receiver := newTestGitHubReceiver(t, ctx.Db, "test")
+	delivers := []model.GHWebhookEventReceiverDeliver{
+		{GHWebhookReceiverId: receiver.ID, Delivered: true, Status: model.DeliverStatusDelivered, PayloadSize: 42},
+		{GHWebhookReceiverId: receiver.ID, Status: model.DeliverStatusThrottled},
+		{GHWebhookReceiverId: receiver.ID, Delivered: true, Status: model.DeliverStatusCancelled, CancelledByID: 1},
+	}
+	ctx.Db.Omit("GHWebhookEventDeliver").Create(&delivers)
+
+	var list model.ListResponse[GHWebhookEventReceiverDeliverSearchDTO]
+	path := fmt.Sprintf("/gh-webhook-event-receiver-deliver?filter=ghWebhookReceiverId==%d;status!=%q", receiver.ID,
+		model.DeliverStatusDelivered)
+	if code := serve(t, ctx, http.MethodGet, path, "", &list); code != http.StatusOK {
+		t.Fatalf("should list the deliveries, got %d", code)
+	}
+	if len(list.Entries) != 2 || list.Entries[0].Status != model.DeliverStatusThrottled ||
+		list.Entries[1].CancelledByID != 1 {
+		t.Fatalf("should list the throttled and cancelled deliveries, got %+v", list.Entries)
+	}
+
+	var got GHWebhookEventReceiverDeliverSearchDTO
+	path = fmt.Sprintf("/gh-webhook-event-receiver-deliver/%d", delivers[0].ID)
+	if code := serve(t, ctx, http.MethodGet, path, "", &got); code != http.StatusOK || got.PayloadSize != 42 {
+		t.Fatalf("should get the delivery, got %d %+v", code, got)
+	}
+
+	path = fmt.Sprintf("/gh-webhook-event-receiver-deliver/%d/ack", delivers[0].ID)
+	if code := serve(t, ctx, http.MethodPut, path, `{"ack": "completed"}`, nil); code != http.StatusOK {
+		t.Fatalf("should ack the delivery, got %d", code)
+	}
+	var acked model.GHWebhookEventReceiverDeliver
+	ctx.Db.First(&acked, delivers[0].ID)
+	if acked.Ack != "completed" {
+		t.Fatalf("the delivery should be acked, got %+v", acked)
+	}
+	if code := serve(t, ctx, http.MethodPut, path, `{}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("the ack is required, got %d", code)
+	}
+}
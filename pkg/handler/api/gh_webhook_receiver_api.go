@@ -1,9 +1,11 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"gh-webhook/pkg/core"
+	"gh-webhook/pkg/launcher"
 	"gh-webhook/pkg/model"
 	"github.com/dranikpg/dto-mapper"
 	"github.com/gin-gonic/gin"
@@ -18,59 +20,87 @@ type GHWebhookReceiverAPIHandler struct {
 	db *gorm.DB
 }
 
-type GHWebhookReceiverConfigCreateDTO struct {
-	Type      string `json:"type" binding:"required"`
-	URL       string `json:"url" binding:"required"`
-	Auth      string `json:"auth" binding:"required"`
-	Username  string `json:"username"`
-	Password  string `json:"password"`
-	Parameter string `json:"parameter" binding:"required"` // optional
-}
-
 type GHWebhookReceiverCreateDTO struct {
-	Name           string                           `json:"name" binding:"required"`
-	GitHubId       uint                             `json:"githubId" binding:"required"`
-	ReceiverConfig GHWebhookReceiverConfigCreateDTO `json:"receiverConfig" binding:"required"`
-}
+	Name           string                     `json:"name" binding:"required"`
+	GitHubId       uint                       `json:"githubId" binding:"required"`
+	ReceiverConfig json.RawMessage            `json:"receiverConfig" binding:"required"` // validated by the schema of its type
+	CircuitBreaker model.CircuitBreakerConfig `json:"circuitBreaker"`
+	RateLimit      model.RateLimitConfig      `json:"rateLimit"`
 
-type GHWebhookReceiverConfigUpdateDTO struct {
-	Type      *string `json:"type"`
-	URL       *string `json:"url"`
-	Auth      *string `json:"auth"`
-	Username  *string `json:"username"`
-	Password  *string `json:"password"`
-	Parameter *string `json:"parameter"` // optional
+	Enabled            *bool                     `json:"enabled"`
+	MaintenanceWindows []model.MaintenanceWindow `json:"maintenanceWindows"`
+	UnavailablePolicy  string                    `json:"unavailablePolicy" binding:"omitempty,oneof=skip hold"`
+	OrderingKey        string                    `json:"orderingKey"`
+	Org                string                    `json:"org"`  // glob, empty for all orgs
+	Repo               string                    `json:"repo"` // glob, empty for all repositories
+	Enrichments        []string                  `json:"enrichments"`
+	Transform          *model.PayloadTransform   `json:"transform"`
 }
 
 type GHWebhookReceiverUpdateDTO struct {
-	Name           *string                          `json:"name"`
-	ReceiverConfig GHWebhookReceiverConfigUpdateDTO `json:"receiverConfig"`
+	Name           *string                     `json:"name"`
+	ReceiverConfig json.RawMessage             `json:"receiverConfig"` // json merge patch of the receiver config
+	CircuitBreaker *model.CircuitBreakerConfig `json:"circuitBreaker"`
+	RateLimit      *model.RateLimitConfig      `json:"rateLimit"`
+
+	Enabled            *bool                      `json:"enabled"`
+	MaintenanceWindows *[]model.MaintenanceWindow `json:"maintenanceWindows"`
+	UnavailablePolicy  *string                    `json:"unavailablePolicy" binding:"omitempty,oneof=skip hold"`
+	OrderingKey        *string                    `json:"orderingKey"` // empty removes the ordering
+	Org                *string                    `json:"org"`         // empty removes the org scope
+	Repo               *string                    `json:"repo"`        // empty removes the repo scope
+	Enrichments        *[]string                  `json:"enrichments"` // [] removes the enrichments
+	Transform          *model.PayloadTransform    `json:"transform"`   // {} removes the transform
 }
 
 type GHWebhookReceiverSearchDTO struct {
 	ID             uint   `json:"id" rsql:"id,filter,sort"`
 	Name           string `json:"name" rsql:"name,filter,sort"`
 	GitHub         GitHubSearchDTO
-	ReceiverConfig GHWebhookReceiverConfigSearchDTO `json:"config"`
-	CreatedAt      time.Time                        `json:"createdAt" `
-	UpdatedAt      time.Time                        `json:"updatedAt" `
+	ReceiverConfig map[string]interface{}     `json:"config"` // secrets are write-only, <field>Set indicates it's set
+	CircuitBreaker model.CircuitBreakerConfig `json:"circuitBreaker"`
+	Circuit        CircuitStateDTO            `json:"circuit"`
+	RateLimit      model.RateLimitConfig      `json:"rateLimit"`
+
+	Enabled            bool                      `json:"enabled"`
+	MaintenanceWindows []model.MaintenanceWindow `json:"maintenanceWindows"`
+	UnavailablePolicy  string                    `json:"unavailablePolicy"`
+	OrderingKey        string                    `json:"orderingKey"`
+	Org                string                    `json:"org" rsql:"org,filter,sort"`
+	Repo               string                    `json:"repo" rsql:"repo,filter,sort"`
+	Enrichments        []string                  `json:"enrichments"`
+	Transform          *model.PayloadTransform   `json:"transform"`
+
+	CreatedAt time.Time `json:"createdAt" `
+	UpdatedAt time.Time `json:"updatedAt" `
+}
+
+type CircuitStateDTO struct {
+	State    string     `json:"state"`
+	Failures int        `json:"failures"`
+	OpenedAt *time.Time `json:"openedAt"`
 }
 
-type GHWebhookReceiverConfigSearchDTO struct {
-	Type      string `json:"type"`
-	URL       string `json:"url" `
-	Auth      string `json:"auth" `
-	Username  string `json:"username"`
-	Password  string `json:"password"`
-	Parameter string `json:"parameter" ` // optional
+// newReceiverMapper mapper which redacts the receiver secrets
+func newReceiverMapper() *dto.Mapper {
+	mapper := &dto.Mapper{}
+	mapper.AddConvFunc(func(config model.GHWebhookReceiverConfig) (map[string]interface{}, error) {
+		return config.Redacted()
+	})
+	mapper.AddConvFunc(func(enabled *bool) bool {
+		return enabled == nil || *enabled
+	})
+	return mapper
 }
 
 func (h *GHWebhookReceiverAPIHandler) Register(c *core.GHPRContext) error {
 	h.db = c.Db
 	c.Gin.POST(fmt.Sprintf("%s/gh-webhook-receiver/", c.Cfg.APIPrefix), h.Post)
 	c.Gin.PATCH(fmt.Sprintf("%s/gh-webhook-receiver/:id", c.Cfg.APIPrefix), h.Update)
-	c.Gin.GET(fmt.Sprintf("%s/gh-webhook-receiver/:id", c.Cfg.APIPrefix), h.Delete)
+	c.Gin.GET(fmt.Sprintf("%s/gh-webhook-receiver/:id", c.Cfg.APIPrefix), h.Get)
+	c.Gin.DELETE(fmt.Sprintf("%s/gh-webhook-receiver/:id", c.Cfg.APIPrefix), h.Delete)
 	c.Gin.GET(fmt.Sprintf("%s/gh-webhook-receiver", c.Cfg.APIPrefix), h.List)
+	c.Gin.PUT(fmt.Sprintf("%s/gh-webhook-receiver/:id/circuit/reset", c.Cfg.APIPrefix), h.ResetCircuit)
 	return nil
 }
 
@@ -95,21 +125,31 @@ func (h *GHWebhookReceiverAPIHandler) Post(c *gin.Context) {
 		c.JSON(http.StatusUnprocessableEntity, model.NewErrorMsgDTOFromErr(dbErr.Error))
 		return
 	}
+	receiverConfig, err := ParseReceiverConfig(createDTO.ReceiverConfig)
+	if err != nil {
+		log.Errorf("invalid request: %v", err)
+		c.JSON(http.StatusBadRequest, model.NewErrorMsgDTOFromErr(err))
+		return
+	}
+
 	receiver := model.GHWebhookReceiver{
-		Name:     createDTO.Name,
-		GitHubId: createDTO.GitHubId,
-		GitHub:   github,
-		ReceiverConfig: model.GHWebhookReceiverConfig{
-			Type:      createDTO.ReceiverConfig.Type,
-			URL:       createDTO.ReceiverConfig.URL,
-			Auth:      createDTO.ReceiverConfig.Auth,
-			Username:  createDTO.ReceiverConfig.Username,
-			Password:  createDTO.ReceiverConfig.Password,
-			Parameter: createDTO.ReceiverConfig.Parameter},
-		Subscribes: nil,
-	}
-
-	if err := receiver.ReceiverConfig.IsValid(); err != nil {
+		Name:               createDTO.Name,
+		GitHubId:           createDTO.GitHubId,
+		GitHub:             github,
+		ReceiverConfig:     receiverConfig,
+		Subscribes:         nil,
+		CircuitBreaker:     createDTO.CircuitBreaker,
+		RateLimit:          createDTO.RateLimit,
+		Enabled:            createDTO.Enabled,
+		MaintenanceWindows: createDTO.MaintenanceWindows,
+		UnavailablePolicy:  createDTO.UnavailablePolicy,
+		OrderingKey:        createDTO.OrderingKey,
+		Org:                createDTO.Org,
+		Repo:               createDTO.Repo,
+		Enrichments:        createDTO.Enrichments,
+		Transform:          createDTO.Transform,
+	}
+	if err = receiver.IsValid(); err != nil {
 		log.Errorf("invalid request: %v", err)
 		c.JSON(http.StatusBadRequest, model.NewErrorMsgDTOFromErr(err))
 		return
@@ -143,7 +183,7 @@ func (h *GHWebhookReceiverAPIHandler) Get(c *gin.Context) {
 		c.JSON(http.StatusUnprocessableEntity, model.NewErrorMsgDTOFromErr(db.Error))
 		return
 	}
-	mapper := dto.Mapper{}
+	mapper := newReceiverMapper()
 	to := GHWebhookReceiverSearchDTO{}
 	err = mapper.Map(&to, receiver)
 	if err != nil {
@@ -151,7 +191,7 @@ func (h *GHWebhookReceiverAPIHandler) Get(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, model.NewErrorMsgDTOFromErr(err))
 		return
 	}
-	c.JSON(http.StatusOK, receiver)
+	c.JSON(http.StatusOK, to)
 }
 
 // Delete delete webhook receiver
@@ -172,6 +212,17 @@ func (h *GHWebhookReceiverAPIHandler) Delete(c *gin.Context) {
 		c.JSON(http.StatusNotFound, model.NewErrorMsgDTO(http.StatusText(http.StatusNotFound)))
 		return
 	}
+	launcher.EvictHTTPClient(id)
+	model.EvictReceiverTransform(id)
+	// the subscribes of the deleted receiver are never matched again
+	var subscribeIds []uint
+	if err = h.db.Model(&model.GHWebHookSubscribe{}).Where("gh_webhook_receiver_id = ?", id).
+		Pluck("id", &subscribeIds).Error; err != nil {
+		log.Errorf("failed to find the subscribes of webhook receiver %d: %v", id, err)
+	}
+	for _, subscribeId := range subscribeIds {
+		model.EvictSubscribeMatcher(subscribeId)
+	}
 	c.JSON(http.StatusNoContent, nil)
 }
 
@@ -196,7 +247,7 @@ func (h *GHWebhookReceiverAPIHandler) List(c *gin.Context) {
 		return
 	}
 	var receiverDTOs []GHWebhookReceiverSearchDTO
-	mapper := dto.Mapper{}
+	mapper := newReceiverMapper()
 	err = mapper.Map(&receiverDTOs, subs)
 	if err != nil {
 		log.Errorf("failed to map: %v", err)
@@ -233,45 +284,107 @@ func (h *GHWebhookReceiverAPIHandler) Update(c *gin.Context) {
 		updateCnt++
 	}
 
-	if updateDTO.ReceiverConfig.Auth != nil {
-		receiver.ReceiverConfig.Auth = *updateDTO.ReceiverConfig.Auth
+	if len(updateDTO.ReceiverConfig) > 0 {
+		receiverConfig, err := MergeReceiverConfig(receiver.ReceiverConfig, updateDTO.ReceiverConfig)
+		if err != nil {
+			log.Errorf("invalid request: %v", err)
+			c.JSON(http.StatusBadRequest, model.NewErrorMsgDTOFromErr(err))
+			return
+		}
+		receiver.ReceiverConfig = receiverConfig
+		updateCnt++
+	}
+
+	if updateDTO.CircuitBreaker != nil {
+		receiver.CircuitBreaker = *updateDTO.CircuitBreaker
+		updateCnt++
+	}
+
+	if updateDTO.RateLimit != nil {
+		receiver.RateLimit = *updateDTO.RateLimit
+		updateCnt++
+	}
+
+	if updateDTO.Enabled != nil {
+		receiver.Enabled = updateDTO.Enabled
 		updateCnt++
 	}
 
-	if updateDTO.ReceiverConfig.Type != nil {
-		receiver.ReceiverConfig.Type = *updateDTO.ReceiverConfig.Type
+	if updateDTO.MaintenanceWindows != nil {
+		receiver.MaintenanceWindows = *updateDTO.MaintenanceWindows
 		updateCnt++
 	}
 
-	if updateDTO.ReceiverConfig.URL != nil {
-		receiver.ReceiverConfig.URL = *updateDTO.ReceiverConfig.URL
+	if updateDTO.UnavailablePolicy != nil {
+		receiver.UnavailablePolicy = *updateDTO.UnavailablePolicy
 		updateCnt++
 	}
 
-	if updateDTO.ReceiverConfig.Username != nil {
-		receiver.ReceiverConfig.Username = *updateDTO.ReceiverConfig.Username
+	if updateDTO.OrderingKey != nil {
+		receiver.OrderingKey = *updateDTO.OrderingKey
 		updateCnt++
 	}
 
-	if updateDTO.ReceiverConfig.Password != nil {
-		receiver.ReceiverConfig.Password = *updateDTO.ReceiverConfig.Password
+	if updateDTO.Org != nil {
+		receiver.Org = *updateDTO.Org
 		updateCnt++
 	}
 
-	if updateDTO.ReceiverConfig.Parameter != nil {
-		receiver.ReceiverConfig.Parameter = *updateDTO.ReceiverConfig.Parameter
+	if updateDTO.Repo != nil {
+		receiver.Repo = *updateDTO.Repo
 		updateCnt++
 	}
 
+	if updateDTO.Enrichments != nil {
+		receiver.Enrichments = *updateDTO.Enrichments
+		updateCnt++
+	}
+
+	if updateDTO.Transform != nil {
+		receiver.Transform = updateDTO.Transform
+		if receiver.Transform.IsEmpty() {
+			receiver.Transform = nil
+		}
+		updateCnt++
+	}
+
+	if err = receiver.IsValid(); err != nil {
+		log.Errorf("invalid request: %v", err)
+		c.JSON(http.StatusBadRequest, model.NewErrorMsgDTOFromErr(err))
+		return
+	}
+
 	if updateCnt <= 0 {
 		c.JSON(http.StatusBadRequest, model.NewErrorMsgDTO("no field to update"))
 		return
 	}
-	db := h.db.Save(&receiver)
+	// the circuit may be opened or closed by a delivery meanwhile, it's not overwritten
+	db := h.db.Model(&receiver).Select("*").Omit(model.CircuitColumns...).Updates(&receiver)
 	if db.Error != nil {
 		log.Errorf("failed to update webhook receiver: %v", db.Error)
 		c.JSON(http.StatusUnprocessableEntity, model.NewErrorMsgDTOFromErr(db.Error))
 		return
 	}
+	launcher.EvictHTTPClient(receiver.ID)
+	c.JSON(http.StatusOK, model.NewIDResponse(receiver.ID))
+}
+
+// ResetCircuit close the receiver circuit, its paused deliveries are delivered in order
+func (h *GHWebhookReceiverAPIHandler) ResetCircuit(c *gin.Context) {
+	id := core.GetPathVarUInt(c, "id")
+	if id == nil {
+		return
+	}
+	receiver := model.GHWebhookReceiver{}
+	if !core.GetModel(c, h.db, &receiver, "id = ?", *id) {
+		return
+	}
+	receiver.Circuit.Reset()
+	db := h.db.Model(&receiver).Updates(receiver.Circuit.Columns())
+	if db.Error != nil {
+		log.Errorf("failed to reset circuit of webhook receiver: %v", db.Error)
+		c.JSON(http.StatusUnprocessableEntity, model.NewErrorMsgDTOFromErr(db.Error))
+		return
+	}
 	c.JSON(http.StatusOK, model.NewIDResponse(receiver.ID))
 }
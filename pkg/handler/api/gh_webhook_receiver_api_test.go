@@ -0,0 +1,121 @@
+package api
+
+import (
+	"fmt"
+	"gh-webhook/pkg/model"
+	"gorm.io/gorm"
+	"net/http"
+	"testing"
+)
+
+func Test_ReceiverAPI(t *testing.T) {
+	ctx := newTestContext(t)
+	if err := (&GHWebhookReceiverAPIHandler{}).Register(ctx); err != nil {
+		t.Fatal(err)
+	}
+	gh := model.GitHub{Name: "github", API: "api.github.com"}
+	ctx.Db.Create(&gh)
+
+	for _, config := range []string{
+		`{"type": "ftp", "url": "http://localhost/ci", "auth": {"type": "none"}}`,
+		`{"url": "http://localhost/ci", "auth": {"type": "none"}}`,
+		`{"type": "http", "url": "http://localhost/ci", "auth": {"type": "token", "header": "Authorization"}}`,
+		`{"type": "http", "url": "http://localhost/ci", "auth": {"type": "none"}, "parameter": "payload"}`,
+	} {
+		body := fmt.Sprintf(`{"name": "ci", "githubId": %d, "receiverConfig": %s}`, gh.ID, config)
+		if code := serve(t, ctx, http.MethodPost, "/gh-webhook-receiver/", body, nil); code != http.StatusBadRequest {
+			t.Fatalf("should reject the receiver config %s, got %d", config, code)
+		}
+	}
+
+	var created model.IDResponse
+	body := fmt.Sprintf(`{"name": "ci", "githubId": %d, "receiverConfig": {"type": "http", "url": "http://localhost/ci",
+		"auth": {"type": "token", "header": "Authorization", "token": "Bearer ci"}}}`, gh.ID)
+	if code := serve(t, ctx, http.MethodPost, "/gh-webhook-receiver/", body, &created); code != http.StatusCreated {
+		t.Fatalf("should create the receiver, got %d", code)
+	}
+	path := fmt.Sprintf("/gh-webhook-receiver/%d", created.ID)
+
+	// the secrets are write-only
+	var receiver GHWebhookReceiverSearchDTO
+	if code := serve(t, ctx, http.MethodGet, path, "", &receiver); code != http.StatusOK {
+		t.Fatalf("should get the receiver, got %d", code)
+	}
+	auth, _ := receiver.ReceiverConfig["auth"].(map[string]interface{})
+	if receiver.ReceiverConfig["type"] != model.HTTP || auth["tokenSet"] != true || auth["token"] != nil {
+		t.Fatalf("the token should be redacted, got %v", receiver.ReceiverConfig)
+	}
+
+	// the config is merged, the token isn't changed by the patch
+	body = `{"receiverConfig": {"url": "http://localhost/ci2", "headers": {"X-Env": "ci"}}}`
+	if code := serve(t, ctx, http.MethodPatch, path, body, nil); code != http.StatusOK {
+		t.Fatalf("should update the receiver, got %d", code)
+	}
+	var saved model.GHWebhookReceiver
+	ctx.Db.First(&saved, created.ID)
+	config := saved.ReceiverConfig.GetHTTPConfig()
+	if config == nil || config.URL != "http://localhost/ci2" || config.Headers["X-Env"] != "ci" ||
+		len(config.Auth.Token) == 0 {
+		t.Fatalf("unexpected receiver config %+v", saved.ReceiverConfig.Config)
+	}
+	// the circuit opened by a delivery after the receiver is loaded by the update isn't overwritten
+	opened := false
+	err := ctx.Db.Callback().Query().After("gorm:query").Register("test:open_circuit", func(tx *gorm.DB) {
+		if opened {
+			return
+		}
+		opened = true
+		tx.Session(&gorm.Session{NewDB: true}).Model(&model.GHWebhookReceiver{}).Where("id = ?", created.ID).
+			Updates(map[string]interface{}{"circuit_state": model.CircuitOpen, "circuit_failures": 5})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := serve(t, ctx, http.MethodPatch, path, `{"name": "ci2"}`, nil); code != http.StatusOK {
+		t.Fatalf("should update the receiver, got %d", code)
+	}
+	saved = model.GHWebhookReceiver{}
+	ctx.Db.First(&saved, created.ID)
+	if saved.Name != "ci2" || saved.Circuit.State != model.CircuitOpen || saved.Circuit.Failures != 5 {
+		t.Fatalf("the name should be updated and the circuit kept open, got %s %+v", saved.Name, saved.Circuit)
+	}
+
+	body = `{"receiverConfig": {"auth": {"token": null}}}`
+	if code := serve(t, ctx, http.MethodPatch, path, body, nil); code != http.StatusBadRequest {
+		t.Fatalf("should reject the token auth without token, got %d", code)
+	}
+	for _, body := range []string{`{"circuitBreaker": {"failureThreshold": -1}}`, `{"orderingKey": "after +"}`,
+		`{"transform": {"jq": ".after |"}}`} {
+		if code := serve(t, ctx, http.MethodPatch, path, body, nil); code != http.StatusBadRequest {
+			t.Fatalf("should reject the invalid update %s, got %d", body, code)
+		}
+	}
+
+	if code := serve(t, ctx, http.MethodDelete, path, "", nil); code != http.StatusNoContent {
+		t.Fatalf("should delete the receiver, got %d", code)
+	}
+	if code := serve(t, ctx, http.MethodGet, path, "", nil); code != http.StatusNotFound {
+		t.Fatalf("the deleted receiver should not be found, got %d", code)
+	}
+}
+
+func Test_ReceiverDeleteEvictsMatchers(t *testing.T) {
+	ctx := newTestContext(t)
+	if err := (&GHWebhookReceiverAPIHandler{}).Register(ctx); err != nil {
+		t.Fatal(err)
+	}
+	receiver := newTestGitHubReceiver(t, ctx.Db, "evict", model.GHWebHookSubscribe{Events: []string{"push"}})
+	sub := receiver.Subscribes[0]
+	matcher, err := model.GetSubscribeMatcher(&sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := fmt.Sprintf("/gh-webhook-receiver/%d", receiver.ID)
+	if code := serve(t, ctx, http.MethodDelete, path, "", nil); code != http.StatusNoContent {
+		t.Fatalf("should delete the receiver, got %d", code)
+	}
+	if evicted, _ := model.GetSubscribeMatcher(&sub); evicted == matcher {
+		t.Fatal("the subscribes of the deleted receiver should be evicted")
+	}
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gh-webhook/pkg/model"
+	"github.com/dranikpg/dto-mapper"
+	"github.com/gin-gonic/gin/binding"
+)
+
+type ReceiverAuthDTO struct {
+	Type         string   `json:"type" binding:"required,oneof=none basic token oauth2"`
+	Username     string   `json:"username" binding:"required_if=Type basic"`
+	Password     string   `json:"password" binding:"required_if=Type basic"`
+	Header       string   `json:"header" binding:"required_if=Type token"`
+	Token        string   `json:"token" binding:"required_if=Type token"`
+	TokenURL     string   `json:"tokenUrl" binding:"required_if=Type oauth2,omitempty,url"`
+	ClientID     string   `json:"clientId" binding:"required_if=Type oauth2"`
+	ClientSecret string   `json:"clientSecret" binding:"required_if=Type oauth2"`
+	Scopes       []string `json:"scopes"`
+	Audience     string   `json:"audience"`
+}
+
+type ReceiverTLSDTO struct {
+	CACert             string `json:"caCert"`
+	ClientCert         string `json:"clientCert" binding:"required_with=ClientKey"`
+	ClientKey          string `json:"clientKey" binding:"required_with=ClientCert"`
+	ServerName         string `json:"serverName"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+}
+
+type HTTPReceiverConfigDTO struct {
+	URL             string            `json:"url" binding:"required,url"`
+	Auth            ReceiverAuthDTO   `json:"auth" binding:"required"`
+	TLS             ReceiverTLSDTO    `json:"tls"`
+	Method          string            `json:"method" binding:"omitempty,oneof=POST PUT PATCH"`
+	Headers         map[string]string `json:"headers"`
+	Proxy           string            `json:"proxy" binding:"omitempty,url"`
+	ConnectTimeout  model.Duration    `json:"connectTimeout" binding:"gte=0"`
+	ResponseTimeout model.Duration    `json:"responseTimeout" binding:"gte=0"`
+	SuccessStatus   []string          `json:"successStatus"`
+	CancelURL       string            `json:"cancelUrl" binding:"omitempty,url"`
+}
+
+type JenkinsReceiverConfigDTO struct {
+	HTTPReceiverConfigDTO
+	Parameter string `json:"parameter" binding:"required"`
+	ServerURL string `json:"serverUrl" binding:"omitempty,url"`
+}
+
+// newReceiverConfigDTO create the typed dto, its binding tags are the json schema of the receiver type
+func newReceiverConfigDTO(receiverType string) (interface{}, error) {
+	switch receiverType {
+	case model.HTTP:
+		return &HTTPReceiverConfigDTO{}, nil
+	case model.Jenkins:
+		return &JenkinsReceiverConfigDTO{}, nil
+	default:
+		return nil, fmt.Errorf("invalid receiver type %s", receiverType)
+	}
+}
+
+// ParseReceiverConfig validate the receiver config json against the schema of its type and convert it to model
+func ParseReceiverConfig(data json.RawMessage) (model.GHWebhookReceiverConfig, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return model.GHWebhookReceiverConfig{}, err
+	}
+
+	var receiverType string
+	if err := json.Unmarshal(fields["type"], &receiverType); err != nil {
+		return model.GHWebhookReceiverConfig{}, fmt.Errorf("receiver type is required")
+	}
+	delete(fields, "type")
+
+	configDTO, err := newReceiverConfigDTO(receiverType)
+	if err != nil {
+		return model.GHWebhookReceiverConfig{}, err
+	}
+
+	typedData, err := json.Marshal(fields)
+	if err != nil {
+		return model.GHWebhookReceiverConfig{}, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(typedData))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(configDTO); err != nil {
+		return model.GHWebhookReceiverConfig{}, fmt.Errorf("invalid %s receiver config: %v", receiverType, err)
+	}
+	if err = binding.Validator.ValidateStruct(configDTO); err != nil {
+		return model.GHWebhookReceiverConfig{}, fmt.Errorf("invalid %s receiver config: %v", receiverType, err)
+	}
+
+	config, err := model.NewReceiverConfig(receiverType)
+	if err != nil {
+		return model.GHWebhookReceiverConfig{}, err
+	}
+	mapper := dto.Mapper{}
+	if err = mapper.Map(config, configDTO); err != nil {
+		return model.GHWebhookReceiverConfig{}, err
+	}
+
+	receiverConfig := model.NewGHWebhookReceiverConfig(config)
+	return receiverConfig, receiverConfig.IsValid()
+}
+
+// MergeReceiverConfig apply the json merge patch to the receiver config, null removes the field
+func MergeReceiverConfig(config model.GHWebhookReceiverConfig, patch json.RawMessage) (model.GHWebhookReceiverConfig, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return model.GHWebhookReceiverConfig{}, err
+	}
+	var current map[string]interface{}
+	if err = json.Unmarshal(data, &current); err != nil {
+		return model.GHWebhookReceiverConfig{}, err
+	}
+	var changes map[string]interface{}
+	if err = json.Unmarshal(patch, &changes); err != nil {
+		return model.GHWebhookReceiverConfig{}, err
+	}
+
+	merged, err := json.Marshal(mergePatch(current, changes))
+	if err != nil {
+		return model.GHWebhookReceiverConfig{}, err
+	}
+	return ParseReceiverConfig(merged)
+}
+
+func mergePatch(current map[string]interface{}, changes map[string]interface{}) map[string]interface{} {
+	if current == nil {
+		current = map[string]interface{}{}
+	}
+	for k, v := range changes {
+		if v == nil {
+			delete(current, k)
+			continue
+		}
+		patchMap, ok := v.(map[string]interface{})
+		if !ok {
+			current[k] = v
+			continue
+		}
+		curMap, _ := current[k].(map[string]interface{})
+		current[k] = mergePatch(curMap, patchMap)
+	}
+	return current
+}
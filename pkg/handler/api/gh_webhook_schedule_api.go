@@ -0,0 +1,275 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"gh-webhook/pkg/core"
+	"gh-webhook/pkg/model"
+	"github.com/dranikpg/dto-mapper"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"net/http"
+	"time"
+)
+
+// GHWebhookScheduleAPIHandler path: gh-webhook-schedule
+type GHWebhookScheduleAPIHandler struct {
+	db *gorm.DB
+}
+
+type GHWebhookScheduleCreateDTO struct {
+	Name     string `json:"name" binding:"required"`
+	GitHubId uint   `json:"githubId" binding:"required"`
+	Cron     string `json:"cron" binding:"required"`
+	Timezone string `json:"timezone"` // default UTC
+	Event    string `json:"event"`    // default schedule
+	Repo     string `json:"repo"`     // org/repo
+	Ref      string `json:"ref"`      // e.g. refs/heads/main
+	Payload  string `json:"payload"`  // json object template, e.g. {"scan": {{toJson .Repo}}}
+	Enabled  *bool  `json:"enabled"`
+}
+
+type GHWebhookScheduleUpdateDTO struct {
+	Name     *string `json:"name"`
+	Cron     *string `json:"cron"`
+	Timezone *string `json:"timezone"`
+	Event    *string `json:"event"`   // empty for schedule
+	Repo     *string `json:"repo"`    // empty removes the repository
+	Ref      *string `json:"ref"`     // empty removes the ref
+	Payload  *string `json:"payload"` // empty removes the template
+	Enabled  *bool   `json:"enabled"`
+}
+
+type GHWebhookScheduleSearchDTO struct {
+	ID          uint       `json:"id" rsql:"id,filter,sort"`
+	Name        string     `json:"name" rsql:"name,filter,sort"`
+	GitHubId    uint       `json:"githubId" rsql:"githubId,filter,sort"`
+	Cron        string     `json:"cron"`
+	Timezone    string     `json:"timezone"`
+	Event       string     `json:"event" rsql:"event,filter,sort"`
+	Repo        string     `json:"repo" rsql:"repo,filter,sort"`
+	Ref         string     `json:"ref"`
+	Payload     string     `json:"payload"`
+	Enabled     bool       `json:"enabled"`
+	NextRunAt   *time.Time `json:"nextRunAt" rsql:"nextRunAt,filter,sort"`
+	LastRunAt   *time.Time `json:"lastRunAt" rsql:"lastRunAt,filter,sort"`
+	LastEventId uint       `json:"lastEventId"` // the last synthetic event of the schedule
+
+	CreatedAt time.Time `json:"createdAt" `
+	UpdatedAt time.Time `json:"updatedAt" `
+}
+
+func newScheduleMapper() *dto.Mapper {
+	mapper := &dto.Mapper{}
+	mapper.AddConvFunc(func(enabled *bool) bool {
+		return enabled == nil || *enabled
+	})
+	return mapper
+}
+
+func (h *GHWebhookScheduleAPIHandler) Register(c *core.GHPRContext) error {
+	h.db = c.Db
+	c.Gin.POST(fmt.Sprintf("%s/gh-webhook-schedule/", c.Cfg.APIPrefix), h.Post)
+	c.Gin.PATCH(fmt.Sprintf("%s/gh-webhook-schedule/:id", c.Cfg.APIPrefix), h.Update)
+	c.Gin.GET(fmt.Sprintf("%s/gh-webhook-schedule/:id", c.Cfg.APIPrefix), h.Get)
+	c.Gin.DELETE(fmt.Sprintf("%s/gh-webhook-schedule/:id", c.Cfg.APIPrefix), h.Delete)
+	c.Gin.GET(fmt.Sprintf("%s/gh-webhook-schedule", c.Cfg.APIPrefix), h.List)
+	return nil
+}
+
+// Post create a new schedule
+func (h *GHWebhookScheduleAPIHandler) Post(c *gin.Context) {
+	var createDTO GHWebhookScheduleCreateDTO
+	if err := c.ShouldBindJSON(&createDTO); err != nil {
+		log.Errorf("failed to bind json: %v", err)
+		c.JSON(http.StatusBadRequest, model.NewErrorMsgDTOFromErr(err))
+		return
+	}
+
+	var github model.GitHub
+	db := h.db.First(&github, "id = ?", createDTO.GitHubId)
+	if db.Error != nil {
+		log.Errorf("failed to find github: %v", db.Error)
+		if errors.Is(db.Error, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusBadRequest, model.NewErrorMsgDTO(fmt.Sprintf("github %d not found", createDTO.GitHubId)))
+			return
+		}
+		c.JSON(http.StatusUnprocessableEntity, model.NewErrorMsgDTOFromErr(db.Error))
+		return
+	}
+
+	schedule := model.GHWebhookSchedule{
+		Name:     createDTO.Name,
+		Cron:     createDTO.Cron,
+		Timezone: createDTO.Timezone,
+		GitHubId: createDTO.GitHubId,
+		GitHub:   github,
+		Event:    createDTO.Event,
+		Repo:     createDTO.Repo,
+		Ref:      createDTO.Ref,
+		Payload:  createDTO.Payload,
+		Enabled:  createDTO.Enabled,
+	}
+	if !h.setNextRun(c, &schedule) {
+		return
+	}
+
+	db = h.db.Save(&schedule)
+	if db.Error != nil {
+		log.Errorf("failed to save schedule: %v", db.Error)
+		c.JSON(http.StatusUnprocessableEntity, model.NewErrorMsgDTOFromErr(db.Error))
+		return
+	}
+	c.JSON(http.StatusCreated, model.NewIDResponse(schedule.ID))
+}
+
+// setNextRun validate the schedule and set its next activation from now, the activations missed while it was
+// disabled aren't triggered
+func (h *GHWebhookScheduleAPIHandler) setNextRun(c *gin.Context, schedule *model.GHWebhookSchedule) bool {
+	if err := schedule.IsValid(); err != nil {
+		log.Errorf("invalid request: %v", err)
+		c.JSON(http.StatusBadRequest, model.NewErrorMsgDTOFromErr(err))
+		return false
+	}
+	schedule.NextRunAt = nil
+	if !schedule.IsEnabled() {
+		return true
+	}
+	next, err := schedule.Next(time.Now())
+	if err != nil {
+		log.Errorf("invalid request: %v", err)
+		c.JSON(http.StatusBadRequest, model.NewErrorMsgDTOFromErr(err))
+		return false
+	}
+	schedule.NextRunAt = next
+	return true
+}
+
+// Get get schedule
+func (h *GHWebhookScheduleAPIHandler) Get(c *gin.Context) {
+	id := core.GetPathVarUInt(c, "id")
+	if id == nil {
+		return
+	}
+	schedule := model.GHWebhookSchedule{}
+	if !core.GetModel(c, h.db, &schedule, "id = ?", *id) {
+		return
+	}
+	to := GHWebhookScheduleSearchDTO{}
+	if err := newScheduleMapper().Map(&to, schedule); err != nil {
+		log.Errorf("failed to map: %v", err)
+		c.JSON(http.StatusInternalServerError, model.NewErrorMsgDTOFromErr(err))
+		return
+	}
+	c.JSON(http.StatusOK, to)
+}
+
+// Delete delete schedule, its events are kept
+func (h *GHWebhookScheduleAPIHandler) Delete(c *gin.Context) {
+	id := core.GetPathVarUInt(c, "id")
+	if id == nil {
+		return
+	}
+	db := h.db.Delete(&model.GHWebhookSchedule{}, "id = ?", *id)
+	if db.Error != nil {
+		log.Errorf("failed to delete schedule: %v", db.Error)
+		c.JSON(http.StatusUnprocessableEntity, model.NewErrorMsgDTOFromErr(db.Error))
+		return
+	}
+	if db.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, model.NewErrorMsgDTO(http.StatusText(http.StatusNotFound)))
+		return
+	}
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// List list schedules
+func (h *GHWebhookScheduleAPIHandler) List(c *gin.Context) {
+	var schedules []model.GHWebhookSchedule
+	if !core.SearchModel(c, h.db, GHWebhookScheduleSearchDTO{}, &schedules) {
+		return
+	}
+	var scheduleDTOs []GHWebhookScheduleSearchDTO
+	if err := newScheduleMapper().Map(&scheduleDTOs, schedules); err != nil {
+		log.Errorf("failed to map: %v", err)
+		c.JSON(http.StatusInternalServerError, model.NewErrorMsgDTOFromErr(err))
+		return
+	}
+	c.JSON(http.StatusOK, model.NewListResponse(scheduleDTOs))
+}
+
+// Update update schedule, the next activation is computed again from now
+func (h *GHWebhookScheduleAPIHandler) Update(c *gin.Context) {
+	id := core.GetPathVarUInt(c, "id")
+	if id == nil {
+		return
+	}
+	updateDTO := GHWebhookScheduleUpdateDTO{}
+	if err := c.ShouldBindJSON(&updateDTO); err != nil {
+		log.Errorf("invalid request: %v", err)
+		c.JSON(http.StatusBadRequest, model.NewErrorMsgDTOFromErr(err))
+		return
+	}
+
+	schedule := model.GHWebhookSchedule{}
+	if !core.GetModel(c, h.db, &schedule, "id = ?", *id) {
+		return
+	}
+
+	updateCnt := 0
+	if updateDTO.Name != nil {
+		schedule.Name = *updateDTO.Name
+		updateCnt++
+	}
+
+	if updateDTO.Cron != nil {
+		schedule.Cron = *updateDTO.Cron
+		updateCnt++
+	}
+
+	if updateDTO.Timezone != nil {
+		schedule.Timezone = *updateDTO.Timezone
+		updateCnt++
+	}
+
+	if updateDTO.Event != nil {
+		schedule.Event = *updateDTO.Event
+		updateCnt++
+	}
+
+	if updateDTO.Repo != nil {
+		schedule.Repo = *updateDTO.Repo
+		updateCnt++
+	}
+
+	if updateDTO.Ref != nil {
+		schedule.Ref = *updateDTO.Ref
+		updateCnt++
+	}
+
+	if updateDTO.Payload != nil {
+		schedule.Payload = *updateDTO.Payload
+		updateCnt++
+	}
+
+	if updateDTO.Enabled != nil {
+		schedule.Enabled = updateDTO.Enabled
+		updateCnt++
+	}
+
+	if updateCnt <= 0 {
+		c.JSON(http.StatusBadRequest, model.NewErrorMsgDTO("no field to update"))
+		return
+	}
+	if !h.setNextRun(c, &schedule) {
+		return
+	}
+	db := h.db.Save(&schedule)
+	if db.Error != nil {
+		log.Errorf("failed to update schedule: %v", db.Error)
+		c.JSON(http.StatusUnprocessableEntity, model.NewErrorMsgDTOFromErr(db.Error))
+		return
+	}
+	c.JSON(http.StatusOK, model.NewIDResponse(schedule.ID))
+}
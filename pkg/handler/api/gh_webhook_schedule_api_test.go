@@ -0,0 +1,74 @@
+package api
+
+import (
+	"fmt"
+	"gh-webhook/pkg/model"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_ScheduleAPI(t *testing.T) {
+	ctx := newTestContext(t)
+	if err := (&GHWebhookScheduleAPIHandler{}).Register(ctx); err != nil {
+		t.Fatal(err)
+	}
+	gh := model.GitHub{Name: "github", API: "api.github.com"}
+	ctx.Db.Create(&gh)
+
+	for _, body := range []string{
+		fmt.Sprintf(`{"name": "nightly", "githubId": %d, "cron": "0 2 * *"}`, gh.ID),
+		fmt.Sprintf(`{"name": "nightly", "githubId": %d, "cron": "0 2 * * *", "ref": "main"}`, gh.ID),
+		fmt.Sprintf(`{"name": "nightly", "githubId": %d, "cron": "0 2 * * *", "payload": "[1]"}`, gh.ID),
+		`{"name": "nightly", "githubId": 404, "cron": "0 2 * * *"}`,
+	} {
+		if code := serve(t, ctx, http.MethodPost, "/gh-webhook-schedule/", body, nil); code != http.StatusBadRequest {
+			t.Fatalf("should reject the schedule %s, got %d", body, code)
+		}
+	}
+
+	var created model.IDResponse
+	body := fmt.Sprintf(`{"name": "nightly", "githubId": %d, "cron": "0 2 * * *", "repo": "octo/mono",
+		"ref": "refs/heads/main", "payload": "{\"scan\": {{toJson .Repo}}}"}`, gh.ID)
+	if code := serve(t, ctx, http.MethodPost, "/gh-webhook-schedule/", body, &created); code != http.StatusCreated {
+		t.Fatalf("should create the schedule, got %d", code)
+	}
+	path := fmt.Sprintf("/gh-webhook-schedule/%d", created.ID)
+
+	var schedule GHWebhookScheduleSearchDTO
+	if code := serve(t, ctx, http.MethodGet, path, "", &schedule); code != http.StatusOK {
+		t.Fatalf("should get the schedule, got %d", code)
+	}
+	if !schedule.Enabled || schedule.NextRunAt == nil || !schedule.NextRunAt.After(time.Now()) {
+		t.Fatalf("the enabled schedule should have the next run, got %+v", schedule)
+	}
+
+	// the disabled schedule has no next run
+	if code := serve(t, ctx, http.MethodPatch, path, `{"enabled": false}`, nil); code != http.StatusOK {
+		t.Fatalf("should disable the schedule, got %d", code)
+	}
+	schedule = GHWebhookScheduleSearchDTO{}
+	serve(t, ctx, http.MethodGet, path, "", &schedule)
+	if schedule.Enabled || schedule.NextRunAt != nil {
+		t.Fatalf("the disabled schedule should have no next run, got %+v", schedule)
+	}
+	if code := serve(t, ctx, http.MethodPatch, path, `{}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("should reject the update without fields, got %d", code)
+	}
+	if code := serve(t, ctx, http.MethodPatch, path, `{"cron": "* *"}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("should reject the invalid cron, got %d", code)
+	}
+
+	var list model.ListResponse[GHWebhookScheduleSearchDTO]
+	code := serve(t, ctx, http.MethodGet, `/gh-webhook-schedule?filter=repo=="octo/mono"`, "", &list)
+	if code != http.StatusOK || len(list.Entries) != 1 || list.Entries[0].ID != created.ID {
+		t.Fatalf("should list the schedule of the repo, got %d %+v", code, list.Entries)
+	}
+
+	if code := serve(t, ctx, http.MethodDelete, path, "", nil); code != http.StatusNoContent {
+		t.Fatalf("should delete the schedule, got %d", code)
+	}
+	if code := serve(t, ctx, http.MethodGet, path, "", nil); code != http.StatusNotFound {
+		t.Fatalf("the deleted schedule should not be found, got %d", code)
+	}
+}
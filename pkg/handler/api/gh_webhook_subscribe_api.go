@@ -4,17 +4,20 @@ import (
 	"errors"
 	"fmt"
 	"gh-webhook/pkg/core"
+	"gh-webhook/pkg/github"
 	"gh-webhook/pkg/model"
 	"github.com/dranikpg/dto-mapper"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 	"net/http"
+	"slices"
 )
 
 // GHWebhookSubscribeAPIHandler path: gh-webhook-receiver/<receiver id>/subscribe
 type GHWebhookSubscribeAPIHandler struct {
-	db *gorm.DB
+	db       *gorm.DB
+	ghClient *github.Client
 }
 
 type GHWebhookFieldCreateDTO struct {
@@ -22,21 +25,60 @@ type GHWebhookFieldCreateDTO struct {
 	NegativeMatches []string                           `json:"negativeMatches"`
 	Child           map[string]GHWebhookFieldCreateDTO `json:"child"`
 	Expr            string                             `json:"expr"`
+	Missing         string                             `json:"missing" binding:"omitempty,oneof=fail match"`
 }
 
 type GHWebhookSubscribeCreateDTO struct {
 	GHWebHookReceiverID uint
-	Event               string `json:"event"`
-
-	Filters map[string]GHWebhookFieldCreateDTO `json:"filters"`
+	Event               string   `json:"event"` // single event, kept for compatibility
+	Events              []string `json:"events"`
+	Actions             []string `json:"actions"`
+	Org                 string   `json:"org"`
+	Repo                string   `json:"repo"`
+	Branches            []string `json:"branches"`
+	BranchesIgnore      []string `json:"branchesIgnore"`
+	Tags                []string `json:"tags"`
+	TagsIgnore          []string `json:"tagsIgnore"`
+	Paths               []string `json:"paths"`
+	PathsIgnore         []string `json:"pathsIgnore"`
+
+	Labels             *model.LabelFilter `json:"labels"`
+	Senders            *model.LoginFilter `json:"senders"`
+	Authors            *model.LoginFilter `json:"authors"`
+	AuthorAssociations []string           `json:"authorAssociations"`
+	Teams              []string           `json:"teams"`
+
+	Filters     map[string]GHWebhookFieldCreateDTO `json:"filters"`
+	Match       *model.GHWebhookMatch              `json:"match"`
+	Debounce    *model.DebounceConfig              `json:"debounce"`
+	Concurrency *model.ConcurrencyConfig           `json:"concurrency"`
 }
 
 type GHWebhookSubscribeSearchDTO struct {
-	ID                  uint   `json:"id" rsql:"id,filter,sort"`
-	GHWebHookReceiverID uint   `json:"recieverId" rsql:"recieverId,filter,sort"`
-	Event               string `json:"event"`
-
-	Filters map[string]GHWebhookFieldSearchDTO `json:"filters"`
+	ID                  uint     `json:"id" rsql:"id,filter,sort"`
+	GHWebHookReceiverID uint     `json:"recieverId" rsql:"recieverId,filter,sort" gorm:"column:gh_webhook_receiver_id"`
+	Event               string   `json:"event"` // deprecated, the first of the events, kept for compatibility
+	Events              []string `json:"events"`
+	Actions             []string `json:"actions"`
+	Org                 string   `json:"org"`
+	Repo                string   `json:"repo"`
+	Branches            []string `json:"branches"`
+	BranchesIgnore      []string `json:"branchesIgnore"`
+	Tags                []string `json:"tags"`
+	TagsIgnore          []string `json:"tagsIgnore"`
+	Paths               []string `json:"paths"`
+	PathsIgnore         []string `json:"pathsIgnore"`
+
+	Labels             *model.LabelFilter `json:"labels"`
+	Senders            *model.LoginFilter `json:"senders"`
+	Authors            *model.LoginFilter `json:"authors"`
+	AuthorAssociations []string           `json:"authorAssociations"`
+	Teams              []string           `json:"teams"`
+
+	Filters     map[string]GHWebhookFieldSearchDTO `json:"filters"`
+	Match       *model.GHWebhookMatch              `json:"match"`
+	Debounce    *model.DebounceConfig              `json:"debounce"`
+	Concurrency *model.ConcurrencyConfig           `json:"concurrency"`
 }
 
 type GHWebhookFieldSearchDTO struct {
@@ -44,12 +86,32 @@ type GHWebhookFieldSearchDTO struct {
 	NegativeMatches []string                           `json:"negativeMatches"`
 	Child           map[string]GHWebhookFieldSearchDTO `json:"child"`
 	Expr            string                             `json:"expr"`
+	Missing         string                             `json:"missing"`
 }
 
 type GHWebhookSubscribeUpdateDTO struct {
-	Event string `json:"event"`
-
-	Filters map[string]GHWebhookFieldUpdateDTO `json:"filters"`
+	Event          string    `json:"event"` // single event, kept for compatibility
+	Events         []string  `json:"events"`
+	Actions        *[]string `json:"actions"`        // [] removes the actions
+	Org            *string   `json:"org"`            // empty removes the org scope
+	Repo           *string   `json:"repo"`           // empty removes the repo scope
+	Branches       *[]string `json:"branches"`       // [] removes the branches
+	BranchesIgnore *[]string `json:"branchesIgnore"` // [] removes the ignored branches
+	Tags           *[]string `json:"tags"`           // [] removes the tags
+	TagsIgnore     *[]string `json:"tagsIgnore"`     // [] removes the ignored tags
+	Paths          *[]string `json:"paths"`          // [] removes the paths
+	PathsIgnore    *[]string `json:"pathsIgnore"`    // [] removes the ignored paths
+
+	Labels             *model.LabelFilter `json:"labels"`             // {} removes the labels
+	Senders            *model.LoginFilter `json:"senders"`            // {} removes the senders
+	Authors            *model.LoginFilter `json:"authors"`            // {} removes the authors
+	AuthorAssociations *[]string          `json:"authorAssociations"` // [] removes the author associations
+	Teams              *[]string          `json:"teams"`              // [] removes the teams
+
+	Filters     map[string]GHWebhookFieldUpdateDTO `json:"filters"`
+	Match       *model.GHWebhookMatch              `json:"match"`       // {} removes the match
+	Debounce    *model.DebounceConfig              `json:"debounce"`    // {} removes the debounce
+	Concurrency *model.ConcurrencyConfig           `json:"concurrency"` // {} removes the concurrency
 }
 
 type GHWebhookFieldUpdateDTO struct {
@@ -57,15 +119,19 @@ type GHWebhookFieldUpdateDTO struct {
 	NegativeMatches []string                           `json:"negativeMatches"`
 	Child           map[string]GHWebhookFieldUpdateDTO `json:"child"`
 	Expr            string                             `json:"expr"`
+	Missing         string                             `json:"missing" binding:"omitempty,oneof=fail match"`
 }
 
 func (h *GHWebhookSubscribeAPIHandler) Register(c *core.GHPRContext) error {
 	h.db = c.Db
+	h.ghClient = github.NewClient(c.Secrets)
 	c.Gin.POST(fmt.Sprintf("%s/gh-webhook-receiver/:id/subscribe", c.Cfg.APIPrefix), h.Post)
 	c.Gin.PATCH(fmt.Sprintf("%s/gh-webhook-receiver/:id/subscribe/:cId", c.Cfg.APIPrefix), h.Update)
 	c.Gin.GET(fmt.Sprintf("%s/gh-webhook-receiver/:id/subscribe/:cId", c.Cfg.APIPrefix), h.Get)
 	c.Gin.DELETE(fmt.Sprintf("%s/gh-webhook-receiver/:id/subscribe/:cId", c.Cfg.APIPrefix), h.Delete)
 	c.Gin.GET(fmt.Sprintf("%s/gh-webhook-receiver/:id/subscribe", c.Cfg.APIPrefix), h.List)
+	c.Gin.POST(fmt.Sprintf("%s/gh-webhook-receiver/:id/subscribe/test", c.Cfg.APIPrefix), h.DryRun)
+	c.Gin.POST(fmt.Sprintf("%s/gh-webhook-receiver/:id/subscribe/:cId/test", c.Cfg.APIPrefix), h.DryRunSubscribe)
 	return nil
 }
 
@@ -96,29 +162,25 @@ func (h *GHWebhookSubscribeAPIHandler) Post(c *gin.Context) {
 		c.JSON(http.StatusUnprocessableEntity, model.NewErrorMsgDTOFromErr(err))
 		return
 	}
-	mapper := dto.Mapper{}
-	var filters map[string]model.GHWebhookField
-	err = mapper.Map(&filters, createDto.Filters)
+	sub, err := newSubscribe(receiver, createDto)
 	if err != nil {
 		log.Errorf("failed to bind json: %v", err)
 		c.JSON(http.StatusUnprocessableEntity, model.NewErrorMsgDTOFromErr(err))
 		return
 	}
 
-	sub := model.GHWebHookSubscribe{
-		GHWebhookReceiverID: pId,
-		GHWebhookReceiver:   receiver,
-		Event:               createDto.Event,
-		Filters:             filters,
-	}
-
 	if err = sub.IsValid(); err != nil {
 		log.Errorf("invalid request: %v", err)
 		c.JSON(http.StatusBadRequest, model.NewErrorMsgDTOFromErr(err))
 		return
 	}
+	if _, err = model.CompileSubscribe(&sub); err != nil {
+		log.Errorf("failed to compile webhook receiver subscribe: %v", err)
+		c.JSON(http.StatusBadRequest, model.NewErrorMsgDTOFromErr(err))
+		return
+	}
 
-	h.db.Save(&sub)
+	db = h.db.Save(&sub)
 	if db.Error != nil {
 		log.Errorf("failed to save webhook receiver: %v", db.Error)
 		c.JSON(http.StatusUnprocessableEntity, model.NewErrorMsgDTOFromErr(db.Error))
@@ -176,39 +238,24 @@ func (h *GHWebhookSubscribeAPIHandler) Get(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, model.NewErrorMsgDTOFromErr(err))
 		return
 	}
+	to.Event = legacyEvent(to.Events)
 	c.JSON(http.StatusOK, to)
 }
 
 func (h *GHWebhookSubscribeAPIHandler) Update(c *gin.Context) {
-	pId, err := core.UIntParam(c, "pId")
+	pId, err := core.UIntParam(c, "id")
 	if err != nil {
 		log.Errorf("failed to convert pId: %v", err)
 		c.JSON(http.StatusBadRequest, model.NewErrorMsgDTOFromErr(err))
 		return
 	}
-
-	receiver := model.GHWebHookSubscribe{}
-	db := h.db.First(&receiver, "id = ?", pId)
-	if db.Error != nil {
-		log.Errorf("failed to find webhook receiver: %v", db.Error)
-		if errors.Is(db.Error, gorm.ErrRecordNotFound) {
-			c.JSON(http.StatusBadRequest, model.NewErrorMsgDTO(http.StatusText(http.StatusBadRequest)))
-			return
-		}
-		c.JSON(http.StatusUnprocessableEntity, model.NewErrorMsgDTOFromErr(db.Error))
+	cId := core.GetPathVarUInt(c, "cId")
+	if cId == nil {
 		return
 	}
 
 	sub := model.GHWebHookSubscribe{}
-
-	db = h.db.First(&sub, "id = ?", receiver.ID)
-	if db.Error != nil {
-		log.Errorf("failed to find webhook receiver subscribe: %v", db.Error)
-		if errors.Is(db.Error, gorm.ErrRecordNotFound) {
-			c.JSON(http.StatusNotFound, model.NewErrorMsgDTO(http.StatusText(http.StatusNotFound)))
-			return
-		}
-		c.JSON(http.StatusUnprocessableEntity, model.NewErrorMsgDTOFromErr(db.Error))
+	if !core.GetModel(c, h.db, &sub, "id = ?", *cId) {
 		return
 	}
 
@@ -224,28 +271,115 @@ func (h *GHWebhookSubscribeAPIHandler) Update(c *gin.Context) {
 		return
 	}
 
-	if len(updateDto.Event) <= 0 && len(updateDto.Filters) <= 0 {
+	if len(updateDto.Event) <= 0 && len(updateDto.Events) <= 0 && updateDto.Actions == nil &&
+		updateDto.Org == nil && updateDto.Repo == nil && updateDto.Branches == nil &&
+		updateDto.BranchesIgnore == nil && updateDto.Tags == nil && updateDto.TagsIgnore == nil &&
+		updateDto.Paths == nil && updateDto.PathsIgnore == nil &&
+		updateDto.Labels == nil && updateDto.Senders == nil && updateDto.Authors == nil &&
+		updateDto.AuthorAssociations == nil && updateDto.Teams == nil &&
+		len(updateDto.Filters) <= 0 && updateDto.Match == nil &&
+		updateDto.Debounce == nil &&
+		updateDto.Concurrency == nil {
 		c.JSON(http.StatusBadRequest, model.NewErrorMsgDTO("no field to update"))
 		return
 	}
 
-	sub.Event = updateDto.Event
-	mapper := dto.Mapper{}
-	var filters map[string]model.GHWebhookField
-	err = mapper.Map(&filters, updateDto.Filters)
-	if err != nil {
-		log.Errorf("failed to bind json: %v", err)
-		c.JSON(http.StatusUnprocessableEntity, model.NewErrorMsgDTOFromErr(err))
-		return
+	if len(updateDto.Event) > 0 || len(updateDto.Events) > 0 {
+		sub.Events = mergeEvents(updateDto.Event, updateDto.Events)
+	}
+	if updateDto.Actions != nil {
+		sub.Actions = *updateDto.Actions
+	}
+	if updateDto.Org != nil {
+		sub.Org = *updateDto.Org
+	}
+	if updateDto.Repo != nil {
+		sub.Repo = *updateDto.Repo
+	}
+	if updateDto.Branches != nil {
+		sub.Branches = *updateDto.Branches
+	}
+	if updateDto.BranchesIgnore != nil {
+		sub.BranchesIgnore = *updateDto.BranchesIgnore
+	}
+	if updateDto.Tags != nil {
+		sub.Tags = *updateDto.Tags
+	}
+	if updateDto.TagsIgnore != nil {
+		sub.TagsIgnore = *updateDto.TagsIgnore
+	}
+	if updateDto.Paths != nil {
+		sub.Paths = *updateDto.Paths
+	}
+	if updateDto.PathsIgnore != nil {
+		sub.PathsIgnore = *updateDto.PathsIgnore
+	}
+	if updateDto.Labels != nil {
+		sub.Labels = updateDto.Labels
+		if len(updateDto.Labels.Include) == 0 && len(updateDto.Labels.Exclude) == 0 {
+			sub.Labels = nil
+		}
+	}
+	if updateDto.Senders != nil {
+		sub.Senders = updateDto.Senders
+		if len(updateDto.Senders.Allow) == 0 && len(updateDto.Senders.Deny) == 0 {
+			sub.Senders = nil
+		}
+	}
+	if updateDto.Authors != nil {
+		sub.Authors = updateDto.Authors
+		if len(updateDto.Authors.Allow) == 0 && len(updateDto.Authors.Deny) == 0 {
+			sub.Authors = nil
+		}
+	}
+	if updateDto.AuthorAssociations != nil {
+		sub.AuthorAssociations = *updateDto.AuthorAssociations
+	}
+	if updateDto.Teams != nil {
+		sub.Teams = *updateDto.Teams
+	}
+	if len(updateDto.Filters) > 0 {
+		mapper := dto.Mapper{}
+		var filters map[string]model.GHWebhookField
+		err = mapper.Map(&filters, updateDto.Filters)
+		if err != nil {
+			log.Errorf("failed to bind json: %v", err)
+			c.JSON(http.StatusUnprocessableEntity, model.NewErrorMsgDTOFromErr(err))
+			return
+		}
+		sub.Filters = filters
+	}
+	if updateDto.Match != nil {
+		sub.Match = updateDto.Match
+		if len(updateDto.Match.All) == 0 && len(updateDto.Match.Any) == 0 && updateDto.Match.Not == nil &&
+			len(updateDto.Match.Filters) == 0 {
+			sub.Match = nil
+		}
+	}
+	if updateDto.Debounce != nil {
+		sub.Debounce = updateDto.Debounce
+		if len(updateDto.Debounce.Key) == 0 && updateDto.Debounce.Window == 0 {
+			sub.Debounce = nil
+		}
+	}
+	if updateDto.Concurrency != nil {
+		sub.Concurrency = updateDto.Concurrency
+		if len(updateDto.Concurrency.Group) == 0 {
+			sub.Concurrency = nil
+		}
 	}
-	sub.Filters = filters
 	if err = sub.IsValid(); err != nil {
 		log.Errorf("invalid request: %v", err)
 		c.JSON(http.StatusBadRequest, model.NewErrorMsgDTOFromErr(err))
 		return
 	}
+	if _, err = model.CompileSubscribe(&sub); err != nil {
+		log.Errorf("failed to compile webhook receiver subscribe: %v", err)
+		c.JSON(http.StatusBadRequest, model.NewErrorMsgDTOFromErr(err))
+		return
+	}
 
-	db = h.db.Save(&sub)
+	db := h.db.Save(&sub)
 	if db.Error != nil {
 		log.Errorf("failed to update webhook receiver subscribe: %v", db.Error)
 		c.JSON(http.StatusUnprocessableEntity, model.NewErrorMsgDTOFromErr(db.Error))
@@ -255,49 +389,34 @@ func (h *GHWebhookSubscribeAPIHandler) Update(c *gin.Context) {
 }
 
 func (h *GHWebhookSubscribeAPIHandler) Delete(c *gin.Context) {
-	pId, err := core.UIntParam(c, "pId")
+	pId, err := core.UIntParam(c, "id")
 	if err != nil {
 		log.Errorf("failed to convert pId: %v", err)
 		c.JSON(http.StatusBadRequest, model.NewErrorMsgDTOFromErr(err))
 		return
 	}
-
-	receiver := model.GHWebHookSubscribe{}
-	db := h.db.First(&receiver, "id = ?", pId)
-	if db.Error != nil {
-		log.Errorf("failed to find webhook receiver: %v", db.Error)
-		if errors.Is(db.Error, gorm.ErrRecordNotFound) {
-			c.JSON(http.StatusBadRequest, model.NewErrorMsgDTO(http.StatusText(http.StatusBadRequest)))
-			return
-		}
-		c.JSON(http.StatusUnprocessableEntity, model.NewErrorMsgDTOFromErr(db.Error))
+	cId := core.GetPathVarUInt(c, "cId")
+	if cId == nil {
 		return
 	}
 
 	sub := model.GHWebHookSubscribe{}
-
-	db = h.db.First(&sub, "id = ?", receiver.ID)
-	if db.Error != nil {
-		log.Errorf("failed to find webhook receiver subscribe: %v", db.Error)
-		if errors.Is(db.Error, gorm.ErrRecordNotFound) {
-			c.JSON(http.StatusNotFound, model.NewErrorMsgDTO(http.StatusText(http.StatusNotFound)))
-			return
-		}
-		c.JSON(http.StatusUnprocessableEntity, model.NewErrorMsgDTOFromErr(db.Error))
+	if !core.GetModel(c, h.db, &sub, "id = ?", *cId) {
 		return
 	}
 
 	if sub.GHWebhookReceiverID != pId {
-		c.JSON(http.StatusBadRequest, model.NewErrorMsgDTO("cannot update receiver"))
+		c.JSON(http.StatusBadRequest, model.NewErrorMsgDTO("cannot delete receiver"))
 		return
 	}
 
-	db = h.db.Delete(&sub)
+	db := h.db.Delete(&sub)
 	if db.Error != nil {
 		log.Errorf("failed to delete webhook receiver subscribe: %v", db.Error)
 		c.JSON(http.StatusUnprocessableEntity, model.NewErrorMsgDTOFromErr(db.Error))
 		return
 	}
+	model.EvictSubscribeMatcher(sub.ID)
 	c.JSON(http.StatusNoContent, nil)
 }
 
@@ -328,6 +447,58 @@ func (h *GHWebhookSubscribeAPIHandler) List(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, model.NewErrorMsgDTOFromErr(err))
 		return
 	}
+	for i := range subDTOs {
+		subDTOs[i].Event = legacyEvent(subDTOs[i].Events)
+	}
 
 	c.JSON(http.StatusOK, model.NewListResponse(subDTOs))
 }
+
+func newSubscribe(receiver model.GHWebhookReceiver, createDto GHWebhookSubscribeCreateDTO) (model.GHWebHookSubscribe,
+	error) {
+	mapper := dto.Mapper{}
+	var filters map[string]model.GHWebhookField
+	if err := mapper.Map(&filters, createDto.Filters); err != nil {
+		return model.GHWebHookSubscribe{}, err
+	}
+
+	return model.GHWebHookSubscribe{
+		GHWebhookReceiverID: receiver.ID,
+		GHWebhookReceiver:   receiver,
+		Events:              mergeEvents(createDto.Event, createDto.Events),
+		Actions:             createDto.Actions,
+		Org:                 createDto.Org,
+		Repo:                createDto.Repo,
+		Branches:            createDto.Branches,
+		BranchesIgnore:      createDto.BranchesIgnore,
+		Tags:                createDto.Tags,
+		TagsIgnore:          createDto.TagsIgnore,
+		Paths:               createDto.Paths,
+		PathsIgnore:         createDto.PathsIgnore,
+		Labels:              createDto.Labels,
+		Senders:             createDto.Senders,
+		Authors:             createDto.Authors,
+		AuthorAssociations:  createDto.AuthorAssociations,
+		Teams:               createDto.Teams,
+		Filters:             filters,
+		Match:               createDto.Match,
+		Debounce:            createDto.Debounce,
+		Concurrency:         createDto.Concurrency,
+	}, nil
+}
+
+// mergeEvents the events with the legacy single event
+func mergeEvents(event string, events []string) []string {
+	if len(event) > 0 && !slices.Contains(events, event) {
+		return append([]string{event}, events...)
+	}
+	return events
+}
+
+// legacyEvent the single event of the clients before the events, the first of the events
+func legacyEvent(events []string) string {
+	if len(events) == 0 {
+		return ""
+	}
+	return events[0]
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"gh-webhook/pkg/config"
+	"gh-webhook/pkg/core"
+	"gh-webhook/pkg/model"
+	"gh-webhook/pkg/secret"
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestContext the context to register the handlers with a new db, the routes have no api prefix
+func newTestContext(t *testing.T) *core.GHPRContext {
+	gin.SetMode(gin.TestMode)
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "gh_pr.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = model.Init(db); err != nil {
+		t.Fatal(err)
+	}
+	return &core.GHPRContext{
+		Gin:     gin.New(),
+		Db:      db,
+		Cfg:     &config.Config{},
+		Secrets: secret.NewResolver(time.Minute),
+	}
+}
+
+// serve the request with the json body, the response is decoded into result unless it's nil
+func serve(t *testing.T, ctx *core.GHPRContext, method string, path string, body string, result interface{}) int {
+	recorder := httptest.NewRecorder()
+	ctx.Gin.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+	if result != nil && recorder.Code < http.StatusMultipleChoices {
+		if err := json.Unmarshal(recorder.Body.Bytes(), result); err != nil {
+			t.Fatalf("invalid response of %s %s: %v, %s", method, path, err, recorder.Body.String())
+		}
+	}
+	return recorder.Code
+}
+
+// newTestGitHubReceiver create a github and a receiver of it with the subscribes
+func newTestGitHubReceiver(t *testing.T, db *gorm.DB, name string,
+	subscribes ...model.GHWebHookSubscribe) model.GHWebhookReceiver {
+	gh := model.GitHub{Name: name, API: name + ".github.com"}
+	if err := db.Create(&gh).Error; err != nil {
+		t.Fatal(err)
+	}
+	receiver := model.GHWebhookReceiver{
+		Name:     name,
+		GitHubId: gh.ID,
+		ReceiverConfig: model.NewGHWebhookReceiverConfig(&model.HTTPReceiverConfig{
+			URL:  "http://localhost/" + name,
+			Auth: model.ReceiverAuth{Type: model.NoneAuth},
+		}),
+		Subscribes: subscribes,
+	}
+	if err := db.Create(&receiver).Error; err != nil {
+		t.Fatal(err)
+	}
+	return receiver
+}
+
+func Test_SubscribeListByReceiver(t *testing.T) {
+	ctx := newTestContext(t)
+	if err := (&GHWebhookSubscribeAPIHandler{}).Register(ctx); err != nil {
+		t.Fatal(err)
+	}
+	newTestGitHubReceiver(t, ctx.Db, "first", model.GHWebHookSubscribe{Events: []string{"push"}})
+	second := newTestGitHubReceiver(t, ctx.Db, "second", model.GHWebHookSubscribe{Events: []string{"issues"}},
+		model.GHWebHookSubscribe{Events: []string{"pull_request"}})
+
+	var list model.ListResponse[GHWebhookSubscribeSearchDTO]
+	path := fmt.Sprintf("/gh-webhook-receiver/%d/subscribe?filter=recieverId==%d&sort=id,desc", second.ID, second.ID)
+	if code := serve(t, ctx, http.MethodGet, path, "", &list); code != http.StatusOK {
+		t.Fatalf("should list the subscribes, got %d", code)
+	}
+	if len(list.Entries) != 2 || list.Entries[0].Events[0] != "pull_request" || list.Entries[1].Events[0] != "issues" {
+		t.Fatalf("should list the subscribes of the second receiver, got %+v", list.Entries)
+	}
+	if list.Entries[0].Event != "pull_request" {
+		t.Fatalf("the deprecated event should be the first of the events, got %+v", list.Entries[0])
+	}
+
+	// the deprecated event of the old clients is the first of the events
+	var created model.IDResponse
+	path = fmt.Sprintf("/gh-webhook-receiver/%d/subscribe", second.ID)
+	if code := serve(t, ctx, http.MethodPost, path, `{"event": "release"}`, &created); code != http.StatusCreated {
+		t.Fatalf("should create the subscribe of the deprecated event, got %d", code)
+	}
+	var sub GHWebhookSubscribeSearchDTO
+	serve(t, ctx, http.MethodGet, fmt.Sprintf("%s/%d", path, created.ID), "", &sub)
+	if sub.Event != "release" || !slices.Equal(sub.Events, []string{"release"}) {
+		t.Fatalf("the deprecated event should be moved to the events, got %+v", sub)
+	}
+}
+
+// Test_SearchColumns the filter and sort fields of the search DTOs are columns of their models
+func Test_SearchColumns(t *testing.T) {
+	db := newTestContext(t).Db
+	searches := []struct {
+		dto   interface{}
+		model interface{}
+	}{
+		{GitHubSearchDTO{}, model.GitHub{}},
+		{GHWebhookReceiverSearchDTO{}, model.GHWebhookReceiver{}},
+		{GHWebhookSubscribeSearchDTO{}, model.GHWebHookSubscribe{}},
+		{GHWebhookEventSearchDTO{}, model.GHWebhookEvent{}},
+		{GHWebhookEventDeliverSearchDTO{}, model.GHWebhookEventDeliver{}},
+		{GHWebhookEventReceiverDeliverSearchDTO{}, model.GHWebhookEventReceiverDeliver{}},
+		{GHWebhookSubscribeMatchSearchDTO{}, model.GHWebhookSubscribeMatch{}},
+		{GHWebhookScheduleSearchDTO{}, model.GHWebhookSchedule{}},
+	}
+	for _, search := range searches {
+		var fields []string
+		typ := reflect.TypeOf(search.dto)
+		for i := 0; i < typ.NumField(); i++ {
+			if name, _, _ := strings.Cut(typ.Field(i).Tag.Get("rsql"), ","); len(name) > 0 {
+				fields = append(fields, name)
+			}
+		}
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/?sort="+strings.Join(fields, ";"), nil)
+		helper := core.NewRSQLHelper()
+		if err := helper.ParseFilter(search.dto, c); err != nil {
+			t.Fatalf("%T: %v", search.dto, err)
+		}
+		var rows []map[string]interface{}
+		if err := db.Model(search.model).Order(helper.SortSQL).Find(&rows).Error; err != nil {
+			t.Fatalf("%T should sort by the columns of %T: %v", search.dto, search.model, err)
+		}
+	}
+}
+
+func Test_SubscribeCompile(t *testing.T) {
+	ctx := newTestContext(t)
+	if err := (&GHWebhookSubscribeAPIHandler{}).Register(ctx); err != nil {
+		t.Fatal(err)
+	}
+	receiver := newTestGitHubReceiver(t, ctx.Db, "compile")
+	path := fmt.Sprintf("/gh-webhook-receiver/%d/subscribe", receiver.ID)
+
+	// the child keys are relative to their parent, so $.id is valid alone but not as a child
+	invalid := `{"events": ["push"], "filters": {"$.head_commit": {"child": {"$.id": {"expr": "cur != nil"}}}}}`
+	if code := serve(t, ctx, http.MethodPost, path, invalid, nil); code != http.StatusBadRequest {
+		t.Fatalf("should reject the subscribe which can't be compiled, got %d", code)
+	}
+	var created model.IDResponse
+	valid := `{"events": ["push"], "filters": {"$.head_commit": {"child": {"id": {"expr": "cur != nil"}}}}}`
+	if code := serve(t, ctx, http.MethodPost, path, valid, &created); code != http.StatusCreated {
+		t.Fatalf("should create the subscribe, got %d", code)
+	}
+	subPath := fmt.Sprintf("%s/%d", path, created.ID)
+	if code := serve(t, ctx, http.MethodPatch, subPath, invalid, nil); code != http.StatusBadRequest {
+		t.Fatalf("should reject the update which can't be compiled, got %d", code)
+	}
+
+	var sub model.GHWebHookSubscribe
+	if err := ctx.Db.First(&sub, created.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	matcher, err := model.GetSubscribeMatcher(&sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := serve(t, ctx, http.MethodDelete, subPath, "", nil); code != http.StatusNoContent {
+		t.Fatalf("should delete the subscribe, got %d", code)
+	}
+	if evicted, _ := model.GetSubscribeMatcher(&sub); evicted == matcher {
+		t.Fatal("the deleted subscribe should be evicted")
+	}
+}
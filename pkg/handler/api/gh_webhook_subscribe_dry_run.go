@@ -0,0 +1,202 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gh-webhook/pkg/core"
+	"gh-webhook/pkg/model"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+// GHWebhookSubscribeDryRunDTO the event to evaluate, either a stored event or an inline payload with its event name
+type GHWebhookSubscribeDryRunDTO struct {
+	EventID uint                   `json:"eventId"`
+	Event   string                 `json:"event"`
+	Payload map[string]interface{} `json:"payload"`
+
+	// evaluate this subscribe instead of the stored ones, e.g. before creating it, receiver dry run only
+	Subscribe *GHWebhookSubscribeCreateDTO `json:"subscribe"`
+}
+
+// GHWebhookSubscribeDryRunResultDTO the receiver is delivered by the first matched subscribe, nothing is delivered
+// by the dry run
+type GHWebhookSubscribeDryRunResultDTO struct {
+	Matched     bool                `json:"matched"`
+	SubscribeID uint                `json:"subscribeId,omitempty"` // the first matched subscribe
+	Event       string              `json:"event"`
+	Action      string              `json:"action"`
+	Reason      string              `json:"reason,omitempty"` // why the receiver isn't delivered regardless of traces
+	Traces      []*model.MatchTrace `json:"traces"`
+}
+
+// DryRun evaluate the subscribes of the receiver against the event
+func (h *GHWebhookSubscribeAPIHandler) DryRun(c *gin.Context) {
+	pId := core.GetPathVarUInt(c, "id")
+	if pId == nil {
+		return
+	}
+	receiver := model.GHWebhookReceiver{}
+	if !core.GetModel(c, h.db, &receiver, "id = ?", *pId) {
+		return
+	}
+
+	var dryRunDto GHWebhookSubscribeDryRunDTO
+	if err := c.ShouldBindJSON(&dryRunDto); err != nil {
+		log.Errorf("failed to bind json: %v", err)
+		c.JSON(http.StatusUnprocessableEntity, model.NewErrorMsgDTOFromErr(err))
+		return
+	}
+
+	var subs []model.GHWebHookSubscribe
+	if dryRunDto.Subscribe != nil {
+		sub, err := newSubscribe(receiver, *dryRunDto.Subscribe)
+		if err != nil {
+			log.Errorf("failed to bind json: %v", err)
+			c.JSON(http.StatusUnprocessableEntity, model.NewErrorMsgDTOFromErr(err))
+			return
+		}
+		if err = sub.IsValid(); err != nil {
+			log.Errorf("invalid request: %v", err)
+			c.JSON(http.StatusBadRequest, model.NewErrorMsgDTOFromErr(err))
+			return
+		}
+		subs = append(subs, sub)
+	} else {
+		db := h.db.Where("gh_webhook_receiver_id = ?", receiver.ID).Order("id").Find(&subs)
+		if db.Error != nil {
+			log.Errorf("failed to find webhook receiver subscribes: %v", db.Error)
+			c.JSON(http.StatusUnprocessableEntity, model.NewErrorMsgDTOFromErr(db.Error))
+			return
+		}
+	}
+	h.dryRun(c, dryRunDto, receiver, subs)
+}
+
+// DryRunSubscribe evaluate the subscribe against the event
+func (h *GHWebhookSubscribeAPIHandler) DryRunSubscribe(c *gin.Context) {
+	pId := core.GetPathVarUInt(c, "id")
+	if pId == nil {
+		return
+	}
+	cId := core.GetPathVarUInt(c, "cId")
+	if cId == nil {
+		return
+	}
+	receiver := model.GHWebhookReceiver{}
+	if !core.GetModel(c, h.db, &receiver, "id = ?", *pId) {
+		return
+	}
+	sub := model.GHWebHookSubscribe{}
+	if !core.GetModel(c, h.db, &sub, "id = ?", *cId) {
+		return
+	}
+	if sub.GHWebhookReceiverID != receiver.ID {
+		log.Errorf("webhook receiver subscribe not found")
+		c.JSON(http.StatusNotFound, model.NewErrorMsgDTO(http.StatusText(http.StatusNotFound)))
+		return
+	}
+
+	var dryRunDto GHWebhookSubscribeDryRunDTO
+	if err := c.ShouldBindJSON(&dryRunDto); err != nil {
+		log.Errorf("failed to bind json: %v", err)
+		c.JSON(http.StatusUnprocessableEntity, model.NewErrorMsgDTOFromErr(err))
+		return
+	}
+	if dryRunDto.Subscribe != nil {
+		c.JSON(http.StatusBadRequest, model.NewErrorMsgDTO("subscribe is only supported by the receiver dry run"))
+		return
+	}
+	h.dryRun(c, dryRunDto, receiver, []model.GHWebHookSubscribe{sub})
+}
+
+// dryRun evaluate the subscribes, the GitHub API lookups use the GitHub server of the receiver
+func (h *GHWebhookSubscribeAPIHandler) dryRun(c *gin.Context, dryRunDto GHWebhookSubscribeDryRunDTO,
+	receiver model.GHWebhookReceiver, subs []model.GHWebHookSubscribe) {
+	ghEvent, payload, status, err := h.dryRunEvent(dryRunDto)
+	if err != nil {
+		log.Errorf("invalid dry run event: %v", err)
+		c.JSON(status, model.NewErrorMsgDTOFromErr(err))
+		return
+	}
+	if ghEvent.GitHubId == 0 {
+		ghEvent.GitHubId = receiver.GitHubId
+	}
+	lookup := h.ghClient.NewEventLookup(func() (model.GitHub, error) {
+		var gh model.GitHub
+		if db := h.db.First(&gh, ghEvent.GitHubId); db.Error != nil {
+			return gh, fmt.Errorf("failed to find github %d: %v", ghEvent.GitHubId, db.Error)
+		}
+		return gh, nil
+	}, ghEvent, payload)
+
+	if len(receiver.Enrichments) > 0 {
+		payload = model.WithEnriched(payload, lookup.Enrich(receiver.Enrichments))
+	}
+
+	result := GHWebhookSubscribeDryRunResultDTO{
+		Event:  ghEvent.Event,
+		Action: ghEvent.Action,
+		Traces: []*model.MatchTrace{},
+	}
+	if !receiver.MatchesScope(ghEvent) {
+		result.Reason = fmt.Sprintf("%s isn't in the scope %s/%s of the receiver", ghEvent.OrgRepo, receiver.Org,
+			receiver.Repo)
+	}
+	for i := range subs {
+		matcher, err := model.CompileSubscribe(&subs[i])
+		if err != nil {
+			result.Traces = append(result.Traces, &model.MatchTrace{
+				SubscribeID: subs[i].ID,
+				Reason:      fmt.Sprintf("failed to compile subscribe: %v", err),
+				Steps:       []model.MatchStep{},
+			})
+			continue
+		}
+		trace := matcher.Trace(payload, ghEvent, lookup)
+		if trace.Matched && !result.Matched && len(result.Reason) == 0 {
+			result.Matched = true
+			result.SubscribeID = subs[i].ID
+		}
+		result.Traces = append(result.Traces, trace)
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// dryRunEvent the stored event or the inline one, the action of the inline event is the action of its payload
+func (h *GHWebhookSubscribeAPIHandler) dryRunEvent(dryRunDto GHWebhookSubscribeDryRunDTO) (model.GHWebhookEvent,
+	map[string]interface{}, int, error) {
+	var payload map[string]interface{}
+	if dryRunDto.EventID > 0 {
+		if len(dryRunDto.Event) > 0 || dryRunDto.Payload != nil {
+			return model.GHWebhookEvent{}, nil, http.StatusBadRequest,
+				fmt.Errorf("either eventId or event and payload is required")
+		}
+		ghEvent := model.GHWebhookEvent{}
+		db := h.db.First(&ghEvent, "id = ?", dryRunDto.EventID)
+		if errors.Is(db.Error, gorm.ErrRecordNotFound) {
+			return ghEvent, nil, http.StatusNotFound, fmt.Errorf("event %d not found", dryRunDto.EventID)
+		} else if db.Error != nil {
+			return ghEvent, nil, http.StatusUnprocessableEntity, db.Error
+		}
+		if err := json.Unmarshal([]byte(ghEvent.Payload), &payload); err != nil {
+			return ghEvent, nil, http.StatusUnprocessableEntity, fmt.Errorf("invalid payload of event %d: %v",
+				ghEvent.ID, err)
+		}
+		return ghEvent, payload, http.StatusOK, nil
+	}
+
+	if len(dryRunDto.Event) == 0 || dryRunDto.Payload == nil {
+		return model.GHWebhookEvent{}, nil, http.StatusBadRequest,
+			fmt.Errorf("either eventId or event and payload is required")
+	}
+	ghEvent := model.GHWebhookEvent{Event: dryRunDto.Event}
+	ghEvent.SetOrgRepo(dryRunDto.Payload)
+	if action, ok := dryRunDto.Payload["action"].(string); ok {
+		ghEvent.Action = action
+	}
+	return ghEvent, dryRunDto.Payload, http.StatusOK, nil
+}
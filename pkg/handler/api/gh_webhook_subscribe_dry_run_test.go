@@ -0,0 +1,122 @@
+package api
+
+import (
+	"fmt"
+	"gh-webhook/pkg/model"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+const dryRunPush = `{"event": "push", "payload": {"ref": "refs/heads/%s",
+	"repository": {"full_name": "octo/mono", "name": "mono", "owner": {"login": "octo"}}}}`
+
+// failedStep whether the trace has a step of the kind which doesn't match
+func failedStep(trace *model.MatchTrace, kind string) bool {
+	return slices.ContainsFunc(trace.Steps, func(step model.MatchStep) bool {
+		return step.Kind == kind && !step.Matched
+	})
+}
+
+func Test_DryRun(t *testing.T) {
+	ctx := newTestContext(t)
+	if err := (&GHWebhookSubscribeAPIHandler{}).Register(ctx); err != nil {
+		t.Fatal(err)
+	}
+	receiver := newTestGitHubReceiver(t, ctx.Db, "dry-run",
+		model.GHWebHookSubscribe{Events: []string{"push"}, Branches: []string{"main"}},
+		model.GHWebHookSubscribe{Events: []string{"pull_request"}})
+	path := fmt.Sprintf("/gh-webhook-receiver/%d/subscribe/test", receiver.ID)
+
+	var result GHWebhookSubscribeDryRunResultDTO
+	if code := serve(t, ctx, http.MethodPost, path, fmt.Sprintf(dryRunPush, "main"), &result); code != http.StatusOK {
+		t.Fatalf("should dry run the subscribes, got %d", code)
+	}
+	if !result.Matched || result.SubscribeID != receiver.Subscribes[0].ID || len(result.Traces) != 2 {
+		t.Fatalf("the push to main should match the first subscribe, got %+v", result)
+	}
+	if result.Traces[1].Matched || !failedStep(result.Traces[1], model.TraceEvent) {
+		t.Fatalf("the pull request subscribe shouldn't match the push, got %+v", result.Traces[1])
+	}
+
+	result = GHWebhookSubscribeDryRunResultDTO{}
+	if code := serve(t, ctx, http.MethodPost, path, fmt.Sprintf(dryRunPush, "dev"), &result); code != http.StatusOK {
+		t.Fatalf("should dry run the subscribes, got %d", code)
+	}
+	if result.Matched || result.SubscribeID != 0 || len(result.Traces) != 2 {
+		t.Fatalf("the push to dev shouldn't match, got %+v", result)
+	}
+	if trace := result.Traces[0]; len(trace.Reason) == 0 || !failedStep(trace, model.TraceRef) {
+		t.Fatalf("the branch should be the failed condition, got %+v", trace)
+	}
+
+	// the stored event is evaluated like the inline one
+	event := model.GHWebhookEvent{Event: "push", GitHubId: receiver.GitHubId,
+		Payload: `{"ref": "refs/heads/main", "repository": {"full_name": "octo/mono"}}`}
+	ctx.Db.Omit("GitHub").Create(&event)
+	result = GHWebhookSubscribeDryRunResultDTO{}
+	body := fmt.Sprintf(`{"eventId": %d}`, event.ID)
+	if code := serve(t, ctx, http.MethodPost, path, body, &result); code != http.StatusOK || !result.Matched {
+		t.Fatalf("the stored push to main should match, got %d %+v", code, result)
+	}
+
+	// the inline subscribe is evaluated instead of the stored ones
+	result = GHWebhookSubscribeDryRunResultDTO{}
+	body = `{"event": "push", "payload": {"ref": "refs/heads/dev"}, "subscribe": {"events": ["push"], "branches": ["dev"]}}`
+	if code := serve(t, ctx, http.MethodPost, path, body, &result); code != http.StatusOK {
+		t.Fatalf("should dry run the inline subscribe, got %d", code)
+	}
+	if !result.Matched || len(result.Traces) != 1 {
+		t.Fatalf("the inline subscribe should match the push to dev, got %+v", result)
+	}
+
+	body = `{"event": "push", "payload": {}, "subscribe": {"branches": ["dev"]}}`
+	if code := serve(t, ctx, http.MethodPost, path, body, nil); code != http.StatusBadRequest {
+		t.Fatalf("should reject the invalid inline subscribe, got %d", code)
+	}
+	if code := serve(t, ctx, http.MethodPost, path, `{"event": "push"}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("should reject the event without payload, got %d", code)
+	}
+}
+
+func Test_DryRunSubscribe(t *testing.T) {
+	ctx := newTestContext(t)
+	if err := (&GHWebhookSubscribeAPIHandler{}).Register(ctx); err != nil {
+		t.Fatal(err)
+	}
+	receiver := newTestGitHubReceiver(t, ctx.Db, "dry-run",
+		model.GHWebHookSubscribe{Events: []string{"push"}, Branches: []string{"main"}},
+		model.GHWebHookSubscribe{Events: []string{"pull_request"}})
+	other := newTestGitHubReceiver(t, ctx.Db, "other", model.GHWebHookSubscribe{Events: []string{"push"}})
+	path := func(receiverId uint, sub model.GHWebHookSubscribe) string {
+		return fmt.Sprintf("/gh-webhook-receiver/%d/subscribe/%d/test", receiverId, sub.ID)
+	}
+
+	var result GHWebhookSubscribeDryRunResultDTO
+	code := serve(t, ctx, http.MethodPost, path(receiver.ID, receiver.Subscribes[0]), fmt.Sprintf(dryRunPush, "main"),
+		&result)
+	if code != http.StatusOK || !result.Matched || len(result.Traces) != 1 {
+		t.Fatalf("the subscribe should match the push to main, got %d %+v", code, result)
+	}
+
+	result = GHWebhookSubscribeDryRunResultDTO{}
+	code = serve(t, ctx, http.MethodPost, path(receiver.ID, receiver.Subscribes[1]), fmt.Sprintf(dryRunPush, "main"),
+		&result)
+	if code != http.StatusOK || result.Matched || len(result.Traces) != 1 {
+		t.Fatalf("only the pull request subscribe should be evaluated, got %d %+v", code, result)
+	}
+	if trace := result.Traces[0]; trace.SubscribeID != receiver.Subscribes[1].ID || len(trace.Reason) == 0 ||
+		!failedStep(trace, model.TraceEvent) {
+		t.Fatalf("the event should be the failed condition, got %+v", trace)
+	}
+
+	body := `{"event": "push", "payload": {}, "subscribe": {"events": ["push"]}}`
+	code = serve(t, ctx, http.MethodPost, path(receiver.ID, receiver.Subscribes[0]), body, nil)
+	if code != http.StatusBadRequest {
+		t.Fatalf("should reject the inline subscribe, got %d", code)
+	}
+	if code = serve(t, ctx, http.MethodPost, path(receiver.ID, other.Subscribes[0]), fmt.Sprintf(dryRunPush, "main"),
+		nil); code != http.StatusNotFound {
+		t.Fatalf("should not find the subscribe of another receiver, got %d", code)
+	}
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"fmt"
+	"gh-webhook/pkg/core"
+	"gh-webhook/pkg/model"
+	"github.com/dranikpg/dto-mapper"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"net/http"
+	"time"
+)
+
+// GHWebhookSubscribeMatchAPIHandler match decisions of the subscribes, e.g. the events of a repo rejected by
+// subscribe 12 today:
+// filter=ghWebHookSubscribeId==12;orgRepo=="org/repo";matched==false;createdAt=ge="2024-05-12T00:00:00Z"
+type GHWebhookSubscribeMatchAPIHandler struct {
+	db *gorm.DB
+}
+
+type GHWebhookSubscribeMatchSearchDTO struct {
+	ID                              uint           `json:"id" rsql:"id,filter,sort"`
+	CreatedAt                       time.Time      `json:"createdAt" rsql:"createdAt,filter,sort"`
+	GHWebhookEventID                uint           `json:"ghWebhookEventId" rsql:"ghWebhookEventId,filter,sort"`
+	GHWebhookReceiverID             uint           `json:"ghWebhookReceiverId" rsql:"ghWebhookReceiverId,filter,sort"`
+	GHWebHookSubscribeID            uint           `json:"ghWebHookSubscribeId" rsql:"ghWebHookSubscribeId,filter,sort"`
+	GHWebhookEventReceiverDeliverID uint           `json:"ghWebhookEventReceiverDeliverId" rsql:"ghWebhookEventReceiverDeliverId,filter,sort"`
+	OrgRepo                         string         `json:"orgRepo" rsql:"orgRepo,filter,sort"`
+	Event                           string         `json:"event" rsql:"event,filter,sort"`
+	Action                          string         `json:"action" rsql:"action,filter,sort"`
+	Matched                         bool           `json:"matched" rsql:"matched,filter,sort"`
+	FailedCondition                 string         `json:"failedCondition" rsql:"failedCondition,filter,sort"`
+	Reason                          string         `json:"reason"`
+	EvalTime                        model.Duration `json:"evalTime"`
+}
+
+func (h *GHWebhookSubscribeMatchAPIHandler) Register(c *core.GHPRContext) error {
+	h.db = c.Db
+	c.Gin.GET(fmt.Sprintf("%s/gh-webhook-subscribe-match", c.Cfg.APIPrefix), h.List)
+	c.Gin.GET(fmt.Sprintf("%s/gh-webhook-subscribe-match/:id", c.Cfg.APIPrefix), h.Get)
+	return nil
+}
+
+func (h *GHWebhookSubscribeMatchAPIHandler) List(c *gin.Context) {
+	var matches []model.GHWebhookSubscribeMatch
+	if !core.SearchModel(c, h.db, GHWebhookSubscribeMatchSearchDTO{}, &matches) {
+		return
+	}
+	var matchDTOs []GHWebhookSubscribeMatchSearchDTO
+	mapper := dto.Mapper{}
+	err := mapper.Map(&matchDTOs, matches)
+	if err != nil {
+		log.Errorf("failed to map: %v", err)
+		c.JSON(http.StatusInternalServerError, model.NewErrorMsgDTOFromErr(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, model.NewListResponse(matchDTOs))
+}
+
+func (h *GHWebhookSubscribeMatchAPIHandler) Get(c *gin.Context) {
+	id := core.GetPathVarUInt(c, "id")
+	if id == nil {
+		return
+	}
+	match := model.GHWebhookSubscribeMatch{}
+	if !core.GetModel(c, h.db, &match, "id = ?", *id) {
+		return
+	}
+	mapper := dto.Mapper{}
+	to := GHWebhookSubscribeMatchSearchDTO{}
+	err := mapper.Map(&to, match)
+	if err != nil {
+		log.Errorf("failed to map: %v", err)
+		c.JSON(http.StatusInternalServerError, model.NewErrorMsgDTOFromErr(err))
+		return
+	}
+	c.JSON(http.StatusOK, to)
+}
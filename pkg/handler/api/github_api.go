@@ -14,15 +14,17 @@ import (
 )
 
 type GitHubCreateDTO struct {
-	Web  string `json:"web" binding:"required"`
-	API  string `json:"api" binding:"required"`
-	Name string `json:"name" binding:"required"`
+	Web   string `json:"web" binding:"required"`
+	API   string `json:"api" binding:"required"`
+	Name  string `json:"name" binding:"required"`
+	Token string `json:"token"`
 }
 
 type GitHubUpdateDTO struct {
-	Web  string `json:"web" `
-	API  string `json:"api" `
-	Name string `json:"name" `
+	Web   string  `json:"web" `
+	API   string  `json:"api" `
+	Name  string  `json:"name" `
+	Token *string `json:"token"` // "" removes the token
 }
 
 type GitHubSearchDTO struct {
@@ -32,6 +34,7 @@ type GitHubSearchDTO struct {
 	Web       string    `json:"web" rsql:"web,filter,sort"`
 	API       string    `json:"api" rsql:"api,filter,sort"`
 	Name      string    `json:"name" rsql:"name,filter,sort"`
+	Token     string    `json:"token"`
 }
 
 // GitHubAPIHandler path: github
@@ -58,9 +61,15 @@ func (h *GitHubAPIHandler) Post(c *gin.Context) {
 		return
 	}
 	github := model.GitHub{
-		Web:  ghCreateDTO.Web,
-		API:  ghCreateDTO.API,
-		Name: ghCreateDTO.Name,
+		Web:   ghCreateDTO.Web,
+		API:   ghCreateDTO.API,
+		Name:  ghCreateDTO.Name,
+		Token: ghCreateDTO.Token,
+	}
+	if err := github.IsValid(); err != nil {
+		log.Errorf("invalid request: %v", err)
+		c.JSON(http.StatusBadRequest, model.NewErrorMsgDTOFromErr(err))
+		return
 	}
 	db := h.db.Save(&github)
 	if db.Error != nil {
@@ -115,7 +124,8 @@ func (h *GitHubAPIHandler) Update(c *gin.Context) {
 		return
 	}
 
-	if len(ghUpdateDTO.API) <= 0 && len(ghUpdateDTO.Web) <= 0 && len(ghUpdateDTO.Name) <= 0 {
+	if len(ghUpdateDTO.API) <= 0 && len(ghUpdateDTO.Web) <= 0 && len(ghUpdateDTO.Name) <= 0 &&
+		ghUpdateDTO.Token == nil {
 		c.JSON(http.StatusBadRequest, model.NewErrorMsgDTO("no field to update"))
 		return
 	}
@@ -139,6 +149,14 @@ func (h *GitHubAPIHandler) Update(c *gin.Context) {
 	if len(ghUpdateDTO.Name) > 0 {
 		github.Name = ghUpdateDTO.Name
 	}
+	if ghUpdateDTO.Token != nil {
+		github.Token = *ghUpdateDTO.Token
+	}
+	if err = github.IsValid(); err != nil {
+		log.Errorf("invalid request: %v", err)
+		c.JSON(http.StatusBadRequest, model.NewErrorMsgDTOFromErr(err))
+		return
+	}
 	db = h.db.Save(&github)
 	if db.Error != nil {
 		log.Errorf("failed to save github: %v", db.Error)
@@ -0,0 +1,329 @@
+package webhook
+
+import (
+	"errors"
+	"gh-webhook/pkg/model"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	circuitRecoverInterval       = 10 * time.Second
+	matchPruneInterval           = time.Hour
+	recoverRoutineId       int32 = 0 // routine id of the deliveries drained by the recover loop
+)
+
+// backlogStatus deliveries waiting for the receiver, they are delivered in order
+var backlogStatus = []string{model.DeliverStatusPaused, model.DeliverStatusHeld, model.DeliverStatusThrottled}
+
+// receiverCircuit serialize the circuit transitions of a receiver and make sure only one drain runs
+type receiverCircuit struct {
+	mutex    sync.Mutex
+	draining atomic.Bool
+}
+
+func (h *GHWebhookDeliverHandler) getCircuit(receiverId uint) *receiverCircuit {
+	circuit, _ := h.circuits.LoadOrStore(receiverId, &receiverCircuit{})
+	return circuit.(*receiverCircuit)
+}
+
+// deliver launch the delivery when the receiver is available, its circuit is closed and there is no backlog,
+// otherwise the delivery is skipped, held or paused, held and paused deliveries are delivered in order by the
+// recover loop once the caller saved them. payload is the enriched payload of the event, nil if it's not parsed.
+func (h *GHWebhookDeliverHandler) deliver(routineId int32, re model.GHWebhookReceiver, event model.GHWebhookEvent,
+	payload map[string]interface{}, receiverDeliver *model.GHWebhookEventReceiverDeliver) {
+	if h.cancelIfSuperseded(routineId, receiverDeliver) {
+		return
+	}
+	if reason := re.Unavailable(time.Now()); len(reason) > 0 {
+		log.Infof("[go routine %d] %s, %s event %d for receiver %d", routineId, reason,
+			re.GetUnavailablePolicy(), event.ID, re.ID)
+		receiverDeliver.Status = model.DeliverStatusSkipped
+		if re.GetUnavailablePolicy() == model.UnavailableHold {
+			receiverDeliver.Status = model.DeliverStatusHeld
+		}
+		receiverDeliver.Error = reason
+		return
+	}
+	if status := h.backlogStatus(re); len(status) > 0 {
+		log.Infof("[go routine %d] receiver %d circuit is not closed or has backlog, %s event %d",
+			routineId, re.ID, status, event.ID)
+		receiverDeliver.Status = status
+		return
+	}
+	if limiter := h.getLimiter(re); limiter != nil && !limiter.allow(time.Now()) {
+		log.Infof("[go routine %d] receiver %d is rate limited, throttle event %d", routineId, re.ID, event.ID)
+		receiverDeliver.Status = model.DeliverStatusThrottled
+		return
+	}
+
+	deliverErr := h.launchDelivery(routineId, re, event, payload, receiverDeliver)
+	var payloadErr *payloadError
+	if errors.As(deliverErr, &payloadErr) {
+		// the receiver isn't called, so the circuit isn't affected
+		log.Errorf("[go routine %d] receiver %d event %d: %v", routineId, re.ID, event.ID, deliverErr)
+		receiverDeliver.Status = model.DeliverStatusFailed
+		receiverDeliver.Error = deliverErr.Error()
+		return
+	}
+	// delivered once the receiver is called, not while the delivery is parked
+	receiverDeliver.Delivered = true
+	receiverDeliver.Status = model.DeliverStatusDelivered
+	if deliverErr != nil {
+		receiverDeliver.Status = model.DeliverStatusFailed
+		receiverDeliver.Error = deliverErr.Error()
+	}
+	h.recordResult(routineId, re, deliverErr == nil)
+	if deliverErr == nil {
+		h.cancelSuperseded(routineId, re, *receiverDeliver)
+	}
+}
+
+// backlogStatus the status to park the delivery with if the circuit is not closed or it must wait behind the
+// backlog, empty if it can be delivered now
+func (h *GHWebhookDeliverHandler) backlogStatus(re model.GHWebhookReceiver) string {
+	circuit := h.getCircuit(re.ID)
+	circuit.mutex.Lock()
+	defer circuit.mutex.Unlock()
+
+	state, err := h.loadCircuit(re.ID)
+	if err != nil {
+		// don't hold deliveries because the state is unknown
+		log.Errorf("failed to load circuit of receiver %d: %v", re.ID, err)
+		return ""
+	}
+	if !state.Allow() {
+		return model.DeliverStatusPaused
+	}
+	var statuses []string
+	r := h.db.Model(&model.GHWebhookEventReceiverDeliver{}).
+		Where("gh_webhook_receiver_id = ? AND status IN ?", re.ID, backlogStatus).
+		Distinct().Pluck("status", &statuses)
+	if r.Error != nil {
+		log.Errorf("failed to find backlog of receiver %d: %v", re.ID, r.Error)
+		return ""
+	}
+	switch {
+	case len(statuses) == 0:
+		return ""
+	case len(statuses) == 1 && statuses[0] == model.DeliverStatusThrottled:
+		// only rate limited deliveries are waiting, the delivery waits for a token behind them
+		return model.DeliverStatusThrottled
+	default:
+		// the receiver is recovering from an open circuit or a maintenance window
+		return model.DeliverStatusPaused
+	}
+}
+
+func (h *GHWebhookDeliverHandler) loadCircuit(receiverId uint) (model.CircuitState, error) {
+	var receiver model.GHWebhookReceiver
+	r := h.db.Select("id", "circuit_state", "circuit_failures", "circuit_opened_at").First(&receiver, receiverId)
+	return receiver.Circuit, r.Error
+}
+
+func (h *GHWebhookDeliverHandler) saveCircuit(receiverId uint, state model.CircuitState) error {
+	return h.db.Model(&model.GHWebhookReceiver{}).Where("id = ?", receiverId).Updates(state.Columns()).Error
+}
+
+// recordResult update the receiver circuit by the delivery result
+func (h *GHWebhookDeliverHandler) recordResult(routineId int32, re model.GHWebhookReceiver, success bool) {
+	circuit := h.getCircuit(re.ID)
+	circuit.mutex.Lock()
+	defer circuit.mutex.Unlock()
+
+	state, err := h.loadCircuit(re.ID)
+	if err != nil {
+		log.Errorf("[go routine %d] failed to load circuit of receiver %d: %v", routineId, re.ID, err)
+		return
+	}
+	if success && state.GetState() == model.CircuitClosed && state.Failures == 0 {
+		return
+	}
+	before := state.GetState()
+	state.Record(re.CircuitBreaker, success, time.Now())
+	if before != state.GetState() {
+		log.Warningf("[go routine %d] receiver %d circuit changed from %s to %s after %d failures", routineId, re.ID,
+			before, state.GetState(), state.Failures)
+	}
+	if err = h.saveCircuit(re.ID, state); err != nil {
+		log.Errorf("[go routine %d] failed to save circuit of receiver %d: %v", routineId, re.ID, err)
+	}
+}
+
+// recoverDeliveries periodically drain the backlog and send the debounced deliveries which are due, the expired
+// match decisions are pruned less often, until the handler is closed
+func (h *GHWebhookDeliverHandler) recoverDeliveries() {
+	defer h.wg.Done()
+	ticker := time.NewTicker(circuitRecoverInterval)
+	defer ticker.Stop()
+	pruneTicker := time.NewTicker(matchPruneInterval)
+	defer pruneTicker.Stop()
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+			h.recoverReceivers()
+			h.flushDueDebounced()
+		case <-pruneTicker.C:
+			h.pruneMatches(time.Now())
+		}
+	}
+}
+
+// recoverReceivers drain the backlog of the receivers, one drain per receiver at a time
+func (h *GHWebhookDeliverHandler) recoverReceivers() {
+	var receiverIds []uint
+	r := h.db.Model(&model.GHWebhookEventReceiverDeliver{}).Where("status IN ?", backlogStatus).
+		Distinct().Pluck("gh_webhook_receiver_id", &receiverIds)
+	if r.Error != nil {
+		log.Errorf("failed to find receivers with backlog: %v", r.Error)
+		return
+	}
+	for _, receiverId := range receiverIds {
+		h.drainAsync(receiverId)
+	}
+}
+
+// drainAsync start draining the backlog of the receiver unless it's being drained
+func (h *GHWebhookDeliverHandler) drainAsync(receiverId uint) {
+	circuit := h.getCircuit(receiverId)
+	if !circuit.draining.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer circuit.draining.Store(false)
+		h.drain(receiverId)
+	}()
+}
+
+// drain deliver the backlog in order once the receiver is available, the first one probes the receiver if the
+// circuit is open
+func (h *GHWebhookDeliverHandler) drain(receiverId uint) {
+	for {
+		var re model.GHWebhookReceiver
+		r := h.db.First(&re, receiverId)
+		if errors.Is(r.Error, gorm.ErrRecordNotFound) {
+			h.failBacklog(receiverId, "receiver not found")
+			return
+		} else if r.Error != nil {
+			log.Errorf("failed to find receiver %d: %v", receiverId, r.Error)
+			return
+		}
+		if len(re.Unavailable(time.Now())) > 0 || !h.halfOpen(re) {
+			return
+		}
+
+		var receiverDeliver model.GHWebhookEventReceiverDeliver
+		r = h.db.Preload("GHWebhookEventDeliver.GHWebhookEvent").
+			Where("gh_webhook_receiver_id = ? AND status IN ?", receiverId, backlogStatus).
+			Order("id").First(&receiverDeliver)
+		if errors.Is(r.Error, gorm.ErrRecordNotFound) {
+			return
+		} else if r.Error != nil {
+			log.Errorf("failed to find backlog of receiver %d: %v", receiverId, r.Error)
+			return
+		}
+
+		if h.cancelIfSuperseded(recoverRoutineId, &receiverDeliver) {
+			r = h.db.Model(&receiverDeliver).Updates(map[string]interface{}{
+				"status":          receiverDeliver.Status,
+				"cancelled_by_id": receiverDeliver.CancelledByID,
+			})
+			if r.Error != nil {
+				log.Errorf("failed to update delivery %d: %v", receiverDeliver.ID, r.Error)
+				return
+			}
+			continue
+		}
+
+		updates := map[string]interface{}{
+			"delivered": true,
+			"status":    model.DeliverStatusDelivered,
+			"error":     "",
+		}
+		queuedAt := time.Now()
+		if receiverDeliver.Status == model.DeliverStatusThrottled {
+			queuedAt = receiverDeliver.CreatedAt
+		}
+		if wait := h.waitForToken(re); wait > 0 || receiverDeliver.Status == model.DeliverStatusThrottled {
+			throttleWait := time.Since(queuedAt)
+			updates["throttle_wait"] = model.Duration(throttleWait)
+			h.recordThrottle(receiverId, throttleWait)
+		}
+
+		event := receiverDeliver.GHWebhookEventDeliver.GHWebhookEvent
+		log.Infof("[go routine %d] resume delivery %d of event %d to receiver %d", recoverRoutineId,
+			receiverDeliver.ID, event.ID, receiverId)
+		deliverErr := h.launchDelivery(recoverRoutineId, re, event, nil, &receiverDeliver)
+		var payloadErr *payloadError
+		if errors.As(deliverErr, &payloadErr) {
+			log.Errorf("[go routine %d] receiver %d event %d: %v", recoverRoutineId, receiverId, event.ID, deliverErr)
+			r = h.db.Model(&receiverDeliver).Updates(map[string]interface{}{
+				"status": model.DeliverStatusFailed,
+				"error":  deliverErr.Error(),
+			})
+			if r.Error != nil {
+				log.Errorf("failed to update delivery %d: %v", receiverDeliver.ID, r.Error)
+				return
+			}
+			continue
+		}
+		updates["payload_size"] = receiverDeliver.PayloadSize
+		if deliverErr != nil {
+			updates["status"] = model.DeliverStatusFailed
+			updates["error"] = deliverErr.Error()
+		}
+		h.recordResult(recoverRoutineId, re, deliverErr == nil)
+
+		if re.Circuit.GetState() != model.CircuitClosed && deliverErr != nil {
+			// the failed probe stays in the backlog until the next probe
+			continue
+		}
+		updates["receiver_ref"] = receiverDeliver.ReceiverRef
+		if r = h.db.Model(&receiverDeliver).Updates(updates); r.Error != nil {
+			log.Errorf("failed to update delivery %d: %v", receiverDeliver.ID, r.Error)
+			return
+		}
+		if deliverErr == nil {
+			receiverDeliver.Status = model.DeliverStatusDelivered
+			h.cancelSuperseded(recoverRoutineId, re, receiverDeliver)
+		}
+	}
+}
+
+// halfOpen move the open circuit to half-open when it's time to probe, return false if the receiver can't be
+// delivered yet
+func (h *GHWebhookDeliverHandler) halfOpen(re model.GHWebhookReceiver) bool {
+	circuit := h.getCircuit(re.ID)
+	circuit.mutex.Lock()
+	defer circuit.mutex.Unlock()
+
+	switch re.Circuit.GetState() {
+	case model.CircuitClosed, model.CircuitHalfOpen:
+		return true
+	}
+	if !re.Circuit.ProbeDue(re.CircuitBreaker, time.Now()) {
+		return false
+	}
+	state := re.Circuit
+	state.State = model.CircuitHalfOpen
+	if err := h.saveCircuit(re.ID, state); err != nil {
+		log.Errorf("failed to save circuit of receiver %d: %v", re.ID, err)
+		return false
+	}
+	log.Infof("receiver %d circuit is half-open, probing", re.ID)
+	return true
+}
+
+func (h *GHWebhookDeliverHandler) failBacklog(receiverId uint, reason string) {
+	r := h.db.Model(&model.GHWebhookEventReceiverDeliver{}).
+		Where("gh_webhook_receiver_id = ? AND status IN ?", receiverId, backlogStatus).
+		Updates(map[string]interface{}{"status": model.DeliverStatusFailed, "error": reason})
+	if r.Error != nil {
+		log.Errorf("failed to fail backlog of receiver %d: %v", receiverId, r.Error)
+	}
+}
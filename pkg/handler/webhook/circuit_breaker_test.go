@@ -0,0 +1,237 @@
+package webhook
+
+import (
+	"encoding/json"
+	"gh-webhook/pkg/config"
+	"gh-webhook/pkg/github"
+	"gh-webhook/pkg/model"
+	"gh-webhook/pkg/secret"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestDb(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "gh_pr.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = model.Init(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// testReceiver receiver with a push subscribe, delivered records the ids of the delivered events, payloads
+// their payloads
+type testReceiver struct {
+	db        *gorm.DB
+	handler   *GHWebhookDeliverHandler
+	receiver  model.GHWebhookReceiver
+	healthy   atomic.Bool
+	delay     func() // called before the receiver responds
+	mutex     sync.Mutex
+	delivered []uint
+	payloads  []string
+}
+
+func newTestReceiver(t *testing.T, update func(receiver *model.GHWebhookReceiver)) *testReceiver {
+	tr := &testReceiver{db: newTestDb(t)}
+	tr.healthy.Store(true)
+	ts := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if !tr.healthy.Load() {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if tr.delay != nil {
+			tr.delay()
+		}
+		var body map[string]interface{}
+		_ = json.NewDecoder(request.Body).Decode(&body)
+		tr.mutex.Lock()
+		event := body["event"].(map[string]interface{})
+		tr.delivered = append(tr.delivered, uint(event["ID"].(float64)))
+		tr.payloads = append(tr.payloads, event["Payload"].(string))
+		tr.mutex.Unlock()
+		writer.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ts.Close)
+
+	gh := model.GitHub{Name: "github", API: "api.github.com"}
+	tr.db.Create(&gh)
+	tr.receiver = model.GHWebhookReceiver{
+		Name:     "test",
+		GitHubId: gh.ID,
+		ReceiverConfig: model.NewGHWebhookReceiverConfig(&model.HTTPReceiverConfig{
+			URL:  ts.URL,
+			Auth: model.ReceiverAuth{Type: model.NoneAuth},
+		}),
+		Subscribes: []model.GHWebHookSubscribe{{
+			Events:  []string{"push"},
+			Filters: map[string]model.GHWebhookField{"$.action": {PositiveMatches: []string{"push"}}},
+		}},
+	}
+	update(&tr.receiver)
+	if err := tr.db.Create(&tr.receiver).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := secret.NewResolver(time.Minute)
+	tr.handler = &GHWebhookDeliverHandler{
+		db:             tr.db,
+		config:         &config.Config{},
+		secretResolver: resolver,
+		ghClient:       github.NewClient(resolver),
+	}
+	return tr
+}
+
+// send create and handle n push events
+func (tr *testReceiver) send(n int) []model.GHWebhookEvent {
+	var payloads []string
+	for i := 0; i < n; i++ {
+		payloads = append(payloads, `{"action": "push"}`)
+	}
+	return tr.sendPayloads(payloads...)
+}
+
+func (tr *testReceiver) sendPayloads(payloads ...string) []model.GHWebhookEvent {
+	var events []model.GHWebhookEvent
+	for _, payload := range payloads {
+		event := model.GHWebhookEvent{
+			Payload:  payload,
+			Event:    "push",
+			Action:   "push",
+			GitHubId: tr.receiver.GitHubId,
+		}
+		tr.db.Omit("GitHub").Create(&event)
+		events = append(events, event)
+		tr.handler.handle(1, event)
+	}
+	return events
+}
+
+// drain the backlog and wait until it's done
+func (tr *testReceiver) drain() {
+	tr.handler.drainAsync(tr.receiver.ID)
+	for tr.handler.getCircuit(tr.receiver.ID).draining.Load() {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (tr *testReceiver) assertStatus(t *testing.T, statuses ...string) {
+	var delivers []model.GHWebhookEventReceiverDeliver
+	tr.db.Order("id").Find(&delivers)
+	if len(delivers) != len(statuses) {
+		t.Fatalf("should have %d deliveries, got %d", len(statuses), len(delivers))
+	}
+	for i, deliver := range delivers {
+		if deliver.Status != statuses[i] {
+			t.Fatalf("delivery %d should be %s, got %s", i, statuses[i], deliver.Status)
+		}
+	}
+}
+
+func (tr *testReceiver) assertDelivered(t *testing.T, events ...model.GHWebhookEvent) {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	if len(tr.delivered) != len(events) {
+		t.Fatalf("should deliver %d events, got %v", len(events), tr.delivered)
+	}
+	for i, event := range events {
+		if tr.delivered[i] != event.ID {
+			t.Fatalf("events should be delivered in order, got %v", tr.delivered)
+		}
+	}
+}
+
+func Test_CircuitBreaker(t *testing.T) {
+	tr := newTestReceiver(t, func(receiver *model.GHWebhookReceiver) {
+		receiver.CircuitBreaker = model.CircuitBreakerConfig{FailureThreshold: 2}
+	})
+	tr.healthy.Store(false)
+	events := tr.send(4)
+
+	tr.assertStatus(t, model.DeliverStatusFailed, model.DeliverStatusFailed, model.DeliverStatusPaused,
+		model.DeliverStatusPaused)
+	var delivered []bool
+	tr.db.Model(&model.GHWebhookEventReceiverDeliver{}).Order("id").Pluck("delivered", &delivered)
+	if !delivered[0] || !delivered[1] || delivered[2] || delivered[3] {
+		t.Fatalf("the paused deliveries should not be delivered, got %v", delivered)
+	}
+	state, _ := tr.handler.loadCircuit(tr.receiver.ID)
+	if state.GetState() != model.CircuitOpen {
+		t.Fatal("circuit should be open")
+	}
+
+	// not due to probe yet
+	tr.drain()
+	tr.assertDelivered(t)
+
+	tr.healthy.Store(true)
+	openedAt := time.Now().Add(-2 * model.DefaultOpenDuration)
+	state.OpenedAt = &openedAt
+	if err := tr.handler.saveCircuit(tr.receiver.ID, state); err != nil {
+		t.Fatal(err)
+	}
+	tr.drain()
+
+	tr.assertDelivered(t, events[2], events[3])
+	tr.db.Model(&model.GHWebhookEventReceiverDeliver{}).Order("id").Pluck("delivered", &delivered)
+	if !delivered[2] || !delivered[3] {
+		t.Fatalf("the drained deliveries should be delivered, got %v", delivered)
+	}
+	state, _ = tr.handler.loadCircuit(tr.receiver.ID)
+	if state.GetState() != model.CircuitClosed {
+		t.Fatal("circuit should be closed")
+	}
+}
+
+func Test_MaintenanceWindow(t *testing.T) {
+	start := time.Now().Add(-time.Minute)
+	end := start.Add(time.Hour)
+	tr := newTestReceiver(t, func(receiver *model.GHWebhookReceiver) {
+		receiver.MaintenanceWindows = []model.MaintenanceWindow{{Start: &start, End: &end}}
+		receiver.UnavailablePolicy = model.UnavailableHold
+	})
+	events := tr.send(2)
+	tr.assertStatus(t, model.DeliverStatusHeld, model.DeliverStatusHeld)
+
+	tr.drain()
+	tr.assertDelivered(t)
+
+	// the window ends, new events wait for the held ones
+	tr.db.Model(&tr.receiver).Update("maintenance_windows", "[]")
+	tr.receiver.MaintenanceWindows = nil
+	events = append(events, tr.send(1)...)
+	tr.drain()
+	tr.assertDelivered(t, events...)
+	tr.assertStatus(t, model.DeliverStatusDelivered, model.DeliverStatusDelivered, model.DeliverStatusDelivered)
+
+	disabled := false
+	tr.db.Model(&tr.receiver).Updates(model.GHWebhookReceiver{Enabled: &disabled, UnavailablePolicy: model.UnavailableSkip})
+	tr.send(1)
+	tr.assertStatus(t, model.DeliverStatusDelivered, model.DeliverStatusDelivered, model.DeliverStatusDelivered,
+		model.DeliverStatusSkipped)
+}
+
+func Test_recoverDeliveriesStop(t *testing.T) {
+	tr := newTestReceiver(t, func(receiver *model.GHWebhookReceiver) {})
+	tr.handler.Start(0)
+	closed := make(chan struct{})
+	go func() {
+		_ = tr.handler.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("the recover loop should stop when the handler is closed")
+	}
+}
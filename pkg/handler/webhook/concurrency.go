@@ -0,0 +1,98 @@
+package webhook
+
+import (
+	"fmt"
+	"gh-webhook/pkg/model"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// cancelWindow the older deliveries of the group sent within the window may still be running on the receiver, the
+// ones before it are done and never cancelled
+const cancelWindow = 24 * time.Hour
+
+// supersedingStatus a newer delivery of the concurrency group in these status supersedes the older ones
+var supersedingStatus = append([]string{model.DeliverStatusDelivered}, backlogStatus...)
+
+// cancelIfSuperseded mark the delivery cancelled instead of launching it if a newer delivery of its concurrency group
+// is delivered or waiting to be delivered
+func (h *GHWebhookDeliverHandler) cancelIfSuperseded(routineId int32,
+	receiverDeliver *model.GHWebhookEventReceiverDeliver) bool {
+	if len(receiverDeliver.ConcurrencyGroup) == 0 {
+		return false
+	}
+
+	var newer []model.GHWebhookEventReceiverDeliver
+	r := h.db.Select("id").
+		Where("gh_webhook_receiver_id = ? AND concurrency_group = ? AND id > ? AND status IN ?",
+			receiverDeliver.GHWebhookReceiverId, receiverDeliver.ConcurrencyGroup, receiverDeliver.ID,
+			supersedingStatus).
+		Order("id").Limit(1).Find(&newer)
+	if r.Error != nil {
+		log.Errorf("[go routine %d] failed to find newer deliveries of group %s: %v", routineId,
+			receiverDeliver.ConcurrencyGroup, r.Error)
+		return false
+	} else if len(newer) == 0 {
+		return false
+	}
+
+	log.Infof("[go routine %d] delivery %d is superseded by delivery %d of group %s, cancel it", routineId,
+		receiverDeliver.ID, newer[0].ID, receiverDeliver.ConcurrencyGroup)
+	receiverDeliver.Status = model.DeliverStatusCancelled
+	receiverDeliver.CancelledByID = newer[0].ID
+	return true
+}
+
+// cancelSuperseded ask the receiver to cancel the older deliveries of the concurrency group which may still be
+// running, i.e. sent within the cancel window and not acked, they are marked cancelled once the receiver cancels them
+func (h *GHWebhookDeliverHandler) cancelSuperseded(routineId int32, re model.GHWebhookReceiver,
+	current model.GHWebhookEventReceiverDeliver) {
+	if len(current.ConcurrencyGroup) == 0 {
+		return
+	}
+
+	var previous []model.GHWebhookEventReceiverDeliver
+	r := h.db.Preload("GHWebhookEventDeliver").
+		Where("gh_webhook_receiver_id = ? AND concurrency_group = ? AND id < ? AND status = ? AND ack = ? "+
+			"AND created_at > ?", re.ID, current.ConcurrencyGroup, current.ID, model.DeliverStatusDelivered, "",
+			time.Now().Add(-cancelWindow)).
+		Order("id").Find(&previous)
+	if r.Error != nil {
+		log.Errorf("[go routine %d] failed to find superseded deliveries of group %s: %v", routineId,
+			current.ConcurrencyGroup, r.Error)
+		return
+	} else if len(previous) == 0 {
+		return
+	}
+
+	launcherInst, resolved, launcherErr := h.getLauncher(re)
+	for _, prev := range previous {
+		cancelErr := launcherErr
+		if cancelErr == nil {
+			cancelErr = launcherInst.Cancel(routineId, h.config, resolved, prev, current)
+		}
+		if cancelErr != nil {
+			log.Warningf("[go routine %d] receiver %d failed to cancel delivery %d: %v", routineId, re.ID, prev.ID,
+				cancelErr)
+			r = h.db.Model(&prev).Update("error", fmt.Sprintf("failed to cancel: %v", cancelErr))
+			if r.Error != nil {
+				log.Errorf("[go routine %d] failed to update delivery %d: %v", routineId, prev.ID, r.Error)
+			}
+			continue
+		}
+
+		// the delivery acked meanwhile is done, it's not marked cancelled
+		r = h.db.Model(&model.GHWebhookEventReceiverDeliver{}).
+			Where("id = ? AND status = ? AND ack = ?", prev.ID, model.DeliverStatusDelivered, "").
+			Updates(map[string]interface{}{
+				"status":          model.DeliverStatusCancelled,
+				"cancelled_by_id": current.ID,
+			})
+		if r.Error != nil {
+			log.Errorf("[go routine %d] failed to cancel delivery %d: %v", routineId, prev.ID, r.Error)
+		} else if r.RowsAffected > 0 {
+			log.Infof("[go routine %d] cancelled delivery %d superseded by delivery %d", routineId, prev.ID,
+				current.ID)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package webhook
+
+import (
+	"encoding/json"
+	"gh-webhook/pkg/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Concurrency(t *testing.T) {
+	var mutex sync.Mutex
+	var cancelled []uint
+	cancelServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var body map[string]interface{}
+		_ = json.NewDecoder(request.Body).Decode(&body)
+		mutex.Lock()
+		cancelled = append(cancelled, uint(body["eventDeliverId"].(float64)))
+		mutex.Unlock()
+		writer.WriteHeader(http.StatusOK)
+	}))
+	defer cancelServer.Close()
+
+	tr := newTestReceiver(t, func(receiver *model.GHWebhookReceiver) {
+		receiver.ReceiverConfig.GetHTTPConfig().CancelURL = cancelServer.URL
+		receiver.Subscribes[0].Concurrency = &model.ConcurrencyConfig{Group: `"pr-" + string(number)`}
+	})
+	events := tr.sendPayloads(
+		`{"action": "push", "number": 1}`,
+		`{"action": "push", "number": 2}`,
+		`{"action": "push", "number": 1}`,
+	)
+	tr.assertDelivered(t, events...)
+	tr.assertStatus(t, model.DeliverStatusCancelled, model.DeliverStatusDelivered, model.DeliverStatusDelivered)
+
+	var delivers []model.GHWebhookEventReceiverDeliver
+	tr.db.Order("id").Find(&delivers)
+	if delivers[0].CancelledByID != delivers[2].ID || delivers[0].ConcurrencyGroup != delivers[2].ConcurrencyGroup {
+		t.Fatal("the cancelled delivery should link to the newer one of its group")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(cancelled) != 1 || cancelled[0] != delivers[0].ID {
+		t.Fatalf("receiver should be asked to cancel delivery %d, got %v", delivers[0].ID, cancelled)
+	}
+}
+
+func Test_ConcurrencyBacklog(t *testing.T) {
+	tr := newTestReceiver(t, func(receiver *model.GHWebhookReceiver) {
+		receiver.Subscribes[0].Concurrency = &model.ConcurrencyConfig{Group: `"pr-" + string(number)`}
+		receiver.UnavailablePolicy = model.UnavailableHold
+		disabled := false
+		receiver.Enabled = &disabled
+	})
+	events := tr.sendPayloads(
+		`{"action": "push", "number": 1}`,
+		`{"action": "push", "number": 1}`,
+	)
+	tr.assertStatus(t, model.DeliverStatusHeld, model.DeliverStatusHeld)
+
+	// the held delivery superseded before it's sent never reaches the receiver
+	tr.db.Model(&tr.receiver).Update("enabled", true)
+	tr.drain()
+	tr.assertDelivered(t, events[1])
+	tr.assertStatus(t, model.DeliverStatusCancelled, model.DeliverStatusDelivered)
+}
+
+func Test_ConcurrencyDone(t *testing.T) {
+	var mutex sync.Mutex
+	var cancelled []uint
+	cancelOK := true
+	cancelServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var body map[string]interface{}
+		_ = json.NewDecoder(request.Body).Decode(&body)
+		mutex.Lock()
+		defer mutex.Unlock()
+		cancelled = append(cancelled, uint(body["eventDeliverId"].(float64)))
+		if !cancelOK {
+			writer.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer cancelServer.Close()
+
+	tr := newTestReceiver(t, func(receiver *model.GHWebhookReceiver) {
+		receiver.ReceiverConfig.GetHTTPConfig().CancelURL = cancelServer.URL
+		receiver.Subscribes[0].Concurrency = &model.ConcurrencyConfig{Group: `"pr-" + string(number)`}
+	})
+	tr.sendPayloads(`{"action": "push", "number": 1}`, `{"action": "push", "number": 2}`)
+	// the first one is acked by the receiver, the second one was sent before the cancel window
+	tr.db.Model(&model.GHWebhookEventReceiverDeliver{}).Where("id = ?", 1).Update("ack", "completed")
+	tr.db.Model(&model.GHWebhookEventReceiverDeliver{}).Where("id = ?", 2).
+		Update("created_at", time.Now().Add(-cancelWindow-time.Hour))
+	tr.sendPayloads(`{"action": "push", "number": 1}`, `{"action": "push", "number": 2}`)
+	tr.assertStatus(t, model.DeliverStatusDelivered, model.DeliverStatusDelivered, model.DeliverStatusDelivered,
+		model.DeliverStatusDelivered)
+	mutex.Lock()
+	if len(cancelled) != 0 {
+		t.Fatalf("the done deliveries should not be cancelled, got %v", cancelled)
+	}
+	cancelOK = false
+	mutex.Unlock()
+
+	// the delivery stays delivered until the receiver cancels it
+	tr.sendPayloads(`{"action": "push", "number": 1}`)
+	tr.assertStatus(t, model.DeliverStatusDelivered, model.DeliverStatusDelivered, model.DeliverStatusDelivered,
+		model.DeliverStatusDelivered, model.DeliverStatusDelivered)
+	var failed model.GHWebhookEventReceiverDeliver
+	tr.db.First(&failed, 3)
+	if !strings.HasPrefix(failed.Error, "failed to cancel") {
+		t.Fatalf("the failed cancel should be recorded, got %s", failed.Error)
+	}
+}
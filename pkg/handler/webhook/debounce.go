@@ -0,0 +1,165 @@
+package webhook
+
+import (
+	"fmt"
+	"gh-webhook/pkg/model"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"slices"
+	"time"
+)
+
+// debounce hold the delivery for the debounce window of the subscribe, the held deliveries of the same key are
+// coalesced into it. The delivery is sent when the window ends without a newer one.
+func (h *GHWebhookDeliverHandler) debounce(routineId int32, re model.GHWebhookReceiver, sub model.GHWebHookSubscribe,
+	payload map[string]interface{}, event model.GHWebhookEvent, receiverDeliver *model.GHWebhookEventReceiverDeliver) {
+	key, err := sub.Debounce.CoalesceKey(payload)
+	if err != nil {
+		log.Warningf("[go routine %d] failed to get debounce key of event %d, deliver it now: %v", routineId,
+			event.ID, err)
+		h.deliver(routineId, re, event, payload, receiverDeliver)
+		return
+	}
+
+	dueAt := time.Now().Add(sub.Debounce.Window.Duration())
+	receiverDeliver.Status = model.DeliverStatusDebounced
+	receiverDeliver.CoalesceKey = fmt.Sprintf("%d:%s", sub.ID, key)
+	receiverDeliver.DueAt = &dueAt
+	var coalesced int64
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		coalesced, err = coalesce(tx, re, receiverDeliver)
+		return err
+	})
+	if err != nil {
+		log.Errorf("[go routine %d] failed to debounce delivery %d, deliver it now: %v", routineId,
+			receiverDeliver.ID, err)
+		receiverDeliver.Status = ""
+		receiverDeliver.CoalesceKey = ""
+		receiverDeliver.DueAt = nil
+		h.deliver(routineId, re, event, payload, receiverDeliver)
+		return
+	}
+	if receiverDeliver.Status == model.DeliverStatusCoalesced {
+		log.Infof("[go routine %d] delivery %d of key %s is coalesced into the newer delivery %d", routineId,
+			receiverDeliver.ID, receiverDeliver.CoalesceKey, receiverDeliver.CoalescedIntoID)
+		return
+	} else if coalesced > 0 {
+		log.Infof("[go routine %d] coalesced %d deliveries of key %s into delivery %d", routineId, coalesced,
+			receiverDeliver.CoalesceKey, receiverDeliver.ID)
+	}
+
+	deliverId := receiverDeliver.ID
+	h.debounceTimers.Store(deliverId, time.AfterFunc(sub.Debounce.Window.Duration(), func() {
+		h.debounceTimers.Delete(deliverId)
+		h.flushDebounced(deliverId)
+	}))
+}
+
+// coalesce save the debounced delivery and coalesce the older debounced deliveries of its key into it, the delivery
+// is coalesced itself if a newer one of its key is debounced already. Only the older deliveries are superseded, so
+// the events handled out of order still deliver the latest one.
+func coalesce(tx *gorm.DB, re model.GHWebhookReceiver, receiverDeliver *model.GHWebhookEventReceiverDeliver) (int64,
+	error) {
+	var newer model.GHWebhookEventReceiverDeliver
+	r := tx.Where("gh_webhook_receiver_id = ? AND coalesce_key = ? AND status = ? AND id > ?", re.ID,
+		receiverDeliver.CoalesceKey, model.DeliverStatusDebounced, receiverDeliver.ID).Order("id DESC").
+		Limit(1).Find(&newer)
+	if r.Error != nil {
+		return 0, r.Error
+	}
+	if newer.ID > 0 {
+		receiverDeliver.Status = model.DeliverStatusCoalesced
+		receiverDeliver.CoalescedIntoID = newer.ID
+	}
+	r = tx.Model(receiverDeliver).Updates(map[string]interface{}{
+		"status":            receiverDeliver.Status,
+		"coalesce_key":      receiverDeliver.CoalesceKey,
+		"due_at":            receiverDeliver.DueAt,
+		"coalesced_into_id": receiverDeliver.CoalescedIntoID,
+	})
+	if r.Error != nil || newer.ID > 0 {
+		return 0, r.Error
+	}
+
+	older := tx.Model(&model.GHWebhookEventReceiverDeliver{}).Select("id").
+		Where("gh_webhook_receiver_id = ? AND coalesce_key = ? AND status = ? AND id < ?", re.ID,
+			receiverDeliver.CoalesceKey, model.DeliverStatusDebounced, receiverDeliver.ID)
+	// the deliveries coalesced into the superseded ones now link to the latest one
+	r = tx.Model(&model.GHWebhookEventReceiverDeliver{}).
+		Where("gh_webhook_receiver_id = ? AND status = ? AND coalesced_into_id IN (?)", re.ID,
+			model.DeliverStatusCoalesced, older).
+		Update("coalesced_into_id", receiverDeliver.ID)
+	if r.Error != nil {
+		return 0, r.Error
+	}
+	r = tx.Model(&model.GHWebhookEventReceiverDeliver{}).
+		Where("gh_webhook_receiver_id = ? AND coalesce_key = ? AND status = ? AND id < ?", re.ID,
+			receiverDeliver.CoalesceKey, model.DeliverStatusDebounced, receiverDeliver.ID).
+		Updates(map[string]interface{}{
+			"status":            model.DeliverStatusCoalesced,
+			"coalesced_into_id": receiverDeliver.ID,
+		})
+	return r.RowsAffected, r.Error
+}
+
+// flushDueDebounced send the debounced deliveries whose window ended, e.g. the ones held before a restart
+func (h *GHWebhookDeliverHandler) flushDueDebounced() {
+	var deliverIds []uint
+	r := h.db.Model(&model.GHWebhookEventReceiverDeliver{}).
+		Where("status = ? AND due_at <= ?", model.DeliverStatusDebounced, time.Now()).Order("id").
+		Pluck("id", &deliverIds)
+	if r.Error != nil {
+		log.Errorf("failed to find due debounced deliveries: %v", r.Error)
+		return
+	}
+	for _, deliverId := range deliverIds {
+		h.flushDebounced(deliverId)
+	}
+}
+
+// flushDebounced send the debounced delivery unless it's coalesced or already sent
+func (h *GHWebhookDeliverHandler) flushDebounced(deliverId uint) {
+	// claim the delivery, only one of the timer and the recover loop sends it
+	r := h.db.Model(&model.GHWebhookEventReceiverDeliver{}).
+		Where("id = ? AND status = ? AND due_at <= ?", deliverId, model.DeliverStatusDebounced, time.Now()).
+		Update("status", "")
+	if r.Error != nil {
+		log.Errorf("failed to claim debounced delivery %d: %v", deliverId, r.Error)
+		return
+	} else if r.RowsAffected == 0 {
+		return
+	}
+
+	var receiverDeliver model.GHWebhookEventReceiverDeliver
+	if r = h.db.Preload("GHWebhookEventDeliver.GHWebhookEvent").First(&receiverDeliver, deliverId); r.Error != nil {
+		log.Errorf("failed to find debounced delivery %d: %v", deliverId, r.Error)
+		return
+	}
+	var re model.GHWebhookReceiver
+	if r = h.db.First(&re, receiverDeliver.GHWebhookReceiverId); r.Error != nil {
+		log.Errorf("failed to find receiver %d: %v", receiverDeliver.GHWebhookReceiverId, r.Error)
+		h.db.Model(&receiverDeliver).Updates(map[string]interface{}{
+			"status": model.DeliverStatusFailed,
+			"error":  "receiver not found",
+		})
+		return
+	}
+
+	event := receiverDeliver.GHWebhookEventDeliver.GHWebhookEvent
+	log.Infof("[go routine %d] debounce window of delivery %d ended, deliver event %d", recoverRoutineId,
+		deliverId, event.ID)
+	h.deliver(recoverRoutineId, re, event, nil, &receiverDeliver)
+	r = h.db.Model(&receiverDeliver).Updates(map[string]interface{}{
+		"delivered":       receiverDeliver.Delivered,
+		"status":          receiverDeliver.Status,
+		"error":           receiverDeliver.Error,
+		"receiver_ref":    receiverDeliver.ReceiverRef,
+		"cancelled_by_id": receiverDeliver.CancelledByID,
+	})
+	if r.Error != nil {
+		log.Errorf("failed to update delivery %d: %v", deliverId, r.Error)
+	} else if slices.Contains(backlogStatus, receiverDeliver.Status) {
+		h.drainAsync(re.ID)
+	}
+}
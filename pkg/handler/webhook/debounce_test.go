@@ -0,0 +1,87 @@
+package webhook
+
+import (
+	"gh-webhook/pkg/model"
+	"slices"
+	"testing"
+	"time"
+)
+
+func Test_Debounce(t *testing.T) {
+	tr := newTestReceiver(t, func(receiver *model.GHWebhookReceiver) {
+		receiver.Subscribes[0].Debounce = &model.DebounceConfig{
+			Window: model.Duration(time.Second),
+			Key:    `repository.full_name + "#" + string(number)`,
+		}
+	})
+	events := tr.sendPayloads(
+		`{"action": "push", "number": 1, "repository": {"full_name": "zhaojunlucky/veda"}}`,
+		`{"action": "push", "number": 1, "repository": {"full_name": "zhaojunlucky/veda"}}`,
+		`{"action": "push", "number": 2, "repository": {"full_name": "zhaojunlucky/veda"}}`,
+		`{"action": "push", "number": 1, "repository": {"full_name": "zhaojunlucky/veda"}}`,
+	)
+	tr.assertStatus(t, model.DeliverStatusCoalesced, model.DeliverStatusCoalesced, model.DeliverStatusDebounced,
+		model.DeliverStatusDebounced)
+
+	var delivers []model.GHWebhookEventReceiverDeliver
+	tr.db.Order("id").Find(&delivers)
+	if delivers[0].CoalescedIntoID != delivers[3].ID || delivers[1].CoalescedIntoID != delivers[3].ID {
+		t.Fatal("coalesced deliveries should link to the latest one")
+	}
+
+	// wait for the debounce timers
+	time.Sleep(1500 * time.Millisecond)
+	tr.assertStatus(t, model.DeliverStatusCoalesced, model.DeliverStatusCoalesced, model.DeliverStatusDelivered,
+		model.DeliverStatusDelivered)
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	if len(tr.delivered) != 2 || !slices.Contains(tr.delivered, events[2].ID) ||
+		!slices.Contains(tr.delivered, events[3].ID) {
+		t.Fatalf("only the latest events should be delivered, got %v", tr.delivered)
+	}
+}
+
+func Test_coalesce(t *testing.T) {
+	tr := newTestReceiver(t, func(receiver *model.GHWebhookReceiver) {})
+	dueAt := time.Now().Add(time.Minute)
+	delivers := []model.GHWebhookEventReceiverDeliver{
+		{GHWebhookReceiverId: tr.receiver.ID},
+		{GHWebhookReceiverId: tr.receiver.ID},
+	}
+	tr.db.Create(&delivers)
+	for i := range delivers {
+		delivers[i].Status = model.DeliverStatusDebounced
+		delivers[i].CoalesceKey = "1:veda"
+		delivers[i].DueAt = &dueAt
+	}
+
+	// the newer delivery is debounced first, the older one handled later doesn't supersede it
+	if _, err := coalesce(tr.db, tr.receiver, &delivers[1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := coalesce(tr.db, tr.receiver, &delivers[0]); err != nil {
+		t.Fatal(err)
+	}
+	tr.assertStatus(t, model.DeliverStatusCoalesced, model.DeliverStatusDebounced)
+	var older model.GHWebhookEventReceiverDeliver
+	tr.db.First(&older, delivers[0].ID)
+	if older.CoalescedIntoID != delivers[1].ID {
+		t.Fatalf("the older delivery should be coalesced into the newer one, got %d", older.CoalescedIntoID)
+	}
+}
+
+func Test_DebounceClose(t *testing.T) {
+	tr := newTestReceiver(t, func(receiver *model.GHWebhookReceiver) {
+		receiver.Subscribes[0].Debounce = &model.DebounceConfig{Window: model.Duration(100 * time.Millisecond),
+			Key: `"veda"`}
+	})
+	tr.handler.stop = make(chan struct{})
+	tr.send(1)
+	if err := tr.handler.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the stopped timer doesn't send the delivery, the recover loop flushes it after the restart
+	time.Sleep(300 * time.Millisecond)
+	tr.assertStatus(t, model.DeliverStatusDebounced)
+}
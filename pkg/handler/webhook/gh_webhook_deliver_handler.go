@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"gh-webhook/pkg/config"
 	"gh-webhook/pkg/core"
+	"gh-webhook/pkg/github"
 	"gh-webhook/pkg/launcher"
 	"gh-webhook/pkg/model"
+	"gh-webhook/pkg/secret"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
@@ -16,15 +18,24 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type GHWebhookDeliverHandler struct {
-	queue        model.Queue
-	wg           sync.WaitGroup
-	routineId    int32
-	db           *gorm.DB
-	compiledExpr sync.Map
-	config       *config.Config
+	queue          model.Queue
+	wg             sync.WaitGroup
+	routineId      int32
+	db             *gorm.DB
+	circuits       sync.Map // receiver id -> *receiverCircuit
+	limiters       sync.Map // receiver id -> *tokenBucket
+	throttles      sync.Map // receiver id -> *throttleMetrics
+	debounceTimers sync.Map // receiver deliver id -> *time.Timer flushing the debounced delivery
+	sequencer      keySequencer
+	config         *config.Config
+	secretResolver *secret.Resolver
+	ghClient       *github.Client
+	stop           chan struct{} // closed to stop the recover loop
+	stopOnce       sync.Once
 }
 
 type GHEvent struct {
@@ -33,11 +44,13 @@ type GHEvent struct {
 }
 
 func (h *GHWebhookDeliverHandler) Start(processors int) {
-	h.wg.Add(processors)
+	h.stop = make(chan struct{})
+	h.wg.Add(processors + 1)
 
 	for i := 0; i < processors; i++ {
 		go h.handleWebHook()
 	}
+	go h.recoverDeliveries()
 }
 
 func (h *GHWebhookDeliverHandler) handleWebHook() {
@@ -46,24 +59,60 @@ func (h *GHWebhookDeliverHandler) handleWebHook() {
 	log.Infof("[go routine %d] started", routineId)
 	defer h.wg.Done()
 	for {
-		ghEvent, ok := <-h.queue // Receive with value and ok result
+		ghEvent, ticket, ok := h.sequencer.receive(h.queue) // Receive with value and ok result
 		if !ok {
 			log.Warningf("Channel closed, go routine %d exited", routineId)
 			return
 		}
 		log.Infof("[go routine %d] received web hook event %s action %s with payload %d", routineId,
 			ghEvent.Event, ghEvent.Action, ghEvent.ID)
-		h.handle(routineId, ghEvent)
+		h.handleTicket(routineId, ghEvent, ticket)
 
 	}
 }
 
+// Close stop the recover loop and the debounce timers, and wait for the processors to exit once the queue is closed,
+// the debounced deliveries are flushed by the recover loop after a restart
 func (h *GHWebhookDeliverHandler) Close() error {
+	h.stopOnce.Do(func() { close(h.stop) })
+	h.debounceTimers.Range(func(deliverId, timer any) bool {
+		timer.(*time.Timer).Stop()
+		h.debounceTimers.Delete(deliverId)
+		return true
+	})
 	h.wg.Wait()
 	return nil
 }
 
 func (h *GHWebhookDeliverHandler) handle(routineId int32, ghEvent model.GHWebhookEvent) {
+	h.handleTicket(routineId, ghEvent, h.sequencer.ticket())
+}
+
+// handleTicket handle the event, the deliveries of the receivers with ordering key are processed in ticket order
+// per key
+func (h *GHWebhookDeliverHandler) handleTicket(routineId int32, ghEvent model.GHWebhookEvent, ticket uint64) {
+	h.handleReceivers(routineId, ghEvent, ticket, 0)
+}
+
+// handleReceivers handle the event for the receivers, only the receiver of receiverId if it's not 0, the saved event
+// deliver log is returned
+func (h *GHWebhookDeliverHandler) handleReceivers(routineId int32, ghEvent model.GHWebhookEvent, ticket uint64,
+	receiverId uint) (receiverLog model.GHWebhookEventDeliver) {
+	registered := false
+	var keys []string
+	submitted := map[string]bool{}
+	defer func() {
+		// the later tickets wait for this one to register
+		if !registered {
+			h.sequencer.register(ticket, nil)
+		}
+		// and for the jobs of its keys, the keys which aren't submitted because of a panic are skipped
+		for _, key := range keys {
+			if !submitted[key] {
+				h.sequencer.skip(ticket, key)
+			}
+		}
+	}()
 	defer func() {
 		if r := recover(); r != nil {
 			log.Warningf("[go routine %d] handle: event %d panic occurred: %v, %s", routineId, ghEvent.ID, r,
@@ -71,7 +120,7 @@ func (h *GHWebhookDeliverHandler) handle(routineId int32, ghEvent model.GHWebhoo
 		}
 	}()
 
-	receiverLog := model.GHWebhookEventDeliver{
+	receiverLog = model.GHWebhookEventDeliver{
 		GHWebhookEventId: ghEvent.ID,
 		GHWebhookEvent:   ghEvent,
 	}
@@ -96,8 +145,15 @@ func (h *GHWebhookDeliverHandler) handle(routineId int32, ghEvent model.GHWebhoo
 		return
 	}
 
+	var candidates []model.GHWebhookReceiver
+	r := model.ScopeCandidates(h.db.Model(&model.GHWebhookReceiver{}).Preload("Subscribes"), ghEvent.GitHubId,
+		ghEvent.Org).Find(&candidates)
 	var receiver []model.GHWebhookReceiver
-	r := h.db.Model(&model.GHWebhookReceiver{}).Preload("Subscribes").Where("github_id = ?", ghEvent.GitHubId).Find(&receiver)
+	for _, re := range candidates {
+		if (receiverId == 0 || re.ID == receiverId) && re.MatchesScope(ghEvent) {
+			receiver = append(receiver, re)
+		}
+	}
 	if r.Error != nil {
 		log.Errorf("[go routine %d] failed to find receiver: %v", routineId, r.Error)
 		receiverLog.Delivered = false
@@ -117,13 +173,40 @@ func (h *GHWebhookDeliverHandler) handle(routineId int32, ghEvent model.GHWebhoo
 	}
 	log.Infof("[go routine %d] found receivers %s", routineId, strings.Join(ids, ", "))
 
+	orderingKeys := map[uint]string{}
+	for _, re := range receiver {
+		if len(re.OrderingKey) == 0 {
+			continue
+		}
+		key, err := re.GetOrderingKey(payload)
+		if err != nil {
+			log.Infof("[go routine %d] event %d has no ordering key for receiver %d: %v", routineId, ghEvent.ID,
+				re.ID, err)
+			continue
+		}
+		orderingKeys[re.ID] = fmt.Sprintf("%d:%s", re.ID, key)
+		keys = append(keys, orderingKeys[re.ID])
+	}
+	h.sequencer.register(ticket, keys)
+	registered = true
+
+	lookup := h.eventLookup(ghEvent, payload)
 	for _, re := range receiver {
-		h.handleReceiver(routineId, re, ghEvent, payload, receiverLog)
+		job := func() {
+			h.handleReceiver(routineId, re, ghEvent, payload, lookup, receiverLog)
+		}
+		if key, ok := orderingKeys[re.ID]; ok {
+			submitted[key] = true
+			h.sequencer.submit(ticket, key, job)
+		} else {
+			runGuarded(fmt.Sprintf("[go routine %d] event %d receiver %d", routineId, ghEvent.ID, re.ID), job)
+		}
 	}
+	return
 }
 
 func (h *GHWebhookDeliverHandler) handleReceiver(routineId int32, re model.GHWebhookReceiver, event model.GHWebhookEvent,
-	payload map[string]interface{}, receiverLog model.GHWebhookEventDeliver) {
+	payload map[string]interface{}, lookup *github.EventLookup, receiverLog model.GHWebhookEventDeliver) {
 	receiverDeliver := model.GHWebhookEventReceiverDeliver{
 		GHWebhookReceiverId:     re.ID,
 		Delivered:               false,
@@ -131,53 +214,132 @@ func (h *GHWebhookDeliverHandler) handleReceiver(routineId int32, re model.GHWeb
 		GHWebhookEventDeliverID: receiverLog.ID,
 	}
 
+	var matches []model.GHWebhookSubscribeMatch
 	defer func() {
 		r := h.db.Save(&receiverDeliver)
 		if r.Error != nil {
 			log.Errorf("[go routine %d] failed to create receiver deliver log: %v", routineId, r.Error)
+		} else if slices.Contains(backlogStatus, receiverDeliver.Status) {
+			// the backlog is drained from db, so only after the delivery is saved
+			h.drainAsync(re.ID)
 		}
+		h.saveMatches(routineId, receiverDeliver.ID, matches)
 	}()
 
 	if r := h.db.Save(&receiverDeliver); r.Error != nil {
 		log.Errorf("[go routine %d] failed to create receiver deliver log: %v", routineId, r.Error)
 	}
 
+	receiverDeliver.Status = model.DeliverStatusSkipped
 	if len(re.Subscribes) == 0 {
 		receiverDeliver.Error = fmt.Sprintf("[go routine %d] no subscribe found for receiver %d", routineId, re.ID)
 		log.Warning(receiverDeliver.Error)
 		return
 	} else if !slices.Contains(launcher.SupportedReceiverType, re.ReceiverConfig.Type) {
+		receiverDeliver.Status = model.DeliverStatusFailed
 		receiverDeliver.Error = fmt.Sprintf("[go routine %d] unsupported receiver type %s", routineId, re.ReceiverConfig.Type)
 		log.Warning(receiverDeliver.Error)
 		return
 	}
 
+	if len(re.Enrichments) > 0 {
+		// the enrichments are got before matching, so the filters of all subscribes can use them
+		payload = model.WithEnriched(payload, lookup.Enrich(re.Enrichments))
+	}
 	for _, sub := range re.Subscribes {
-		if sub.Event != event.Action {
-			log.Infof("[go routine %d] skip subscribe for event %s", routineId, sub.Event)
+		evalStart := time.Now()
+		matcher, err := model.GetSubscribeMatcher(&sub)
+		if err != nil {
+			log.Errorf("[go routine %d] failed to compile subscribe %d: %v", routineId, sub.ID, err)
+			matches = append(matches, model.NewGHWebhookSubscribeMatch(sub, event,
+				fmt.Errorf("failed to compile subscribe: %v", err), time.Since(evalStart)))
 			continue
 		}
-
-		if err := sub.Matches(payload, event); err != nil {
-			log.Warningf("[go routine %d] failed to match subscribe: %v", routineId, err)
+		err = matcher.Matches(payload, event, lookup)
+		matches = append(matches, model.NewGHWebhookSubscribeMatch(sub, event, err, time.Since(evalStart)))
+		if err != nil {
+			log.Infof("[go routine %d] subscribe %d doesn't match: %v", routineId, sub.ID, err)
 			continue
 		}
 
-		receiverDeliver.Delivered = true
-		deliverErr := h.launchDelivery(routineId, re, event, receiverDeliver)
-		if deliverErr != nil {
-			receiverDeliver.Error = deliverErr.Error()
+		if sub.Concurrency != nil {
+			group, err := sub.Concurrency.GroupKey(payload)
+			if err != nil {
+				log.Warningf("[go routine %d] failed to get concurrency group of event %d: %v", routineId,
+					event.ID, err)
+			} else {
+				receiverDeliver.ConcurrencyGroup = fmt.Sprintf("%d:%s", sub.ID, group)
+			}
+		}
+		if sub.Debounce != nil {
+			h.debounce(routineId, re, sub, payload, event, &receiverDeliver)
+		} else {
+			h.deliver(routineId, re, event, payload, &receiverDeliver)
 		}
 		break
 	}
 
 }
 
-func (h *GHWebhookDeliverHandler) launchDelivery(routineId int32, re model.GHWebhookReceiver, event model.GHWebhookEvent,
-	receiverDeliver model.GHWebhookEventReceiverDeliver) error {
+// saveMatches save the match decisions of the subscribes for the delivery
+func (h *GHWebhookDeliverHandler) saveMatches(routineId int32, receiverDeliverId uint,
+	matches []model.GHWebhookSubscribeMatch) {
+	if len(matches) == 0 {
+		return
+	}
+	for i := range matches {
+		matches[i].GHWebhookEventReceiverDeliverID = receiverDeliverId
+	}
+	if r := h.db.Create(&matches); r.Error != nil {
+		log.Errorf("[go routine %d] failed to save subscribe matches of delivery %d: %v", routineId,
+			receiverDeliverId, r.Error)
+	}
+}
 
-	launcherInst, err := launcher.NewLauncher(re.ReceiverConfig.Type)
+// pruneMatches delete the match decisions older than the retention
+func (h *GHWebhookDeliverHandler) pruneMatches(now time.Time) {
+	r := h.db.Unscoped().Where("created_at < ?", now.Add(-h.config.GetMatchRetention())).
+		Delete(&model.GHWebhookSubscribeMatch{})
+	if r.Error != nil {
+		log.Errorf("failed to prune subscribe matches: %v", r.Error)
+	} else if r.RowsAffected > 0 {
+		log.Infof("pruned %d subscribe matches", r.RowsAffected)
+	}
+}
+
+// eventLookup the GitHub API lookups of the event, they are shared by all receivers of the event
+func (h *GHWebhookDeliverHandler) eventLookup(ghEvent model.GHWebhookEvent,
+	payload map[string]interface{}) *github.EventLookup {
+	return h.ghClient.NewEventLookup(func() (model.GitHub, error) {
+		var gh model.GitHub
+		if r := h.db.First(&gh, ghEvent.GitHubId); r.Error != nil {
+			return gh, fmt.Errorf("failed to find github %d: %v", ghEvent.GitHubId, r.Error)
+		}
+		return gh, nil
+	}, ghEvent, payload)
+}
+
+// payloadError the payload can't be prepared for the receiver, so the receiver isn't called
+type payloadError struct {
+	err error
+}
+
+func (e *payloadError) Error() string {
+	return e.err.Error()
+}
 
+// launchDelivery send the event to the receiver with the enriched and transformed payload. payload is the enriched
+// payload used for matching, the event is enriched again if it's nil, e.g. the delivery is sent from the backlog.
+// A *payloadError is returned if the payload can't be prepared.
+func (h *GHWebhookDeliverHandler) launchDelivery(routineId int32, re model.GHWebhookReceiver, event model.GHWebhookEvent,
+	payload map[string]interface{}, receiverDeliver *model.GHWebhookEventReceiverDeliver) error {
+	event, err := h.receiverPayload(re, event, payload)
+	if err != nil {
+		return &payloadError{err: err}
+	}
+	receiverDeliver.PayloadSize = len(event.Payload)
+
+	launcherInst, re, err := h.getLauncher(re)
 	if err != nil {
 		return err
 	}
@@ -185,6 +347,47 @@ func (h *GHWebhookDeliverHandler) launchDelivery(routineId int32, re model.GHWeb
 	return launcherInst.Launch(routineId, h.config, re, event, receiverDeliver)
 }
 
+// receiverPayload the event with the payload sent to the receiver, the enrichments are attached before the
+// transform, so both of them can read model.EnrichedKey
+func (h *GHWebhookDeliverHandler) receiverPayload(re model.GHWebhookReceiver, event model.GHWebhookEvent,
+	payload map[string]interface{}) (model.GHWebhookEvent, error) {
+	if len(re.Enrichments) > 0 {
+		if payload == nil {
+			if err := json.Unmarshal([]byte(event.Payload), &payload); err != nil {
+				return event, fmt.Errorf("failed to parse payload as json: %v", err)
+			}
+			payload = model.WithEnriched(payload, h.eventLookup(event, payload).Enrich(re.Enrichments))
+		}
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return event, fmt.Errorf("failed to encode enriched payload: %v", err)
+		}
+		event.Payload = string(data)
+	}
+	return re.TransformPayload(event)
+}
+
+// getLauncher the launcher of the receiver type and the receiver with resolved secrets
+func (h *GHWebhookDeliverHandler) getLauncher(re model.GHWebhookReceiver) (launcher.GHWebhookReceiverLauncher,
+	model.GHWebhookReceiver, error) {
+	launcherInst, err := launcher.NewLauncher(re.ReceiverConfig.Type)
+
+	if err != nil {
+		return nil, re, err
+	}
+
+	// launchers get the resolved secrets instead of the references stored in db
+	receiverConfig, err := re.ReceiverConfig.Clone()
+	if err != nil {
+		return nil, re, err
+	}
+	if err = h.secretResolver.ResolveAll(receiverConfig.Secrets()); err != nil {
+		return nil, re, err
+	}
+	re.ReceiverConfig = receiverConfig
+	return launcherInst, re, nil
+}
+
 func (h *GHWebhookDeliverHandler) Get(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
@@ -192,14 +395,28 @@ func (h *GHWebhookDeliverHandler) Get(c *gin.Context) {
 	})
 }
 
+// Metrics delivery metrics, throttle is the throttled deliveries and their total wait per receiver id
+func (h *GHWebhookDeliverHandler) Metrics(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"queue":    len(h.queue),
+		"throttle": h.throttleMetrics(),
+	})
+}
+
 func (h *GHWebhookDeliverHandler) Register(c *core.GHPRContext) error {
 	h.queue = model.GetQueue()
 	h.wg = sync.WaitGroup{}
 	h.routineId = 0
 	h.db = c.Db
-	h.compiledExpr = sync.Map{}
+	h.circuits = sync.Map{}
+	h.limiters = sync.Map{}
+	h.throttles = sync.Map{}
 	h.config = c.Cfg
+	h.secretResolver = c.Secrets
+	h.ghClient = github.NewClient(c.Secrets)
 	h.Start(4)
 	c.Gin.GET(fmt.Sprintf("%s/gh-webhook-handler/queue", c.Cfg.APIPrefix), h.Get)
+	c.Gin.GET(fmt.Sprintf("%s/gh-webhook-handler/metrics", c.Cfg.APIPrefix), h.Metrics)
+	c.Gin.POST(fmt.Sprintf("%s/gh-webhook-event/inject", c.Cfg.APIPrefix), h.Inject)
 	return nil
 }
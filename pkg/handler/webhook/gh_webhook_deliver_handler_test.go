@@ -2,9 +2,11 @@ package webhook
 
 import (
 	"database/sql/driver"
+	"encoding/json"
 	"fmt"
 	"gh-webhook/pkg/config"
 	"gh-webhook/pkg/model"
+	"gh-webhook/pkg/secret"
 	"github.com/DATA-DOG/go-sqlmock"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/driver/sqlite"
@@ -13,6 +15,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 )
@@ -68,7 +71,7 @@ func Test_handle(t *testing.T) {
 	handler.db = db
 	mock.ExpectBegin()
 	mock.ExpectExec("INSERT INTO `git_hubs`").WithArgs(PrepareArgs(7)...).WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectExec("INSERT INTO `gh_webhook_events`").WithArgs(PrepareArgs(11)...).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO `gh_webhook_events`").WithArgs(PrepareArgs(13)...).WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectExec("INSERT INTO `gh_webhook_event_delivers`").WithArgs(PrepareArgs(6)...).WillReturnResult(sqlmock.NewResult(1, 1))
 
 	mock.ExpectCommit()
@@ -79,7 +82,7 @@ func Test_handle(t *testing.T) {
 	receiverConfig := fmt.Sprintf(`
 {
 "type": "jenkins",
-"auth": "none",
+"auth": {"type": "none"},
 "parameter": "test",
 "url": "%s"
 }
@@ -90,15 +93,24 @@ func Test_handle(t *testing.T) {
 
 	filters := `{"action":{"positiveMatches":["push"],"negativeMatches":[]}}`
 	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `gh_web_hook_subscribes`")).WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at",
-		"deleted_at", "gh_webhook_receiver_id", "event", "filters"}).AddRow(1, time.Now(), time.Now(), nil, 1, "push", filters))
+		"deleted_at", "gh_webhook_receiver_id", "events", "filters"}).AddRow(1, time.Now(), time.Now(), nil, 1, `["push"]`, filters))
 
 	mock.ExpectBegin()
 	mock.ExpectExec("INSERT INTO `git_hubs`").WithArgs(PrepareArgs(7)...).WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectExec("INSERT INTO `gh_webhook_events`").WithArgs(PrepareArgs(12)...).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO `gh_webhook_events`").WithArgs(PrepareArgs(14)...).WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectExec("INSERT INTO `gh_webhook_event_delivers`").WithArgs(PrepareArgs(7)...).WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectExec("INSERT INTO `gh_webhook_event_receiver_delivers`").WithArgs(PrepareArgs(8)...).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO `gh_webhook_event_receiver_delivers`").WithArgs(PrepareArgs(16)...).WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectCommit()
 
+	circuitRows := []string{"id", "circuit_state", "circuit_failures", "circuit_opened_at"}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id`,`circuit_state`")).WillReturnRows(sqlmock.NewRows(circuitRows).
+		AddRow(1, model.CircuitClosed, 0, nil))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `gh_webhook_event_receiver_delivers`")).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id`,`circuit_state`")).WillReturnRows(sqlmock.NewRows(circuitRows).
+		AddRow(1, model.CircuitClosed, 0, nil))
+
+	handler.secretResolver = secret.NewResolver(time.Minute)
 	handler.config = &config.Config{
 		DBType:     "",
 		DBDsn:      "",
@@ -117,3 +129,260 @@ func PrepareArgs(count int) []driver.Value {
 	}
 	return args
 }
+
+func Test_SubscribeMatches(t *testing.T) {
+	tr := newTestReceiver(t, func(receiver *model.GHWebhookReceiver) {
+		receiver.Subscribes = append([]model.GHWebHookSubscribe{{
+			Events:  []string{"push"},
+			Filters: map[string]model.GHWebhookField{"$.action": {PositiveMatches: []string{"^opened$"}}},
+		}}, receiver.Subscribes...)
+	})
+	events := tr.sendPayloads(`{"action": "push"}`, `{"action": "closed"}`)
+
+	var matches []model.GHWebhookSubscribeMatch
+	tr.db.Order("id").Find(&matches)
+	if len(matches) != 4 {
+		t.Fatalf("should record 4 match decisions, got %d", len(matches))
+	}
+	expected := []struct {
+		event   uint
+		sub     uint
+		matched bool
+	}{
+		{events[0].ID, tr.receiver.Subscribes[0].ID, false},
+		{events[0].ID, tr.receiver.Subscribes[1].ID, true},
+		{events[1].ID, tr.receiver.Subscribes[0].ID, false},
+		{events[1].ID, tr.receiver.Subscribes[1].ID, false},
+	}
+	for i, match := range matches {
+		e := expected[i]
+		if match.GHWebhookEventID != e.event || match.GHWebHookSubscribeID != e.sub || match.Matched != e.matched {
+			t.Fatalf("unexpected match decision %d: %+v", i, match)
+		}
+		if match.GHWebhookReceiverID != tr.receiver.ID || match.GHWebhookEventReceiverDeliverID == 0 {
+			t.Fatalf("match decision %d should link the delivery: %+v", i, match)
+		}
+		if !match.Matched && (match.FailedCondition != "filters[$.action]" || len(match.Reason) == 0) {
+			t.Fatalf("match decision %d should tell why it doesn't match: %+v", i, match)
+		}
+	}
+}
+
+func Test_pruneMatches(t *testing.T) {
+	tr := newTestReceiver(t, func(receiver *model.GHWebhookReceiver) {})
+	tr.handler.config.MatchRetention = time.Hour
+	tr.send(2)
+	tr.db.Model(&model.GHWebhookSubscribeMatch{}).Where("id = ?", 1).
+		Update("created_at", time.Now().Add(-2*time.Hour))
+
+	tr.handler.pruneMatches(time.Now())
+	var ids []uint
+	tr.db.Unscoped().Model(&model.GHWebhookSubscribeMatch{}).Order("id").Pluck("id", &ids)
+	if len(ids) != 1 || ids[0] != 2 {
+		t.Fatalf("only the expired match decision should be deleted, got %v", ids)
+	}
+}
+
+func Test_SubscribePaths(t *testing.T) {
+	requests := 0
+	api := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requests++
+		if request.URL.Path != "/repos/octo/mono/pulls/7/files" {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(writer, `[{"filename": "services/api/main.go"}, {"filename": "docs/README.md"}]`)
+	}))
+	t.Cleanup(api.Close)
+
+	tr := newTestReceiver(t, func(receiver *model.GHWebhookReceiver) {
+		receiver.Subscribes = []model.GHWebHookSubscribe{{
+			Events:      []string{"pull_request"},
+			Paths:       []string{"services/api/**"},
+			PathsIgnore: []string{"**/*.md"},
+		}, {
+			Events: []string{"pull_request"},
+			Paths:  []string{"services/web/**"},
+		}}
+	})
+	tr.db.Model(&model.GitHub{}).Where("id = ?", tr.receiver.GitHubId).Update("api", api.URL)
+
+	event := model.GHWebhookEvent{
+		Payload: `{"action": "opened", "repository": {"full_name": "octo/mono"},
+"pull_request": {"number": 7, "head": {"sha": "abc"}}}`,
+		Event:    "pull_request",
+		Action:   "opened",
+		GitHubId: tr.receiver.GitHubId,
+	}
+	tr.db.Omit("GitHub").Create(&event)
+	tr.handler.handle(1, event)
+
+	tr.assertStatus(t, model.DeliverStatusDelivered)
+	if requests != 1 {
+		t.Fatalf("the pull request files should be fetched once, got %d requests", requests)
+	}
+	var matches []model.GHWebhookSubscribeMatch
+	tr.db.Order("id").Find(&matches)
+	if len(matches) != 1 || !matches[0].Matched {
+		t.Fatalf("the first subscribe should match the changed api files, got %+v", matches)
+	}
+}
+
+func Test_ReceiverScope(t *testing.T) {
+	tr := newTestReceiver(t, func(receiver *model.GHWebhookReceiver) {
+		receiver.Org = "ZhaojunLucky"
+		receiver.Repo = "gh-*"
+	})
+	var payloads []string
+	for _, repo := range []string{"zhaojunlucky/gh-webhook", "zhaojunlucky/veda", "octo/gh-webhook"} {
+		payloads = append(payloads, fmt.Sprintf(`{"action": "push", "repository": {"full_name": "%s"}}`, repo))
+	}
+	for _, payload := range payloads {
+		event := model.GHWebhookEvent{Payload: payload, Event: "push", Action: "push", GitHubId: tr.receiver.GitHubId}
+		var parsed map[string]interface{}
+		_ = json.Unmarshal([]byte(payload), &parsed)
+		event.SetOrgRepo(parsed)
+		tr.db.Omit("GitHub").Create(&event)
+		tr.handler.handle(1, event)
+	}
+
+	// only the event in the scope is delivered to the receiver
+	tr.assertStatus(t, model.DeliverStatusDelivered)
+	var delivers []model.GHWebhookEventDeliver
+	tr.db.Order("id").Find(&delivers)
+	if len(delivers) != 3 || !delivers[0].Delivered || delivers[1].Delivered || delivers[2].Delivered {
+		t.Fatalf("the events out of the scope should have no receiver, got %+v", delivers)
+	}
+}
+
+func Test_Enrichments(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/repos/octo/mono/pulls/7":
+			fmt.Fprint(writer, `{"number": 7, "mergeable": true, "mergeable_state": "clean"}`)
+		case "/repos/octo/mono/pulls/8":
+			fmt.Fprint(writer, `{"number": 8, "mergeable": false, "mergeable_state": "dirty"}`)
+		default:
+			writer.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(api.Close)
+
+	tr := newTestReceiver(t, func(receiver *model.GHWebhookReceiver) {
+		receiver.Enrichments = []string{model.EnrichPullRequest}
+		receiver.Subscribes = []model.GHWebHookSubscribe{{
+			Events: []string{"issue_comment"},
+			Filters: map[string]model.GHWebhookField{
+				`$["$enriched"].pull_request.mergeable`: {Expr: "cur == true"},
+			},
+		}}
+	})
+	tr.db.Model(&model.GitHub{}).Where("id = ?", tr.receiver.GitHubId).Update("api", api.URL)
+
+	for _, number := range []int{7, 8} {
+		event := model.GHWebhookEvent{
+			Payload: fmt.Sprintf(`{"action": "created", "repository": {"full_name": "octo/mono"},
+"issue": {"number": %d, "pull_request": {}}, "comment": {"body": "/test"}}`, number),
+			Event:    "issue_comment",
+			Action:   "created",
+			GitHubId: tr.receiver.GitHubId,
+		}
+		tr.db.Omit("GitHub").Create(&event)
+		tr.handler.handle(1, event)
+	}
+
+	// only the mergeable pull request is delivered
+	tr.assertStatus(t, model.DeliverStatusDelivered, model.DeliverStatusSkipped)
+}
+
+func Test_Transform(t *testing.T) {
+	tr := newTestReceiver(t, func(receiver *model.GHWebhookReceiver) {
+		receiver.Transform = &model.PayloadTransform{Fields: map[string]string{
+			"action": "$.action",
+			"sha":    "$.after",
+		}}
+	})
+	large := fmt.Sprintf(`{"action": "push", "after": "abc", "commits": [%s]}`,
+		strings.TrimSuffix(strings.Repeat(`{"message": "fix", "modified": ["a.go"]},`, 100), ","))
+	tr.sendPayloads(large)
+
+	tr.assertStatus(t, model.DeliverStatusDelivered)
+	if len(tr.payloads) != 1 || tr.payloads[0] != `{"action":"push","sha":"abc"}` {
+		t.Fatalf("should deliver the projected payload, got %v", tr.payloads)
+	}
+	var deliver model.GHWebhookEventReceiverDeliver
+	tr.db.First(&deliver)
+	if deliver.PayloadSize != len(tr.payloads[0]) {
+		t.Fatalf("payload size should be %d, got %d", len(tr.payloads[0]), deliver.PayloadSize)
+	}
+
+	// the failed transform is recorded on the delivery without calling the receiver
+	tr.db.Model(&tr.receiver).Updates(model.GHWebhookReceiver{Transform: &model.PayloadTransform{Expr: `int(after)`}})
+	tr.sendPayloads(large)
+	tr.assertStatus(t, model.DeliverStatusDelivered, model.DeliverStatusFailed)
+	var failed model.GHWebhookEventReceiverDeliver
+	tr.db.Last(&failed)
+	if !strings.HasPrefix(failed.Error, "failed to transform payload") {
+		t.Fatalf("transform error should be recorded, got %s", failed.Error)
+	}
+	if len(tr.payloads) != 1 {
+		t.Fatalf("the receiver should not be called, got %v", tr.payloads)
+	}
+}
+
+func Test_EnrichedTransform(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/repos/octo/mono/pulls/7":
+			fmt.Fprint(writer, `{"number": 7, "mergeable": true}`)
+		case "/repos/octo/mono/pulls/8":
+			fmt.Fprint(writer, `{"number": 8, "mergeable": false}`)
+		default:
+			writer.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(api.Close)
+
+	tr := newTestReceiver(t, func(receiver *model.GHWebhookReceiver) {
+		receiver.Enrichments = []string{model.EnrichPullRequest}
+		receiver.UnavailablePolicy = model.UnavailableHold
+		receiver.Transform = &model.PayloadTransform{Fields: map[string]string{
+			"number":    "$.issue.number",
+			"mergeable": `$["$enriched"].pull_request.mergeable`,
+		}}
+		receiver.Subscribes = []model.GHWebHookSubscribe{{
+			Events:  []string{"issue_comment"},
+			Filters: map[string]model.GHWebhookField{"$.action": {PositiveMatches: []string{"created"}}},
+		}}
+	})
+	tr.db.Model(&model.GitHub{}).Where("id = ?", tr.receiver.GitHubId).Update("api", api.URL)
+	send := func(number int) {
+		event := model.GHWebhookEvent{
+			Payload: fmt.Sprintf(`{"action": "created", "repository": {"full_name": "octo/mono"},
+"issue": {"number": %d, "pull_request": {}}}`, number),
+			Event:    "issue_comment",
+			Action:   "created",
+			GitHubId: tr.receiver.GitHubId,
+		}
+		tr.db.Omit("GitHub").Create(&event)
+		tr.handler.handle(1, event)
+	}
+
+	send(7)
+	// the held delivery is enriched again when it's drained
+	start := time.Now().Add(-time.Minute)
+	end := start.Add(time.Hour)
+	tr.db.Model(&tr.receiver).Updates(model.GHWebhookReceiver{
+		MaintenanceWindows: []model.MaintenanceWindow{{Start: &start, End: &end}},
+	})
+	send(8)
+	tr.assertStatus(t, model.DeliverStatusDelivered, model.DeliverStatusHeld)
+	tr.db.Model(&tr.receiver).Select("maintenance_windows").Updates(model.GHWebhookReceiver{})
+	tr.drain()
+
+	tr.assertStatus(t, model.DeliverStatusDelivered, model.DeliverStatusDelivered)
+	expected := []string{`{"mergeable":true,"number":7}`, `{"mergeable":false,"number":8}`}
+	if strings.Join(tr.payloads, ",") != strings.Join(expected, ",") {
+		t.Fatalf("the transform should read the enrichments, got %v", tr.payloads)
+	}
+}
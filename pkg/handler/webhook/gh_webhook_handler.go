@@ -48,6 +48,7 @@ func (h *GHWebhookHandler) Post(c *gin.Context) {
 		log.Errorf("failed to unmarshal payload: %v", err)
 		c.JSON(http.StatusUnprocessableEntity, gin.H{"status": "Failed to unmarshal payload as map[string]interface{}"})
 	}
+	ghHookEvent.SetOrgRepo(payload)
 	action, err := jsonpath.Get("$.action", payload)
 	if err != nil {
 		log.Errorf("failed to get action from payload: %v", err)
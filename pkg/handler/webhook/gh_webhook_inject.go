@@ -0,0 +1,157 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gh-webhook/pkg/fixture"
+	"gh-webhook/pkg/model"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"net/http"
+	"time"
+)
+
+// injectRoutineId routine id of the injected events, they are handled by the request
+const injectRoutineId int32 = -1
+
+// GHWebhookEventInjectDTO the event to inject, either an inline payload or a bundled fixture
+type GHWebhookEventInjectDTO struct {
+	Event   string                 `json:"event"`   // required by the inline payload, default the fixture
+	Payload map[string]interface{} `json:"payload"` // inline payload
+	Fixture string                 `json:"fixture"` // the bundled payload of the event, e.g. push
+
+	// values of the dotted paths of the payload, e.g. {"repository.full_name": "octo/mono"}
+	Overrides map[string]interface{} `json:"overrides"`
+
+	GitHubId   uint `json:"githubId"`   // default the GitHub server of the receiver
+	ReceiverId uint `json:"receiverId"` // only match the receiver, all receivers of the GitHub server if 0
+}
+
+// GHWebhookEventInjectResultDTO the deliveries waiting for an earlier delivery of their ordering key are created
+// once it's done, so they are not in DeliveryIds
+type GHWebhookEventInjectResultDTO struct {
+	EventId        uint   `json:"eventId"`
+	EventDeliverId uint   `json:"eventDeliverId"`
+	DeliveryIds    []uint `json:"deliveryIds"`
+	Error          string `json:"error,omitempty"` // why no receiver is delivered, e.g. no receivers found
+}
+
+// Inject persist a synthetic event and handle it like the events from GitHub, e.g. to test a new receiver
+func (h *GHWebhookDeliverHandler) Inject(c *gin.Context) {
+	var injectDTO GHWebhookEventInjectDTO
+	if err := c.ShouldBindJSON(&injectDTO); err != nil {
+		log.Errorf("failed to bind json: %v", err)
+		c.JSON(http.StatusBadRequest, model.NewErrorMsgDTOFromErr(err))
+		return
+	}
+
+	ghEvent, status, err := h.injectEvent(injectDTO)
+	if err != nil {
+		log.Errorf("invalid inject event: %v", err)
+		c.JSON(status, model.NewErrorMsgDTOFromErr(err))
+		return
+	}
+	if r := h.db.Omit("GitHub").Create(&ghEvent); r.Error != nil {
+		log.Errorf("failed to create webhook event: %v", r.Error)
+		c.JSON(http.StatusUnprocessableEntity, model.NewErrorMsgDTOFromErr(r.Error))
+		return
+	}
+	log.Infof("[go routine %d] injected %s event %d", injectRoutineId, ghEvent.Event, ghEvent.ID)
+
+	receiverLog := h.handleReceivers(injectRoutineId, ghEvent, h.sequencer.ticket(), injectDTO.ReceiverId)
+	var deliveries []model.GHWebhookEventReceiverDeliver
+	r := h.db.Select("id").Where("gh_webhook_event_deliver_id = ?", receiverLog.ID).Order("id").Find(&deliveries)
+	if r.Error != nil {
+		log.Errorf("failed to find deliveries of event %d: %v", ghEvent.ID, r.Error)
+		c.JSON(http.StatusUnprocessableEntity, model.NewErrorMsgDTOFromErr(r.Error))
+		return
+	}
+	result := GHWebhookEventInjectResultDTO{
+		EventId:        ghEvent.ID,
+		EventDeliverId: receiverLog.ID,
+		DeliveryIds:    []uint{},
+		Error:          receiverLog.Error,
+	}
+	for _, deliver := range deliveries {
+		result.DeliveryIds = append(result.DeliveryIds, deliver.ID)
+	}
+	c.JSON(http.StatusCreated, result)
+}
+
+// injectEvent the synthetic event of the inline payload or the fixture with the overrides
+func (h *GHWebhookDeliverHandler) injectEvent(injectDTO GHWebhookEventInjectDTO) (model.GHWebhookEvent, int, error) {
+	payload := injectDTO.Payload
+	event := injectDTO.Event
+	switch {
+	case payload != nil && len(injectDTO.Fixture) > 0:
+		return model.GHWebhookEvent{}, http.StatusBadRequest, fmt.Errorf("either payload or fixture is required")
+	case payload != nil:
+		if len(event) == 0 {
+			return model.GHWebhookEvent{}, http.StatusBadRequest, fmt.Errorf("event is required by the payload")
+		}
+	case len(injectDTO.Fixture) > 0:
+		var err error
+		if payload, err = fixture.Load(injectDTO.Fixture); err != nil {
+			return model.GHWebhookEvent{}, http.StatusBadRequest, err
+		}
+		if len(event) == 0 {
+			event = injectDTO.Fixture
+		}
+	default:
+		return model.GHWebhookEvent{}, http.StatusBadRequest, fmt.Errorf("either payload or fixture is required")
+	}
+	if err := fixture.Override(payload, injectDTO.Overrides); err != nil {
+		return model.GHWebhookEvent{}, http.StatusBadRequest, err
+	}
+
+	gitHubId := injectDTO.GitHubId
+	if injectDTO.ReceiverId > 0 {
+		var receiver model.GHWebhookReceiver
+		if status, err := h.findInjectModel(&receiver, "receiver", injectDTO.ReceiverId); err != nil {
+			return model.GHWebhookEvent{}, status, err
+		}
+		if gitHubId == 0 {
+			gitHubId = receiver.GitHubId
+		} else if gitHubId != receiver.GitHubId {
+			return model.GHWebhookEvent{}, http.StatusBadRequest,
+				fmt.Errorf("receiver %d doesn't belong to github %d", receiver.ID, gitHubId)
+		}
+	}
+	if gitHubId == 0 {
+		return model.GHWebhookEvent{}, http.StatusBadRequest, fmt.Errorf("either githubId or receiverId is required")
+	}
+	var github model.GitHub
+	if status, err := h.findInjectModel(&github, "github", gitHubId); err != nil {
+		return model.GHWebhookEvent{}, status, err
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return model.GHWebhookEvent{}, http.StatusBadRequest, err
+	}
+	ghEvent := model.GHWebhookEvent{
+		HookMeta:  map[string]string{"X-GitHub-Event": event},
+		Payload:   string(data),
+		Event:     event,
+		PayloadId: fmt.Sprintf("inject-%d", time.Now().UnixNano()),
+		GitHubId:  gitHubId,
+		Synthetic: true,
+	}
+	if action, ok := payload["action"].(string); ok {
+		ghEvent.Action = action
+	}
+	ghEvent.SetOrgRepo(payload)
+	return ghEvent, http.StatusOK, nil
+}
+
+func (h *GHWebhookDeliverHandler) findInjectModel(dest interface{}, name string, id uint) (int, error) {
+	r := h.db.First(dest, id)
+	if errors.Is(r.Error, gorm.ErrRecordNotFound) {
+		return http.StatusBadRequest, fmt.Errorf("%s %d not found", name, id)
+	} else if r.Error != nil {
+		return http.StatusUnprocessableEntity, r.Error
+	}
+	return http.StatusOK, nil
+}
@@ -0,0 +1,101 @@
+package webhook
+
+import (
+	"encoding/json"
+	"gh-webhook/pkg/model"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_Inject(t *testing.T) {
+	tr := newTestReceiver(t, func(receiver *model.GHWebhookReceiver) {
+		receiver.Subscribes = []model.GHWebHookSubscribe{{
+			Events:   []string{"push"},
+			Branches: []string{"release/*"},
+		}}
+	})
+	other := model.GHWebhookReceiver{
+		Name:           "other",
+		GitHubId:       tr.receiver.GitHubId,
+		ReceiverConfig: tr.receiver.ReceiverConfig,
+		Subscribes:     []model.GHWebHookSubscribe{{Events: []string{"push"}}},
+	}
+	if err := tr.db.Create(&other).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/gh-webhook-event/inject", tr.handler.Inject)
+	inject := func(body string) (int, GHWebhookEventInjectResultDTO) {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/gh-webhook-event/inject",
+			strings.NewReader(body)))
+		var result GHWebhookEventInjectResultDTO
+		_ = json.Unmarshal(recorder.Body.Bytes(), &result)
+		return recorder.Code, result
+	}
+
+	code, result := inject(`{"fixture": "push", "overrides": {"ref": "refs/heads/release/1.2"}, "receiverId": 1}`)
+	if code != http.StatusCreated || len(result.DeliveryIds) != 1 {
+		t.Fatalf("should deliver the receiver only, got %d %+v", code, result)
+	}
+	var event model.GHWebhookEvent
+	tr.db.First(&event, result.EventId)
+	if !event.Synthetic || event.Event != "push" || !strings.Contains(event.Payload, "refs/heads/release/1.2") {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	tr.assertStatus(t, model.DeliverStatusDelivered)
+
+	code, result = inject(`{"event": "push", "payload": {"ref": "refs/heads/main"}, "githubId": 1}`)
+	if code != http.StatusCreated || len(result.DeliveryIds) != 2 {
+		t.Fatalf("should evaluate both receivers, got %d %+v", code, result)
+	}
+	tr.assertStatus(t, model.DeliverStatusDelivered, model.DeliverStatusSkipped, model.DeliverStatusDelivered)
+
+	for _, body := range []string{
+		`{"fixture": "push", "payload": {}, "githubId": 1}`,
+		`{"payload": {}, "githubId": 1}`,
+		`{"fixture": "deployment_status", "githubId": 1}`,
+		`{"fixture": "push", "overrides": {"ref.name": "main"}, "githubId": 1}`,
+		`{"fixture": "push"}`,
+		`{"fixture": "push", "receiverId": 3}`,
+	} {
+		if code, _ = inject(body); code != http.StatusBadRequest {
+			t.Fatalf("%s should be bad request, got %d", body, code)
+		}
+	}
+}
+
+func Test_InjectRef(t *testing.T) {
+	tr := newTestReceiver(t, func(receiver *model.GHWebhookReceiver) {
+		receiver.Subscribes = []model.GHWebHookSubscribe{
+			{Events: []string{"pull_request"}, Branches: []string{"main"}},
+			{Events: []string{"create"}, Branches: []string{"release/*"}},
+			{Events: []string{"release"}, Tags: []string{"v1.*"}},
+		}
+	})
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/gh-webhook-event/inject", tr.handler.Inject)
+
+	// the injected events are matched by the ref of their event like the events from GitHub
+	for _, body := range []string{
+		`{"fixture": "pull_request", "receiverId": 1}`,
+		`{"fixture": "create", "receiverId": 1}`,
+		`{"fixture": "release", "receiverId": 1}`,
+		`{"fixture": "release", "overrides": {"release.tag_name": "v2.0.0"}, "receiverId": 1}`,
+	} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/gh-webhook-event/inject",
+			strings.NewReader(body)))
+		if recorder.Code != http.StatusCreated {
+			t.Fatalf("%s should be injected, got %d", body, recorder.Code)
+		}
+	}
+	tr.assertStatus(t, model.DeliverStatusDelivered, model.DeliverStatusDelivered, model.DeliverStatusDelivered,
+		model.DeliverStatusSkipped)
+}
@@ -0,0 +1,105 @@
+package webhook
+
+import (
+	"gh-webhook/pkg/core"
+	"gh-webhook/pkg/model"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"sync"
+	"time"
+)
+
+// the schedules are checked more often than the minute resolution of the cron so an activation isn't late
+const scheduleInterval = 10 * time.Second
+
+// GHWebhookScheduler create the synthetic events of the due schedules, they are queued like the events from GitHub
+type GHWebhookScheduler struct {
+	db       *gorm.DB
+	queue    model.Queue
+	wg       sync.WaitGroup
+	stop     chan struct{} // closed to stop the schedule loop and the pending push
+	stopOnce sync.Once
+}
+
+func (s *GHWebhookScheduler) Register(c *core.GHPRContext) error {
+	s.db = c.Db
+	s.queue = model.GetQueue()
+	s.stop = make(chan struct{})
+	s.wg.Add(1)
+	go s.start()
+	return nil
+}
+
+// Close stop the schedule loop and wait for it to exit
+func (s *GHWebhookScheduler) Close() error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	s.wg.Wait()
+	return nil
+}
+
+func (s *GHWebhookScheduler) start() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			log.Info("scheduler stopped")
+			return
+		case now := <-ticker.C:
+			runGuarded("scheduler", func() {
+				s.run(now)
+			})
+		}
+	}
+}
+
+// run trigger the schedules due at now, an activation missed while gh-webhook was down is triggered once
+func (s *GHWebhookScheduler) run(now time.Time) {
+	var schedules []model.GHWebhookSchedule
+	r := s.db.Where("(enabled IS NULL OR enabled = ?) AND next_run_at <= ?", true, now).Order("next_run_at").
+		Find(&schedules)
+	if r.Error != nil {
+		log.Errorf("failed to find due schedules: %v", r.Error)
+		return
+	}
+	for _, schedule := range schedules {
+		s.trigger(schedule, now)
+	}
+}
+
+func (s *GHWebhookScheduler) trigger(schedule model.GHWebhookSchedule, now time.Time) {
+	// nil if the cron has no activation anymore or it's invalid, the schedule isn't triggered again
+	next, err := schedule.Next(now)
+	if err != nil {
+		log.Errorf("invalid schedule %d: %v", schedule.ID, err)
+	}
+	updates := map[string]interface{}{"last_run_at": now, "next_run_at": next}
+
+	event, err := schedule.NewEvent(*schedule.NextRunAt)
+	if err != nil {
+		log.Errorf("failed to create event of schedule %d: %v", schedule.ID, err)
+	} else if r := s.db.Omit("GitHub").Create(&event); r.Error != nil {
+		log.Errorf("failed to create event of schedule %d: %v", schedule.ID, r.Error)
+	} else {
+		log.Infof("schedule %d created %s event %d", schedule.ID, event.Event, event.ID)
+		updates["last_event_id"] = event.ID
+	}
+
+	if r := s.db.Model(&schedule).Updates(updates); r.Error != nil {
+		log.Errorf("failed to update schedule %d: %v", schedule.ID, r.Error)
+	}
+	if event.ID > 0 {
+		s.push(event)
+	}
+}
+
+// push queue the event, it waits for a processor so the due schedules don't pile up goroutines, the event isn't
+// queued once the scheduler is stopped
+func (s *GHWebhookScheduler) push(event model.GHWebhookEvent) {
+	select {
+	case s.queue <- event:
+	case <-s.stop:
+		log.Warningf("scheduler stopped, event %d of schedule %d isn't queued", event.ID, event.ScheduleId)
+	}
+}
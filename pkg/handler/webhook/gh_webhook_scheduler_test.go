@@ -0,0 +1,84 @@
+package webhook
+
+import (
+	"gh-webhook/pkg/model"
+	"testing"
+	"time"
+)
+
+func Test_Scheduler(t *testing.T) {
+	tr := newTestReceiver(t, func(receiver *model.GHWebhookReceiver) {
+		receiver.Subscribes = []model.GHWebHookSubscribe{{
+			Events:   []string{model.ScheduleEvent},
+			Branches: []string{"main"},
+		}}
+	})
+	now := time.Date(2024, 3, 2, 2, 0, 5, 0, time.UTC)
+	due := time.Date(2024, 3, 2, 2, 0, 0, 0, time.UTC)
+	disabled := false
+	schedules := []model.GHWebhookSchedule{
+		{Name: "nightly", Cron: "0 2 * * *", GitHubId: tr.receiver.GitHubId, Repo: "octo/mono",
+			Ref: "refs/heads/main", NextRunAt: &due},
+		{Name: "disabled", Cron: "0 2 * * *", GitHubId: tr.receiver.GitHubId, Enabled: &disabled, NextRunAt: &due},
+	}
+	tr.db.Omit("GitHub").Create(&schedules)
+
+	scheduler := &GHWebhookScheduler{db: tr.db, queue: make(model.Queue, 1)}
+	scheduler.run(now)
+
+	var event model.GHWebhookEvent
+	select {
+	case event = <-scheduler.queue:
+	case <-time.After(time.Second):
+		t.Fatal("the event of the due schedule should be queued")
+	}
+	if !event.Synthetic || event.ScheduleId != schedules[0].ID || event.Event != model.ScheduleEvent {
+		t.Fatalf("unexpected event %+v", event)
+	}
+
+	var nightly model.GHWebhookSchedule
+	tr.db.First(&nightly, schedules[0].ID)
+	if nightly.LastEventId != event.ID || nightly.NextRunAt == nil || !nightly.NextRunAt.Equal(due.AddDate(0, 0, 1)) {
+		t.Fatalf("unexpected schedule %+v", nightly)
+	}
+	var count int64
+	tr.db.Model(&model.GHWebhookEvent{}).Count(&count)
+	if count != 1 {
+		t.Fatalf("only the enabled schedule should be triggered, got %d events", count)
+	}
+
+	// the synthetic event is matched and delivered like the events from GitHub
+	tr.handler.handle(1, event)
+	tr.assertDelivered(t, event)
+}
+
+func Test_SchedulerClose(t *testing.T) {
+	tr := newTestReceiver(t, func(receiver *model.GHWebhookReceiver) {})
+	due := time.Date(2024, 3, 2, 2, 0, 0, 0, time.UTC)
+	schedule := model.GHWebhookSchedule{Name: "nightly", Cron: "0 2 * * *", GitHubId: tr.receiver.GitHubId,
+		NextRunAt: &due}
+	tr.db.Omit("GitHub").Create(&schedule)
+
+	// no processor receives the event, the push waits until the scheduler is closed
+	scheduler := &GHWebhookScheduler{db: tr.db, queue: make(model.Queue), stop: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		scheduler.run(due.Add(5 * time.Second))
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if err := scheduler.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the pending push should stop once the scheduler is closed")
+	}
+
+	var nightly model.GHWebhookSchedule
+	tr.db.First(&nightly, schedule.ID)
+	if nightly.LastEventId == 0 || !nightly.NextRunAt.Equal(due.AddDate(0, 0, 1)) {
+		t.Fatalf("the schedule should be updated before the event is queued, got %+v", nightly)
+	}
+}
@@ -0,0 +1,123 @@
+package webhook
+
+import (
+	"fmt"
+	"gh-webhook/pkg/model"
+	log "github.com/sirupsen/logrus"
+	"runtime/debug"
+	"sync"
+)
+
+// keySequencer run the jobs of the same ordering key in ingestion order while the jobs of different keys run in
+// parallel. Every received event gets a ticket, the tickets register their keys in ticket order, then the job of a
+// ticket runs once the jobs of the earlier tickets of the key are done. The worker which submits the first runnable
+// job of a key runs the following ones as well, so no worker blocks waiting for its turn.
+type keySequencer struct {
+	receiveMutex sync.Mutex
+	mutex        sync.Mutex
+	registered   *sync.Cond // created on first use, the zero value is ready to use
+	issued       uint64     // last issued ticket
+	registerCnt  uint64     // tickets registered so far
+	lanes        map[string]*orderingLane
+}
+
+type orderingLane struct {
+	tickets []uint64          // registered tickets in order
+	jobs    map[uint64]func() // submitted jobs by ticket
+	running bool
+}
+
+// receive the next event of the queue with its ticket, the tickets follow the queue order
+func (s *keySequencer) receive(queue model.Queue) (model.GHWebhookEvent, uint64, bool) {
+	s.receiveMutex.Lock()
+	defer s.receiveMutex.Unlock()
+	ghEvent, ok := <-queue
+	if !ok {
+		return ghEvent, 0, false
+	}
+	return ghEvent, s.ticket(), true
+}
+
+func (s *keySequencer) ticket() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.issued++
+	return s.issued
+}
+
+// register the ordering keys of the ticket after the earlier tickets registered theirs, every ticket must be
+// registered exactly once, even without keys
+func (s *keySequencer) register(ticket uint64, keys []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.registered == nil {
+		s.registered = sync.NewCond(&s.mutex)
+		s.lanes = map[string]*orderingLane{}
+	}
+	for s.registerCnt+1 != ticket {
+		s.registered.Wait()
+	}
+	for _, key := range keys {
+		lane, ok := s.lanes[key]
+		if !ok {
+			lane = &orderingLane{jobs: map[uint64]func(){}}
+			s.lanes[key] = lane
+		}
+		lane.tickets = append(lane.tickets, ticket)
+	}
+	s.registerCnt++
+	s.registered.Broadcast()
+}
+
+// submit the job of the ticket for the registered key, it runs now if it's the turn of the ticket, otherwise it runs
+// after the earlier jobs of the key on the worker running them
+func (s *keySequencer) submit(ticket uint64, key string, job func()) {
+	s.mutex.Lock()
+	lane, ok := s.lanes[key]
+	if !ok {
+		s.mutex.Unlock()
+		log.Errorf("ticket %d submitted unregistered ordering key %s", ticket, key)
+		return
+	}
+	lane.jobs[ticket] = job
+	if lane.running {
+		s.mutex.Unlock()
+		return
+	}
+
+	lane.running = true
+	for len(lane.tickets) > 0 {
+		head := lane.tickets[0]
+		next, ok := lane.jobs[head]
+		if !ok {
+			// the worker of the head ticket runs the lane once it submits
+			break
+		}
+		lane.tickets = lane.tickets[1:]
+		delete(lane.jobs, head)
+		s.mutex.Unlock()
+		runGuarded(fmt.Sprintf("ticket %d of ordering key %s", head, key), next)
+		s.mutex.Lock()
+	}
+	lane.running = false
+	if len(lane.tickets) == 0 {
+		delete(s.lanes, key)
+	}
+	s.mutex.Unlock()
+}
+
+// skip the turn of the ticket for the registered key, so the later jobs of the key don't wait for a job which is never
+// submitted
+func (s *keySequencer) skip(ticket uint64, key string) {
+	s.submit(ticket, key, func() {})
+}
+
+// runGuarded run the job and recover its panic, so a failed job doesn't stop the ones after it
+func runGuarded(name string, job func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Warningf("%s: panic occurred: %v, %s", name, r, string(debug.Stack()))
+		}
+	}()
+	job()
+}
@@ -0,0 +1,97 @@
+package webhook
+
+import (
+	"fmt"
+	"gh-webhook/pkg/model"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_keySequencer(t *testing.T) {
+	var s keySequencer
+	var mutex sync.Mutex
+	var order []string
+	record := func(name string) func() {
+		return func() {
+			mutex.Lock()
+			order = append(order, name)
+			mutex.Unlock()
+		}
+	}
+
+	tickets := []uint64{s.ticket(), s.ticket(), s.ticket(), s.ticket()}
+	s.register(tickets[0], []string{"a"})
+	s.register(tickets[1], nil)
+	s.register(tickets[2], []string{"a", "b"})
+	s.register(tickets[3], []string{"b"})
+
+	// the later jobs wait for the first one of their key
+	s.submit(tickets[3], "b", record("4b"))
+	s.submit(tickets[2], "a", record("3a"))
+	if len(order) != 0 {
+		t.Fatalf("jobs should wait for their turn, got %v", order)
+	}
+	s.submit(tickets[2], "b", record("3b"))
+	s.submit(tickets[0], "a", func() { panic("failed job") })
+
+	expected := []string{"3b", "4b", "3a"}
+	if fmt.Sprint(order) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, order)
+	}
+	if len(s.lanes) != 0 {
+		t.Fatal("done lanes should be removed")
+	}
+
+	// the skipped ticket doesn't stall the later jobs of its key
+	order = nil
+	tickets = []uint64{s.ticket(), s.ticket()}
+	s.register(tickets[0], []string{"c"})
+	s.register(tickets[1], []string{"c"})
+	s.submit(tickets[1], "c", record("6c"))
+	s.skip(tickets[0], "c")
+	if fmt.Sprint(order) != "[6c]" || len(s.lanes) != 0 {
+		t.Fatalf("the job after the skipped ticket should run, got %v", order)
+	}
+}
+
+func Test_OrderedDelivery(t *testing.T) {
+	tr := newTestReceiver(t, func(receiver *model.GHWebhookReceiver) {
+		receiver.OrderingKey = `repository.full_name + "#" + string(number)`
+	})
+	// random response times reorder the deliveries of concurrent workers without ordering
+	tr.delay = func() { time.Sleep(time.Duration(rand.Intn(20)) * time.Millisecond) }
+
+	tr.handler.queue = make(model.Queue)
+	tr.handler.Start(4)
+	numbers := map[uint]int{}
+	for i := 0; i < 24; i++ {
+		number := i % 3
+		event := model.GHWebhookEvent{
+			Payload:  fmt.Sprintf(`{"action": "push", "number": %d, "repository": {"full_name": "zhaojunlucky/veda"}}`, number),
+			Event:    "push",
+			Action:   "push",
+			GitHubId: tr.receiver.GitHubId,
+		}
+		tr.db.Omit("GitHub").Create(&event)
+		numbers[event.ID] = number
+		tr.handler.queue <- event
+	}
+	close(tr.handler.queue)
+	_ = tr.handler.Close()
+
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	if len(tr.delivered) != 24 {
+		t.Fatalf("should deliver 24 events, got %d", len(tr.delivered))
+	}
+	last := map[int]uint{}
+	for _, eventId := range tr.delivered {
+		number := numbers[eventId]
+		if eventId < last[number] {
+			t.Fatalf("events of number %d are delivered out of order: %v", number, tr.delivered)
+		}
+		last[number] = eventId
+	}
+}
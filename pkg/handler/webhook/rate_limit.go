@@ -0,0 +1,118 @@
+package webhook
+
+import (
+	"gh-webhook/pkg/model"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tokenBucket rate limit of a receiver, reservations can take future tokens so waiting deliveries keep their order
+type tokenBucket struct {
+	mutex  sync.Mutex
+	cfg    model.RateLimitConfig
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(cfg model.RateLimitConfig, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		cfg:    cfg,
+		tokens: float64(cfg.GetBurst()),
+		last:   now,
+	}
+}
+
+// rate tokens per second
+func (b *tokenBucket) rate() float64 {
+	return float64(b.cfg.Requests) / b.cfg.GetInterval().Seconds()
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = min(float64(b.cfg.GetBurst()), b.tokens+now.Sub(b.last).Seconds()*b.rate())
+		b.last = now
+	}
+}
+
+// allow take a token if one is available now
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// reserve take a token and return how long to wait until it's available
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate() * float64(time.Second))
+}
+
+// throttleMetrics throttled deliveries of a receiver
+type throttleMetrics struct {
+	throttled atomic.Int64
+	wait      atomic.Int64 // nanoseconds
+}
+
+type ThrottleMetricsDTO struct {
+	Throttled    int64          `json:"throttled"`
+	ThrottleWait model.Duration `json:"throttleWait"`
+}
+
+// getLimiter the token bucket of the receiver, nil if the receiver isn't rate limited, the bucket is recreated when
+// the rate limit changes
+func (h *GHWebhookDeliverHandler) getLimiter(re model.GHWebhookReceiver) *tokenBucket {
+	if !re.RateLimit.Enabled() {
+		h.limiters.Delete(re.ID)
+		return nil
+	}
+	if limiter, ok := h.limiters.Load(re.ID); ok && limiter.(*tokenBucket).cfg == re.RateLimit {
+		return limiter.(*tokenBucket)
+	}
+	limiter := newTokenBucket(re.RateLimit, time.Now())
+	h.limiters.Store(re.ID, limiter)
+	return limiter
+}
+
+// waitForToken block until the receiver rate limit allows the next delivery, return how long it waited
+func (h *GHWebhookDeliverHandler) waitForToken(re model.GHWebhookReceiver) time.Duration {
+	limiter := h.getLimiter(re)
+	if limiter == nil {
+		return 0
+	}
+	wait := limiter.reserve(time.Now())
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+	return wait
+}
+
+func (h *GHWebhookDeliverHandler) recordThrottle(receiverId uint, wait time.Duration) {
+	metrics, _ := h.throttles.LoadOrStore(receiverId, &throttleMetrics{})
+	metrics.(*throttleMetrics).throttled.Add(1)
+	metrics.(*throttleMetrics).wait.Add(int64(wait))
+}
+
+func (h *GHWebhookDeliverHandler) throttleMetrics() map[uint]ThrottleMetricsDTO {
+	result := map[uint]ThrottleMetricsDTO{}
+	h.throttles.Range(func(key, value any) bool {
+		metrics := value.(*throttleMetrics)
+		result[key.(uint)] = ThrottleMetricsDTO{
+			Throttled:    metrics.throttled.Load(),
+			ThrottleWait: model.Duration(metrics.wait.Load()),
+		}
+		return true
+	})
+	return result
+}
@@ -0,0 +1,71 @@
+package webhook
+
+import (
+	"gh-webhook/pkg/model"
+	"testing"
+	"time"
+)
+
+func Test_tokenBucket(t *testing.T) {
+	now := time.Now()
+	bucket := newTokenBucket(model.RateLimitConfig{Requests: 2, Interval: model.Duration(time.Second), Burst: 2}, now)
+	if !bucket.allow(now) || !bucket.allow(now) {
+		t.Fatal("burst should be allowed")
+	}
+	if bucket.allow(now) {
+		t.Fatal("should be throttled after burst")
+	}
+	if wait := bucket.reserve(now); wait != 500*time.Millisecond {
+		t.Fatalf("should wait 500ms, got %v", wait)
+	}
+	if wait := bucket.reserve(now); wait != time.Second {
+		t.Fatalf("reservations should queue, got %v", wait)
+	}
+	if !bucket.allow(now.Add(1500 * time.Millisecond)) {
+		t.Fatal("tokens should be refilled")
+	}
+}
+
+func Test_RateLimit(t *testing.T) {
+	tr := newTestReceiver(t, func(receiver *model.GHWebhookReceiver) {
+		receiver.RateLimit = model.RateLimitConfig{Requests: 10, Interval: model.Duration(time.Second)}
+	})
+	events := tr.send(3)
+	tr.drain()
+
+	tr.assertDelivered(t, events...)
+	tr.assertStatus(t, model.DeliverStatusDelivered, model.DeliverStatusDelivered, model.DeliverStatusDelivered)
+
+	var delivers []model.GHWebhookEventReceiverDeliver
+	tr.db.Order("id").Find(&delivers)
+	if delivers[0].ThrottleWait != 0 || delivers[2].ThrottleWait.Duration() < 100*time.Millisecond {
+		t.Fatalf("unexpected throttle wait %v", delivers)
+	}
+	metrics := tr.handler.throttleMetrics()[tr.receiver.ID]
+	if metrics.Throttled != 2 || metrics.ThrottleWait <= 0 {
+		t.Fatalf("unexpected metrics %+v", metrics)
+	}
+}
+
+func Test_backlogStatus(t *testing.T) {
+	tr := newTestReceiver(t, func(receiver *model.GHWebhookReceiver) {
+		receiver.RateLimit = model.RateLimitConfig{Requests: 10, Interval: model.Duration(time.Second)}
+	})
+	if status := tr.handler.backlogStatus(tr.receiver); status != "" {
+		t.Fatalf("the delivery without backlog shouldn't wait, got %s", status)
+	}
+
+	// the rate limited backlog throttles the delivery
+	tr.db.Create(&model.GHWebhookEventReceiverDeliver{GHWebhookReceiverId: tr.receiver.ID,
+		Status: model.DeliverStatusThrottled})
+	if status := tr.handler.backlogStatus(tr.receiver); status != model.DeliverStatusThrottled {
+		t.Fatalf("the delivery should be throttled behind the throttled backlog, got %s", status)
+	}
+
+	// the paused backlog pauses the delivery even if the receiver is rate limited
+	tr.db.Create(&model.GHWebhookEventReceiverDeliver{GHWebhookReceiverId: tr.receiver.ID,
+		Status: model.DeliverStatusPaused})
+	if status := tr.handler.backlogStatus(tr.receiver); status != model.DeliverStatusPaused {
+		t.Fatalf("the delivery should be paused behind the paused backlog, got %s", status)
+	}
+}
@@ -0,0 +1,499 @@
+package jq
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// builtin the implementation of a builtin for its input, the args are the unevaluated filters
+type builtin func(input interface{}, args []node) ([]interface{}, error)
+
+// builtins the supported builtins by name/arity
+var builtins map[string]builtin
+
+func init() {
+	builtins = map[string]builtin{
+		"empty/0": func(interface{}, []node) ([]interface{}, error) {
+			return nil, nil
+		},
+		"not/0": value1(func(input interface{}) (interface{}, error) {
+			return !truthy(input), nil
+		}),
+		"length/0": value1(length),
+		"type/0": value1(func(input interface{}) (interface{}, error) {
+			return typeOf(input), nil
+		}),
+		"keys/0": value1(func(input interface{}) (interface{}, error) {
+			switch value := input.(type) {
+			case map[string]interface{}:
+				return stringsToValues(sortedKeys(value)), nil
+			case []interface{}:
+				keys := make([]interface{}, 0, len(value))
+				for i := range value {
+					keys = append(keys, float64(i))
+				}
+				return keys, nil
+			}
+			return nil, fmt.Errorf("%s has no keys", typeOf(input))
+		}),
+		"has/1": value2(func(input, key interface{}) (interface{}, error) {
+			switch value := input.(type) {
+			case map[string]interface{}:
+				if k, ok := key.(string); ok {
+					_, has := value[k]
+					return has, nil
+				}
+			case []interface{}:
+				if k, ok := key.(float64); ok {
+					return k >= 0 && int(k) < len(value), nil
+				}
+			}
+			return nil, fmt.Errorf("cannot check whether %s has a %s key", typeOf(input), typeOf(key))
+		}),
+		"map/1": func(input interface{}, args []node) ([]interface{}, error) {
+			return (&arrayNode{body: &pipeNode{left: &iterateNode{target: identityNode{}}, right: args[0]}}).eval(input)
+		},
+		"select/1": func(input interface{}, args []node) ([]interface{}, error) {
+			conds, err := args[0].eval(input)
+			if err != nil {
+				return nil, err
+			}
+			var outputs []interface{}
+			for _, cond := range conds {
+				if truthy(cond) {
+					outputs = append(outputs, input)
+				}
+			}
+			return outputs, nil
+		},
+		"add/0": value1(func(input interface{}) (interface{}, error) {
+			values, err := iterate(input)
+			if err != nil {
+				return nil, err
+			}
+			var sum interface{}
+			for _, value := range values {
+				if sum, err = add(sum, value); err != nil {
+					return nil, err
+				}
+			}
+			return sum, nil
+		}),
+		"any/0": value1(func(input interface{}) (interface{}, error) {
+			values, err := iterate(input)
+			if err != nil {
+				return nil, err
+			}
+			for _, value := range values {
+				if truthy(value) {
+					return true, nil
+				}
+			}
+			return false, nil
+		}),
+		"all/0": value1(func(input interface{}) (interface{}, error) {
+			values, err := iterate(input)
+			if err != nil {
+				return nil, err
+			}
+			for _, value := range values {
+				if !truthy(value) {
+					return false, nil
+				}
+			}
+			return true, nil
+		}),
+		"first/0": value1(func(input interface{}) (interface{}, error) {
+			return index(input, float64(0))
+		}),
+		"last/0": value1(func(input interface{}) (interface{}, error) {
+			return index(input, float64(-1))
+		}),
+		"first/1": func(input interface{}, args []node) ([]interface{}, error) {
+			values, err := args[0].eval(input)
+			if err != nil || len(values) == 0 {
+				return nil, err
+			}
+			return values[:1], nil
+		},
+		"reverse/0": value1(func(input interface{}) (interface{}, error) {
+			switch value := input.(type) {
+			case nil:
+				return []interface{}{}, nil
+			case string:
+				runes := []rune(value)
+				for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+					runes[i], runes[j] = runes[j], runes[i]
+				}
+				return string(runes), nil
+			case []interface{}:
+				reversed := make([]interface{}, 0, len(value))
+				for i := len(value) - 1; i >= 0; i-- {
+					reversed = append(reversed, value[i])
+				}
+				return reversed, nil
+			}
+			return nil, fmt.Errorf("cannot reverse %s", typeOf(input))
+		}),
+		"sort/0": value1(func(input interface{}) (interface{}, error) {
+			return sortBy(input, func(value interface{}) (interface{}, error) {
+				return value, nil
+			})
+		}),
+		"sort_by/1": func(input interface{}, args []node) ([]interface{}, error) {
+			sorted, err := sortBy(input, func(value interface{}) (interface{}, error) {
+				return (&arrayNode{body: args[0]}).evalOne(value)
+			})
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{sorted}, nil
+		},
+		"unique/0": value1(func(input interface{}) (interface{}, error) {
+			sorted, err := sortBy(input, func(value interface{}) (interface{}, error) {
+				return value, nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			unique := []interface{}{}
+			for _, value := range sorted {
+				if len(unique) == 0 || compare(unique[len(unique)-1], value) != 0 {
+					unique = append(unique, value)
+				}
+			}
+			return unique, nil
+		}),
+		"min/0": value1(func(input interface{}) (interface{}, error) {
+			return extreme(input, -1)
+		}),
+		"max/0": value1(func(input interface{}) (interface{}, error) {
+			return extreme(input, 1)
+		}),
+		"contains/1": value2(func(input, element interface{}) (interface{}, error) {
+			if typeOf(input) != typeOf(element) {
+				return nil, fmt.Errorf("%s and %s cannot have their containment checked", typeOf(input), typeOf(element))
+			}
+			return contains(input, element), nil
+		}),
+		"to_entries/0":   value1(toEntries),
+		"from_entries/0": value1(fromEntries),
+		"with_entries/1": func(input interface{}, args []node) ([]interface{}, error) {
+			entries, err := toEntries(input)
+			if err != nil {
+				return nil, err
+			}
+			mapped, err := builtins["map/1"](entries, args)
+			if err != nil {
+				return nil, err
+			}
+			object, err := fromEntries(mapped[0])
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{object}, nil
+		},
+		"tostring/0": value1(func(input interface{}) (interface{}, error) {
+			if s, ok := input.(string); ok {
+				return s, nil
+			}
+			return toJSON(input), nil
+		}),
+		"tonumber/0": value1(func(input interface{}) (interface{}, error) {
+			switch value := input.(type) {
+			case float64:
+				return value, nil
+			case string:
+				number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil {
+					return nil, fmt.Errorf("cannot parse %q as a number", value)
+				}
+				return number, nil
+			}
+			return nil, fmt.Errorf("%s cannot be parsed as a number", typeOf(input))
+		}),
+		"tojson/0": value1(func(input interface{}) (interface{}, error) {
+			return toJSON(input), nil
+		}),
+		"fromjson/0": value1(func(input interface{}) (interface{}, error) {
+			s, ok := input.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s cannot be parsed as json", typeOf(input))
+			}
+			var value interface{}
+			if err := json.Unmarshal([]byte(s), &value); err != nil {
+				return nil, err
+			}
+			return value, nil
+		}),
+		"ascii_downcase/0": stringFunc(strings.ToLower),
+		"ascii_upcase/0":   stringFunc(strings.ToUpper),
+		"startswith/1": string2(func(s, prefix string) (interface{}, error) {
+			return strings.HasPrefix(s, prefix), nil
+		}),
+		"endswith/1": string2(func(s, suffix string) (interface{}, error) {
+			return strings.HasSuffix(s, suffix), nil
+		}),
+		"ltrimstr/1": string2(func(s, prefix string) (interface{}, error) {
+			return strings.TrimPrefix(s, prefix), nil
+		}),
+		"rtrimstr/1": string2(func(s, suffix string) (interface{}, error) {
+			return strings.TrimSuffix(s, suffix), nil
+		}),
+		"split/1": string2(func(s, sep string) (interface{}, error) {
+			return split(s, sep), nil
+		}),
+		"test/1": string2(func(s, re string) (interface{}, error) {
+			compiled, err := regexp.Compile(re)
+			if err != nil {
+				return nil, err
+			}
+			return compiled.MatchString(s), nil
+		}),
+		"join/1": value2(func(input, sep interface{}) (interface{}, error) {
+			s, ok := sep.(string)
+			if !ok {
+				return nil, fmt.Errorf("the separator must be a string, got %s", typeOf(sep))
+			}
+			values, err := iterate(input)
+			if err != nil {
+				return nil, err
+			}
+			parts := make([]string, 0, len(values))
+			for _, value := range values {
+				switch v := value.(type) {
+				case nil:
+					parts = append(parts, "")
+				case string:
+					parts = append(parts, v)
+				case float64, bool:
+					parts = append(parts, toJSON(v))
+				default:
+					return nil, fmt.Errorf("cannot join %s", typeOf(value))
+				}
+			}
+			return strings.Join(parts, s), nil
+		}),
+	}
+}
+
+// value1 the builtin without args which maps its input to one value
+func value1(fn func(input interface{}) (interface{}, error)) builtin {
+	return func(input interface{}, _ []node) ([]interface{}, error) {
+		output, err := fn(input)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{output}, nil
+	}
+}
+
+// value2 the builtin with one arg, called for each value of the arg
+func value2(fn func(input, arg interface{}) (interface{}, error)) builtin {
+	return func(input interface{}, args []node) ([]interface{}, error) {
+		values, err := args[0].eval(input)
+		if err != nil {
+			return nil, err
+		}
+		outputs := make([]interface{}, 0, len(values))
+		for _, value := range values {
+			output, err := fn(input, value)
+			if err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, output)
+		}
+		return outputs, nil
+	}
+}
+
+func stringFunc(fn func(s string) string) builtin {
+	return value1(func(input interface{}) (interface{}, error) {
+		s, ok := input.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a string", typeOf(input))
+		}
+		return fn(s), nil
+	})
+}
+
+func string2(fn func(s, arg string) (interface{}, error)) builtin {
+	return value2(func(input, arg interface{}) (interface{}, error) {
+		s, ok := input.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a string", typeOf(input))
+		}
+		a, ok := arg.(string)
+		if !ok {
+			return nil, fmt.Errorf("the argument must be a string, got %s", typeOf(arg))
+		}
+		return fn(s, a)
+	})
+}
+
+// evalOne the single output of the node
+func (n *arrayNode) evalOne(input interface{}) (interface{}, error) {
+	outputs, err := n.eval(input)
+	if err != nil {
+		return nil, err
+	}
+	return outputs[0], nil
+}
+
+func iterate(input interface{}) ([]interface{}, error) {
+	return (&iterateNode{target: identityNode{}}).eval(input)
+}
+
+func length(input interface{}) (interface{}, error) {
+	switch value := input.(type) {
+	case nil:
+		return float64(0), nil
+	case bool:
+		return nil, fmt.Errorf("boolean has no length")
+	case float64:
+		return math.Abs(value), nil
+	case string:
+		return float64(utf8.RuneCountInString(value)), nil
+	case []interface{}:
+		return float64(len(value)), nil
+	case map[string]interface{}:
+		return float64(len(value)), nil
+	}
+	return nil, fmt.Errorf("%s has no length", typeOf(input))
+}
+
+// sortBy the array sorted stably by the keys of its values
+func sortBy(input interface{}, key func(value interface{}) (interface{}, error)) ([]interface{}, error) {
+	array, ok := input.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s cannot be sorted, it must be an array", typeOf(input))
+	}
+	keys := make([]interface{}, len(array))
+	for i, value := range array {
+		k, err := key(value)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = k
+	}
+	indexes := make([]int, len(array))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return compare(keys[indexes[i]], keys[indexes[j]]) < 0
+	})
+	sorted := make([]interface{}, 0, len(array))
+	for _, i := range indexes {
+		sorted = append(sorted, array[i])
+	}
+	return sorted, nil
+}
+
+// extreme the min (sign -1) or max (sign 1) of the array, null if it's empty
+func extreme(input interface{}, sign int) (interface{}, error) {
+	array, ok := input.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s has no min or max, it must be an array", typeOf(input))
+	}
+	var extreme interface{}
+	for i, value := range array {
+		if i == 0 || compare(value, extreme)*sign > 0 {
+			extreme = value
+		}
+	}
+	return extreme, nil
+}
+
+// contains whether b is contained in a, substrings, all elements of arrays and recursively the values of objects
+func contains(a, b interface{}) bool {
+	switch av := a.(type) {
+	case string:
+		bv, ok := b.(string)
+		return ok && strings.Contains(av, bv)
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, be := range bv {
+			found := false
+			for _, ae := range av {
+				if contains(ae, be) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, value := range bv {
+			if existing, has := av[key]; !has || !contains(existing, value) {
+				return false
+			}
+		}
+		return true
+	}
+	return compare(a, b) == 0
+}
+
+func toEntries(input interface{}) (interface{}, error) {
+	object, ok := input.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s has no entries, it must be an object", typeOf(input))
+	}
+	entries := make([]interface{}, 0, len(object))
+	for _, key := range sortedKeys(object) {
+		entries = append(entries, map[string]interface{}{"key": key, "value": object[key]})
+	}
+	return entries, nil
+}
+
+// fromEntries the object of the entries, the keys may be named key, k, name or Name and the values value or v
+func fromEntries(input interface{}) (interface{}, error) {
+	entries, ok := input.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s has no entries, it must be an array", typeOf(input))
+	}
+	object := make(map[string]interface{}, len(entries))
+	for _, entry := range entries {
+		e, ok := entry.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("the entry must be an object, got %s", typeOf(entry))
+		}
+		var key interface{}
+		for _, name := range []string{"key", "k", "name", "Name"} {
+			if key = e[name]; truthy(key) {
+				break
+			}
+		}
+		var k string
+		switch kv := key.(type) {
+		case string:
+			k = kv
+		case float64, bool:
+			k = toJSON(kv)
+		default:
+			return nil, fmt.Errorf("the entry key must be a string, got %s", typeOf(key))
+		}
+		value, has := e["value"]
+		if !has {
+			value = e["v"]
+		}
+		object[k] = value
+	}
+	return object, nil
+}
@@ -0,0 +1,655 @@
+package jq
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// node a jq expression, it outputs zero or more values for the input
+type node interface {
+	eval(input interface{}) ([]interface{}, error)
+}
+
+type identityNode struct{}
+
+func (identityNode) eval(input interface{}) ([]interface{}, error) {
+	return []interface{}{input}, nil
+}
+
+// recurseNode .., the input and all the values in it
+type recurseNode struct{}
+
+func (recurseNode) eval(input interface{}) ([]interface{}, error) {
+	outputs := []interface{}{input}
+	switch value := input.(type) {
+	case []interface{}:
+		for _, item := range value {
+			items, _ := recurseNode{}.eval(item)
+			outputs = append(outputs, items...)
+		}
+	case map[string]interface{}:
+		for _, key := range sortedKeys(value) {
+			items, _ := recurseNode{}.eval(value[key])
+			outputs = append(outputs, items...)
+		}
+	}
+	return outputs, nil
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n *literalNode) eval(interface{}) ([]interface{}, error) {
+	return []interface{}{n.value}, nil
+}
+
+type pipeNode struct {
+	left, right node
+}
+
+func (n *pipeNode) eval(input interface{}) ([]interface{}, error) {
+	lefts, err := n.left.eval(input)
+	if err != nil {
+		return nil, err
+	}
+	var outputs []interface{}
+	for _, left := range lefts {
+		rights, err := n.right.eval(left)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, rights...)
+	}
+	return outputs, nil
+}
+
+type commaNode struct {
+	left, right node
+}
+
+func (n *commaNode) eval(input interface{}) ([]interface{}, error) {
+	lefts, err := n.left.eval(input)
+	if err != nil {
+		return nil, err
+	}
+	rights, err := n.right.eval(input)
+	if err != nil {
+		return nil, err
+	}
+	return append(lefts, rights...), nil
+}
+
+// altNode a // b, the truthy outputs of a, the outputs of b if there is none, the errors of a are ignored
+type altNode struct {
+	left, right node
+}
+
+func (n *altNode) eval(input interface{}) ([]interface{}, error) {
+	lefts, _ := n.left.eval(input)
+	var outputs []interface{}
+	for _, left := range lefts {
+		if truthy(left) {
+			outputs = append(outputs, left)
+		}
+	}
+	if len(outputs) > 0 {
+		return outputs, nil
+	}
+	return n.right.eval(input)
+}
+
+type logicNode struct {
+	and         bool
+	left, right node
+}
+
+func (n *logicNode) eval(input interface{}) ([]interface{}, error) {
+	lefts, err := n.left.eval(input)
+	if err != nil {
+		return nil, err
+	}
+	var outputs []interface{}
+	for _, left := range lefts {
+		// short circuit like jq
+		if truthy(left) != n.and {
+			outputs = append(outputs, !n.and)
+			continue
+		}
+		rights, err := n.right.eval(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, right := range rights {
+			outputs = append(outputs, truthy(right))
+		}
+	}
+	return outputs, nil
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+func (n *binaryNode) eval(input interface{}) ([]interface{}, error) {
+	lefts, err := n.left.eval(input)
+	if err != nil {
+		return nil, err
+	}
+	rights, err := n.right.eval(input)
+	if err != nil {
+		return nil, err
+	}
+	var outputs []interface{}
+	for _, right := range rights {
+		for _, left := range lefts {
+			output, err := binary(n.op, left, right)
+			if err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, output)
+		}
+	}
+	return outputs, nil
+}
+
+type negateNode struct {
+	operand node
+}
+
+func (n *negateNode) eval(input interface{}) ([]interface{}, error) {
+	values, err := n.operand.eval(input)
+	if err != nil {
+		return nil, err
+	}
+	outputs := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		number, ok := value.(float64)
+		if !ok {
+			return nil, fmt.Errorf("%s cannot be negated", typeOf(value))
+		}
+		outputs = append(outputs, -number)
+	}
+	return outputs, nil
+}
+
+// indexNode target[key], .foo is the key foo of the identity
+type indexNode struct {
+	target, key node
+}
+
+func (n *indexNode) eval(input interface{}) ([]interface{}, error) {
+	targets, err := n.target.eval(input)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := n.key.eval(input)
+	if err != nil {
+		return nil, err
+	}
+	var outputs []interface{}
+	for _, target := range targets {
+		for _, key := range keys {
+			output, err := index(target, key)
+			if err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, output)
+		}
+	}
+	return outputs, nil
+}
+
+func index(target, key interface{}) (interface{}, error) {
+	switch value := target.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		if k, ok := key.(string); ok {
+			return value[k], nil
+		}
+	case []interface{}:
+		if k, ok := key.(float64); ok {
+			i := int(math.Floor(k))
+			if i < 0 {
+				i += len(value)
+			}
+			if i < 0 || i >= len(value) {
+				return nil, nil
+			}
+			return value[i], nil
+		}
+	}
+	return nil, fmt.Errorf("cannot index %s with %s", typeOf(target), typeOf(key))
+}
+
+// iterateNode target[], the values of the array or object
+type iterateNode struct {
+	target node
+}
+
+func (n *iterateNode) eval(input interface{}) ([]interface{}, error) {
+	targets, err := n.target.eval(input)
+	if err != nil {
+		return nil, err
+	}
+	var outputs []interface{}
+	for _, target := range targets {
+		switch value := target.(type) {
+		case []interface{}:
+			outputs = append(outputs, value...)
+		case map[string]interface{}:
+			for _, key := range sortedKeys(value) {
+				outputs = append(outputs, value[key])
+			}
+		default:
+			return nil, fmt.Errorf("cannot iterate over %s", typeOf(target))
+		}
+	}
+	return outputs, nil
+}
+
+// sliceNode target[from:to] of the array or string
+type sliceNode struct {
+	target, from, to node
+}
+
+func (n *sliceNode) eval(input interface{}) ([]interface{}, error) {
+	targets, err := n.target.eval(input)
+	if err != nil {
+		return nil, err
+	}
+	bound := func(bound node, length int, otherwise int) (int, error) {
+		if bound == nil {
+			return otherwise, nil
+		}
+		values, err := bound.eval(input)
+		if err != nil {
+			return 0, err
+		}
+		if len(values) != 1 {
+			return 0, fmt.Errorf("slice bound must have one value")
+		}
+		number, ok := values[0].(float64)
+		if !ok {
+			return 0, fmt.Errorf("slice bound must be a number, got %s", typeOf(values[0]))
+		}
+		i := int(math.Floor(number))
+		if i < 0 {
+			i += length
+		}
+		return min(max(i, 0), length), nil
+	}
+
+	var outputs []interface{}
+	for _, target := range targets {
+		var length int
+		switch value := target.(type) {
+		case nil:
+			outputs = append(outputs, nil)
+			continue
+		case []interface{}:
+			length = len(value)
+		case string:
+			length = len([]rune(value))
+		default:
+			return nil, fmt.Errorf("cannot slice %s", typeOf(target))
+		}
+		from, err := bound(n.from, length, 0)
+		if err != nil {
+			return nil, err
+		}
+		to, err := bound(n.to, length, length)
+		if err != nil {
+			return nil, err
+		}
+		to = max(from, to)
+		if array, ok := target.([]interface{}); ok {
+			outputs = append(outputs, append([]interface{}{}, array[from:to]...))
+		} else {
+			outputs = append(outputs, string([]rune(target.(string))[from:to]))
+		}
+	}
+	return outputs, nil
+}
+
+// tryNode body?, the errors of the body are ignored
+type tryNode struct {
+	body node
+}
+
+func (n *tryNode) eval(input interface{}) ([]interface{}, error) {
+	outputs, err := n.body.eval(input)
+	if err != nil {
+		return nil, nil
+	}
+	return outputs, nil
+}
+
+// arrayNode [body], the array of the outputs of the body
+type arrayNode struct {
+	body node
+}
+
+func (n *arrayNode) eval(input interface{}) ([]interface{}, error) {
+	if n.body == nil {
+		return []interface{}{[]interface{}{}}, nil
+	}
+	values, err := n.body.eval(input)
+	if err != nil {
+		return nil, err
+	}
+	if values == nil {
+		values = []interface{}{}
+	}
+	return []interface{}{values}, nil
+}
+
+type objectEntry struct {
+	key, value node
+}
+
+// objectNode {key: value, ...}, an object for every combination of the outputs of the keys and values
+type objectNode struct {
+	entries []objectEntry
+}
+
+func (n *objectNode) eval(input interface{}) ([]interface{}, error) {
+	objects := []map[string]interface{}{{}}
+	for _, entry := range n.entries {
+		keys, err := entry.key.eval(input)
+		if err != nil {
+			return nil, err
+		}
+		values, err := entry.value.eval(input)
+		if err != nil {
+			return nil, err
+		}
+		var next []map[string]interface{}
+		for _, object := range objects {
+			for _, key := range keys {
+				k, ok := key.(string)
+				if !ok {
+					return nil, fmt.Errorf("object key must be a string, got %s", typeOf(key))
+				}
+				for _, value := range values {
+					copied := make(map[string]interface{}, len(object)+1)
+					for ck, cv := range object {
+						copied[ck] = cv
+					}
+					copied[k] = value
+					next = append(next, copied)
+				}
+			}
+		}
+		objects = next
+	}
+	outputs := make([]interface{}, 0, len(objects))
+	for _, object := range objects {
+		outputs = append(outputs, object)
+	}
+	return outputs, nil
+}
+
+type ifNode struct {
+	cond, then, otherwise node
+}
+
+func (n *ifNode) eval(input interface{}) ([]interface{}, error) {
+	conds, err := n.cond.eval(input)
+	if err != nil {
+		return nil, err
+	}
+	var outputs []interface{}
+	for _, cond := range conds {
+		branch := n.otherwise
+		if truthy(cond) {
+			branch = n.then
+		}
+		values, err := branch.eval(input)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, values...)
+	}
+	return outputs, nil
+}
+
+// callNode the call of the builtin, the args are evaluated by the builtin
+type callNode struct {
+	name string
+	fn   builtin
+	args []node
+}
+
+func (n *callNode) eval(input interface{}) ([]interface{}, error) {
+	outputs, err := n.fn(input, n.args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", n.name, err)
+	}
+	return outputs, nil
+}
+
+// truthy false and null are false, all the other values are true
+func truthy(value interface{}) bool {
+	return value != nil && value != false
+}
+
+func typeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// typeOrder the order of the types in comparisons: null, false, true, numbers, strings, arrays, objects
+func typeOrder(value interface{}) int {
+	switch v := value.(type) {
+	case nil:
+		return 0
+	case bool:
+		if v {
+			return 2
+		}
+		return 1
+	case float64:
+		return 3
+	case string:
+		return 4
+	case []interface{}:
+		return 5
+	}
+	return 6
+}
+
+// compare the values like jq, negative if a is less than b
+func compare(a, b interface{}) int {
+	if ta, tb := typeOrder(a), typeOrder(b); ta != tb {
+		return ta - tb
+	}
+	switch av := a.(type) {
+	case float64:
+		bv := b.(float64)
+		switch {
+		case av < bv:
+			return -1
+		case av > bv:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(av, b.(string))
+	case []interface{}:
+		bv := b.([]interface{})
+		for i := 0; i < len(av) && i < len(bv); i++ {
+			if c := compare(av[i], bv[i]); c != 0 {
+				return c
+			}
+		}
+		return len(av) - len(bv)
+	case map[string]interface{}:
+		bv := b.(map[string]interface{})
+		ak, bk := sortedKeys(av), sortedKeys(bv)
+		if c := compare(stringsToValues(ak), stringsToValues(bk)); c != 0 {
+			return c
+		}
+		for _, key := range ak {
+			if c := compare(av[key], bv[key]); c != 0 {
+				return c
+			}
+		}
+	}
+	return 0
+}
+
+func binary(op string, left, right interface{}) (interface{}, error) {
+	switch op {
+	case "==":
+		return compare(left, right) == 0, nil
+	case "!=":
+		return compare(left, right) != 0, nil
+	case "<":
+		return compare(left, right) < 0, nil
+	case "<=":
+		return compare(left, right) <= 0, nil
+	case ">":
+		return compare(left, right) > 0, nil
+	case ">=":
+		return compare(left, right) >= 0, nil
+	case "+":
+		return add(left, right)
+	}
+
+	ln, lok := left.(float64)
+	rn, rok := right.(float64)
+	switch {
+	case lok && rok:
+		switch op {
+		case "-":
+			return ln - rn, nil
+		case "*":
+			return ln * rn, nil
+		case "/":
+			if rn == 0 {
+				return nil, fmt.Errorf("%v and %v cannot be divided because the divisor is zero", ln, rn)
+			}
+			return ln / rn, nil
+		case "%":
+			if int(rn) == 0 {
+				return nil, fmt.Errorf("%v and %v cannot be divided because the divisor is zero", ln, rn)
+			}
+			return float64(int(ln) % int(rn)), nil
+		}
+	case op == "-":
+		la, lok := left.([]interface{})
+		ra, rok := right.([]interface{})
+		if lok && rok {
+			outputs := []interface{}{}
+			for _, item := range la {
+				if !containsValue(ra, item) {
+					outputs = append(outputs, item)
+				}
+			}
+			return outputs, nil
+		}
+	case op == "/":
+		ls, lok := left.(string)
+		rs, rok := right.(string)
+		if lok && rok {
+			return split(ls, rs), nil
+		}
+	}
+	return nil, fmt.Errorf("%s and %s cannot be applied %s", typeOf(left), typeOf(right), op)
+}
+
+func add(left, right interface{}) (interface{}, error) {
+	if left == nil {
+		return right, nil
+	} else if right == nil {
+		return left, nil
+	}
+	switch lv := left.(type) {
+	case float64:
+		if rv, ok := right.(float64); ok {
+			return lv + rv, nil
+		}
+	case string:
+		if rv, ok := right.(string); ok {
+			return lv + rv, nil
+		}
+	case []interface{}:
+		if rv, ok := right.([]interface{}); ok {
+			return append(append([]interface{}{}, lv...), rv...), nil
+		}
+	case map[string]interface{}:
+		if rv, ok := right.(map[string]interface{}); ok {
+			merged := make(map[string]interface{}, len(lv)+len(rv))
+			for k, v := range lv {
+				merged[k] = v
+			}
+			for k, v := range rv {
+				merged[k] = v
+			}
+			return merged, nil
+		}
+	}
+	return nil, fmt.Errorf("%s and %s cannot be added", typeOf(left), typeOf(right))
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if compare(v, value) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func split(s, sep string) []interface{} {
+	outputs := []interface{}{}
+	if len(s) == 0 {
+		return outputs
+	}
+	for _, part := range strings.Split(s, sep) {
+		outputs = append(outputs, part)
+	}
+	return outputs
+}
+
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func stringsToValues(strs []string) []interface{} {
+	values := make([]interface{}, 0, len(strs))
+	for _, str := range strs {
+		values = append(values, str)
+	}
+	return values
+}
+
+// toJSON the compact json of the value, the value is decoded json so it can always be encoded
+func toJSON(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
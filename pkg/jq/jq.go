@@ -0,0 +1,572 @@
+package jq
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Query parsed jq program, a subset of jq:
+//   - paths: ., .foo, ."foo", .[0], .[-1], .["foo"], .[1:3], .[], .., the optional suffix ?
+//   - pipes and operators: |, ",", //, and, or, ==, !=, <, <=, >, >=, +, -, *, /, %
+//   - constructions and literals: [...], {a: .x, "b": .y, (.k): .v, c}, strings, numbers, true, false, null
+//   - if ... then ... elif ... then ... else ... end
+//   - the builtins in builtins.go, e.g. map(f), select(f), length, keys, to_entries, join(s), test(re)
+//
+// Variables, reduce, foreach, string interpolation, formats like @csv and the user defined functions are not
+// supported.
+type Query struct {
+	root node
+}
+
+// Parse the jq program, e.g. {sha: .after, files: ([.commits[].modified[]] | unique)}
+func Parse(src string) (*Query, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parsePipe()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %s at %d", tok, tok.pos)
+	}
+	return &Query{root: root}, nil
+}
+
+// Run the program on the json value, e.g. the payload decoded by encoding/json, the values it outputs are returned
+func (q *Query) Run(input interface{}) ([]interface{}, error) {
+	return q.root.eval(input)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunct
+	tokenIdent
+	tokenField // .foo
+	tokenString
+	tokenNumber
+)
+
+type token struct {
+	kind  tokenKind
+	text  string      // the punctuation, identifier or field name
+	value interface{} // the value of the string and number literals
+	pos   int         // the offset of the first rune
+	end   int         // the offset after the last rune
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of program"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// punctuations longest first, so .. isn't lexed as two dots
+var punctuations = []string{"..", "//", "==", "!=", "<=", ">=", ".", "[", "]", "{", "}", "(", ")", "|", ",", ":",
+	";", "?", "<", ">", "+", "-", "*", "/", "%"}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isIdentPart(r rune) bool {
+	return isIdentStart(r) || unicode.IsDigit(r)
+}
+
+func lex(src string) ([]token, error) {
+	var tokens []token
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '#':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == '.' && i+1 < len(runes) && isIdentStart(runes[i+1]):
+			start := i
+			i++
+			for i < len(runes) && isIdentPart(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenField, text: string(runes[start+1 : i]), pos: start, end: i})
+		case isIdentStart(r):
+			start := i
+			for i < len(runes) && isIdentPart(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: string(runes[start:i]), pos: start, end: i})
+		case unicode.IsDigit(r):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.' || runes[i] == 'e' ||
+				runes[i] == 'E' || ((runes[i] == '+' || runes[i] == '-') && (runes[i-1] == 'e' || runes[i-1] == 'E'))) {
+				i++
+			}
+			number, err := strconv.ParseFloat(string(runes[start:i]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %s at %d", string(runes[start:i]), start)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: string(runes[start:i]), value: number, pos: start, end: i})
+		case r == '"':
+			start := i
+			i++
+			for i < len(runes) && runes[i] != '"' {
+				if runes[i] == '\\' {
+					if i+1 < len(runes) && runes[i+1] == '(' {
+						return nil, fmt.Errorf("string interpolation is not supported at %d", i)
+					}
+					i++
+				}
+				i++
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at %d", start)
+			}
+			i++
+			var str string
+			if err := json.Unmarshal([]byte(string(runes[start:i])), &str); err != nil {
+				return nil, fmt.Errorf("invalid string at %d: %v", start, err)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: string(runes[start:i]), value: str, pos: start, end: i})
+		default:
+			matched := false
+			for _, punct := range punctuations {
+				if strings.HasPrefix(string(runes[i:]), punct) {
+					tokens = append(tokens, token{kind: tokenPunct, text: punct, pos: i, end: i + len([]rune(punct))})
+					i += len([]rune(punct))
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected %q at %d", r, i)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(runes), end: len(runes)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) isPunct(text string) bool {
+	tok := p.peek()
+	return tok.kind == tokenPunct && tok.text == text
+}
+
+func (p *parser) isKeyword(text string) bool {
+	tok := p.peek()
+	return tok.kind == tokenIdent && tok.text == text
+}
+
+func (p *parser) expect(text string) error {
+	tok := p.next()
+	if (tok.kind != tokenPunct && tok.kind != tokenIdent) || tok.text != text {
+		return fmt.Errorf("expected %q at %d, got %s", text, tok.pos, tok)
+	}
+	return nil
+}
+
+// adjacent whether the next token follows the previous one without space, e.g. the index of .foo[0]
+func (p *parser) adjacent() bool {
+	if p.pos == 0 {
+		return false
+	}
+	return p.tokens[p.pos-1].end == p.tokens[p.pos].pos
+}
+
+func (p *parser) parsePipe() (node, error) {
+	left, err := p.parseComma()
+	if err != nil {
+		return nil, err
+	}
+	for p.isPunct("|") {
+		p.next()
+		right, err := p.parseComma()
+		if err != nil {
+			return nil, err
+		}
+		left = &pipeNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseComma() (node, error) {
+	left, err := p.parseAlt()
+	if err != nil {
+		return nil, err
+	}
+	for p.isPunct(",") {
+		p.next()
+		right, err := p.parseAlt()
+		if err != nil {
+			return nil, err
+		}
+		left = &commaNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAlt() (node, error) {
+	left, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.isPunct("//") {
+		p.next()
+		// right associative like jq
+		right, err := p.parseAlt()
+		if err != nil {
+			return nil, err
+		}
+		return &altNode{left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicNode{and: false, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseCompare()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and") {
+		p.next()
+		right, err := p.parseCompare()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicNode{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseCompare() (node, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.isPunct(op) {
+			p.next()
+			right, err := p.parseAdditive()
+			if err != nil {
+				return nil, err
+			}
+			return &binaryNode{op: op, left: left, right: right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAdditive() (node, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for p.isPunct("+") || p.isPunct("-") {
+		op := p.next().text
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseMultiplicative() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isPunct("*") || p.isPunct("/") || p.isPunct("%") {
+		op := p.next().text
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isPunct("-") {
+		p.next()
+		operand, err := p.parsePostfix()
+		if err != nil {
+			return nil, err
+		}
+		return &negateNode{operand: operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	term, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch tok := p.peek(); {
+		case tok.kind == tokenField:
+			p.next()
+			term = &indexNode{target: term, key: &literalNode{value: tok.text}}
+		case p.isPunct(".") && p.tokens[p.pos+1].kind == tokenString:
+			p.next()
+			term = &indexNode{target: term, key: &literalNode{value: p.next().value}}
+		case p.isPunct(".") && p.tokens[p.pos+1].kind == tokenPunct && p.tokens[p.pos+1].text == "[":
+			p.next()
+			if term, err = p.parseBracket(term); err != nil {
+				return nil, err
+			}
+		case p.isPunct("[") && p.adjacent():
+			if term, err = p.parseBracket(term); err != nil {
+				return nil, err
+			}
+		case p.isPunct("?"):
+			p.next()
+			term = &tryNode{body: term}
+		default:
+			return term, nil
+		}
+	}
+}
+
+// parseBracket the suffix [], [index] or [from:to] of the target
+func (p *parser) parseBracket(target node) (node, error) {
+	if err := p.expect("["); err != nil {
+		return nil, err
+	}
+	if p.isPunct("]") {
+		p.next()
+		return &iterateNode{target: target}, nil
+	}
+	var from, to node
+	var err error
+	if !p.isPunct(":") {
+		if from, err = p.parsePipe(); err != nil {
+			return nil, err
+		}
+	}
+	if p.isPunct(":") {
+		p.next()
+		if !p.isPunct("]") {
+			if to, err = p.parsePipe(); err != nil {
+				return nil, err
+			}
+		}
+		if err = p.expect("]"); err != nil {
+			return nil, err
+		}
+		return &sliceNode{target: target, from: from, to: to}, nil
+	}
+	if err = p.expect("]"); err != nil {
+		return nil, err
+	}
+	return &indexNode{target: target, key: from}, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.peek()
+	switch tok.kind {
+	case tokenField:
+		p.next()
+		return &indexNode{target: identityNode{}, key: &literalNode{value: tok.text}}, nil
+	case tokenString, tokenNumber:
+		p.next()
+		return &literalNode{value: tok.value}, nil
+	case tokenIdent:
+		return p.parseIdent()
+	case tokenEOF:
+		return nil, fmt.Errorf("unexpected end of program")
+	}
+
+	switch tok.text {
+	case ".":
+		p.next()
+		if next := p.peek(); next.kind == tokenString && p.adjacent() {
+			p.next()
+			return &indexNode{target: identityNode{}, key: &literalNode{value: next.value}}, nil
+		} else if p.isPunct("[") && p.adjacent() {
+			return p.parseBracket(identityNode{})
+		}
+		return identityNode{}, nil
+	case "..":
+		p.next()
+		return recurseNode{}, nil
+	case "(":
+		p.next()
+		body, err := p.parsePipe()
+		if err != nil {
+			return nil, err
+		}
+		return body, p.expect(")")
+	case "[":
+		p.next()
+		if p.isPunct("]") {
+			p.next()
+			return &arrayNode{}, nil
+		}
+		body, err := p.parsePipe()
+		if err != nil {
+			return nil, err
+		}
+		return &arrayNode{body: body}, p.expect("]")
+	case "{":
+		return p.parseObject()
+	}
+	return nil, fmt.Errorf("unexpected %s at %d", tok, tok.pos)
+}
+
+func (p *parser) parseIdent() (node, error) {
+	tok := p.next()
+	switch tok.text {
+	case "true":
+		return &literalNode{value: true}, nil
+	case "false":
+		return &literalNode{value: false}, nil
+	case "null":
+		return &literalNode{value: nil}, nil
+	case "if":
+		return p.parseIf()
+	}
+
+	var args []node
+	if p.isPunct("(") {
+		p.next()
+		for {
+			arg, err := p.parsePipe()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if !p.isPunct(";") {
+				break
+			}
+			p.next()
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	}
+	fn, ok := builtins[fmt.Sprintf("%s/%d", tok.text, len(args))]
+	if !ok {
+		return nil, fmt.Errorf("function %s/%d is not supported at %d", tok.text, len(args), tok.pos)
+	}
+	return &callNode{name: tok.text, fn: fn, args: args}, nil
+}
+
+// parseIf the if after the if keyword, elif is an if in the else branch, a missing else is the identity
+func (p *parser) parseIf() (node, error) {
+	cond, err := p.parsePipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.expect("then"); err != nil {
+		return nil, err
+	}
+	then, err := p.parsePipe()
+	if err != nil {
+		return nil, err
+	}
+	ifNode := &ifNode{cond: cond, then: then, otherwise: identityNode{}}
+	switch {
+	case p.isKeyword("elif"):
+		p.next()
+		ifNode.otherwise, err = p.parseIf()
+		return ifNode, err
+	case p.isKeyword("else"):
+		p.next()
+		if ifNode.otherwise, err = p.parsePipe(); err != nil {
+			return nil, err
+		}
+	}
+	return ifNode, p.expect("end")
+}
+
+func (p *parser) parseObject() (node, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	obj := &objectNode{}
+	for !p.isPunct("}") {
+		var entry objectEntry
+		tok := p.next()
+		switch {
+		case tok.kind == tokenIdent:
+			entry.key = &literalNode{value: tok.text}
+			entry.value = &indexNode{target: identityNode{}, key: entry.key}
+		case tok.kind == tokenString:
+			entry.key = &literalNode{value: tok.value}
+			entry.value = &indexNode{target: identityNode{}, key: entry.key}
+		case tok.kind == tokenPunct && tok.text == "(":
+			key, err := p.parsePipe()
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expect(")"); err != nil {
+				return nil, err
+			}
+			entry.key = key
+		default:
+			return nil, fmt.Errorf("unexpected %s in object at %d", tok, tok.pos)
+		}
+		if p.isPunct(":") {
+			p.next()
+			value, err := p.parseAlt()
+			if err != nil {
+				return nil, err
+			}
+			entry.value = value
+		} else if entry.value == nil {
+			return nil, fmt.Errorf("expected \":\" after the computed key at %d", p.peek().pos)
+		}
+		obj.entries = append(obj.entries, entry)
+		if !p.isPunct(",") {
+			break
+		}
+		p.next()
+	}
+	return obj, p.expect("}")
+}
@@ -0,0 +1,128 @@
+package jq
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const testInput = `{
+  "ref": "refs/heads/main",
+  "after": "abc",
+  "deleted": false,
+  "repository": {"full_name": "org/repo", "private": true},
+  "commits": [
+    {"id": "c1", "modified": ["a.go", "b.go"], "added": []},
+    {"id": "c2", "modified": ["b.go"], "added": ["c.md"]}
+  ],
+  "labels": [{"name": "bug"}, {"name": "Ready"}],
+  "counts": {"b": 2, "a": 1},
+  "n": 7
+}`
+
+func TestParse_Invalid(t *testing.T) {
+	for _, src := range []string{"", ".[", ". |", "{a:}", "unknown", "map", "map(.; .)", "if . then 1", `"abc`,
+		"1 +", "$x", "reduce .[] as $x (0; . + $x)", `"\(.a)"`, "@csv", ". . ."} {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("%s should be invalid", src)
+		}
+	}
+}
+
+func TestQuery_Run(t *testing.T) {
+	var input interface{}
+	if err := json.Unmarshal([]byte(testInput), &input); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		".":                                `[` + compact(t, testInput) + `]`,
+		".ref":                             `["refs/heads/main"]`,
+		`.repository.full_name`:            `["org/repo"]`,
+		`."repository"["private"]`:         `[true]`,
+		".missing.deeper":                  `[null]`,
+		".commits[0].id, .commits[-1].id":  `["c1","c2"]`,
+		".commits[5]":                      `[null]`,
+		".commits[].id":                    `["c1","c2"]`,
+		"[.commits[].modified[]] | unique": `[["a.go","b.go"]]`,
+		".commits[1:] | length":            `[1]`,
+		`.ref[11:]`:                        `["main"]`,
+		".counts[]":                        `[1,2]`,
+		".counts | keys":                   `[["a","b"]]`,
+		".counts | to_entries":             `[[{"key":"a","value":1},{"key":"b","value":2}]]`,
+		`.counts | with_entries(select(.value > 1))`:                        `[{"b":2}]`,
+		`{sha: .after, repo: .repository.full_name, n}`:                     `[{"n":7,"repo":"org/repo","sha":"abc"}]`,
+		`{(.after): 1, "x y": .deleted}`:                                    `[{"abc":1,"x y":false}]`,
+		`{id: .commits[].id}`:                                               `[{"id":"c1"},{"id":"c2"}]`,
+		`[.labels[].name | ascii_downcase] | join(",")`:                     `["bug,ready"]`,
+		`.labels | map(select(.name | test("^[A-Z]")))`:                     `[[{"name":"Ready"}]]`,
+		`.labels | map(.name) | sort`:                                       `[["Ready","bug"]]`,
+		`.labels | sort_by(.name | ascii_downcase) | first.name`:            `["bug"]`,
+		`.ref | startswith("refs/heads/")`:                                  `[true]`,
+		`.ref | ltrimstr("refs/heads/")`:                                    `["main"]`,
+		`.ref | split("/") | last`:                                          `["main"]`,
+		`.ref / "/" | length`:                                               `[3]`,
+		`.n + 1, .n - 1, .n * 2, .n / 2, .n % 4, -.n`:                       `[8,6,14,3.5,3,-7]`,
+		`"a" + "b", [1] + [2], {a: 1} + {b: 2}, null + 1`:                   `["ab",[1,2],{"a":1,"b":2},1]`,
+		`[1, 2, 3] - [2]`:                                                   `[[1,3]]`,
+		`.n > 5 and .deleted | not`:                                         `[true]`,
+		`.deleted or .n == 7`:                                               `[true]`,
+		`.n < "a", null < false, [] > {}`:                                   `[true,true,false]`,
+		`.missing // "default"`:                                             `["default"]`,
+		`.deleted // .after`:                                                `["abc"]`,
+		`(.ref | tonumber) // 0`:                                            `[0]`,
+		`.ref | tonumber?`:                                                  `[]`,
+		`.ref.x?`:                                                           `[]`,
+		`if .deleted then "delete" elif .n > 5 then "big" else "small" end`: `["big"]`,
+		`if .deleted then 1 end`:                                            `[` + compact(t, testInput) + `]`,
+		`[.commits[] | select(.added | length > 0) | .id]`:                  `[["c2"]]`,
+		`.commits | map(.modified | length) | add`:                          `[3]`,
+		`[.commits[].added | any], [.commits[] | has("id")] | all`:          `[false,true]`,
+		`.labels | contains([{name: "bug"}])`:                               `[true]`,
+		`.repository | tojson | fromjson | .private`:                        `[true]`,
+		`.n | tostring, type`:                                               `["7","number"]`,
+		`[empty], [1, 2] | reverse`:                                         `[[],[2,1]]`,
+		`[3, 1, 2] | min, max`:                                              `[1,3]`,
+		`[..] | length`:                                                     `[29]`,
+		`first(.commits[].id)`:                                              `["c1"]`,
+		`[{key: "k", value: 1}] | from_entries`:                             `[{"k":1}]`,
+		`"é\n" | length`:                                                    `[2]`,
+		`1e3, 0.5`:                                                          `[1000,0.5]`,
+	}
+	for src, expected := range tests {
+		query, err := Parse(src)
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		outputs, err := query.Run(input)
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		if outputs == nil {
+			outputs = []interface{}{}
+		}
+		if actual := compact(t, toJSON(outputs)); actual != expected {
+			t.Errorf("%s: expected %s, got %s", src, expected, actual)
+		}
+	}
+}
+
+func TestQuery_RunError(t *testing.T) {
+	for _, src := range []string{".ref.x", ".n[]", ".n + .ref", ".ref | keys", "{(.n): 1}", ".n / 0", "[.n] | join(1)"} {
+		query, err := Parse(src)
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		if _, err = query.Run(map[string]interface{}{"ref": "main", "n": float64(1)}); err == nil {
+			t.Errorf("%s should fail", src)
+		}
+	}
+}
+
+func compact(t *testing.T, data string) string {
+	var value interface{}
+	if err := json.Unmarshal([]byte(data), &value); err != nil {
+		t.Fatal(err)
+	}
+	return toJSON(value)
+}
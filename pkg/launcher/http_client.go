@@ -0,0 +1,269 @@
+package launcher
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"gh-webhook/pkg/model"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tokenExpirySkew      = 30 * time.Second // refresh oauth2 tokens before they expire
+	defaultTokenLifetime = 5 * time.Minute  // when the token response has no expires_in
+)
+
+type pooledClient struct {
+	client *http.Client
+	users  int // the receivers using the client
+}
+
+// HTTPClientPool http clients keyed by the hash of the receiver config, so a client is rebuilt when the config
+// changes, the client is closed once no receiver uses it
+type HTTPClientPool struct {
+	mutex     sync.Mutex
+	clients   map[[32]byte]*pooledClient // config hash -> client
+	receivers map[uint][32]byte          // receiver id -> config hash of its client
+}
+
+func NewHTTPClientPool() *HTTPClientPool {
+	return &HTTPClientPool{
+		clients:   map[[32]byte]*pooledClient{},
+		receivers: map[uint][32]byte{},
+	}
+}
+
+var clientPool = NewHTTPClientPool()
+
+// EvictHTTPClient release the client of the receiver, e.g. it's updated or deleted
+func EvictHTTPClient(receiverId uint) {
+	clientPool.Evict(receiverId)
+}
+
+// Get the client of the receiver, cfg must have resolved secrets
+func (p *HTTPClientPool) Get(receiverId uint, cfg *model.HTTPReceiverConfig) (*http.Client, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(data)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if current, ok := p.receivers[receiverId]; ok && current == hash {
+		return p.clients[hash].client, nil
+	}
+
+	pooled, ok := p.clients[hash]
+	if !ok {
+		client, err := newHTTPClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		pooled = &pooledClient{client: client}
+		p.clients[hash] = pooled
+	}
+	p.release(receiverId)
+	pooled.users++
+	p.receivers[receiverId] = hash
+	return pooled.client, nil
+}
+
+// Evict release the client of the receiver, the client is closed once no receiver uses it
+func (p *HTTPClientPool) Evict(receiverId uint) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.release(receiverId)
+}
+
+func (p *HTTPClientPool) release(receiverId uint) {
+	hash, ok := p.receivers[receiverId]
+	if !ok {
+		return
+	}
+	delete(p.receivers, receiverId)
+	pooled := p.clients[hash]
+	if pooled.users--; pooled.users <= 0 {
+		pooled.client.CloseIdleConnections()
+		delete(p.clients, hash)
+	}
+}
+
+func newHTTPClient(cfg *model.HTTPReceiverConfig) (*http.Client, error) {
+	tlsConfig, err := newTLSConfig(&cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.DialContext = (&net.Dialer{
+		Timeout:   cfg.GetConnectTimeout(),
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = cfg.GetConnectTimeout()
+	transport.ResponseHeaderTimeout = cfg.GetResponseTimeout()
+	if len(cfg.Proxy) > 0 {
+		proxyUrl, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+
+	var roundTripper http.RoundTripper = transport
+	if cfg.Auth.Type == model.OAuth2Auth {
+		roundTripper = &oauth2Transport{
+			base:   transport,
+			source: newTokenSource(&cfg.Auth, &http.Client{Transport: transport}),
+		}
+	}
+	return &http.Client{
+		Transport: roundTripper,
+		Timeout:   cfg.GetConnectTimeout() + cfg.GetResponseTimeout(),
+	}, nil
+}
+
+func newTLSConfig(cfg *model.ReceiverTLS) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if len(cfg.CACert) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(cfg.CACert)) {
+			return nil, fmt.Errorf("invalid CA certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(cfg.ClientCert) > 0 {
+		cert, err := tls.X509KeyPair([]byte(cfg.ClientCert), []byte(cfg.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// tokenSource fetch oauth2 tokens by client credentials grant and cache them until they expire
+type tokenSource struct {
+	auth      model.ReceiverAuth
+	client    *http.Client
+	mutex     sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+func newTokenSource(auth *model.ReceiverAuth, client *http.Client) *tokenSource {
+	return &tokenSource{auth: *auth, client: client}
+}
+
+func (s *tokenSource) Token() (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.token) > 0 && time.Now().Before(s.expiresAt) {
+		return s.token, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(s.auth.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.auth.Scopes, " "))
+	}
+	if len(s.auth.Audience) > 0 {
+		form.Set("audience", s.auth.Audience)
+	}
+	req, err := http.NewRequest(http.MethodPost, s.auth.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(s.auth.ClientID), url.QueryEscape(s.auth.ClientSecret))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch oauth2 token: %s", resp.Status)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to parse oauth2 token: %v", err)
+	}
+	if len(body.AccessToken) == 0 {
+		return "", fmt.Errorf("oauth2 token response has no access token")
+	}
+
+	lifetime := time.Duration(body.ExpiresIn) * time.Second
+	if lifetime <= 0 {
+		lifetime = defaultTokenLifetime
+	}
+	s.token = body.AccessToken
+	s.expiresAt = time.Now().Add(max(lifetime-tokenExpirySkew, lifetime/2))
+	return s.token, nil
+}
+
+type oauth2Transport struct {
+	base   http.RoundTripper
+	source *tokenSource
+}
+
+// invalidate drop the cached token unless it's already refreshed, e.g. it's revoked before it expires
+func (s *tokenSource) invalidate(token string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.token == token {
+		s.token = ""
+	}
+}
+
+// RoundTrip the request is retried once with a new token if the token is rejected
+func (t *oauth2Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.source.Token()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.roundTrip(req, token)
+	// the body which can't be read again isn't retried
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || !replayable {
+		return resp, err
+	}
+
+	t.source.invalidate(token)
+	if token, err = t.source.Token(); err != nil {
+		return resp, nil
+	}
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return t.roundTrip(retry, token)
+}
+
+func (t *oauth2Transport) roundTrip(req *http.Request, token string) (*http.Response, error) {
+	// RoundTrip must not modify the request
+	cloned := req.Clone(req.Context())
+	cloned.Header.Set("Authorization", "Bearer "+token)
+	return t.base.RoundTrip(cloned)
+}
@@ -0,0 +1,289 @@
+package launcher
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"gh-webhook/pkg/model"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM string
+	keyPEM  string
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert, isCA bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:              []string{cn},
+	}
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		keyPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})),
+	}
+}
+
+func TestHTTPClientPool_MTLS(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, true)
+	serverCert := newTestCert(t, "receiver.local", ca, false)
+	clientCert := newTestCert(t, "gh-webhook", ca, false)
+
+	caPool := x509.NewCertPool()
+	caPool.AddCert(ca.cert)
+	serverKeyPair, err := tls.X509KeyPair([]byte(serverCert.certPEM), []byte(serverCert.keyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		fmt.Fprint(writer, request.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverKeyPair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    caPool,
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	cfg := &model.HTTPReceiverConfig{
+		URL:  ts.URL,
+		Auth: model.ReceiverAuth{Type: model.NoneAuth},
+		TLS: model.ReceiverTLS{
+			CACert:     ca.certPEM,
+			ClientCert: clientCert.certPEM,
+			ClientKey:  clientCert.keyPEM,
+			ServerName: "receiver.local",
+		},
+	}
+	if err = cfg.IsValid(); err != nil {
+		t.Fatal(err)
+	}
+
+	pool := NewHTTPClientPool()
+	client, err := pool.Get(1, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %s", resp.Status)
+	}
+
+	reused, err := pool.Get(1, cfg)
+	if err != nil || reused != client {
+		t.Fatal("client should be reused")
+	}
+
+	withoutCert := *cfg
+	withoutCert.TLS.ClientCert = ""
+	withoutCert.TLS.ClientKey = ""
+	client, err = pool.Get(1, &withoutCert)
+	if err != nil || client == reused {
+		t.Fatal("client should be rebuilt when config changes")
+	}
+	if resp, err = client.Get(ts.URL); err == nil {
+		resp.Body.Close()
+		t.Fatal("request without client certificate should fail")
+	}
+}
+
+func TestHTTPClientPool_OAuth2(t *testing.T) {
+	var tokenRequests int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		atomic.AddInt32(&tokenRequests, 1)
+		clientId, clientSecret, _ := request.BasicAuth()
+		if clientId != "gh-webhook" || clientSecret != "secret" ||
+			request.FormValue("grant_type") != "client_credentials" || request.FormValue("scope") != "hook.write hook.read" ||
+			request.FormValue("audience") != "receiver" {
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(writer, `{"access_token":"access","token_type":"bearer","expires_in":3600}`)
+	}))
+	defer tokenServer.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("Authorization") != "Bearer access" {
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		writer.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	cfg := &model.HTTPReceiverConfig{
+		URL: ts.URL,
+		Auth: model.ReceiverAuth{
+			Type:         model.OAuth2Auth,
+			TokenURL:     tokenServer.URL,
+			ClientID:     "gh-webhook",
+			ClientSecret: "secret",
+			Scopes:       []string{"hook.write", "hook.read"},
+			Audience:     "receiver",
+		},
+	}
+	if err := cfg.IsValid(); err != nil {
+		t.Fatal(err)
+	}
+
+	pool := NewHTTPClientPool()
+	for i := 0; i < 3; i++ {
+		client, err := pool.Get(1, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status %s", resp.Status)
+		}
+	}
+	if atomic.LoadInt32(&tokenRequests) != 1 {
+		t.Fatal("token should be cached")
+	}
+
+	cfg.Auth.ClientSecret = "invalid"
+	client, err := pool.Get(1, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Get(ts.URL); err == nil {
+		t.Fatal("invalid client secret should fail")
+	}
+}
+
+func TestHTTPClientPool_Evict(t *testing.T) {
+	cfg := &model.HTTPReceiverConfig{URL: "http://localhost", Auth: model.ReceiverAuth{Type: model.NoneAuth}}
+	pool := NewHTTPClientPool()
+	first, err := pool.Get(1, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second, _ := pool.Get(2, cfg); second != first {
+		t.Fatal("the receivers of the same config should share the client")
+	}
+
+	pool.Evict(1)
+	if len(pool.clients) != 1 {
+		t.Fatal("the client should be kept while a receiver uses it")
+	}
+	pool.Evict(2)
+	if len(pool.clients) != 0 || len(pool.receivers) != 0 {
+		t.Fatal("the client should be removed once no receiver uses it")
+	}
+	if client, _ := pool.Get(1, cfg); client == first {
+		t.Fatal("the evicted client should be built again")
+	}
+
+	updated := *cfg
+	updated.Headers = map[string]string{"X-Env": "ci"}
+	if _, err = pool.Get(1, &updated); err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.clients) != 1 {
+		t.Fatal("the client of the old config should be removed")
+	}
+}
+
+func TestHTTPClientPool_OAuth2Revoked(t *testing.T) {
+	var tokenRequests int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		n := atomic.AddInt32(&tokenRequests, 1)
+		writer.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(writer, `{"access_token":"access-%d","token_type":"bearer","expires_in":3600}`, n)
+	}))
+	defer tokenServer.Close()
+
+	// the first token is revoked before it expires
+	ts := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+		if request.Header.Get("Authorization") != "Bearer access-2" || string(body) != "payload" {
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		writer.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	cfg := &model.HTTPReceiverConfig{
+		URL: ts.URL,
+		Auth: model.ReceiverAuth{
+			Type:         model.OAuth2Auth,
+			TokenURL:     tokenServer.URL,
+			ClientID:     "gh-webhook",
+			ClientSecret: "secret",
+		},
+	}
+	client, err := NewHTTPClientPool().Get(1, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Post(ts.URL, "text/plain", strings.NewReader("payload"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("the request should be retried with a new token, got %s", resp.Status)
+		}
+	}
+	if atomic.LoadInt32(&tokenRequests) != 2 {
+		t.Fatalf("the rejected token should be refreshed once, got %d token requests", tokenRequests)
+	}
+}
@@ -16,75 +16,139 @@ type HttpAppLauncher struct {
 }
 
 func (h *HttpAppLauncher) Launch(routineId int32, config *config.Config, re model.GHWebhookReceiver, event model.GHWebhookEvent,
-	receiverDeliver model.GHWebhookEventReceiverDeliver) error {
+	receiverDeliver *model.GHWebhookEventReceiverDeliver) error {
 
-	str, err := h.GetPayload(config, re, event, receiverDeliver)
+	str, err := h.GetPayload(config, re, event, *receiverDeliver)
 	if err != nil {
 		return err
 	}
 
-	url := re.ReceiverConfig.URL
-	if len(url) == 0 {
-		return fmt.Errorf("invalid url")
-	}
-
-	auth := re.ReceiverConfig.Auth
-	if !slices.Contains(SupportedAuthType, auth) {
-		return fmt.Errorf("unsupported auth type %s", auth)
+	httpConfig := re.ReceiverConfig.GetHTTPConfig()
+	if httpConfig == nil {
+		return fmt.Errorf("invalid receiver config")
 	}
 
-	req, err := http.NewRequest("POST", url, strings.NewReader(string(str)))
+	resp, body, err := send(routineId, re, httpConfig.GetMethod(), httpConfig.URL, str)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	if auth == "basic" || auth == "token" {
-		username := re.ReceiverConfig.Username
+	if !httpConfig.IsSuccessStatus(resp.StatusCode) {
+		return fmt.Errorf("failed to send request: %s", resp.Status)
+	}
 
-		password := re.ReceiverConfig.Password
+	log.Infof("succeed to send request: %s, body: %s", resp.Status, body)
+	return nil
+}
 
-		if len(username) == 0 || len(password) == 0 {
-			return fmt.Errorf("username/token header or password/token value is empty")
-		}
+// Cancel notify the cancel url of the receiver if it's configured
+func (h *HttpAppLauncher) Cancel(routineId int32, config *config.Config, re model.GHWebhookReceiver,
+	previous model.GHWebhookEventReceiverDeliver, supersededBy model.GHWebhookEventReceiverDeliver) error {
+	httpConfig := re.ReceiverConfig.GetHTTPConfig()
+	if httpConfig == nil {
+		return fmt.Errorf("invalid receiver config")
+	}
+	return notifyCancel(routineId, config, re, httpConfig, previous, supersededBy)
+}
 
-		if auth == "basic" {
-			req.SetBasicAuth(username, password)
-		} else {
-			req.Header.Add(username, password)
-		}
+func (h *HttpAppLauncher) GetPayload(c *config.Config, re model.GHWebhookReceiver, event model.GHWebhookEvent,
+	receiverDeliver model.GHWebhookEventReceiverDeliver) ([]byte, error) {
+	payload := map[string]interface{}{
+		"url":                fmt.Sprintf("%s/gh-webhook-event/%d", c.APIUrl, event.ID),
+		"event":              event,
+		"eventDeliverAckUrl": fmt.Sprintf("%s/gh-webhook-event-receiver-deliver/%d/ack", c.APIUrl, receiverDeliver.ID),
 	}
-	client := http.DefaultClient
 
-	// Send the request
-	resp, err := client.Do(req)
+	return json.Marshal(payload)
+}
+
+// notifyCancel post the cancelled delivery to the cancel url, nothing to do if it's not configured
+func notifyCancel(routineId int32, c *config.Config, re model.GHWebhookReceiver, httpConfig *model.HTTPReceiverConfig,
+	previous model.GHWebhookEventReceiverDeliver, supersededBy model.GHWebhookEventReceiverDeliver) error {
+	if len(httpConfig.CancelURL) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"url":              fmt.Sprintf("%s/gh-webhook-event/%d", c.APIUrl, previous.GHWebhookEventDeliver.GHWebhookEventId),
+		"eventDeliverId":   previous.ID,
+		"supersededBy":     supersededBy.ID,
+		"concurrencyGroup": previous.ConcurrencyGroup,
+		"receiverRef":      previous.ReceiverRef,
+	})
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 && resp.StatusCode != 201 {
-		return fmt.Errorf("failed to send request: %s", resp.Status)
-	}
-	body := "unknown"
-	data, err := io.ReadAll(resp.Body)
+	resp, _, err := send(routineId, re, http.MethodPost, httpConfig.CancelURL, payload)
 	if err != nil {
-		log.Errorf("[go routine %d] failed to read response body: %v", routineId, err)
-	} else {
-		body = string(data)
+		return err
 	}
-
-	log.Infof("succeed to send request: %s, body: %s", resp.Status, body)
+	if !httpConfig.IsSuccessStatus(resp.StatusCode) {
+		return fmt.Errorf("failed to send cancel request: %s", resp.Status)
+	}
+	log.Infof("[go routine %d] notified receiver %d to cancel delivery %d: %s", routineId, re.ID, previous.ID,
+		resp.Status)
 	return nil
 }
 
-func (h *HttpAppLauncher) GetPayload(c *config.Config, re model.GHWebhookReceiver, event model.GHWebhookEvent,
-	receiverDeliver model.GHWebhookEventReceiverDeliver) ([]byte, error) {
-	payload := map[string]interface{}{
-		"url":                fmt.Sprintf("%s/gh-webhook-event/%d", c.APIUrl, event.ID),
-		"event":              event,
-		"eventDeliverAckUrl": fmt.Sprintf("%s/gh-webhook-receiver-deliver/%d/ack", c.APIUrl, receiverDeliver.ID),
+// send the request with the auth, headers and pooled client of the receiver, return the response and its body
+func send(routineId int32, re model.GHWebhookReceiver, method, url string, payload []byte) (*http.Response, []byte, error) {
+	httpConfig := re.ReceiverConfig.GetHTTPConfig()
+	if httpConfig == nil {
+		return nil, nil, fmt.Errorf("invalid receiver config")
 	}
 
-	return json.Marshal(payload)
+	if len(url) == 0 {
+		return nil, nil, fmt.Errorf("invalid url")
+	}
+
+	auth := httpConfig.Auth
+	if !slices.Contains(SupportedAuthType, auth.Type) {
+		return nil, nil, fmt.Errorf("unsupported auth type %s", auth.Type)
+	}
+
+	var reqBody io.Reader
+	if payload != nil {
+		reqBody = strings.NewReader(string(payload))
+	}
+	req, err := http.NewRequest(method, url, reqBody)
+	if err != nil {
+		return nil, nil, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range httpConfig.Headers {
+		req.Header.Set(name, value)
+	}
+
+	if err = auth.IsValid(); err != nil {
+		return nil, nil, err
+	}
+
+	// oauth2 token is set by the client transport
+	switch auth.Type {
+	case model.BasicAuth:
+		req.SetBasicAuth(auth.Username, auth.Password)
+	case model.TokenAuth:
+		req.Header.Add(auth.Header, auth.Token)
+	}
+	client, err := clientPool.Get(re.ID, httpConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Send the request
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Errorf("[go routine %d] failed to read response body: %v", routineId, err)
+		body = []byte("unknown")
+	}
+	return resp, body, nil
 }
@@ -7,6 +7,7 @@ import (
 	"gorm.io/gorm"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -122,11 +123,10 @@ func Test_Launch(t *testing.T) {
 		Name:     "test",
 		GitHubId: github.ID,
 		GitHub:   github,
-		ReceiverConfig: model.GHWebhookReceiverConfig{
-			Type: "http",
+		ReceiverConfig: model.NewGHWebhookReceiverConfig(&model.HTTPReceiverConfig{
 			URL:  ts.URL,
-			Auth: "none",
-		},
+			Auth: model.ReceiverAuth{Type: model.NoneAuth},
+		}),
 		Subscribes: nil,
 	}
 
@@ -177,7 +177,7 @@ func Test_Launch(t *testing.T) {
 		Ack:       "",
 	}
 
-	err := launcher.Launch(1, &cfg, re, event, deliver)
+	err := launcher.Launch(1, &cfg, re, event, &deliver)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -187,3 +187,58 @@ func handler(writer http.ResponseWriter, request *http.Request) {
 	writer.WriteHeader(200)
 	fmt.Fprintf(writer, "Hello, client!")
 }
+
+func Test_LaunchSettings(t *testing.T) {
+	launcher := HttpAppLauncher{}
+	cfg := config.Config{
+		APIUrl: "http://localhost:8080",
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPut || request.Header.Get("X-Source") != "gh-webhook" {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if request.URL.Path == "/slow" {
+			time.Sleep(500 * time.Millisecond)
+		}
+		writer.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	httpConfig := &model.HTTPReceiverConfig{
+		URL:     ts.URL,
+		Auth:    model.ReceiverAuth{Type: model.NoneAuth},
+		Method:  http.MethodPut,
+		Headers: map[string]string{"X-Source": "gh-webhook"},
+	}
+	re := model.GHWebhookReceiver{
+		Model:          gorm.Model{ID: 30},
+		Name:           "test",
+		ReceiverConfig: model.NewGHWebhookReceiverConfig(httpConfig),
+	}
+	event := model.GHWebhookEvent{
+		Model:   gorm.Model{ID: 2},
+		Payload: "{}",
+		Event:   "pull_request",
+	}
+	deliver := model.GHWebhookEventReceiverDeliver{
+		Model: gorm.Model{ID: 4},
+	}
+
+	err := launcher.Launch(1, &cfg, re, event, &deliver)
+	if err == nil || !strings.Contains(err.Error(), "202 Accepted") {
+		t.Fatal("202 should not be success by default")
+	}
+
+	httpConfig.SuccessStatus = []string{"2xx"}
+	if err = launcher.Launch(1, &cfg, re, event, &deliver); err != nil {
+		t.Fatal(err)
+	}
+
+	httpConfig.URL = ts.URL + "/slow"
+	httpConfig.ResponseTimeout = model.Duration(100 * time.Millisecond)
+	if err = launcher.Launch(1, &cfg, re, event, &deliver); err == nil {
+		t.Fatal("slow receiver should time out")
+	}
+}
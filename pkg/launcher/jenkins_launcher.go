@@ -2,20 +2,75 @@ package launcher
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"gh-webhook/pkg/config"
 	"gh-webhook/pkg/model"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
 )
 
 type JenkinsLauncher struct {
 	HttpAppLauncher
 }
 
+// Launch trigger the jenkins job and keep the queue items it returns to cancel the builds later
+func (h *JenkinsLauncher) Launch(routineId int32, config *config.Config, re model.GHWebhookReceiver, event model.GHWebhookEvent,
+	receiverDeliver *model.GHWebhookEventReceiverDeliver) error {
+	jenkinsConfig := re.ReceiverConfig.GetJenkinsConfig()
+	if jenkinsConfig == nil {
+		return fmt.Errorf("invalid receiver config")
+	}
+
+	str, err := h.GetPayload(config, re, event, *receiverDeliver)
+	if err != nil {
+		return err
+	}
+
+	resp, body, err := send(routineId, re, jenkinsConfig.GetMethod(), jenkinsConfig.URL, str)
+	if err != nil {
+		return err
+	}
+	if !jenkinsConfig.IsSuccessStatus(resp.StatusCode) {
+		return fmt.Errorf("failed to send request: %s", resp.Status)
+	}
+
+	receiverDeliver.ReceiverRef = strings.Join(queueItems(jenkinsConfig, resp, body), ",")
+	log.Infof("succeed to trigger jenkins: %s, queue items: %s", resp.Status, receiverDeliver.ReceiverRef)
+	return nil
+}
+
+// Cancel abort the builds of the previous delivery, or remove them from the queue if they haven't started
+func (h *JenkinsLauncher) Cancel(routineId int32, config *config.Config, re model.GHWebhookReceiver,
+	previous model.GHWebhookEventReceiverDeliver, supersededBy model.GHWebhookEventReceiverDeliver) error {
+	jenkinsConfig := re.ReceiverConfig.GetJenkinsConfig()
+	if jenkinsConfig == nil {
+		return fmt.Errorf("invalid receiver config")
+	}
+
+	var errs []error
+	for _, queueItem := range strings.Split(previous.ReceiverRef, ",") {
+		if len(queueItem) == 0 {
+			continue
+		}
+		if err := cancelQueueItem(routineId, re, jenkinsConfig.GetServerURL(), queueItem); err != nil {
+			errs = append(errs, fmt.Errorf("failed to cancel %s: %v", queueItem, err))
+		}
+	}
+	if err := notifyCancel(routineId, config, re, &jenkinsConfig.HTTPReceiverConfig, previous, supersededBy); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
 func (h *JenkinsLauncher) GetPayload(c *config.Config, re model.GHWebhookReceiver, event model.GHWebhookEvent,
 	receiverLog model.GHWebhookEventReceiverDeliver) ([]byte, error) {
 
-	parameter := re.ReceiverConfig.Parameter
-	if len(parameter) == 0 {
+	jenkinsConfig := re.ReceiverConfig.GetJenkinsConfig()
+	if jenkinsConfig == nil || len(jenkinsConfig.Parameter) == 0 {
 		return nil, fmt.Errorf("invalid parameter")
 	}
 
@@ -26,8 +81,110 @@ func (h *JenkinsLauncher) GetPayload(c *config.Config, re model.GHWebhookReceive
 	}
 
 	jenkinsPayload := map[string]interface{}{
-		parameter: payload,
+		jenkinsConfig.Parameter: payload,
 	}
 
 	return json.Marshal(jenkinsPayload)
 }
+
+// queueItems the queue items of the triggered builds, buildWithParameters returns it as location and the generic
+// webhook trigger returns the triggered jobs in the body
+func queueItems(jenkinsConfig *model.JenkinsReceiverConfig, resp *http.Response, body []byte) []string {
+	server := jenkinsConfig.GetServerURL()
+	if location, err := resp.Location(); err == nil && strings.Contains(location.Path, "/queue/item/") {
+		if !sameServer(server, location.String()) {
+			log.Warningf("ignore queue item %s which isn't on the jenkins server %s", location, server)
+			return nil
+		}
+		return []string{location.String()}
+	}
+
+	var triggered struct {
+		Jobs map[string]struct {
+			Triggered bool   `json:"triggered"`
+			URL       string `json:"url"`
+		} `json:"jobs"`
+	}
+	if err := json.Unmarshal(body, &triggered); err != nil {
+		return nil
+	}
+	var items []string
+	for _, job := range triggered.Jobs {
+		if !job.Triggered || len(job.URL) == 0 {
+			continue
+		}
+		item := absoluteURL(server, job.URL)
+		if !sameServer(server, item) {
+			log.Warningf("ignore queue item %s which isn't on the jenkins server %s", item, server)
+			continue
+		}
+		items = append(items, item)
+	}
+	slices.Sort(items)
+	return items
+}
+
+// cancelQueueItem cancel the queue item or stop its build, only the urls of the jenkins server are called, so the
+// credentials of the receiver aren't sent to the other hosts
+func cancelQueueItem(routineId int32, re model.GHWebhookReceiver, server, queueItem string) error {
+	if !sameServer(server, queueItem) {
+		return fmt.Errorf("queue item isn't on the jenkins server %s", server)
+	}
+	resp, body, err := send(routineId, re, http.MethodGet, strings.TrimSuffix(queueItem, "/")+"/api/json", nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get queue item: %s", resp.Status)
+	}
+
+	var item struct {
+		ID         int  `json:"id"`
+		Cancelled  bool `json:"cancelled"`
+		Executable *struct {
+			URL string `json:"url"`
+		} `json:"executable"`
+	}
+	if err = json.Unmarshal(body, &item); err != nil {
+		return fmt.Errorf("failed to parse queue item: %v", err)
+	}
+
+	cancelUrl := fmt.Sprintf("%s/queue/cancelItem?id=%d", server, item.ID)
+	switch {
+	case item.Cancelled:
+		return nil
+	case item.Executable != nil && len(item.Executable.URL) > 0:
+		cancelUrl = strings.TrimSuffix(absoluteURL(server, item.Executable.URL), "/") + "/stop"
+		if !sameServer(server, cancelUrl) {
+			return fmt.Errorf("build %s isn't on the jenkins server %s", item.Executable.URL, server)
+		}
+	}
+	if resp, _, err = send(routineId, re, http.MethodPost, cancelUrl, nil); err != nil {
+		return err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("failed to cancel build: %s", resp.Status)
+	}
+	log.Infof("[go routine %d] cancelled jenkins build %s: %s", routineId, cancelUrl, resp.Status)
+	return nil
+}
+
+func absoluteURL(server, ref string) string {
+	if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+		return ref
+	}
+	return server + "/" + strings.TrimPrefix(ref, "/")
+}
+
+// sameServer whether the url has the scheme and host of the server
+func sameServer(server, ref string) bool {
+	serverUrl, err := url.Parse(server)
+	if err != nil {
+		return false
+	}
+	refUrl, err := url.Parse(ref)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(serverUrl.Scheme, refUrl.Scheme) && strings.EqualFold(serverUrl.Host, refUrl.Host)
+}
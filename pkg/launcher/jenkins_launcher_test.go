@@ -1,10 +1,15 @@
 package launcher
 
 import (
+	"encoding/json"
 	"fmt"
 	"gh-webhook/pkg/config"
 	"gh-webhook/pkg/model"
 	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
 	"testing"
 	"time"
 )
@@ -33,12 +38,13 @@ func TestJenkinsLauncher_GetPayload(t *testing.T) {
 		Name:     "test",
 		GitHubId: github.ID,
 		GitHub:   github,
-		ReceiverConfig: model.GHWebhookReceiverConfig{
-			Type:      "jenkins",
-			URL:       "http://localhost:8080",
-			Auth:      "none",
+		ReceiverConfig: model.NewGHWebhookReceiverConfig(&model.JenkinsReceiverConfig{
+			HTTPReceiverConfig: model.HTTPReceiverConfig{
+				URL:  "http://localhost:8080",
+				Auth: model.ReceiverAuth{Type: model.NoneAuth},
+			},
 			Parameter: "payload",
-		},
+		}),
 		Subscribes: nil,
 	}
 
@@ -97,3 +103,99 @@ func TestJenkinsLauncher_GetPayload(t *testing.T) {
 	fmt.Println(data)
 
 }
+
+func TestJenkinsLauncher_Cancel(t *testing.T) {
+	launcher := JenkinsLauncher{}
+	cfg := config.Config{
+		APIUrl: "http://localhost:8080",
+	}
+
+	var mutex sync.Mutex
+	var calls []string
+	var cancelPayload map[string]interface{}
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		calls = append(calls, request.Method+" "+request.URL.RequestURI())
+		switch request.URL.Path {
+		case "/job/build/buildWithParameters":
+			writer.Header().Set("Location", ts.URL+"/queue/item/7/")
+			writer.WriteHeader(http.StatusCreated)
+		case "/generic-webhook-trigger/invoke":
+			fmt.Fprint(writer, `{"jobs":{"build":{"triggered":true,"url":"queue/item/8/"}},"message":"Triggered jobs."}`)
+		case "/job/hostile/buildWithParameters":
+			writer.Header().Set("Location", "http://jenkins.example.com/queue/item/9/")
+			writer.WriteHeader(http.StatusCreated)
+		case "/queue/item/7/api/json":
+			fmt.Fprintf(writer, `{"id":7,"executable":{"number":3,"url":"%s/job/build/3/"}}`, ts.URL)
+		case "/queue/item/8/api/json":
+			fmt.Fprint(writer, `{"id":8,"executable":null}`)
+		case "/cancel":
+			if err := json.NewDecoder(request.Body).Decode(&cancelPayload); err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+			}
+		}
+	}))
+	defer ts.Close()
+
+	jenkinsConfig := &model.JenkinsReceiverConfig{
+		HTTPReceiverConfig: model.HTTPReceiverConfig{
+			URL:  ts.URL + "/job/build/buildWithParameters",
+			Auth: model.ReceiverAuth{Type: model.NoneAuth},
+		},
+		Parameter: "payload",
+	}
+	re := model.GHWebhookReceiver{
+		Model:          gorm.Model{ID: 35},
+		ReceiverConfig: model.NewGHWebhookReceiverConfig(jenkinsConfig),
+	}
+	event := model.GHWebhookEvent{Model: gorm.Model{ID: 2}, Payload: "{}", Event: "push"}
+
+	previous := model.GHWebhookEventReceiverDeliver{Model: gorm.Model{ID: 4}}
+	if err := launcher.Launch(1, &cfg, re, event, &previous); err != nil {
+		t.Fatal(err)
+	}
+	if previous.ReceiverRef != ts.URL+"/queue/item/7/" {
+		t.Fatalf("unexpected receiver ref %s", previous.ReceiverRef)
+	}
+	latest := model.GHWebhookEventReceiverDeliver{Model: gorm.Model{ID: 5}}
+	if err := launcher.Cancel(1, &cfg, re, previous, latest); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(calls, "POST /job/build/3/stop") {
+		t.Fatalf("running build should be stopped, calls %v", calls)
+	}
+
+	jenkinsConfig.URL = ts.URL + "/generic-webhook-trigger/invoke"
+	jenkinsConfig.CancelURL = ts.URL + "/cancel"
+	if err := launcher.Launch(1, &cfg, re, event, &previous); err != nil {
+		t.Fatal(err)
+	}
+	if previous.ReceiverRef != ts.URL+"/queue/item/8/" {
+		t.Fatalf("unexpected receiver ref %s", previous.ReceiverRef)
+	}
+	if err := launcher.Cancel(1, &cfg, re, previous, latest); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(calls, "POST /queue/cancelItem?id=8") {
+		t.Fatalf("queued build should be cancelled, calls %v", calls)
+	}
+	if cancelPayload["eventDeliverId"] != float64(4) || cancelPayload["supersededBy"] != float64(5) {
+		t.Fatalf("unexpected cancel payload %v", cancelPayload)
+	}
+
+	// the queue items of the other hosts are never called with the credentials of the receiver
+	jenkinsConfig.URL = ts.URL + "/job/hostile/buildWithParameters"
+	jenkinsConfig.CancelURL = ""
+	if err := launcher.Launch(1, &cfg, re, event, &previous); err != nil {
+		t.Fatal(err)
+	}
+	if len(previous.ReceiverRef) != 0 {
+		t.Fatalf("the queue item of the other host should be ignored, got %s", previous.ReceiverRef)
+	}
+	previous.ReceiverRef = "http://jenkins.example.com/queue/item/9/"
+	if err := launcher.Cancel(1, &cfg, re, previous, latest); err == nil {
+		t.Fatal("the queue item of the other host should not be cancelled")
+	}
+}
@@ -7,9 +7,13 @@ import (
 
 var SupportedReceiverType = []string{model.HTTP, model.Jenkins}
 
-var SupportedAuthType = []string{model.NoneAuth, model.BasicAuth, model.TokenAuth}
+var SupportedAuthType = []string{model.NoneAuth, model.BasicAuth, model.TokenAuth, model.OAuth2Auth}
 
 type GHWebhookReceiverLauncher interface {
+	// Launch send the event to the receiver, it may keep a reference of what the receiver started in the delivery
 	Launch(routineId int32, config *config.Config, re model.GHWebhookReceiver, event model.GHWebhookEvent,
-		receiverDeliver model.GHWebhookEventReceiverDeliver) error
+		receiverDeliver *model.GHWebhookEventReceiverDeliver) error
+	// Cancel ask the receiver to cancel the previous delivery which is superseded by a newer one
+	Cancel(routineId int32, config *config.Config, re model.GHWebhookReceiver,
+		previous model.GHWebhookEventReceiverDeliver, supersededBy model.GHWebhookEventReceiverDeliver) error
 }
@@ -0,0 +1,102 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open" // a paused delivery is probing the receiver
+)
+
+const (
+	DefaultFailureThreshold = 5
+	DefaultOpenDuration     = time.Minute
+)
+
+// CircuitBreakerConfig when to stop delivering to a failing receiver and when to probe it again
+type CircuitBreakerConfig struct {
+	FailureThreshold int      `json:"failureThreshold,omitempty"` // consecutive failures to open, default 5
+	OpenDuration     Duration `json:"openDuration,omitempty"`     // wait before probing, default 1m
+}
+
+func (c CircuitBreakerConfig) GetFailureThreshold() int {
+	if c.FailureThreshold <= 0 {
+		return DefaultFailureThreshold
+	}
+	return c.FailureThreshold
+}
+
+func (c CircuitBreakerConfig) GetOpenDuration() time.Duration {
+	if c.OpenDuration <= 0 {
+		return DefaultOpenDuration
+	}
+	return c.OpenDuration.Duration()
+}
+
+func (c CircuitBreakerConfig) IsValid() error {
+	if c.FailureThreshold < 0 {
+		return fmt.Errorf("failure threshold must not be negative")
+	}
+	if c.OpenDuration < 0 {
+		return fmt.Errorf("open duration must not be negative")
+	}
+	return nil
+}
+
+// CircuitState persisted state of the receiver circuit breaker
+type CircuitState struct {
+	State    string `gorm:"default:closed"`
+	Failures int    // consecutive failures
+	OpenedAt *time.Time
+}
+
+func (s *CircuitState) GetState() string {
+	if len(s.State) == 0 {
+		return CircuitClosed
+	}
+	return s.State
+}
+
+// Allow whether deliveries go to the receiver directly, otherwise they are paused
+func (s *CircuitState) Allow() bool {
+	return s.GetState() == CircuitClosed
+}
+
+// ProbeDue whether the open circuit waited long enough to probe the receiver
+func (s *CircuitState) ProbeDue(cfg CircuitBreakerConfig, now time.Time) bool {
+	return s.GetState() == CircuitOpen && (s.OpenedAt == nil || !now.Before(s.OpenedAt.Add(cfg.GetOpenDuration())))
+}
+
+// Record the delivery result, a failed probe or too many failures open the circuit
+func (s *CircuitState) Record(cfg CircuitBreakerConfig, success bool, now time.Time) {
+	if success {
+		s.Reset()
+		return
+	}
+	s.Failures++
+	if s.GetState() == CircuitHalfOpen || s.Failures >= cfg.GetFailureThreshold() {
+		s.State = CircuitOpen
+		s.OpenedAt = &now
+	}
+}
+
+func (s *CircuitState) Reset() {
+	s.State = CircuitClosed
+	s.Failures = 0
+	s.OpenedAt = nil
+}
+
+// CircuitColumns the receiver columns of the circuit state, they are only updated by the deliveries and the reset
+var CircuitColumns = []string{"circuit_state", "circuit_failures", "circuit_opened_at"}
+
+// Columns the receiver columns to update the circuit state
+func (s *CircuitState) Columns() map[string]interface{} {
+	return map[string]interface{}{
+		"circuit_state":     s.GetState(),
+		"circuit_failures":  s.Failures,
+		"circuit_opened_at": s.OpenedAt,
+	}
+}
@@ -0,0 +1,46 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitState_Record(t *testing.T) {
+	cfg := CircuitBreakerConfig{FailureThreshold: 2, OpenDuration: Duration(time.Minute)}
+	state := CircuitState{}
+	now := time.Now()
+
+	state.Record(cfg, false, now)
+	if !state.Allow() || state.Failures != 1 {
+		t.Fatal("should be closed after 1 failure")
+	}
+	state.Record(cfg, false, now)
+	if state.Allow() || state.GetState() != CircuitOpen {
+		t.Fatal("should be open after 2 failures")
+	}
+	if state.ProbeDue(cfg, now.Add(30*time.Second)) || !state.ProbeDue(cfg, now.Add(time.Minute)) {
+		t.Fatal("should probe after open duration")
+	}
+
+	state.State = CircuitHalfOpen
+	state.Record(cfg, false, now.Add(time.Minute))
+	if state.GetState() != CircuitOpen || !state.OpenedAt.Equal(now.Add(time.Minute)) {
+		t.Fatal("failed probe should open the circuit again")
+	}
+
+	state.State = CircuitHalfOpen
+	state.Record(cfg, true, now)
+	if !state.Allow() || state.Failures != 0 || state.OpenedAt != nil {
+		t.Fatal("successful probe should close the circuit")
+	}
+}
+
+func TestCircuitBreakerConfig_Default(t *testing.T) {
+	cfg := CircuitBreakerConfig{}
+	if cfg.GetFailureThreshold() != DefaultFailureThreshold || cfg.GetOpenDuration() != DefaultOpenDuration {
+		t.Fatal("should use defaults")
+	}
+	if err := (CircuitBreakerConfig{FailureThreshold: -1}).IsValid(); err == nil {
+		t.Fatal("negative threshold should be invalid")
+	}
+}
@@ -0,0 +1,75 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// CodeOwnersPaths where GitHub looks for the CODEOWNERS file, the first one found is used
+var CodeOwnersPaths = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+type codeOwnersRule struct {
+	pattern string
+	globs   []*regexp.Regexp
+	owners  []string
+}
+
+// CodeOwners the rules of a CODEOWNERS file, the last matching rule of a file decides its owners
+type CodeOwners struct {
+	rules []codeOwnersRule
+}
+
+// ParseCodeOwners parse the CODEOWNERS file, the patterns follow the gitignore rules used by GitHub: a pattern
+// without / in the middle matches at any depth and a directory matches the files under it
+func ParseCodeOwners(content string) (*CodeOwners, error) {
+	codeOwners := &CodeOwners{}
+	for i, line := range strings.Split(content, "\n") {
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			line = line[:comment]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		rule := codeOwnersRule{pattern: fields[0], owners: fields[1:]}
+		for _, glob := range codeOwnersGlobs(fields[0]) {
+			re, err := compileGlob(glob)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CODEOWNERS line %d: %v", i+1, err)
+			}
+			rule.globs = append(rule.globs, re)
+		}
+		codeOwners.rules = append(codeOwners.rules, rule)
+	}
+	return codeOwners, nil
+}
+
+// codeOwnersGlobs the globs of the file and of the files under the directory of the pattern
+func codeOwnersGlobs(pattern string) []string {
+	if pattern == "*" {
+		return []string{"**"}
+	}
+	dir := strings.HasSuffix(pattern, "/")
+	pattern = strings.TrimSuffix(pattern, "/")
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	if !anchored {
+		pattern = "**/" + pattern
+	}
+	if dir {
+		return []string{pattern + "/**"}
+	}
+	return []string{pattern, pattern + "/**"}
+}
+
+// Owners the owners of the file, empty if no rule matches or the last matching rule has no owner
+func (c *CodeOwners) Owners(file string) []string {
+	for i := len(c.rules) - 1; i >= 0; i-- {
+		if slices.ContainsFunc(c.rules[i].globs, func(glob *regexp.Regexp) bool { return glob.MatchString(file) }) {
+			return c.rules[i].owners
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseCodeOwners(t *testing.T) {
+	codeOwners, err := ParseCodeOwners(`# default owners
+*       @octo/core
+
+*.md    @octo/docs # docs everywhere
+/build/ @octo/infra
+docs    @octo/writers
+pkg/api/**/*.go @octo/api @hubot
+/pkg/api/generated/
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		file   string
+		owners []string
+	}{
+		{"main.go", []string{"@octo/core"}},
+		{"README.md", []string{"@octo/docs"}},
+		{"pkg/README.md", []string{"@octo/docs"}},
+		{"build/ci/Jenkinsfile", []string{"@octo/infra"}},
+		{"tools/build/x.sh", []string{"@octo/core"}},
+		{"docs/index.html", []string{"@octo/writers"}},
+		{"site/docs/index.html", []string{"@octo/writers"}},
+		{"pkg/api/v1/handler.go", []string{"@octo/api", "@hubot"}},
+		{"pkg/api/generated/types.go", nil},
+	}
+	for _, test := range tests {
+		if owners := codeOwners.Owners(test.file); !slices.Equal(owners, test.owners) {
+			t.Errorf("owners of %s should be %v, got %v", test.file, test.owners, owners)
+		}
+	}
+
+	if _, err = ParseCodeOwners("docs/[a @octo/docs"); err == nil {
+		t.Fatal("invalid pattern should fail")
+	}
+}
@@ -0,0 +1,27 @@
+package model
+
+import (
+	"fmt"
+	"github.com/expr-lang/expr"
+)
+
+// ConcurrencyConfig only the latest delivery of a group keeps running, like the concurrency of github actions with
+// cancel-in-progress, the receiver is asked to cancel the previous delivery of the group
+type ConcurrencyConfig struct {
+	Group string `json:"group"` // expr on the payload, e.g. "pr-" + string(pull_request.number)
+}
+
+func (c *ConcurrencyConfig) IsValid() error {
+	if len(c.Group) == 0 {
+		return fmt.Errorf("concurrency group is required")
+	}
+	if _, err := expr.Compile(c.Group); err != nil {
+		return fmt.Errorf("invalid concurrency group: %v", err)
+	}
+	return nil
+}
+
+// GroupKey evaluate the group expression on the payload
+func (c *ConcurrencyConfig) GroupKey(payload map[string]interface{}) (string, error) {
+	return evalKey("concurrency group", c.Group, payload)
+}
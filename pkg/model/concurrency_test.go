@@ -0,0 +1,28 @@
+package model
+
+import "testing"
+
+func TestConcurrencyConfig_GroupKey(t *testing.T) {
+	concurrency := ConcurrencyConfig{Group: `"pr-" + string(pull_request.number)`}
+	if err := concurrency.IsValid(); err != nil {
+		t.Fatal(err)
+	}
+	group, err := concurrency.GroupKey(map[string]interface{}{
+		"pull_request": map[string]interface{}{"number": float64(12)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group != "pr-12" {
+		t.Fatalf("unexpected group %s", group)
+	}
+
+	if _, err = concurrency.GroupKey(map[string]interface{}{}); err == nil {
+		t.Fatal("missing field should fail")
+	}
+	for _, invalid := range []ConcurrencyConfig{{}, {Group: "pull_request +"}} {
+		if err = invalid.IsValid(); err == nil {
+			t.Errorf("%+v should be invalid", invalid)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package model
+
+import (
+	"fmt"
+	"github.com/expr-lang/expr"
+	"time"
+)
+
+const MinDebounceWindow = time.Second
+
+// DebounceConfig hold matching events until no event of the same key arrives for the window,
+// only the latest one is delivered and the others are coalesced into it
+type DebounceConfig struct {
+	Window Duration `json:"window"`
+	Key    string   `json:"key"` // expr on the payload, e.g. repository.full_name + "#" + string(pull_request.number)
+}
+
+func (d *DebounceConfig) IsValid() error {
+	if d.Window.Duration() < MinDebounceWindow {
+		return fmt.Errorf("debounce window must be at least %s", MinDebounceWindow)
+	}
+	if len(d.Key) == 0 {
+		return fmt.Errorf("debounce key is required")
+	}
+	if _, err := expr.Compile(d.Key); err != nil {
+		return fmt.Errorf("invalid debounce key: %v", err)
+	}
+	return nil
+}
+
+// CoalesceKey evaluate the key expression on the payload
+func (d *DebounceConfig) CoalesceKey(payload map[string]interface{}) (string, error) {
+	return evalKey("debounce key", d.Key, payload)
+}
+
+// evalKey evaluate the key expression on the payload as string, nil is an error
+func evalKey(name, expression string, payload map[string]interface{}) (string, error) {
+	output, err := expr.Eval(expression, payload)
+	if err != nil {
+		return "", err
+	}
+	if output == nil {
+		return "", fmt.Errorf("%s %s is nil", name, expression)
+	}
+	return fmt.Sprint(output), nil
+}
@@ -0,0 +1,36 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDebounceConfig_CoalesceKey(t *testing.T) {
+	debounce := DebounceConfig{
+		Window: Duration(time.Minute),
+		Key:    `repository.full_name + "#" + string(pull_request.number)`,
+	}
+	if err := debounce.IsValid(); err != nil {
+		t.Fatal(err)
+	}
+	key, err := debounce.CoalesceKey(map[string]interface{}{
+		"repository":   map[string]interface{}{"full_name": "zhaojunlucky/veda"},
+		"pull_request": map[string]interface{}{"number": float64(12)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "zhaojunlucky/veda#12" {
+		t.Fatalf("unexpected key %s", key)
+	}
+
+	for _, invalid := range []DebounceConfig{
+		{Window: Duration(time.Millisecond), Key: "repository"},
+		{Window: Duration(time.Minute)},
+		{Window: Duration(time.Minute), Key: "repository +"},
+	} {
+		if err = invalid.IsValid(); err == nil {
+			t.Errorf("%+v should be invalid", invalid)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package model
+
+import (
+	"fmt"
+	"slices"
+)
+
+// EnrichedKey the reserved payload key of the enrichments, e.g. $["$enriched"].pull_request.mergeable in a filter
+// key or root["$enriched"].combined_status.state in an expr
+const EnrichedKey = "$enriched"
+
+const (
+	EnrichPullRequest    = "pull_request"    // the pull request from the API, e.g. mergeable and mergeable_state
+	EnrichCombinedStatus = "combined_status" // the combined status of the head commit
+	EnrichCodeOwners     = "codeowners"      // the owners of the changed files by the CODEOWNERS of the base
+)
+
+var Enrichments = []string{EnrichPullRequest, EnrichCombinedStatus, EnrichCodeOwners}
+
+func ValidateEnrichments(enrichments []string) error {
+	for _, enrichment := range enrichments {
+		if !slices.Contains(Enrichments, enrichment) {
+			return fmt.Errorf("invalid enrichment %s, it should be one of %v", enrichment, Enrichments)
+		}
+	}
+	return nil
+}
+
+// WithEnriched a copy of the payload with the enrichments under EnrichedKey, the payload isn't changed since it's
+// shared by the receivers of the event
+func WithEnriched(payload map[string]interface{}, enriched map[string]interface{}) map[string]interface{} {
+	withEnriched := make(map[string]interface{}, len(payload)+1)
+	for k, v := range payload {
+		withEnriched[k] = v
+	}
+	withEnriched[EnrichedKey] = enriched
+	return withEnriched
+}
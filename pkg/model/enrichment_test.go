@@ -0,0 +1,29 @@
+package model
+
+import "testing"
+
+func TestWithEnriched(t *testing.T) {
+	payload := loadFixture(t, "pull_request")
+	enriched := WithEnriched(payload, map[string]interface{}{
+		EnrichPullRequest: map[string]interface{}{"mergeable": true, "mergeable_state": "clean"},
+		EnrichCodeOwners:  map[string]interface{}{"owners": []interface{}{"@octo/api"}},
+	})
+	if _, ok := payload[EnrichedKey]; ok {
+		t.Fatal("the payload shared by the receivers should not be changed")
+	}
+
+	sub := loadSubscribe(t, `{"events": ["pull_request"], "filters": {
+	"$[\"$enriched\"].pull_request.mergeable_state": {"positiveMatches": ["^clean$"]},
+	"$[\"$enriched\"].codeowners.owners": {"expr": "'@octo/api' in cur"}
+}}`)
+	if err := sub.Matches(enriched, GHWebhookEvent{Event: "pull_request"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.Matches(payload, GHWebhookEvent{Event: "pull_request"}); err == nil {
+		t.Fatal("the payload without enrichments should not match")
+	}
+
+	if err := ValidateEnrichments([]string{EnrichPullRequest, "mergeable"}); err == nil {
+		t.Fatal("unknown enrichment should be invalid")
+	}
+}
@@ -1,11 +1,35 @@
 package model
 
-import "gorm.io/gorm"
+import (
+	"fmt"
+	"gh-webhook/pkg/secret"
+	"gorm.io/gorm"
+	"strings"
+)
 
 // GitHub server configuration
 type GitHub struct {
 	gorm.Model
-	Web  string
-	API  string `gorm:"uniqueIndex"`
-	Name string `gorm:"uniqueIndex"`
+	Web   string
+	API   string `gorm:"uniqueIndex"`
+	Name  string `gorm:"uniqueIndex"`
+	Token string // optional secret reference of the API token, e.g. env:GITHUB_TOKEN, so no token is stored
+}
+
+// GetAPIURL the API base url, https if API has no scheme, e.g. https://api.github.com
+func (g *GitHub) GetAPIURL() string {
+	apiURL := strings.TrimSuffix(g.API, "/")
+	if !strings.Contains(apiURL, "://") {
+		apiURL = "https://" + apiURL
+	}
+	return apiURL
+}
+
+func (g *GitHub) IsValid() error {
+	if len(g.Token) > 0 {
+		if err := secret.ValidateReference(g.Token); err != nil {
+			return fmt.Errorf("token must be a secret reference: %v", err)
+		}
+	}
+	return nil
 }
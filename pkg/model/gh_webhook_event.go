@@ -9,11 +9,25 @@ type GHWebhookEvent struct {
 	Payload   string            // raw payload from GitHub
 	Event     string
 	Action    string
-	OrgRepo   string
+	OrgRepo   string // org/repo, or the org of the events without repository
+	Org       string `gorm:"index:idx_event_org_repo,priority:1"`
+	Repo      string `gorm:"index:idx_event_org_repo,priority:2"`
 	HookId    string
 	PayloadId string
 	GitHubId  uint   // github id
 	GitHub    GitHub // GitHub instance
+
+	Synthetic  bool `gorm:"index"` // created by gh-webhook instead of GitHub, e.g. by a schedule
+	ScheduleId uint // the schedule of the synthetic event
+}
+
+// SetOrgRepo set the org and the repository of the payload
+func (e *GHWebhookEvent) SetOrgRepo(payload map[string]interface{}) {
+	e.Org, e.Repo = ParseOrgRepo(payload)
+	e.OrgRepo = e.Org
+	if len(e.Repo) > 0 {
+		e.OrgRepo = e.Org + "/" + e.Repo
+	}
 }
 
 type Queue chan GHWebhookEvent
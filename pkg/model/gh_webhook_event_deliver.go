@@ -1,6 +1,21 @@
 package model
 
-import "gorm.io/gorm"
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	DeliverStatusDelivered = "delivered"
+	DeliverStatusFailed    = "failed"
+	DeliverStatusSkipped   = "skipped"   // no subscribe matched
+	DeliverStatusPaused    = "paused"    // parked while the receiver circuit is not closed
+	DeliverStatusHeld      = "held"      // held while the receiver is disabled or in maintenance
+	DeliverStatusThrottled = "throttled" // queued until the receiver rate limit has tokens
+	DeliverStatusDebounced = "debounced" // held for the subscribe debounce window
+	DeliverStatusCoalesced = "coalesced" // superseded by a later delivery of the same debounce key
+	DeliverStatusCancelled = "cancelled" // superseded by a later delivery of the same concurrency group
+)
 
 type GHWebhookEventReceiverDeliver struct {
 	gorm.Model
@@ -8,6 +23,15 @@ type GHWebhookEventReceiverDeliver struct {
 	GHWebhookEventDeliverID uint
 	GHWebhookEventDeliver   GHWebhookEventDeliver
 	Delivered               bool
+	Status                  string     `gorm:"index"`
+	ThrottleWait            Duration   // how long the delivery waited for the receiver rate limit
+	CoalesceKey             string     `gorm:"index"`
+	DueAt                   *time.Time // when the debounced delivery is sent
+	CoalescedIntoID         uint       // the delivery which was sent instead of the coalesced one
+	ConcurrencyGroup        string     `gorm:"index"`
+	CancelledByID           uint       // the delivery of the same concurrency group which cancelled this one
+	ReceiverRef             string     // what the receiver started for the delivery, e.g. jenkins queue items
+	PayloadSize             int        // bytes of the payload sent, after the transform of the receiver
 	Error                   string
 	Ack                     string
 }
@@ -0,0 +1,80 @@
+package model
+
+import (
+	"fmt"
+	"github.com/PaesslerAG/jsonpath"
+	"sort"
+)
+
+const maxMatchDepth = 8
+
+// GHWebhookMatch node of the filter tree, it matches when all of its filters, all of All, one of Any match and Not
+// doesn't match, a node without any of them is invalid
+type GHWebhookMatch struct {
+	All     []GHWebhookMatch          `json:"all,omitempty"`
+	Any     []GHWebhookMatch          `json:"any,omitempty"`
+	Not     *GHWebhookMatch           `json:"not,omitempty"`
+	Filters map[string]GHWebhookField `json:"filters,omitempty"`
+}
+
+func (m *GHWebhookMatch) Matches(payload map[string]interface{}, ghEvent GHWebhookEvent) error {
+	node, err := compileMatch(m)
+	if err != nil {
+		return err
+	}
+	return node.matches(payload, ghEvent, nil, "match")
+}
+
+func (m *GHWebhookMatch) IsValid() error {
+	return m.isValid(1)
+}
+
+func (m *GHWebhookMatch) isValid(depth int) error {
+	if depth > maxMatchDepth {
+		return fmt.Errorf("match is deeper than %d", maxMatchDepth)
+	}
+	if len(m.All) == 0 && len(m.Any) == 0 && m.Not == nil && len(m.Filters) == 0 {
+		return fmt.Errorf("empty match")
+	}
+	if err := validateFilters(m.Filters); err != nil {
+		return err
+	}
+	for i := range m.All {
+		if err := m.All[i].isValid(depth + 1); err != nil {
+			return fmt.Errorf("all[%d]: %v", i, err)
+		}
+	}
+	for i := range m.Any {
+		if err := m.Any[i].isValid(depth + 1); err != nil {
+			return fmt.Errorf("any[%d]: %v", i, err)
+		}
+	}
+	if m.Not != nil {
+		if err := m.Not.isValid(depth + 1); err != nil {
+			return fmt.Errorf("not: %v", err)
+		}
+	}
+	return nil
+}
+
+func validateFilters(filters map[string]GHWebhookField) error {
+	for _, k := range sortedKeys(filters) {
+		if _, err := jsonpath.New(k); err != nil {
+			return fmt.Errorf("invalid filter key %s: %v", k, err)
+		}
+		v := filters[k]
+		if err := v.IsValid(); err != nil {
+			return fmt.Errorf("invalid filter %s: %v", k, err)
+		}
+	}
+	return nil
+}
+
+func sortedKeys(filters map[string]GHWebhookField) []string {
+	keys := make([]string, 0, len(filters))
+	for k := range filters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
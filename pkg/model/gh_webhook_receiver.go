@@ -1,12 +1,173 @@
 package model
 
-import "gorm.io/gorm"
+import (
+	"fmt"
+	"github.com/expr-lang/expr"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
 
 type GHWebhookReceiver struct {
 	gorm.Model
 	Name           string
-	GitHubId       uint
+	GitHubId       uint `gorm:"index:idx_receiver_scope,priority:1"`
 	GitHub         GitHub
-	ReceiverConfig GHWebhookReceiverConfig `gorm:"serializer:json"` // credentials and etc.
+	ReceiverConfig GHWebhookReceiverConfig `gorm:"serializer:secret_json"` // credentials are encrypted
 	Subscribes     []GHWebHookSubscribe
+	CircuitBreaker CircuitBreakerConfig `gorm:"serializer:json"`
+	Circuit        CircuitState         `gorm:"embedded;embeddedPrefix:circuit_"`
+	RateLimit      RateLimitConfig      `gorm:"serializer:json"`
+
+	Enabled            *bool               `gorm:"default:true"`
+	MaintenanceWindows []MaintenanceWindow `gorm:"serializer:json"`
+	UnavailablePolicy  string              // skip or hold deliveries while disabled or in maintenance, default skip
+
+	// optional repository scope, globs of the org and the repository name, e.g. zhaojunlucky and gh-*, only the
+	// events of the matched repositories are delivered, the org is lower case so the receivers are selected by index
+	Org     string `gorm:"index:idx_receiver_scope,priority:2"`
+	Repo    string
+	OrgGlob bool `gorm:"index"` // whether the org is a glob, they are selected for every event
+
+	// GitHub API data attached to the payload under $enriched before matching, see Enrichments
+	Enrichments []string `gorm:"serializer:json"`
+
+	// the projection of the payload sent to the receiver, the whole payload is sent if nil
+	Transform *PayloadTransform `gorm:"serializer:json"`
+
+	// expr on the payload, e.g. repository.full_name + "#" + string(pull_request.number), deliveries of the same
+	// key are processed in ingestion order, empty if there is no ordering
+	OrderingKey string
+}
+
+func (r *GHWebhookReceiver) BeforeSave(*gorm.DB) error {
+	r.Org = strings.ToLower(r.Org)
+	r.OrgGlob = isScopeGlob(r.Org)
+	return nil
+}
+
+// ScopeCandidates the receivers of the GitHub server which may be scoped to the org, the repo scope and the org
+// globs are checked by MatchesScope
+func ScopeCandidates(db *gorm.DB, gitHubId uint, org string) *gorm.DB {
+	return db.Where("git_hub_id = ? AND (org IN ? OR org_glob = ?)", gitHubId, []string{"", strings.ToLower(org)},
+		true)
+}
+
+// MatchesScope whether the org and repo of the event match the scope of the receiver
+func (r *GHWebhookReceiver) MatchesScope(ghEvent GHWebhookEvent) bool {
+	return scopeMatches(r.Org, r.Repo, ghEvent.Org, ghEvent.Repo)
+}
+
+func (r *GHWebhookReceiver) IsScopeValid() error {
+	return validateScope(r.Org, r.Repo)
+}
+
+func (r *GHWebhookReceiver) IsEnabled() bool {
+	return r.Enabled == nil || *r.Enabled
+}
+
+func (r *GHWebhookReceiver) GetUnavailablePolicy() string {
+	if len(r.UnavailablePolicy) == 0 {
+		return UnavailableSkip
+	}
+	return r.UnavailablePolicy
+}
+
+// Unavailable the reason why the receiver can't be delivered now, empty if it's available
+func (r *GHWebhookReceiver) Unavailable(now time.Time) string {
+	if !r.IsEnabled() {
+		return "receiver is disabled"
+	}
+	for i, window := range r.MaintenanceWindows {
+		active, err := window.Active(now)
+		if err != nil {
+			log.Errorf("invalid maintenance window %d of receiver %d: %v", i, r.ID, err)
+			continue
+		}
+		if active {
+			return "receiver is in maintenance window"
+		}
+	}
+	return ""
+}
+
+// IsValid validate the circuit breaker, rate limit, availability, ordering key, scope, enrichments and transform
+func (r *GHWebhookReceiver) IsValid() error {
+	if err := r.CircuitBreaker.IsValid(); err != nil {
+		return err
+	}
+	if err := r.RateLimit.IsValid(); err != nil {
+		return err
+	}
+	if err := r.IsAvailabilityValid(); err != nil {
+		return err
+	}
+	if err := r.IsOrderingKeyValid(); err != nil {
+		return err
+	}
+	if err := r.IsScopeValid(); err != nil {
+		return err
+	}
+	if err := ValidateEnrichments(r.Enrichments); err != nil {
+		return err
+	}
+	return r.IsTransformValid()
+}
+
+// IsAvailabilityValid validate the maintenance windows and the unavailable policy
+func (r *GHWebhookReceiver) IsAvailabilityValid() error {
+	switch r.GetUnavailablePolicy() {
+	case UnavailableSkip, UnavailableHold:
+	default:
+		return fmt.Errorf("invalid unavailable policy %s", r.UnavailablePolicy)
+	}
+	for i, window := range r.MaintenanceWindows {
+		if err := window.IsValid(); err != nil {
+			return fmt.Errorf("invalid maintenance window %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// TransformPayload the event with the payload transformed for the receiver
+func (r *GHWebhookReceiver) TransformPayload(event GHWebhookEvent) (GHWebhookEvent, error) {
+	if r.Transform == nil {
+		return event, nil
+	}
+	transform, err := GetReceiverTransform(r)
+	if err != nil {
+		return event, fmt.Errorf("invalid transform: %v", err)
+	}
+	payload, err := transform.Apply(event.Payload)
+	if err != nil {
+		return event, fmt.Errorf("failed to transform payload: %v", err)
+	}
+	event.Payload = payload
+	return event, nil
+}
+
+func (r *GHWebhookReceiver) IsTransformValid() error {
+	if r.Transform == nil {
+		return nil
+	}
+	if err := r.Transform.IsValid(); err != nil {
+		return fmt.Errorf("invalid transform: %v", err)
+	}
+	return nil
+}
+
+// GetOrderingKey evaluate the ordering key on the payload
+func (r *GHWebhookReceiver) GetOrderingKey(payload map[string]interface{}) (string, error) {
+	return evalKey("ordering key", r.OrderingKey, payload)
+}
+
+func (r *GHWebhookReceiver) IsOrderingKeyValid() error {
+	if len(r.OrderingKey) == 0 {
+		return nil
+	}
+	if _, err := expr.Compile(r.OrderingKey); err != nil {
+		return fmt.Errorf("invalid ordering key: %v", err)
+	}
+	return nil
 }
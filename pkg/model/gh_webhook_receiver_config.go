@@ -1,44 +1,414 @@
 package model
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
+	"gh-webhook/pkg/secret"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
-	BasicAuth = "basic"
-	TokenAuth = "token"
-	NoneAuth  = "none"
-	HTTP      = "http"
-	Jenkins   = "jenkins"
+	BasicAuth  = "basic"
+	TokenAuth  = "token"
+	OAuth2Auth = "oauth2"
+	NoneAuth   = "none"
+	HTTP       = "http"
+	Jenkins    = "jenkins"
 )
 
+// ReceiverConfig typed configuration of a receiver type
+type ReceiverConfig interface {
+	GetType() string
+	IsValid() error
+	GetHTTPConfig() *HTTPReceiverConfig
+	Secrets() map[string]*string // json path to secret field
+}
+
+// ReceiverAuth credentials used to call a receiver
+type ReceiverAuth struct {
+	Type         string   `json:"type"`                   // none, basic, token or oauth2
+	Username     string   `json:"username,omitempty"`     // basic auth
+	Password     string   `json:"password,omitempty"`     // basic auth
+	Header       string   `json:"header,omitempty"`       // token auth header name
+	Token        string   `json:"token,omitempty"`        // token auth header value
+	TokenURL     string   `json:"tokenUrl,omitempty"`     // oauth2 client credentials token endpoint
+	ClientID     string   `json:"clientId,omitempty"`     // oauth2
+	ClientSecret string   `json:"clientSecret,omitempty"` // oauth2
+	Scopes       []string `json:"scopes,omitempty"`       // oauth2, optional
+	Audience     string   `json:"audience,omitempty"`     // oauth2, optional
+}
+
+func (a *ReceiverAuth) IsValid() error {
+	switch a.Type {
+	case NoneAuth:
+		return nil
+	case BasicAuth:
+		if a.Username == "" || a.Password == "" {
+			return fmt.Errorf("username or password is empty")
+		}
+	case TokenAuth:
+		if a.Header == "" || a.Token == "" {
+			return fmt.Errorf("token header or token value is empty")
+		}
+	case OAuth2Auth:
+		if !isHTTPURL(a.TokenURL) {
+			return fmt.Errorf("invalid token url %s", a.TokenURL)
+		}
+		if a.ClientID == "" || a.ClientSecret == "" {
+			return fmt.Errorf("client id or client secret is empty")
+		}
+	default:
+		return fmt.Errorf("invalid auth type %s", a.Type)
+	}
+	return nil
+}
+
+// ReceiverTLS tls settings to call a receiver, certificates and key are PEM encoded
+type ReceiverTLS struct {
+	CACert             string `json:"caCert,omitempty"`     // CA bundle to verify the receiver, system CAs if empty
+	ClientCert         string `json:"clientCert,omitempty"` // client certificate for mTLS
+	ClientKey          string `json:"clientKey,omitempty"`  // client key for mTLS
+	ServerName         string `json:"serverName,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"` // lab use only
+}
+
+func (t *ReceiverTLS) IsValid() error {
+	if len(t.CACert) > 0 && !x509.NewCertPool().AppendCertsFromPEM([]byte(t.CACert)) {
+		return fmt.Errorf("invalid CA certificate")
+	}
+	if (len(t.ClientCert) == 0) != (len(t.ClientKey) == 0) {
+		return fmt.Errorf("client certificate and client key must be set together")
+	}
+	// the key can only be verified when it's not a secret reference
+	if len(t.ClientCert) > 0 && !secret.IsReference(t.ClientKey) {
+		if _, err := tls.X509KeyPair([]byte(t.ClientCert), []byte(secret.Unescape(t.ClientKey))); err != nil {
+			return fmt.Errorf("invalid client certificate: %v", err)
+		}
+	}
+	return nil
+}
+
+const (
+	DefaultConnectTimeout  = 10 * time.Second
+	DefaultResponseTimeout = 30 * time.Second
+)
+
+var DefaultSuccessStatus = []string{"200", "201"}
+
+var supportedMethods = []string{http.MethodPost, http.MethodPut, http.MethodPatch}
+
+// HTTPReceiverConfig config of http receiver
+type HTTPReceiverConfig struct {
+	URL             string            `json:"url"`
+	Auth            ReceiverAuth      `json:"auth"`
+	TLS             ReceiverTLS       `json:"tls"`
+	Method          string            `json:"method,omitempty"`          // POST, PUT or PATCH, POST if empty
+	Headers         map[string]string `json:"headers,omitempty"`         // static extra headers
+	Proxy           string            `json:"proxy,omitempty"`           // http(s) proxy, proxy env vars if empty
+	ConnectTimeout  Duration          `json:"connectTimeout,omitempty"`  // DefaultConnectTimeout if empty
+	ResponseTimeout Duration          `json:"responseTimeout,omitempty"` // DefaultResponseTimeout if empty
+	SuccessStatus   []string          `json:"successStatus,omitempty"`   // e.g. 200, 2xx or 200-299
+	CancelURL       string            `json:"cancelUrl,omitempty"`       // notified when a delivery is cancelled
+}
+
+func (c *HTTPReceiverConfig) GetMethod() string {
+	if len(c.Method) == 0 {
+		return http.MethodPost
+	}
+	return c.Method
+}
+
+func (c *HTTPReceiverConfig) GetConnectTimeout() time.Duration {
+	if c.ConnectTimeout <= 0 {
+		return DefaultConnectTimeout
+	}
+	return c.ConnectTimeout.Duration()
+}
+
+func (c *HTTPReceiverConfig) GetResponseTimeout() time.Duration {
+	if c.ResponseTimeout <= 0 {
+		return DefaultResponseTimeout
+	}
+	return c.ResponseTimeout.Duration()
+}
+
+// IsSuccessStatus whether the receiver accepts the delivery by the response status code
+func (c *HTTPReceiverConfig) IsSuccessStatus(code int) bool {
+	patterns := c.SuccessStatus
+	if len(patterns) == 0 {
+		patterns = DefaultSuccessStatus
+	}
+	for _, pattern := range patterns {
+		low, high, err := parseStatusPattern(pattern)
+		if err == nil && code >= low && code <= high {
+			return true
+		}
+	}
+	return false
+}
+
+// parseStatusPattern parse status code pattern 200, 2xx or 200-299 as a range
+func parseStatusPattern(pattern string) (low int, high int, err error) {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if len(pattern) == 3 && strings.HasSuffix(pattern, "xx") {
+		low, err = strconv.Atoi(pattern[:1])
+		low, high = low*100, low*100+99
+	} else if from, to, found := strings.Cut(pattern, "-"); found {
+		if low, err = strconv.Atoi(strings.TrimSpace(from)); err == nil {
+			high, err = strconv.Atoi(strings.TrimSpace(to))
+		}
+	} else {
+		low, err = strconv.Atoi(pattern)
+		high = low
+	}
+	if err != nil || low < 100 || high > 599 || low > high {
+		return 0, 0, fmt.Errorf("invalid success status %s", pattern)
+	}
+	return low, high, nil
+}
+
+func (c *HTTPReceiverConfig) GetType() string {
+	return HTTP
+}
+
+func (c *HTTPReceiverConfig) GetHTTPConfig() *HTTPReceiverConfig {
+	return c
+}
+
+func (c *HTTPReceiverConfig) Secrets() map[string]*string {
+	return map[string]*string{
+		"auth.password":     &c.Auth.Password,
+		"auth.token":        &c.Auth.Token,
+		"auth.clientSecret": &c.Auth.ClientSecret,
+		"tls.clientKey":     &c.TLS.ClientKey,
+	}
+}
+
+func (c *HTTPReceiverConfig) IsValid() error {
+	if !isHTTPURL(c.URL) {
+		return fmt.Errorf("invalid url %s", c.URL)
+	}
+
+	for _, value := range c.Secrets() {
+		if !secret.IsReference(*value) {
+			continue
+		}
+		if err := secret.ValidateReference(*value); err != nil {
+			return err
+		}
+	}
+
+	if len(c.Method) > 0 && !slices.Contains(supportedMethods, c.Method) {
+		return fmt.Errorf("unsupported method %s", c.Method)
+	}
+	for name := range c.Headers {
+		if len(name) == 0 || strings.ContainsAny(name, " \t\r\n:") {
+			return fmt.Errorf("invalid header %s", name)
+		}
+	}
+	if len(c.Proxy) > 0 && !isHTTPURL(c.Proxy) {
+		return fmt.Errorf("invalid proxy %s", c.Proxy)
+	}
+	if len(c.CancelURL) > 0 && !isHTTPURL(c.CancelURL) {
+		return fmt.Errorf("invalid cancel url %s", c.CancelURL)
+	}
+	if c.ConnectTimeout < 0 || c.ResponseTimeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	for _, pattern := range c.SuccessStatus {
+		if _, _, err := parseStatusPattern(pattern); err != nil {
+			return err
+		}
+	}
+
+	if err := c.TLS.IsValid(); err != nil {
+		return err
+	}
+	return c.Auth.IsValid()
+}
+
+func isHTTPURL(str string) bool {
+	u, err := url.Parse(str)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// JenkinsReceiverConfig config of jenkins receiver, the payload is sent as build parameter
+type JenkinsReceiverConfig struct {
+	HTTPReceiverConfig
+	Parameter string `json:"parameter"`
+	ServerURL string `json:"serverUrl,omitempty"` // jenkins root url to cancel builds, scheme and host of url if empty
+}
+
+// GetServerURL the jenkins root url without the trailing slash
+func (c *JenkinsReceiverConfig) GetServerURL() string {
+	if len(c.ServerURL) > 0 {
+		return strings.TrimSuffix(c.ServerURL, "/")
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%s://%s", u.Scheme, u.Host)
+}
+
+func (c *JenkinsReceiverConfig) GetType() string {
+	return Jenkins
+}
+
+func (c *JenkinsReceiverConfig) IsValid() error {
+	if strings.TrimSpace(c.Parameter) == "" {
+		return fmt.Errorf("invalid parameter")
+	}
+	if len(c.ServerURL) > 0 && !isHTTPURL(c.ServerURL) {
+		return fmt.Errorf("invalid server url %s", c.ServerURL)
+	}
+	return c.HTTPReceiverConfig.IsValid()
+}
+
+// NewReceiverConfig create an empty config for the receiver type
+func NewReceiverConfig(receiverType string) (ReceiverConfig, error) {
+	switch receiverType {
+	case HTTP:
+		return &HTTPReceiverConfig{}, nil
+	case Jenkins:
+		return &JenkinsReceiverConfig{}, nil
+	default:
+		return nil, fmt.Errorf("invalid receiver type %s", receiverType)
+	}
+}
+
+// GHWebhookReceiverConfig receiver config stored as json, type is the discriminator of Config
 type GHWebhookReceiverConfig struct {
-	Type      string // http or jenkins
-	URL       string
-	Auth      string
-	Username  string
-	Password  string
-	Parameter string // optional
+	Type   string
+	Config ReceiverConfig
 }
 
-func (c *GHWebhookReceiverConfig) IsValid() error {
-	if c.Auth != BasicAuth && c.Type != TokenAuth && c.Auth != NoneAuth {
-		return fmt.Errorf("invalid auth type %s", c.Type)
+func NewGHWebhookReceiverConfig(config ReceiverConfig) GHWebhookReceiverConfig {
+	return GHWebhookReceiverConfig{
+		Type:   config.GetType(),
+		Config: config,
 	}
+}
 
-	if c.Type != HTTP && c.Type != Jenkins {
+func (c *GHWebhookReceiverConfig) IsValid() error {
+	if c.Config == nil {
 		return fmt.Errorf("invalid receiver type %s", c.Type)
 	}
+	if c.Config.GetType() != c.Type {
+		return fmt.Errorf("receiver type %s doesn't match config type %s", c.Type, c.Config.GetType())
+	}
+	return c.Config.IsValid()
+}
 
-	if c.Type == Jenkins && strings.Trim(c.Parameter, " ") == "" {
-		return fmt.Errorf("invalid parameter")
+// GetHTTPConfig the http settings shared by all receiver types
+func (c *GHWebhookReceiverConfig) GetHTTPConfig() *HTTPReceiverConfig {
+	if c.Config == nil {
+		return nil
 	}
+	return c.Config.GetHTTPConfig()
+}
 
-	if c.Auth != NoneAuth && (c.Username == "" || c.Password == "") {
-		return fmt.Errorf("username/token header or password/token value is empty")
+func (c *GHWebhookReceiverConfig) Secrets() map[string]*string {
+	if c.Config == nil {
+		return map[string]*string{}
 	}
+	return c.Config.Secrets()
+}
 
+// Clone deep copy the config, e.g. to resolve secret references without changing the model
+func (c *GHWebhookReceiverConfig) Clone() (GHWebhookReceiverConfig, error) {
+	cloned := GHWebhookReceiverConfig{}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return cloned, err
+	}
+	err = json.Unmarshal(data, &cloned)
+	return cloned, err
+}
+
+// Redacted the config without secrets, <field>Set indicates whether the secret is set and
+// <field>Ref is the secret reference, e.g. env:JENKINS_TOKEN
+func (c *GHWebhookReceiverConfig) Redacted() (map[string]interface{}, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	for path, value := range c.Secrets() {
+		keys := strings.Split(path, ".")
+		parent := fields
+		for _, key := range keys[:len(keys)-1] {
+			child, ok := parent[key].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				parent[key] = child
+			}
+			parent = child
+		}
+		name := keys[len(keys)-1]
+		delete(parent, name)
+		parent[name+"Set"] = len(*value) > 0
+		if secret.IsReference(*value) {
+			parent[name+"Ref"] = *value
+		}
+	}
+	return fields, nil
+}
+
+func (c *GHWebhookReceiverConfig) GetJenkinsConfig() *JenkinsReceiverConfig {
+	if cfg, ok := c.Config.(*JenkinsReceiverConfig); ok {
+		return cfg
+	}
+	return nil
+}
+
+func (c GHWebhookReceiverConfig) MarshalJSON() ([]byte, error) {
+	fields := map[string]json.RawMessage{}
+	if c.Config != nil {
+		data, err := json.Marshal(c.Config)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(data, &fields); err != nil {
+			return nil, err
+		}
+	}
+	receiverType, err := json.Marshal(c.Type)
+	if err != nil {
+		return nil, err
+	}
+	fields["type"] = receiverType
+	return json.Marshal(fields)
+}
+
+func (c *GHWebhookReceiverConfig) UnmarshalJSON(data []byte) error {
+	var discriminator struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &discriminator); err != nil {
+		return err
+	}
+
+	config, err := NewReceiverConfig(discriminator.Type)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, config); err != nil {
+		return err
+	}
+	c.Type = discriminator.Type
+	c.Config = config
 	return nil
 }
 
@@ -46,20 +416,53 @@ func (c *GHWebhookReceiverConfig) IsValid() error {
 For jenkins receiver,
 ---
 {
+	"type": "jenkins",
 	"url": "http://127.0.0.1:8080/job/aa/build",
-	"auth": "basic or token"
-	"username": "username",
-	"password": "password or token",
+	"auth": {
+		"type": "basic",
+		"username": "username",
+		"password": "password"
+	},
 	"parameter": "payload"
 }
 
 For http receiver,
 ---
 {
-	"url": "http://127.0.0.1:8080/job/aa/build",
-	"auth": "basic or token"
-	"username": "username or token header name",
-	"password": "password or token",
+	"type": "http",
+	"url": "http://127.0.0.1:8080/hook",
+	"auth": {
+		"type": "token",
+		"header": "X-Token",
+		"token": "token"
+	}
+}
+
+For http receiver behind an oauth2 gateway with mTLS,
+---
+{
+	"type": "http",
+	"url": "https://127.0.0.1:8443/hook",
+	"auth": {
+		"type": "oauth2",
+		"tokenUrl": "https://127.0.0.1:8443/oauth/token",
+		"clientId": "gh-webhook",
+		"clientSecret": "vault:kv/ci#client-secret",
+		"scopes": ["hook.write"]
+	},
+	"tls": {
+		"caCert": "-----BEGIN CERTIFICATE-----...",
+		"clientCert": "-----BEGIN CERTIFICATE-----...",
+		"clientKey": "file:/run/secrets/client.key"
+	},
+	"method": "PUT",
+	"headers": {
+		"X-Source": "gh-webhook"
+	},
+	"proxy": "http://proxy.local:3128",
+	"connectTimeout": "5s",
+	"responseTimeout": "1m",
+	"successStatus": ["2xx", "409"]
 }
 
 */
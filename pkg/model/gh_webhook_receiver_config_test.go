@@ -1,14 +1,20 @@
 package model
 
 import (
+	"encoding/json"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestGHWebhookReceiverConfig_InValidAuth(t *testing.T) {
-	cfg := GHWebhookReceiverConfig{
-		Auth: "sd",
-	}
+	cfg := NewGHWebhookReceiverConfig(&HTTPReceiverConfig{
+		URL:  "http://localhost:8080",
+		Auth: ReceiverAuth{Type: "sd"},
+	})
 
 	err := cfg.IsValid()
 
@@ -20,7 +26,6 @@ func TestGHWebhookReceiverConfig_InValidAuth(t *testing.T) {
 
 func TestGHWebhookReceiverConfig_InValidType(t *testing.T) {
 	cfg := GHWebhookReceiverConfig{
-		Auth: NoneAuth,
 		Type: "sdf",
 	}
 
@@ -29,14 +34,34 @@ func TestGHWebhookReceiverConfig_InValidType(t *testing.T) {
 	if err == nil || !strings.Contains(err.Error(), "invalid receiver type") {
 		t.Error("expected error")
 	}
+
+	_, err = NewReceiverConfig("sdf")
+	if err == nil || !strings.Contains(err.Error(), "invalid receiver type") {
+		t.Error("expected error")
+	}
+}
+
+func TestGHWebhookReceiverConfig_InValidURL(t *testing.T) {
+	cfg := NewGHWebhookReceiverConfig(&HTTPReceiverConfig{
+		URL:  "localhost:8080",
+		Auth: ReceiverAuth{Type: NoneAuth},
+	})
+
+	err := cfg.IsValid()
+
+	if err == nil || !strings.Contains(err.Error(), "invalid url") {
+		t.Error("expected error")
+	}
 }
 
 func TestGHWebhookReceiverConfig_InValidParameter(t *testing.T) {
-	cfg := GHWebhookReceiverConfig{
-		Auth:      NoneAuth,
-		Type:      Jenkins,
+	cfg := NewGHWebhookReceiverConfig(&JenkinsReceiverConfig{
+		HTTPReceiverConfig: HTTPReceiverConfig{
+			URL:  "http://localhost:8080",
+			Auth: ReceiverAuth{Type: NoneAuth},
+		},
 		Parameter: " ",
-	}
+	})
 
 	err := cfg.IsValid()
 
@@ -46,30 +71,225 @@ func TestGHWebhookReceiverConfig_InValidParameter(t *testing.T) {
 }
 
 func TestGHWebhookReceiverConfig_InValidUser(t *testing.T) {
-	cfg := GHWebhookReceiverConfig{
-		Auth:      BasicAuth,
-		Type:      Jenkins,
+	cfg := NewGHWebhookReceiverConfig(&JenkinsReceiverConfig{
+		HTTPReceiverConfig: HTTPReceiverConfig{
+			URL:  "http://localhost:8080",
+			Auth: ReceiverAuth{Type: BasicAuth},
+		},
 		Parameter: "payload",
+	})
+
+	err := cfg.IsValid()
+
+	if err == nil || !strings.Contains(err.Error(), "username or password is empty") {
+		t.Error("expected error")
 	}
+}
+
+func TestGHWebhookReceiverConfig_InValidToken(t *testing.T) {
+	cfg := NewGHWebhookReceiverConfig(&HTTPReceiverConfig{
+		URL:  "http://localhost:8080",
+		Auth: ReceiverAuth{Type: TokenAuth, Header: "X-Token"},
+	})
 
 	err := cfg.IsValid()
 
-	if err == nil || !strings.Contains(err.Error(), "username/token header or password/token value is empty") {
+	if err == nil || !strings.Contains(err.Error(), "token header or token value is empty") {
 		t.Error("expected error")
 	}
 }
 
 func TestGHWebhookReceiverConfig_IsValid(t *testing.T) {
-	cfg := GHWebhookReceiverConfig{
-		Auth:      BasicAuth,
-		Type:      Jenkins,
+	cfg := NewGHWebhookReceiverConfig(&JenkinsReceiverConfig{
+		HTTPReceiverConfig: HTTPReceiverConfig{
+			URL:  "http://localhost:8080",
+			Auth: ReceiverAuth{Type: BasicAuth, Username: "aaa", Password: "sdsd"},
+		},
 		Parameter: "payload",
-		Username:  "aaa",
-		Password:  "sdsd",
-	}
+	})
 
 	err := cfg.IsValid()
 	if err != nil {
 		t.Fatal(err)
 	}
 }
+
+func TestGHWebhookReceiverConfig_JSON(t *testing.T) {
+	cfg := NewGHWebhookReceiverConfig(&JenkinsReceiverConfig{
+		HTTPReceiverConfig: HTTPReceiverConfig{
+			URL:  "http://localhost:8080",
+			Auth: ReceiverAuth{Type: TokenAuth, Header: "X-Token", Token: "sdsd"},
+		},
+		Parameter: "payload",
+	})
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fields map[string]interface{}
+	if err = json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["type"] != Jenkins || fields["parameter"] != "payload" || fields["url"] != "http://localhost:8080" {
+		t.Fatalf("unexpected json %s", string(data))
+	}
+
+	var parsed GHWebhookReceiverConfig
+	if err = json.Unmarshal(data, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	jenkinsConfig := parsed.GetJenkinsConfig()
+	if jenkinsConfig == nil {
+		t.Fatal("should be jenkins config")
+	}
+	if jenkinsConfig.Parameter != "payload" || parsed.GetHTTPConfig().Auth.Token != "sdsd" {
+		t.Fatalf("unexpected config %+v", jenkinsConfig)
+	}
+}
+
+func TestLegacyReceiverConfig_Convert(t *testing.T) {
+	var legacy legacyReceiverConfig
+	err := json.Unmarshal([]byte(`{"Type":"http","URL":"http://localhost:8080","Auth":"token",
+"Username":"X-Token","Password":"sdsd","Parameter":""}`), &legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := legacy.convert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.IsValid(); err != nil {
+		t.Fatal(err)
+	}
+	auth := cfg.GetHTTPConfig().Auth
+	if cfg.Type != HTTP || auth.Header != "X-Token" || auth.Token != "sdsd" {
+		t.Fatalf("unexpected config %+v", cfg.Config)
+	}
+
+	err = json.Unmarshal([]byte(`{"type":"http","url":"http://localhost:8080","auth":{"type":"none"}}`), &legacy)
+	if err == nil {
+		t.Fatal("typed config should not be parsed as legacy config")
+	}
+}
+
+func Test_migrateReceiverConfig(t *testing.T) {
+	setTestKeyring(t, "k1")
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "gh_pr.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Init(db); err != nil {
+		t.Fatal(err)
+	}
+	typed := NewGHWebhookReceiverConfig(&HTTPReceiverConfig{URL: "http://localhost:8082",
+		Auth: ReceiverAuth{Type: NoneAuth}})
+	if err = db.Create(&GHWebhookReceiver{Name: "typed", GitHubId: 1, ReceiverConfig: typed}).Error; err != nil {
+		t.Fatal(err)
+	}
+	insert := func(name string, config string) {
+		err := db.Exec("INSERT INTO gh_webhook_receivers (name, git_hub_id, receiver_config) VALUES (?, 1, ?)", name,
+			config).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	insert("no auth", `{"Type":"http","URL":"http://localhost:8080","Auth":"","Username":"","Password":""}`)
+	insert("token", `{"Type":"jenkins","URL":"http://localhost:8081","Auth":"token","Username":"X-Token",
+"Password":"sdsd","Parameter":"payload"}`)
+
+	if err = migrateReceiverConfig(db); err != nil {
+		t.Fatal(err)
+	}
+	var receivers []GHWebhookReceiver
+	if err = db.Order("id").Find(&receivers).Error; err != nil {
+		t.Fatalf("all receivers should be loaded after the migration: %v", err)
+	}
+	if len(receivers) != 3 || receivers[0].ReceiverConfig.GetHTTPConfig().URL != "http://localhost:8082" {
+		t.Fatalf("the typed config should be kept, got %+v", receivers)
+	}
+	if auth := receivers[1].ReceiverConfig.GetHTTPConfig().Auth; auth.Type != NoneAuth {
+		t.Fatalf("the legacy config without auth should have none auth, got %+v", auth)
+	}
+	if receivers[2].ReceiverConfig.Type != Jenkins || receivers[2].ReceiverConfig.GetHTTPConfig().Auth.Token != "sdsd" {
+		t.Fatalf("unexpected config %+v", receivers[2].ReceiverConfig.Config)
+	}
+
+	// the legacy secrets are encrypted at rest
+	var stored string
+	db.Table("gh_webhook_receivers").Select("receiver_config").Where("id = ?", receivers[2].ID).Scan(&stored)
+	if !strings.Contains(stored, `"token":"enc:v1:`) || strings.Contains(stored, "sdsd") {
+		t.Fatalf("the migrated token should be encrypted, got %s", stored)
+	}
+
+	// the legacy config which can't be converted fails the migration instead of breaking all receivers
+	insert("unknown", `{"Type":"ftp","URL":"ftp://localhost","Auth":""}`)
+	if err = migrateReceiverConfig(db); err == nil || !strings.Contains(err.Error(), "receiver 4") {
+		t.Fatalf("the migration should fail on receiver 4, got %v", err)
+	}
+}
+
+func TestHTTPReceiverConfig_IsSuccessStatus(t *testing.T) {
+	cfg := HTTPReceiverConfig{}
+	if !cfg.IsSuccessStatus(201) || cfg.IsSuccessStatus(204) {
+		t.Fatal("default success status should be 200 and 201")
+	}
+
+	cfg.SuccessStatus = []string{"2xx", "409", "300-302"}
+	for _, code := range []int{200, 204, 299, 409, 301} {
+		if !cfg.IsSuccessStatus(code) {
+			t.Fatalf("%d should be success", code)
+		}
+	}
+	for _, code := range []int{400, 303, 500} {
+		if cfg.IsSuccessStatus(code) {
+			t.Fatalf("%d should not be success", code)
+		}
+	}
+}
+
+func TestHTTPReceiverConfig_InValidSettings(t *testing.T) {
+	tests := map[string]func(cfg *HTTPReceiverConfig){
+		"unsupported method":     func(cfg *HTTPReceiverConfig) { cfg.Method = "GET" },
+		"invalid header":         func(cfg *HTTPReceiverConfig) { cfg.Headers = map[string]string{"X Bad": "1"} },
+		"invalid proxy":          func(cfg *HTTPReceiverConfig) { cfg.Proxy = "proxy:3128" },
+		"timeout must not be":    func(cfg *HTTPReceiverConfig) { cfg.ConnectTimeout = -1 },
+		"invalid success status": func(cfg *HTTPReceiverConfig) { cfg.SuccessStatus = []string{"299-200"} },
+	}
+	for expected, update := range tests {
+		cfg := HTTPReceiverConfig{
+			URL:  "http://localhost:8080",
+			Auth: ReceiverAuth{Type: NoneAuth},
+		}
+		update(&cfg)
+		err := cfg.IsValid()
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error %s, got %v", expected, err)
+		}
+	}
+}
+
+func TestHTTPReceiverConfig_Timeout(t *testing.T) {
+	var cfg HTTPReceiverConfig
+	err := json.Unmarshal([]byte(`{"url":"http://localhost:8080","auth":{"type":"none"},"responseTimeout":"1m"}`), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.GetResponseTimeout() != time.Minute || cfg.GetConnectTimeout() != DefaultConnectTimeout {
+		t.Fatal("unexpected timeouts")
+	}
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"responseTimeout":"1m0s"`) {
+		t.Fatalf("unexpected json %s", string(data))
+	}
+
+	if err = json.Unmarshal([]byte(`{"responseTimeout":60}`), &cfg); err == nil {
+		t.Fatal("number timeout should fail")
+	}
+}
@@ -0,0 +1,175 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"gh-webhook/pkg/cron"
+	"gorm.io/gorm"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ScheduleEvent the default event of the synthetic events
+const ScheduleEvent = "schedule"
+
+// GHWebhookSchedule create a synthetic event on every activation of the cron, e.g. nightly builds, the event is
+// matched and delivered like the events from GitHub
+type GHWebhookSchedule struct {
+	gorm.Model
+	Name     string
+	Cron     string // e.g. 0 2 * * * for every night
+	Timezone string // location of the cron, default UTC
+	GitHubId uint
+	GitHub   GitHub
+	Event    string // the event of the synthetic events, default schedule
+	Repo     string // org/repo of the payload, e.g. zhaojunlucky/gh-webhook
+	Ref      string // the ref of the payload, e.g. refs/heads/main
+
+	// json object template merged into the payload, toJson quotes the values, e.g.
+	// {"scan": {{toJson .Repo}}, "at": {{toJson .Time}}}, see ScheduleData
+	Payload string
+
+	Enabled     *bool      `gorm:"default:true"`
+	NextRunAt   *time.Time `gorm:"index"` // nil if the cron has no activation anymore
+	LastRunAt   *time.Time
+	LastEventId uint
+}
+
+// scheduleFuncs the functions of the payload template, toJson renders a value as json so a value with quotes
+// doesn't break the payload
+var scheduleFuncs = template.FuncMap{
+	"toJson": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// ScheduleData the data of the payload template
+type ScheduleData struct {
+	Name string
+	Org  string
+	Repo string // org/repo
+	Ref  string
+	Time string // the activation time, RFC 3339
+}
+
+func (s *GHWebhookSchedule) IsEnabled() bool {
+	return s.Enabled == nil || *s.Enabled
+}
+
+func (s *GHWebhookSchedule) GetEvent() string {
+	if len(s.Event) == 0 {
+		return ScheduleEvent
+	}
+	return s.Event
+}
+
+func (s *GHWebhookSchedule) IsValid() error {
+	if _, err := cron.Parse(s.Cron); err != nil {
+		return err
+	}
+	if _, err := loadLocation(s.Timezone); err != nil {
+		return err
+	}
+	if org, repo, found := strings.Cut(s.Repo, "/"); len(s.Repo) > 0 &&
+		(!found || len(org) == 0 || len(repo) == 0 || strings.Contains(repo, "/")) {
+		return fmt.Errorf("invalid repo %s, it should be org/repo", s.Repo)
+	}
+	if len(s.Ref) > 0 && !strings.HasPrefix(s.Ref, branchPrefix) && !strings.HasPrefix(s.Ref, tagPrefix) {
+		return fmt.Errorf("invalid ref %s, it should start with %s or %s", s.Ref, branchPrefix, tagPrefix)
+	}
+	if _, err := s.payload(time.Now()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Next the first activation after t, nil if there is none
+func (s *GHWebhookSchedule) Next(t time.Time) (*time.Time, error) {
+	schedule, err := cron.Parse(s.Cron)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := loadLocation(s.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	next := schedule.Next(t.In(loc))
+	if next.IsZero() {
+		return nil, nil
+	}
+	return &next, nil
+}
+
+// NewEvent the synthetic event of the activation at t
+func (s *GHWebhookSchedule) NewEvent(t time.Time) (GHWebhookEvent, error) {
+	payload, err := s.payload(t)
+	if err != nil {
+		return GHWebhookEvent{}, err
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return GHWebhookEvent{}, err
+	}
+	event := GHWebhookEvent{
+		HookMeta:   map[string]string{"X-GitHub-Event": s.GetEvent()},
+		Payload:    string(data),
+		Event:      s.GetEvent(),
+		PayloadId:  fmt.Sprintf("schedule-%d-%d", s.ID, t.Unix()),
+		GitHubId:   s.GitHubId,
+		Synthetic:  true,
+		ScheduleId: s.ID,
+	}
+	if action, ok := payload["action"].(string); ok {
+		event.Action = action
+	}
+	event.SetOrgRepo(payload)
+	return event, nil
+}
+
+// payload the payload of the activation at t, the repository and ref like a push event so the scope and the branch
+// filters of the subscribes apply, the rendered template is merged into it
+func (s *GHWebhookSchedule) payload(t time.Time) (map[string]interface{}, error) {
+	payload := map[string]interface{}{
+		"schedule": s.Cron,
+		"time":     t.Format(time.RFC3339),
+	}
+	org, repo, _ := strings.Cut(s.Repo, "/")
+	if len(s.Repo) > 0 {
+		payload["repository"] = map[string]interface{}{
+			"full_name": s.Repo,
+			"name":      repo,
+			"owner":     map[string]interface{}{"login": org},
+		}
+		payload["organization"] = map[string]interface{}{"login": org}
+	}
+	if len(s.Ref) > 0 {
+		payload["ref"] = s.Ref
+	}
+	if len(s.Payload) == 0 {
+		return payload, nil
+	}
+
+	tmpl, err := template.New("payload").Option("missingkey=error").Funcs(scheduleFuncs).Parse(s.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload template: %v", err)
+	}
+	var rendered strings.Builder
+	data := ScheduleData{Name: s.Name, Org: org, Repo: s.Repo, Ref: s.Ref, Time: t.Format(time.RFC3339)}
+	if err = tmpl.Execute(&rendered, data); err != nil {
+		return nil, fmt.Errorf("invalid payload template: %v", err)
+	}
+	if !json.Valid([]byte(rendered.String())) {
+		return nil, fmt.Errorf("payload template should render a json object, quote the values with toJson: %s",
+			rendered.String())
+	}
+	var extra map[string]interface{}
+	if err = json.Unmarshal([]byte(rendered.String()), &extra); err != nil {
+		return nil, fmt.Errorf("payload template should render a json object: %v", err)
+	}
+	for k, v := range extra {
+		payload[k] = v
+	}
+	return payload, nil
+}
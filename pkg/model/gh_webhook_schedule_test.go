@@ -0,0 +1,67 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestGHWebhookSchedule_NewEvent(t *testing.T) {
+	schedule := GHWebhookSchedule{
+		Name:     `nightly "main"`,
+		Cron:     "0 2 * * *",
+		Timezone: "America/New_York",
+		GitHubId: 1,
+		Repo:     "zhaojunlucky/gh-webhook",
+		Ref:      "refs/heads/main",
+		Payload:  `{"action": "nightly", "build": "{{.Repo}}@{{.Ref}}", "name": {{toJson .Name}}}`,
+	}
+	schedule.ID = 3
+	if err := schedule.IsValid(); err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := schedule.Next(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 3, 2, 7, 0, 0, 0, time.UTC); next == nil || !next.Equal(want) {
+		t.Fatalf("next run should be %s, got %v", want, next)
+	}
+
+	event, err := schedule.NewEvent(*next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Event != ScheduleEvent || event.Action != "nightly" || !event.Synthetic || event.ScheduleId != 3 ||
+		event.OrgRepo != "zhaojunlucky/gh-webhook" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	var payload map[string]interface{}
+	if err = json.Unmarshal([]byte(event.Payload), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload["build"] != "zhaojunlucky/gh-webhook@refs/heads/main" || payload["ref"] != "refs/heads/main" ||
+		payload["name"] != `nightly "main"` {
+		t.Fatalf("unexpected payload %v", payload)
+	}
+	if kind, name := eventRef(event, payload); kind != RefBranch || name != "main" {
+		t.Fatalf("the branch filters should apply to the synthetic event, got %s %s", kind, name)
+	}
+}
+
+func TestGHWebhookSchedule_IsValid(t *testing.T) {
+	for _, schedule := range []GHWebhookSchedule{
+		{Cron: "0 2 * *"},
+		{Cron: "0 2 * * *", Timezone: "Mars/Olympus"},
+		{Cron: "0 2 * * *", Repo: "gh-webhook"},
+		{Cron: "0 2 * * *", Ref: "main"},
+		{Cron: "0 2 * * *", Payload: `{"build": "{{.Branch}}"}`},
+		{Cron: "0 2 * * *", Payload: `["{{.Repo}}"]`},
+		{Cron: "0 2 * * *", Name: `nightly "main"`, Payload: `{"name": "{{.Name}}"}`},
+	} {
+		if err := schedule.IsValid(); err == nil {
+			t.Fatalf("schedule %+v should be invalid", schedule)
+		}
+	}
+}
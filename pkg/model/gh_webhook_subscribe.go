@@ -2,20 +2,29 @@ package model
 
 import (
 	"fmt"
-	"github.com/PaesslerAG/jsonpath"
 	"github.com/expr-lang/expr"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
+	"path"
 	"reflect"
 	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 )
 
+const (
+	MissingFail  = "fail"  // the filter doesn't match when the key is missing or null
+	MissingMatch = "match" // the filter matches when the key is missing or null
+)
+
+// GHWebhookField filter of the value at the key, all of its conditions must match
 type GHWebhookField struct {
-	PositiveMatches []string // regex, null or empty will map all
-	NegativeMatches []string // regex, null or empty will skip
-	Child           map[string]GHWebhookField
-	Expr            string // https://expr-lang.org/docs/configuration, empty will match all
+	PositiveMatches []string                  `json:"positiveMatches,omitempty"` // regex, one of them must match if any
+	NegativeMatches []string                  `json:"negativeMatches,omitempty"` // regex, none of them may match
+	Child           map[string]GHWebhookField `json:"child,omitempty"`           // filters of the object fields
+	Expr            string                    `json:"expr,omitempty"`            // https://expr-lang.org/docs/configuration
+	Missing         string                    `json:"missing,omitempty"`         // fail or match, default fail
 }
 
 type GHWebhookFieldVal struct {
@@ -64,93 +73,37 @@ func (v *GHWebhookFieldVal) IsBool() bool {
 	return v.Type.Kind() == reflect.Bool
 }
 
-func (f *GHWebhookField) Matches(payload map[string]interface{}, ghEvent GHWebhookEvent, key string) error {
-	curObj, err := jsonpath.Get(key, payload)
+func (f *GHWebhookField) GetMissing() string {
+	if len(f.Missing) == 0 {
+		return MissingFail
+	}
+	return f.Missing
+}
 
+func (f *GHWebhookField) Matches(payload map[string]interface{}, ghEvent GHWebhookEvent, key string) error {
+	field, err := compileField(f, key)
 	if err != nil {
 		return err
 	}
+	return field.matches(payload, ghEvent, nil, key)
+}
 
-	if curObj == nil {
-		return fmt.Errorf("key %s not found in payload", key)
-	}
-
-	fieldVal := GHWebhookFieldVal{Value: curObj, Type: reflect.TypeOf(curObj)}
-
-	if len(f.NegativeMatches) >= 0 || len(f.PositiveMatches) >= 0 {
-		if !fieldVal.IsNumeric() && !fieldVal.IsString() && !fieldVal.IsBool() {
-			return fmt.Errorf("unsupported type %T for NegativeMatches, PositiveMatches, "+
-				"only string, number or bool is supported", curObj)
-		}
-
-		str := fieldVal.GetAsString()
-
-		for _, negativeMatch := range f.NegativeMatches {
-			matched, err := regexp.MatchString(negativeMatch, str)
-			if err != nil {
-				log.Errorf("failed to do negative match %s with %s", negativeMatch, str)
-				return err
-			}
-			if matched {
-				log.Infof("event[%d] negative matched %s with %s", ghEvent.ID, negativeMatch, str)
-				return fmt.Errorf("event[%d] negative matched %s with %s", ghEvent.ID, negativeMatch, str)
-			}
-		}
-
-		for _, positiveMatch := range f.PositiveMatches {
-			matched, err := regexp.MatchString(positiveMatch, str)
-			if err != nil {
-				log.Errorf("failed to do positive match %s with %s", positiveMatch, str)
-				return err
-			}
-			if matched {
-				log.Infof("event[%d] positive matched %s with %s", ghEvent.ID, positiveMatch, str)
-				break
-			}
-		}
-	}
-
-	if len(f.Expr) > 0 {
-		program, err := expr.Compile(f.Expr, expr.AsBool())
-		if err != nil {
-			log.Errorf("failed to compile expr %s: %v", f.Expr, err)
-			return err
-		}
-		env := map[string]interface{}{
-			"cur":  curObj,
-			"root": payload,
-		}
-		output, err := expr.Run(program, env)
-		if err != nil {
-			log.Errorf("event[%d] failed to run expr %s: %v", ghEvent.ID, f.Expr, err)
-			return err
-		}
-
-		switch reflect.TypeOf(output).Kind() {
-		case reflect.Bool:
-			if !output.(bool) {
-				log.Infof("event[%d] failed to match expr", ghEvent.ID)
-				return fmt.Errorf("event[%d] failed to match expr", ghEvent.ID)
-			}
-		default:
-			log.Errorf("invalid return type %v for expr %s", reflect.TypeOf(output), f.Expr)
-			return fmt.Errorf("invalid return type %v for expr %s", reflect.TypeOf(output), f.Expr)
-		}
-
+// scalarValues the value as strings to match the regex, every element of an array of scalars is a value
+func scalarValues(obj interface{}) ([]string, error) {
+	items, ok := obj.([]interface{})
+	if !ok {
+		items = []interface{}{obj}
 	}
-
-	if len(f.Child) > 0 {
-		if !fieldVal.IsMap() {
-			return fmt.Errorf("unsupported type %T for Child", curObj)
-		}
-		for k, v := range f.Child {
-			if err = v.Matches(payload, ghEvent, fmt.Sprintf("%s.%s", key, k)); err != nil {
-				return err
-			}
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		fieldVal := GHWebhookFieldVal{Value: item, Type: reflect.TypeOf(item)}
+		if item == nil || (!fieldVal.IsNumeric() && !fieldVal.IsString() && !fieldVal.IsBool()) {
+			return nil, fmt.Errorf("unsupported type %T for NegativeMatches, PositiveMatches, "+
+				"only string, number, bool or array of them is supported", obj)
 		}
+		values = append(values, fieldVal.GetAsString())
 	}
-
-	return nil
+	return values, nil
 }
 
 func (f *GHWebhookField) IsValid() error {
@@ -158,6 +111,17 @@ func (f *GHWebhookField) IsValid() error {
 		return fmt.Errorf("no filter")
 	}
 
+	for _, pattern := range append(append([]string{}, f.PositiveMatches...), f.NegativeMatches...) {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid regex %s: %v", pattern, err)
+		}
+	}
+	switch f.GetMissing() {
+	case MissingFail, MissingMatch:
+	default:
+		return fmt.Errorf("invalid missing %s, it should be fail or match", f.Missing)
+	}
+
 	if len(f.Expr) > 0 {
 		_, err := expr.Compile(f.Expr, expr.AsBool())
 		if err != nil {
@@ -165,7 +129,7 @@ func (f *GHWebhookField) IsValid() error {
 			return err
 		}
 	}
-	return nil
+	return validateFilters(f.Child)
 
 }
 
@@ -173,70 +137,179 @@ type GHWebHookSubscribe struct {
 	gorm.Model
 	GHWebhookReceiverID uint
 	GHWebhookReceiver   GHWebhookReceiver
-	Event               string // mandatory
-
-	Filters map[string]GHWebhookField `gorm:"serializer:json"`
+	Events              []string `gorm:"serializer:json"` // mandatory, event names or globs, e.g. pull_request* or *
+	Actions             []string `gorm:"serializer:json"` // optional, the event action must be one of them
+	Org                 string   // optional, glob of the org, e.g. zhaojunlucky
+	Repo                string   // optional, glob of the repository name, e.g. gh-*
+	Branches            []string `gorm:"serializer:json"` // optional, globs, the branch must match, see eventRef
+	BranchesIgnore      []string `gorm:"serializer:json"` // optional, globs, the branch must not match
+	Tags                []string `gorm:"serializer:json"` // optional, globs, the tag must match
+	TagsIgnore          []string `gorm:"serializer:json"` // optional, globs, the tag must not match
+	Paths               []string `gorm:"serializer:json"` // optional, globs, one of the changed files must match
+	PathsIgnore         []string `gorm:"serializer:json"` // optional, globs, the matched changed files are ignored
+
+	Labels             *LabelFilter `gorm:"serializer:json"` // optional, labels of the pull request or issue
+	Senders            *LoginFilter `gorm:"serializer:json"` // optional, login of the sender
+	Authors            *LoginFilter `gorm:"serializer:json"` // optional, login of the author, see eventAuthor
+	AuthorAssociations []string     `gorm:"serializer:json"` // optional, e.g. MEMBER, OWNER or COLLABORATOR
+	Teams              []string     `gorm:"serializer:json"` // optional, org/team-slug, the author must be in one
+
+	Filters     map[string]GHWebhookField `gorm:"serializer:json"` // all of them must match, empty matches all
+	Match       *GHWebhookMatch           `gorm:"serializer:json"` // optional, all/any/not of filters
+	Debounce    *DebounceConfig           `gorm:"serializer:json"` // optional, deliver only the latest event per key
+	Concurrency *ConcurrencyConfig        `gorm:"serializer:json"` // optional, cancel the previous delivery per group
 }
 
+// Matches nil if the event matches the subscribe, otherwise the error tells why it doesn't match, it compiles the
+// subscribe, use the SubscribeMatcher to match many events
 func (s *GHWebHookSubscribe) Matches(payload map[string]interface{}, ghEvent GHWebhookEvent) error {
-	if s.Event != ghEvent.Event {
-		log.Infof("event[%d] %s doesn't match %s", ghEvent.ID, ghEvent.Event, s.Event)
-		return fmt.Errorf("event[%d] %s doesn't match %s", ghEvent.ID, ghEvent.Event, s.Event)
+	matcher, err := CompileSubscribe(s)
+	if err != nil {
+		return err
 	}
+	return matcher.Matches(payload, ghEvent, nil)
+}
 
-	for k, v := range s.Filters {
-		err := v.Matches(payload, ghEvent, k)
-		if err != nil {
-			log.Warningf("filter %s doesn't match", k)
-
-		} else {
-			log.Infof("filter %s matches", k)
-			return nil
-		}
+// MatchesEvent check the event name and action, it's cheap, so it runs before the filters
+func (s *GHWebHookSubscribe) MatchesEvent(ghEvent GHWebhookEvent) error {
+	if !eventMatches(s.Events, ghEvent.Event) {
+		return fmt.Errorf("event[%d] %s doesn't match %v", ghEvent.ID, ghEvent.Event, s.Events)
 	}
-	return fmt.Errorf("event[%d] %s doesn't match %s", ghEvent.ID, ghEvent.Event, s.Event)
+	if len(s.Actions) > 0 && !slices.Contains(s.Actions, ghEvent.Action) {
+		return fmt.Errorf("event[%d] action %s isn't one of %v", ghEvent.ID, ghEvent.Action, s.Actions)
+	}
+	return nil
+}
+
+// eventMatches whether one of the event globs matches the event
+func eventMatches(patterns []string, event string) bool {
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		matched, _ := path.Match(pattern, event)
+		return matched
+	})
 }
 
 func (s *GHWebHookSubscribe) IsValid() error {
-	if len(s.Event) == 0 {
+	if len(s.Events) == 0 {
 		return fmt.Errorf("event is required")
 	}
+	for _, pattern := range s.Events {
+		if _, err := path.Match(pattern, ""); err != nil || len(pattern) == 0 {
+			return fmt.Errorf("invalid event %s", pattern)
+		}
+	}
+	for _, action := range s.Actions {
+		if len(strings.TrimSpace(action)) == 0 {
+			return fmt.Errorf("action must not be empty")
+		}
+	}
+	if err := validateScope(s.Org, s.Repo); err != nil {
+		return err
+	}
+	for _, branches := range [][]string{s.Branches, s.BranchesIgnore} {
+		if _, err := compileRefPatterns(branches, branchPrefix); err != nil {
+			return err
+		}
+	}
+	for _, tags := range [][]string{s.Tags, s.TagsIgnore} {
+		if _, err := compileRefPatterns(tags, tagPrefix); err != nil {
+			return err
+		}
+	}
+	for _, pattern := range append(append([]string{}, s.Paths...), s.PathsIgnore...) {
+		if _, err := compileGlob(pattern); err != nil {
+			return err
+		}
+	}
+	if s.Labels != nil {
+		if err := s.Labels.IsValid(); err != nil {
+			return err
+		}
+	}
+	for _, logins := range []*LoginFilter{s.Senders, s.Authors} {
+		if logins != nil {
+			if err := logins.IsValid(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := validateAuthorAssociations(s.AuthorAssociations); err != nil {
+		return err
+	}
+	for _, team := range s.Teams {
+		if _, _, err := parseTeam(team); err != nil {
+			return err
+		}
+	}
 
-	for k, v := range s.Filters {
-		if err := v.IsValid(); err != nil {
-			return fmt.Errorf("invalid filter %s: %v", k, err)
+	if err := validateFilters(s.Filters); err != nil {
+		return err
+	}
+	if s.Match != nil {
+		if err := s.Match.IsValid(); err != nil {
+			return fmt.Errorf("invalid match: %v", err)
+		}
+	}
+	if s.Debounce != nil {
+		if err := s.Debounce.IsValid(); err != nil {
+			return err
 		}
 	}
+	if s.Concurrency != nil {
+		return s.Concurrency.IsValid()
+	}
 	return nil
 }
 
 /*
-example
+example, the filter keys are jsonpath of the payload, a filter matches when all of its conditions match:
+  - positiveMatches one of the regex matches the value, or an element of the array value
+  - negativeMatches none of the regex matches the value, or any element of the array value
+  - expr the expr returns true, cur is the value and root is the payload
+  - child all filters of the object fields match
+  - missing fail (default) or match when the key is missing or null
+
+the subscribe matches when one of the events matches, the action is one of the actions if any, all filters match
+and the match tree matches, a node of the match tree matches when all of its filters, all of "all", one of "any"
+match and "not" doesn't match. The events are globs, e.g. "pull_request*" or "*".
+
+the org and repo scope the subscribe to the repositories, they are globs of the names like the scope of the receiver.
+
+the branches and tags are globs of the branch or tag of the event, refs/heads/ and refs/tags/ are optional and a
+pattern starting with ! excludes the refs matched by the patterns before it. When only branches or only tags are
+set, the events of the other kind don't match, e.g. a tag push doesn't match a subscribe with branches only.
+
+the labels, senders, authors and author associations are checked before the filters, the teams and the paths need
+the GitHub API, so they are checked last. The paths are globs of the changed files, ** matches any directories.
 {
-	"event": "pull_request",
+	"events": ["pull_request", "pull_request_review*"],
+	"actions": ["opened", "synchronize", "reopened"],
+	"org": "zhaojunlucky",
+	"repo": "gh-*",
+	"branches": ["main", "release/**", "!release/legacy"],
+	"labels": {"include": ["ci"], "exclude": ["skip-ci"]},
+	"senders": {"deny": ["dependabot[bot]"]},
+	"authorAssociations": ["OWNER", "MEMBER", "COLLABORATOR"],
+	"teams": ["zhaojunlucky/maintainers"],
+	"paths": ["services/api/**"],
+	"pathsIgnore": ["services/api/docs/**"],
 	"filters": {
-		"action": {
-			"positiveMatches": ["opened", "synchronize"],
-			"negativeMatches": ["closed"]
-		},
-		"organization": {
-			"positiveMatches": ["zhaojunlucky"],
-			"negativeMatches": []
-		},
-		"repository": {
-			"positiveMatches": ["exia"],
-			"negativeMatches": []
-		},
-		"pull_request": {
+		"$.pull_request": {
 			"child": {
 				"draft": {
-					Expr: "root.pull_request.draft == false"
-				},
-				"mergeable": {
-					Expr: "cur.mergeable == true"
+					"expr": "cur == false"
 				}
 			}
 		}
+	},
+	"match": {
+		"any": [
+			{"filters": {"$.repository.name": {"positiveMatches": ["^exia$"]}}},
+			{"filters": {"$.organization.login": {"positiveMatches": ["^zhaojunlucky$"]}}}
+		],
+		"not": {
+			"filters": {"$.pull_request.labels[*].name": {"positiveMatches": ["^skip-ci$"]}}
+		}
 	}
 }
 */
@@ -0,0 +1,43 @@
+package model
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// GHWebhookSubscribeMatch match decision of a subscribe for an event of its receiver, the subscribes after the
+// matched one aren't evaluated
+type GHWebhookSubscribeMatch struct {
+	gorm.Model
+	GHWebhookEventID                uint   `gorm:"index"`
+	GHWebhookReceiverID             uint   `gorm:"index"`
+	GHWebHookSubscribeID            uint   `gorm:"index"`
+	GHWebhookEventReceiverDeliverID uint   // the delivery of the event to the receiver
+	OrgRepo                         string `gorm:"index"`
+	Event                           string
+	Action                          string
+	Matched                         bool
+	FailedCondition                 string   // the condition which doesn't match, e.g. filters[$.action]
+	Reason                          string   // why it doesn't match
+	EvalTime                        Duration // how long the evaluation took
+}
+
+// NewGHWebhookSubscribeMatch the match decision, err is the result of the subscribe matcher
+func NewGHWebhookSubscribeMatch(sub GHWebHookSubscribe, event GHWebhookEvent, err error,
+	evalTime time.Duration) GHWebhookSubscribeMatch {
+	match := GHWebhookSubscribeMatch{
+		GHWebhookEventID:     event.ID,
+		GHWebhookReceiverID:  sub.GHWebhookReceiverID,
+		GHWebHookSubscribeID: sub.ID,
+		OrgRepo:              event.OrgRepo,
+		Event:                event.Event,
+		Action:               event.Action,
+		Matched:              err == nil,
+		EvalTime:             Duration(evalTime),
+	}
+	if err != nil {
+		match.FailedCondition = FailedCondition(err)
+		match.Reason = err.Error()
+	}
+	return match
+}
@@ -1,8 +1,13 @@
 package model
 
 import (
+	"encoding/json"
+	"gh-webhook/pkg/fixture"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"path/filepath"
 	"reflect"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -125,3 +130,223 @@ func Test_GHWebhookField_Matches_Expr(t *testing.T) {
 	}
 
 }
+
+// loadFixture the bundled payload of the event
+func loadFixture(t testing.TB, name string) map[string]interface{} {
+	payload, err := fixture.Load(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+func Test_GHWebHookSubscribe_Matches(t *testing.T) {
+	tests := []struct {
+		name      string
+		fixture   string
+		subscribe string
+		matches   bool
+	}{
+		{"no filter matches all", "push", `{"events": ["push"]}`, true},
+		{"event must be the same", "push", `{"events": ["pull_request"]}`, false},
+		{"one of the events matches", "issue_comment", `{"events": ["pull_request", "issue_comment"]}`, true},
+		{"wildcard event", "pull_request", `{"events": ["pull_request*"]}`, true},
+		{"wildcard event doesn't match", "issue_comment", `{"events": ["pull_request*"]}`, false},
+		{"all events", "push", `{"events": ["*"]}`, true},
+		{"action in actions", "pull_request", `{"events": ["pull_request"], "actions": ["opened", "reopened"]}`, true},
+		{"action not in actions", "pull_request", `{"events": ["pull_request"], "actions": ["closed"]}`, false},
+		{"event without action", "push", `{"events": ["*"], "actions": ["opened"]}`, false},
+		{"positive match", "push",
+			`{"events": ["push"], "filters": {"$.ref": {"positiveMatches": ["^refs/heads/main$"]}}}`, true},
+		{"positive match is required", "push",
+			`{"events": ["push"], "filters": {"$.ref": {"positiveMatches": ["^refs/tags/", "^refs/heads/release/"]}}}`, false},
+		{"negative match", "push",
+			`{"events": ["push"], "filters": {"$.head_commit.message": {"negativeMatches": ["\\[skip ci\\]"]}}}`, false},
+		{"all filters must match", "pull_request",
+			`{"events": ["pull_request"], "filters": {"$.action": {"positiveMatches": ["^opened$"]},
+"$.pull_request.base.ref": {"positiveMatches": ["^develop$"]}}}`, false},
+		{"filters and child", "pull_request",
+			`{"events": ["pull_request"], "filters": {"$.action": {"positiveMatches": ["^(opened|synchronize)$"]},
+"$.pull_request": {"child": {"draft": {"expr": "cur == false"}, "base.ref": {"positiveMatches": ["^main$"]}}}}}`, true},
+		{"array element matches", "pull_request",
+			`{"events": ["pull_request"], "filters": {"$.pull_request.labels[*].name": {"positiveMatches": ["^bug$"]}}}`, true},
+		{"array element negative matches", "pull_request",
+			`{"events": ["pull_request"], "filters": {"$.pull_request.labels[*].name": {"negativeMatches": ["^area/"]}}}`, false},
+		{"missing key fails by default", "pull_request",
+			`{"events": ["pull_request"], "filters": {"$.pull_request.merged_by.login": {"positiveMatches": [".+"]}}}`, false},
+		{"missing key matches", "pull_request",
+			`{"events": ["pull_request"], "filters": {"$.pull_request.merged_by.login": {"positiveMatches": [".+"],
+"missing": "match"}}}`, true},
+		{"unknown key fails", "push",
+			`{"events": ["push"], "filters": {"$.pull_request.number": {"expr": "cur > 0"}}}`, false},
+		{"expr with root", "issue_comment",
+			`{"events": ["issue_comment"], "filters": {"$.comment.body": {"expr":
+"cur startsWith '/retest' && root.comment.author_association in ['MEMBER', 'OWNER']"}}}`, true},
+		{"comment on pull request", "issue_comment",
+			`{"events": ["issue_comment"], "filters": {"$.issue.pull_request.url": {"positiveMatches": ["/pulls/"]}}}`, true},
+		{"any matches one", "pull_request",
+			`{"events": ["pull_request"], "match": {"any": [
+{"filters": {"$.repository.name": {"positiveMatches": ["^veda$"]}}},
+{"filters": {"$.organization.login": {"positiveMatches": ["^zhaojunlucky$"]}}}]}}`, true},
+		{"any matches none", "pull_request",
+			`{"events": ["pull_request"], "match": {"any": [
+{"filters": {"$.repository.name": {"positiveMatches": ["^veda$"]}}},
+{"filters": {"$.sender.login": {"positiveMatches": ["^zhaojunlucky$"]}}}]}}`, false},
+		{"not without the label", "pull_request",
+			`{"events": ["pull_request"], "match": {"not": {"filters": {"$.pull_request.labels[*].name": {"positiveMatches": ["^skip-ci$"]}}}}}`, true},
+		{"not with the label", "pull_request",
+			`{"events": ["pull_request"], "match": {"not": {"filters": {"$.pull_request.labels[*].name": {"positiveMatches": ["^bug$"]}}}}}`, false},
+		{"not on missing key", "push",
+			`{"events": ["push"], "match": {"not": {"filters": {"$.pull_request.draft": {"expr": "cur == true"}}}}}`, true},
+		{"nested all and any", "issue_comment",
+			`{"events": ["issue_comment"], "filters": {"$.action": {"positiveMatches": ["^created$"]}}, "match": {"all": [
+{"filters": {"$.comment.body": {"positiveMatches": ["^/retest"]}}},
+{"any": [{"filters": {"$.comment.author_association": {"positiveMatches": ["^(OWNER|MEMBER)$"]}}},
+{"filters": {"$.sender.login": {"positiveMatches": ["^octocat$"]}}}]}]}}`, true},
+		{"filters and match must both match", "issue_comment",
+			`{"events": ["issue_comment"], "filters": {"$.action": {"positiveMatches": ["^deleted$"]}},
+"match": {"filters": {"$.comment.body": {"positiveMatches": ["^/retest"]}}}}`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var sub GHWebHookSubscribe
+			if err := json.Unmarshal([]byte(test.subscribe), &sub); err != nil {
+				t.Fatal(err)
+			}
+			if err := sub.IsValid(); err != nil {
+				t.Fatal(err)
+			}
+			payload := loadFixture(t, test.fixture)
+			ghEvent := GHWebhookEvent{Event: test.fixture}
+			if action, ok := payload["action"].(string); ok {
+				ghEvent.Action = action
+			}
+			err := sub.Matches(payload, ghEvent)
+			if (err == nil) != test.matches {
+				t.Fatalf("expected matches %v, got %v", test.matches, err)
+			}
+		})
+	}
+}
+
+func Test_GHWebHookSubscribe_InValid(t *testing.T) {
+	tests := map[string]string{
+		"event is required":  `{"actions": ["opened"]}`,
+		"invalid event":      `{"events": ["pull_request["]}`,
+		"action must not be": `{"events": ["pull_request"], "actions": [""]}`,
+		"invalid regex":      `{"events": ["push"], "filters": {"$.ref": {"positiveMatches": ["(main"]}}}`,
+		"invalid missing":    `{"events": ["push"], "filters": {"$.ref": {"expr": "cur != ''", "missing": "skip"}}}`,
+		"invalid filter key": `{"events": ["push"], "filters": {"$.ref[": {"expr": "cur != ''"}}}`,
+		"empty match":        `{"events": ["push"], "match": {"any": [{}]}}`,
+		"no filter":          `{"events": ["push"], "match": {"not": {"filters": {"$.ref": {}}}}}`,
+	}
+	for expected, subscribe := range tests {
+		var sub GHWebHookSubscribe
+		if err := json.Unmarshal([]byte(subscribe), &sub); err != nil {
+			t.Fatal(err)
+		}
+		err := sub.IsValid()
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error %s, got %v", expected, err)
+		}
+	}
+}
+
+func Test_legacyFilterMatch(t *testing.T) {
+	filters := map[string]GHWebhookField{
+		"$.action":          {PositiveMatches: []string{"^closed$"}},
+		"$.repository.name": {PositiveMatches: []string{"^exia$"}},
+	}
+	sub := GHWebHookSubscribe{Events: []string{"pull_request"}, Filters: filters}
+	payload := loadFixture(t, "pull_request")
+	if err := sub.Matches(payload, GHWebhookEvent{Event: "pull_request"}); err == nil {
+		t.Fatal("all filters should match")
+	}
+
+	// one legacy filter matching was enough
+	sub.Match = legacyFilterMatch(filters)
+	sub.Filters = nil
+	if err := sub.IsValid(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.Matches(payload, GHWebhookEvent{Event: "pull_request"}); err != nil {
+		t.Fatal(err)
+	}
+	if legacyFilterMatch(map[string]GHWebhookField{"$.action": filters["$.action"]}) != nil {
+		t.Fatal("single filter means the same")
+	}
+
+	// no event matched the legacy subscribe without filters
+	sub.Match = legacyFilterMatch(nil)
+	if err := sub.IsValid(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.Matches(payload, GHWebhookEvent{Event: "pull_request"}); err == nil {
+		t.Fatal("the legacy subscribe without filters should match nothing")
+	}
+}
+
+func Test_migrateSubscribeFilters(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "gh_pr.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the subscribes table before the match column
+	err = db.Exec("CREATE TABLE `gh_web_hook_subscribes` (`id` integer PRIMARY KEY AUTOINCREMENT, " +
+		"`created_at` datetime, `updated_at` datetime, `deleted_at` datetime, `gh_webhook_receiver_id` integer, " +
+		"`event` text, `filters` text)").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Exec("INSERT INTO `gh_web_hook_subscribes` (`event`, `filters`) VALUES (?, ?), (?, ?), (?, ?), (?, NULL)",
+		"pull_request", `{"$.action":{"PositiveMatches":["^closed$"]},"$.repository.name":{"PositiveMatches":["^exia$"]}}`,
+		"push", `{"$.ref":{"PositiveMatches":["^refs/heads/main$"]}}`, "pull_request", `{}`, "pull_request")
+
+	// the filters which can't be parsed fail the migration, it runs again once they are fixed
+	db.Exec("INSERT INTO `gh_web_hook_subscribes` (`event`, `filters`) VALUES (?, ?)", "push", `{"$.ref":`)
+	if err = Init(db); err == nil || !strings.Contains(err.Error(), "subscribe 5") {
+		t.Fatalf("the migration should fail on subscribe 5, got %v", err)
+	}
+	if db.Migrator().HasColumn(&GHWebHookSubscribe{}, "Match") {
+		t.Fatal("the match column should not be added when the legacy filters can't be migrated")
+	}
+	db.Exec("DELETE FROM `gh_web_hook_subscribes` WHERE id = 5")
+
+	if err = Init(db); err != nil {
+		t.Fatal(err)
+	}
+	var subs []GHWebHookSubscribe
+	db.Order("id").Find(&subs)
+	if len(subs) != 4 || len(subs[0].Filters) != 0 || subs[0].Match == nil || len(subs[0].Match.Any) != 2 {
+		t.Fatalf("legacy filters should be moved to any match, got %+v", subs)
+	}
+	if !slices.Equal(subs[0].Events, []string{"pull_request"}) || !slices.Equal(subs[1].Events, []string{"push"}) {
+		t.Fatalf("legacy event should be moved to events, got %+v", subs)
+	}
+	if db.Migrator().HasColumn(&GHWebHookSubscribe{}, "event") {
+		t.Fatal("the legacy event column should be dropped once the events are migrated")
+	}
+	if subs[1].Match != nil || subs[1].Filters["$.ref"].PositiveMatches[0] != "^refs/heads/main$" {
+		t.Fatalf("single filter should be kept, got %+v", subs[1])
+	}
+	if err = subs[0].Matches(loadFixture(t, "pull_request"), GHWebhookEvent{Event: "pull_request"}); err != nil {
+		t.Fatal(err)
+	}
+	// the legacy subscribes without filters still match nothing
+	for _, sub := range subs[2:] {
+		if sub.Match == nil || sub.Matches(loadFixture(t, "pull_request"), GHWebhookEvent{Event: "pull_request"}) == nil {
+			t.Fatalf("subscribe %d without filters should match nothing, got %+v", sub.ID, sub)
+		}
+	}
+
+	// migrated once
+	db.Model(&subs[1]).Update("filters", `{"$.action":{"positiveMatches":["^opened$"]},"$.number":{"expr":"cur > 0"}}`)
+	if err = Init(db); err != nil {
+		t.Fatal(err)
+	}
+	db.First(&subs[1], subs[1].ID)
+	if subs[1].Match != nil || len(subs[1].Filters) != 2 {
+		t.Fatalf("subscribes should be migrated once, got %+v", subs[1])
+	}
+}
@@ -0,0 +1,42 @@
+package model
+
+import "testing"
+
+func Test_compileGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		matches bool
+	}{
+		{"docs/**", "docs/README.md", true},
+		{"docs/**", "docs/api/v1/index.md", true},
+		{"docs/**", "pkg/docs/README.md", false},
+		{"docs/*", "docs/api/index.md", false},
+		{"**/*.md", "README.md", true},
+		{"**/*.md", "docs/api/index.md", true},
+		{"**/*.md", "docs/api/index.go", false},
+		{"pkg/**/http*.go", "pkg/core/http.go", true},
+		{"pkg/**/http*.go", "pkg/http_client.go", true},
+		{"cmd/gh_?r/*.go", "cmd/gh_pr/main.go", true},
+		{"*.[ch]", "main.c", true},
+		{"*.[!ch]", "main.c", false},
+		{"go.mod", "go.mod", true},
+		{"go.mod", "go.sum", false},
+		{"a+b/(x).txt", "a+b/(x).txt", true},
+	}
+	for _, test := range tests {
+		re, err := compileGlob(test.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if re.MatchString(test.path) != test.matches {
+			t.Errorf("%s should match %s: %v", test.pattern, test.path, test.matches)
+		}
+	}
+
+	for _, invalid := range []string{"", "docs/[a-", "[z-a]"} {
+		if _, err := compileGlob(invalid); err == nil {
+			t.Errorf("%s should be invalid", invalid)
+		}
+	}
+}
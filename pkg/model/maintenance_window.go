@@ -0,0 +1,78 @@
+package model
+
+import (
+	"fmt"
+	"gh-webhook/pkg/cron"
+	"time"
+)
+
+const (
+	UnavailableSkip = "skip" // deliveries are skipped while the receiver is disabled or in maintenance
+	UnavailableHold = "hold" // deliveries are held and delivered in order once the receiver is available
+)
+
+// MaintenanceWindow the receiver is unavailable for the duration from every cron activation, or between start and end
+type MaintenanceWindow struct {
+	Cron     string     `json:"cron,omitempty"`     // e.g. 0 22 * * fri
+	Duration Duration   `json:"duration,omitempty"` // length of the cron window
+	Timezone string     `json:"timezone,omitempty"` // location of the cron, default UTC
+	Start    *time.Time `json:"start,omitempty"`
+	End      *time.Time `json:"end,omitempty"`
+}
+
+func (w *MaintenanceWindow) IsValid() error {
+	if len(w.Cron) > 0 {
+		if w.Start != nil || w.End != nil {
+			return fmt.Errorf("maintenance window has either cron or start and end")
+		}
+		if _, err := cron.Parse(w.Cron); err != nil {
+			return err
+		}
+		if w.Duration <= 0 {
+			return fmt.Errorf("maintenance window duration must be positive")
+		}
+		_, err := w.location()
+		return err
+	}
+	if w.Start == nil || w.End == nil {
+		return fmt.Errorf("maintenance window requires cron or start and end")
+	}
+	if !w.End.After(*w.Start) {
+		return fmt.Errorf("maintenance window end must be after start")
+	}
+	return nil
+}
+
+func (w *MaintenanceWindow) location() (*time.Location, error) {
+	return loadLocation(w.Timezone)
+}
+
+// loadLocation the location of the cron, UTC if the timezone is empty
+func loadLocation(timezone string) (*time.Location, error) {
+	if len(timezone) == 0 {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %s: %v", timezone, err)
+	}
+	return loc, nil
+}
+
+// Active whether now is in the maintenance window
+func (w *MaintenanceWindow) Active(now time.Time) (bool, error) {
+	if len(w.Cron) == 0 {
+		return w.Start != nil && w.End != nil && !now.Before(*w.Start) && now.Before(*w.End), nil
+	}
+	schedule, err := cron.Parse(w.Cron)
+	if err != nil {
+		return false, err
+	}
+	loc, err := w.location()
+	if err != nil {
+		return false, err
+	}
+	// the first activation after now-duration is the only one whose window can still cover now
+	start := schedule.Next(now.In(loc).Add(-w.Duration.Duration()))
+	return !start.IsZero() && !start.After(now), nil
+}
@@ -0,0 +1,79 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaintenanceWindow_Active(t *testing.T) {
+	window := MaintenanceWindow{Cron: "0 22 * * fri", Duration: Duration(2 * time.Hour), Timezone: "America/New_York"}
+	if err := window.IsValid(); err != nil {
+		t.Fatal(err)
+	}
+	loc, _ := time.LoadLocation("America/New_York")
+	tests := map[time.Time]bool{
+		time.Date(2024, 3, 15, 21, 59, 0, 0, loc):    false,
+		time.Date(2024, 3, 15, 22, 0, 0, 0, loc):     true,
+		time.Date(2024, 3, 15, 23, 59, 0, 0, loc):    true,
+		time.Date(2024, 3, 16, 0, 0, 0, 0, loc):      false,
+		time.Date(2024, 3, 16, 3, 0, 0, 0, time.UTC): true,
+	}
+	for now, expected := range tests {
+		active, err := window.Active(now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if active != expected {
+			t.Errorf("%v should be active %v", now, expected)
+		}
+	}
+
+	start := time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	window = MaintenanceWindow{Start: &start, End: &end}
+	if active, _ := window.Active(start.Add(30 * time.Minute)); !active {
+		t.Fatal("should be active between start and end")
+	}
+	if active, _ := window.Active(end); active {
+		t.Fatal("should not be active at end")
+	}
+}
+
+func TestMaintenanceWindow_InValid(t *testing.T) {
+	start := time.Now()
+	tests := []MaintenanceWindow{
+		{},
+		{Cron: "0 22 * * fri"},
+		{Cron: "0 22 * *", Duration: Duration(time.Hour)},
+		{Cron: "0 22 * * fri", Duration: Duration(time.Hour), Timezone: "Mars/Base"},
+		{Cron: "0 22 * * fri", Duration: Duration(time.Hour), Start: &start},
+		{Start: &start, End: &start},
+	}
+	for _, window := range tests {
+		if err := window.IsValid(); err == nil {
+			t.Errorf("%+v should be invalid", window)
+		}
+	}
+}
+
+func TestGHWebhookReceiver_Unavailable(t *testing.T) {
+	disabled := false
+	start := time.Now().Add(-time.Minute)
+	end := start.Add(time.Hour)
+	receiver := GHWebhookReceiver{}
+	if len(receiver.Unavailable(time.Now())) > 0 || receiver.GetUnavailablePolicy() != UnavailableSkip {
+		t.Fatal("should be available and skip by default")
+	}
+	receiver.MaintenanceWindows = []MaintenanceWindow{{Start: &start, End: &end}}
+	if receiver.Unavailable(time.Now()) != "receiver is in maintenance window" {
+		t.Fatal("should be in maintenance window")
+	}
+	receiver.Enabled = &disabled
+	if receiver.Unavailable(time.Now()) != "receiver is disabled" {
+		t.Fatal("should be disabled")
+	}
+	receiver.UnavailablePolicy = "drop"
+	if err := receiver.IsAvailabilityValid(); err == nil {
+		t.Fatal("should be invalid policy")
+	}
+}
@@ -0,0 +1,50 @@
+package model
+
+const (
+	TraceEvent       = "event"
+	TraceAction      = "action"
+	TraceKey         = "key"      // the value of the filter key
+	TracePositive    = "positive" // a positive regex
+	TraceNegative    = "negative" // a negative regex
+	TraceExpr        = "expr"
+	TraceNot         = "not"
+	TracePaths       = "paths"       // the matched changed file, or the number of changed files if none matches
+	TraceScope       = "scope"       // the org/repo of the event
+	TraceRef         = "ref"         // the branch or tag of the event
+	TraceLabel       = "label"       // the labels of the pull request or issue
+	TraceLogin       = "login"       // the login of the sender or author
+	TraceAssociation = "association" // the author association
+	TraceTeam        = "team"        // the author checked against the team
+	TraceLookup      = "lookup"      // the GitHub API lookups are not available
+)
+
+// MatchTrace evaluation of a subscribe against an event, the steps are in evaluation order
+type MatchTrace struct {
+	SubscribeID uint        `json:"subscribeId,omitempty"`
+	Matched     bool        `json:"matched"`
+	Reason      string      `json:"reason,omitempty"` // why it doesn't match
+	Steps       []MatchStep `json:"steps"`
+}
+
+// MatchStep one evaluated condition, Where is its place in the subscribe, e.g. match.any[0].filters[$.action]
+type MatchStep struct {
+	Where   string      `json:"where"`
+	Kind    string      `json:"kind"`
+	Pattern string      `json:"pattern,omitempty"` // the events, actions, regex or expr
+	Value   interface{} `json:"value,omitempty"`   // the evaluated value, or the expr result
+	Matched bool        `json:"matched"`
+	Error   string      `json:"error,omitempty"`
+}
+
+func (t *MatchTrace) add(step MatchStep) {
+	if t != nil {
+		t.Steps = append(t.Steps, step)
+	}
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,222 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"strings"
+)
+
+// legacyReceiverConfig the flat receiver config before it was typed per receiver type
+type legacyReceiverConfig struct {
+	Type      string
+	URL       string
+	Auth      string
+	Username  string // username or token header name
+	Password  string // password or token value
+	Parameter string
+}
+
+func (l *legacyReceiverConfig) convert() (GHWebhookReceiverConfig, error) {
+	auth := ReceiverAuth{Type: l.Auth}
+	switch l.Auth {
+	case "":
+		// the legacy receivers without auth
+		auth.Type = NoneAuth
+	case BasicAuth:
+		auth.Username = l.Username
+		auth.Password = l.Password
+	case TokenAuth:
+		auth.Header = l.Username
+		auth.Token = l.Password
+	}
+
+	config, err := NewReceiverConfig(l.Type)
+	if err != nil {
+		return GHWebhookReceiverConfig{}, err
+	}
+	httpConfig := config.GetHTTPConfig()
+	httpConfig.URL = l.URL
+	httpConfig.Auth = auth
+	if jenkinsConfig, ok := config.(*JenkinsReceiverConfig); ok {
+		jenkinsConfig.Parameter = l.Parameter
+	}
+	return NewGHWebhookReceiverConfig(config), nil
+}
+
+// isTypedReceiverConfig whether the config is typed, it has an auth object while the legacy auth is a string
+func isTypedReceiverConfig(fields map[string]json.RawMessage) bool {
+	for key, value := range fields {
+		if strings.EqualFold(key, "auth") && strings.HasPrefix(strings.TrimSpace(string(value)), "{") {
+			return true
+		}
+	}
+	return false
+}
+
+// migrateReceiverConfig convert the legacy flat receiver configs to the typed receiver configs
+func migrateReceiverConfig(db *gorm.DB) error {
+	var rows []struct {
+		ID             uint
+		ReceiverConfig string
+	}
+	err := db.Table("gh_webhook_receivers").Select("id", "receiver_config").
+		Where("receiver_config IS NOT NULL").Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		var fields map[string]json.RawMessage
+		if err = json.Unmarshal([]byte(row.ReceiverConfig), &fields); err != nil {
+			return fmt.Errorf("failed to migrate receiver %d config: %v", row.ID, err)
+		}
+		if len(fields) == 0 || isTypedReceiverConfig(fields) {
+			continue
+		}
+		// a legacy config left behind can't be loaded as the typed config, so none of the receivers can be loaded
+		var legacy legacyReceiverConfig
+		if err = json.Unmarshal([]byte(row.ReceiverConfig), &legacy); err != nil {
+			return fmt.Errorf("failed to migrate receiver %d config: %v", row.ID, err)
+		}
+		config, err := legacy.convert()
+		if err != nil {
+			return fmt.Errorf("failed to migrate receiver %d config: %v", row.ID, err)
+		}
+		// saved through the model, so the secret_json serializer encrypts the legacy secrets
+		err = db.Model(&GHWebhookReceiver{}).Where("id = ?", row.ID).Select("ReceiverConfig").
+			Updates(&GHWebhookReceiver{ReceiverConfig: config}).Error
+		if err != nil {
+			return err
+		}
+		log.Infof("migrated receiver %d config to %s receiver config", row.ID, config.Type)
+	}
+	return nil
+}
+
+// neverMatch the match of no event. The match tree has no constant false, so it's the negation of the expr true on
+// the root of the payload, which matches every event.
+func neverMatch() *GHWebhookMatch {
+	always := GHWebhookMatch{Filters: map[string]GHWebhookField{"$": {Expr: "true"}}}
+	return &GHWebhookMatch{Not: &always}
+}
+
+// legacyFilterMatch the match tree of the legacy filters, one of them had to match, nil if the filters mean the same
+// in both semantics. No event matched the legacy subscribe without filters, while the empty filters match all now,
+// so it gets a match which never matches.
+func legacyFilterMatch(filters map[string]GHWebhookField) *GHWebhookMatch {
+	if len(filters) == 0 {
+		return neverMatch()
+	} else if len(filters) == 1 {
+		return nil
+	}
+	match := &GHWebhookMatch{}
+	for _, k := range sortedKeys(filters) {
+		match.Any = append(match.Any, GHWebhookMatch{Filters: map[string]GHWebhookField{k: filters[k]}})
+	}
+	return match
+}
+
+// legacySubscribeMatches the match trees of the legacy subscribes by id, see legacyFilterMatch. They are converted
+// before the match column is added, so the migration runs again after a subscribe which can't be converted is fixed.
+func legacySubscribeMatches(db *gorm.DB) (map[uint]*GHWebhookMatch, error) {
+	var rows []struct {
+		ID      uint
+		Filters *string
+	}
+	err := db.Table("gh_web_hook_subscribes").Select("id", "filters").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make(map[uint]*GHWebhookMatch)
+	for _, row := range rows {
+		var filters map[string]GHWebhookField
+		if row.Filters != nil && len(*row.Filters) > 0 {
+			if err = json.Unmarshal([]byte(*row.Filters), &filters); err != nil {
+				return nil, fmt.Errorf("failed to migrate subscribe %d filters: %v", row.ID, err)
+			}
+		}
+		if match := legacyFilterMatch(filters); match != nil {
+			matches[row.ID] = match
+		}
+	}
+	return matches, nil
+}
+
+// migrateSubscribeFilters keep the legacy subscribes with several filters matching when one of them matches, they
+// are moved to an any match, and the ones without filters matching nothing
+func migrateSubscribeFilters(db *gorm.DB, matches map[uint]*GHWebhookMatch) error {
+	for id, match := range matches {
+		data, err := json.Marshal(match)
+		if err != nil {
+			return err
+		}
+		err = db.Table("gh_web_hook_subscribes").Where("id = ?", id).
+			Updates(map[string]interface{}{"filters": "{}", "match": string(data)}).Error
+		if err != nil {
+			return err
+		}
+		log.Infof("migrated subscribe %d filters to any match", id)
+	}
+	return nil
+}
+
+// migrateSubscribeEvents move the single event of the legacy subscribes to the events
+func migrateSubscribeEvents(db *gorm.DB) error {
+	var rows []struct {
+		ID    uint
+		Event string
+	}
+	err := db.Table("gh_web_hook_subscribes").Select("id", "event").
+		Where("event IS NOT NULL AND event <> ''").Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		data, err := json.Marshal([]string{row.Event})
+		if err != nil {
+			return err
+		}
+		err = db.Table("gh_web_hook_subscribes").Where("id = ?", row.ID).Update("events", string(data)).Error
+		if err != nil {
+			return err
+		}
+		log.Infof("migrated subscribe %d event %s to events", row.ID, row.Event)
+	}
+	return nil
+}
+
+// migrateEventOrgRepo set the org and repo of the events received before they were parsed at ingestion
+func migrateEventOrgRepo(db *gorm.DB) error {
+	var rows []struct {
+		ID      uint
+		Payload string
+	}
+	result := db.Table("gh_webhook_events").Select("id", "payload").Where("org = '' OR org IS NULL").
+		FindInBatches(&rows, 500, func(tx *gorm.DB, batch int) error {
+			for _, row := range rows {
+				var payload map[string]interface{}
+				if err := json.Unmarshal([]byte(row.Payload), &payload); err != nil {
+					log.Errorf("failed to migrate event %d org and repo: %v", row.ID, err)
+					continue
+				}
+				event := GHWebhookEvent{}
+				event.SetOrgRepo(payload)
+				if len(event.Org) == 0 {
+					continue
+				}
+				err := db.Table("gh_webhook_events").Where("id = ?", row.ID).
+					Updates(map[string]interface{}{"org": event.Org, "repo": event.Repo, "org_repo": event.OrgRepo}).
+					Error
+				if err != nil {
+					return err
+				}
+			}
+			log.Infof("migrated org and repo of %d events", len(rows))
+			return nil
+		})
+	return result.Error
+}
@@ -1,12 +1,78 @@
 package model
 
-import "gorm.io/gorm"
+import (
+	"fmt"
+	"gorm.io/gorm"
+)
+
+// models the tables of gh-webhook
+var models = []interface{}{&GitHub{}, &GHWebhookReceiver{}, &GHWebhookEvent{}, &GHWebHookSubscribe{},
+	&GHWebhookEventDeliver{}, &GHWebhookEventReceiverDeliver{}, &GHWebhookSubscribeMatch{}, &GHWebhookSchedule{}}
 
 func Init(db *gorm.DB) error {
-	err := db.AutoMigrate(&GitHub{}, &GHWebhookReceiver{}, &GHWebhookEvent{}, &GHWebHookSubscribe{},
-		&GHWebhookEventDeliver{}, &GHWebhookEventReceiverDeliver{})
-	if err != nil {
+	// subscribes created before the match column use the legacy filter semantics, the ones before the events column
+	// have a single event
+	legacySubscribes := db.Migrator().HasTable(&GHWebHookSubscribe{}) &&
+		!db.Migrator().HasColumn(&GHWebHookSubscribe{}, "Match")
+	legacySubscribeEvents := db.Migrator().HasTable(&GHWebHookSubscribe{}) &&
+		!db.Migrator().HasColumn(&GHWebHookSubscribe{}, "Events")
+	// events received before the org and repo were parsed at ingestion
+	legacyEvents := db.Migrator().HasTable(&GHWebhookEvent{}) && !db.Migrator().HasColumn(&GHWebhookEvent{}, "Org")
+
+	var legacyMatches map[uint]*GHWebhookMatch
+	var err error
+	if legacySubscribes {
+		if legacyMatches, err = legacySubscribeMatches(db); err != nil {
+			return err
+		}
+	}
+
+	if err = db.AutoMigrate(models...); err != nil {
 		return err
 	}
+	if err = migrateReceiverConfig(db); err != nil {
+		return err
+	}
+	if legacySubscribes {
+		if err = migrateSubscribeFilters(db, legacyMatches); err != nil {
+			return err
+		}
+	}
+	if legacySubscribeEvents {
+		if err = migrateSubscribeEvents(db); err != nil {
+			return err
+		}
+	}
+	// the single event is moved to the events, so its column isn't used anymore
+	if db.Migrator().HasColumn(&GHWebHookSubscribe{}, "event") {
+		if err = db.Migrator().DropColumn(&GHWebHookSubscribe{}, "event"); err != nil {
+			return err
+		}
+	}
+	if legacyEvents {
+		return migrateEventOrgRepo(db)
+	}
+	return nil
+}
+
+// CheckSchema fail if the db isn't migrated by Init, for the tools which must not change the schema
+func CheckSchema(db *gorm.DB) error {
+	for _, m := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(m); err != nil {
+			return err
+		}
+		if !db.Migrator().HasTable(m) {
+			return fmt.Errorf("table %s is not migrated", stmt.Schema.Table)
+		}
+		for _, field := range stmt.Schema.Fields {
+			if len(field.DBName) > 0 && !db.Migrator().HasColumn(m, field.DBName) {
+				return fmt.Errorf("column %s of table %s is not migrated", field.DBName, stmt.Schema.Table)
+			}
+		}
+	}
+	if db.Migrator().HasColumn(&GHWebHookSubscribe{}, "event") {
+		return fmt.Errorf("legacy column event of the subscribes is not migrated")
+	}
 	return nil
 }
@@ -0,0 +1,40 @@
+package model
+
+import (
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckSchema(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "gh_pr.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = CheckSchema(db); err == nil {
+		t.Fatal("the empty db should not be current")
+	}
+	if err = Init(db); err != nil {
+		t.Fatal(err)
+	}
+	if err = CheckSchema(db); err != nil {
+		t.Fatalf("the migrated db should be current, got %v", err)
+	}
+
+	if err = db.Exec("ALTER TABLE gh_web_hook_subscribes ADD COLUMN event text").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err = CheckSchema(db); err == nil {
+		t.Fatal("the legacy event column should not be current")
+	}
+	if err = db.Migrator().DropColumn(&GHWebHookSubscribe{}, "event"); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Migrator().DropColumn(&GHWebhookSchedule{}, "Cron"); err != nil {
+		t.Fatal(err)
+	}
+	if err = CheckSchema(db); err == nil {
+		t.Fatal("the missing column should not be current")
+	}
+}
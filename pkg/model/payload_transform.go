@@ -0,0 +1,146 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gh-webhook/pkg/jq"
+	"github.com/PaesslerAG/jsonpath"
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PayloadTransform the projection of the event payload sent to the receiver instead of the whole payload, either
+// Fields, Expr or JQ
+type PayloadTransform struct {
+	// output field to the jsonpath of its value, e.g. {"sha": "$.after", "repo": "$.repository.full_name"}, the
+	// value of a path not found is null
+	Fields map[string]string `json:"fields"`
+	// expr on the payload returning the new payload, e.g. {"sha": after, "files": map(commits, .modified)}
+	Expr string `json:"expr"`
+	// jq program on the payload outputting exactly one value, the new payload, e.g.
+	// {sha: .after, files: ([.commits[].modified[]] | unique)}, see jq.Query for the supported subset of jq
+	JQ string `json:"jq"`
+}
+
+// IsEmpty whether nothing is set, e.g. {} removes the transform of the receiver
+func (t *PayloadTransform) IsEmpty() bool {
+	return len(t.Fields) == 0 && len(t.Expr) == 0 && len(t.JQ) == 0
+}
+
+func (t *PayloadTransform) IsValid() error {
+	_, err := t.Compile()
+	return err
+}
+
+// CompiledTransform the transform compiled with its jsonpaths, expr program or jq query, it's immutable, so the
+// deliveries of the same receiver version share it
+type CompiledTransform struct {
+	Version time.Time // UpdatedAt of the receiver
+	fields  map[string]func(context.Context, interface{}) (interface{}, error)
+	program *vm.Program
+	query   *jq.Query
+}
+
+// Compile validate and compile the transform
+func (t *PayloadTransform) Compile() (*CompiledTransform, error) {
+	set := 0
+	for _, length := range []int{len(t.Fields), len(t.Expr), len(t.JQ)} {
+		if length > 0 {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, fmt.Errorf("one of fields, expr or jq is required")
+	}
+
+	compiled := &CompiledTransform{}
+	var err error
+	switch {
+	case len(t.Expr) > 0:
+		if compiled.program, err = expr.Compile(t.Expr); err != nil {
+			return nil, fmt.Errorf("invalid expr: %v", err)
+		}
+	case len(t.JQ) > 0:
+		if compiled.query, err = jq.Parse(t.JQ); err != nil {
+			return nil, fmt.Errorf("invalid jq: %v", err)
+		}
+	default:
+		compiled.fields = make(map[string]func(context.Context, interface{}) (interface{}, error), len(t.Fields))
+		for field, path := range t.Fields {
+			if len(strings.TrimSpace(field)) == 0 {
+				return nil, fmt.Errorf("field must not be empty")
+			}
+			if compiled.fields[field], err = jsonpath.New(path); err != nil {
+				return nil, fmt.Errorf("invalid jsonpath %s of field %s: %v", path, field, err)
+			}
+		}
+	}
+	return compiled, nil
+}
+
+// Apply transform the json payload, the result is json too
+func (t *CompiledTransform) Apply(payload string) (string, error) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &obj); err != nil {
+		return "", fmt.Errorf("invalid payload: %v", err)
+	}
+
+	var output interface{}
+	var err error
+	switch {
+	case t.program != nil:
+		if output, err = expr.Run(t.program, obj); err != nil {
+			return "", err
+		}
+	case t.query != nil:
+		outputs, err := t.query.Run(obj)
+		if err != nil {
+			return "", err
+		}
+		if len(outputs) != 1 {
+			return "", fmt.Errorf("jq must output one value, got %d", len(outputs))
+		}
+		output = outputs[0]
+	default:
+		fields := make(map[string]interface{}, len(t.fields))
+		for field, path := range t.fields {
+			// like the filters, a path not found isn't an error
+			value, _ := path(context.Background(), obj)
+			fields[field] = value
+		}
+		output = fields
+	}
+
+	data, err := json.Marshal(output)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// transforms receiver id -> *CompiledTransform, shared by the deliveries and the receiver API which evicts the
+// deleted receivers
+var transforms sync.Map
+
+// GetReceiverTransform the compiled transform of the receiver, it's compiled again when the receiver is updated
+func GetReceiverTransform(r *GHWebhookReceiver) (*CompiledTransform, error) {
+	if compiled, ok := transforms.Load(r.ID); ok && compiled.(*CompiledTransform).Version.Equal(r.UpdatedAt) {
+		return compiled.(*CompiledTransform), nil
+	}
+	compiled, err := r.Transform.Compile()
+	if err != nil {
+		return nil, err
+	}
+	compiled.Version = r.UpdatedAt
+	transforms.Store(r.ID, compiled)
+	return compiled, nil
+}
+
+// EvictReceiverTransform remove the compiled transform, e.g. the receiver is deleted
+func EvictReceiverTransform(id uint) {
+	transforms.Delete(id)
+}
@@ -0,0 +1,106 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestPayloadTransform(t *testing.T) {
+	data, err := json.Marshal(loadFixture(t, "push"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := string(data)
+
+	tests := []struct {
+		name      string
+		transform PayloadTransform
+		want      string
+	}{
+		{"fields", PayloadTransform{Fields: map[string]string{
+			"repo":    "$.repository.full_name",
+			"missing": "$.pull_request.number",
+		}}, `{"missing":null,"repo":"zhaojunlucky/exia"}`},
+		{"expr", PayloadTransform{Expr: `{"repo": repository.full_name, "commits": len(commits) > 0}`},
+			`{"commits":true,"repo":"zhaojunlucky/exia"}`},
+		{"jq", PayloadTransform{JQ: `{repo: .repository.full_name, files: ([.commits[].modified[]] | unique | length)}`},
+			`{"files":1,"repo":"zhaojunlucky/exia"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compiled, err := tt.transform.Compile()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := compiled.Apply(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("Apply() = %s, want %s", got, tt.want)
+			}
+			if len(got) >= len(payload) {
+				t.Fatalf("the transformed payload should be smaller, got %d bytes of %d", len(got), len(payload))
+			}
+		})
+	}
+
+	for _, invalid := range []PayloadTransform{
+		{},
+		{Fields: map[string]string{"sha": "$.after"}, Expr: "after"},
+		{Fields: map[string]string{"sha": "$[after"}},
+		{Expr: "after +"},
+		{Expr: "after", JQ: ".after"},
+		{JQ: ".after |"},
+		{JQ: "reduce .commits[] as $c (0; . + 1)"},
+	} {
+		if err := invalid.IsValid(); err == nil {
+			t.Fatalf("transform %+v should be invalid", invalid)
+		}
+	}
+}
+
+func TestPayloadTransform_OneJQOutput(t *testing.T) {
+	for _, program := range []string{".commits[].id", "empty"} {
+		compiled, err := (&PayloadTransform{JQ: program}).Compile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = compiled.Apply(`{"commits": [{"id": "a"}, {"id": "b"}]}`); err == nil {
+			t.Fatalf("%s should fail, it doesn't output one value", program)
+		}
+	}
+}
+
+func TestGetReceiverTransform(t *testing.T) {
+	receiver := &GHWebhookReceiver{Transform: &PayloadTransform{JQ: ".after"}}
+	receiver.ID = 7
+	receiver.UpdatedAt = time.Now()
+	t.Cleanup(func() {
+		EvictReceiverTransform(receiver.ID)
+	})
+
+	compiled, err := GetReceiverTransform(receiver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached, _ := GetReceiverTransform(receiver); cached != compiled {
+		t.Fatal("the transform of the same receiver version should be compiled once")
+	}
+
+	receiver.Transform = &PayloadTransform{Expr: "before"}
+	receiver.UpdatedAt = receiver.UpdatedAt.Add(time.Second)
+	updated, err := GetReceiverTransform(receiver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := updated.Apply(`{"after": "a", "before": "b"}`); got != `"b"` {
+		t.Fatalf("the updated receiver should be compiled again, got %s", got)
+	}
+
+	EvictReceiverTransform(receiver.ID)
+	if _, ok := transforms.Load(receiver.ID); ok {
+		t.Fatal("the evicted transform should be removed")
+	}
+}
@@ -0,0 +1,42 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// RateLimitConfig token bucket of the receiver, requests tokens are added per interval up to burst,
+// zero requests disables rate limiting
+type RateLimitConfig struct {
+	Requests int      `json:"requests,omitempty"`
+	Interval Duration `json:"interval,omitempty"` // default 1m
+	Burst    int      `json:"burst,omitempty"`    // default 1
+}
+
+func (c RateLimitConfig) Enabled() bool {
+	return c.Requests > 0
+}
+
+func (c RateLimitConfig) GetInterval() time.Duration {
+	if c.Interval <= 0 {
+		return time.Minute
+	}
+	return c.Interval.Duration()
+}
+
+func (c RateLimitConfig) GetBurst() int {
+	if c.Burst <= 0 {
+		return 1
+	}
+	return c.Burst
+}
+
+func (c RateLimitConfig) IsValid() error {
+	if c.Requests < 0 || c.Burst < 0 {
+		return fmt.Errorf("rate limit requests and burst must not be negative")
+	}
+	if c.Interval < 0 {
+		return fmt.Errorf("rate limit interval must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseOrgRepo the org and the repository name of the payload, only the org for the events of an org without
+// repository, e.g. organization or membership
+func ParseOrgRepo(payload map[string]interface{}) (string, string) {
+	if repository, ok := payload["repository"].(map[string]interface{}); ok {
+		if fullName, ok := repository["full_name"].(string); ok {
+			if org, repo, found := strings.Cut(fullName, "/"); found {
+				return org, repo
+			}
+		}
+		owner, _ := repository["owner"].(map[string]interface{})
+		org, _ := owner["login"].(string)
+		repo, _ := repository["name"].(string)
+		if len(org) > 0 && len(repo) > 0 {
+			return org, repo
+		}
+	}
+	organization, _ := payload["organization"].(map[string]interface{})
+	org, _ := organization["login"].(string)
+	return org, ""
+}
+
+// isScopeGlob whether the org or repo scope is a glob rather than a name
+func isScopeGlob(scope string) bool {
+	return strings.ContainsAny(scope, "*?[")
+}
+
+// validateScope the org and repo scope are globs of the names, e.g. zhaojunlucky and gh-*, empty matches any
+func validateScope(org string, repo string) error {
+	for _, scope := range []string{org, repo} {
+		if len(scope) == 0 {
+			continue
+		}
+		if strings.Contains(scope, "/") {
+			return fmt.Errorf("invalid scope %s, org and repo are names without /", scope)
+		}
+		if _, err := compileGlob(scope); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scopeMatches whether the org and repo of the event match the scope, case-insensitive like GitHub, an event
+// without repository doesn't match a repo scope
+func scopeMatches(org string, repo string, eventOrg string, eventRepo string) bool {
+	for _, scope := range []struct{ pattern, name string }{{org, eventOrg}, {repo, eventRepo}} {
+		if len(scope.pattern) == 0 {
+			continue
+		}
+		glob, err := compileGlob(strings.ToLower(scope.pattern))
+		if err != nil || !glob.MatchString(strings.ToLower(scope.name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchScope the org and repo of the event, they are parsed from the payload if the event has none, e.g. dry run
+func (m *SubscribeMatcher) matchScope(payload map[string]interface{}, ghEvent GHWebhookEvent, trace *MatchTrace) error {
+	if len(m.org) == 0 && len(m.repo) == 0 {
+		return nil
+	}
+	org, repo := ghEvent.Org, ghEvent.Repo
+	if len(org) == 0 && len(repo) == 0 {
+		org, repo = ParseOrgRepo(payload)
+	}
+	matched := scopeMatches(m.org, m.repo, org, repo)
+	trace.add(MatchStep{Where: "scope", Kind: TraceScope, Pattern: m.org + "/" + m.repo, Value: org + "/" + repo,
+		Matched: matched})
+	if !matched {
+		return &MatchError{Where: "scope", Err: fmt.Errorf("event[%d] %s/%s isn't in scope %s/%s", ghEvent.ID, org,
+			repo, m.org, m.repo)}
+	}
+	return nil
+}
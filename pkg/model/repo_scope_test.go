@@ -0,0 +1,122 @@
+package model
+
+import (
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseOrgRepo(t *testing.T) {
+	tests := []struct {
+		payload map[string]interface{}
+		org     string
+		repo    string
+	}{
+		{loadFixture(t, "push"), "zhaojunlucky", "exia"},
+		{map[string]interface{}{"repository": map[string]interface{}{"name": "veda",
+			"owner": map[string]interface{}{"login": "zhaojunlucky"}}}, "zhaojunlucky", "veda"},
+		{map[string]interface{}{"organization": map[string]interface{}{"login": "octo"}}, "octo", ""},
+		{map[string]interface{}{"action": "push"}, "", ""},
+	}
+	for _, test := range tests {
+		event := GHWebhookEvent{}
+		event.SetOrgRepo(test.payload)
+		if event.Org != test.org || event.Repo != test.repo {
+			t.Errorf("should parse %s/%s, got %s/%s", test.org, test.repo, event.Org, event.Repo)
+		}
+	}
+}
+
+func Test_scopeMatches(t *testing.T) {
+	tests := []struct {
+		org, repo         string
+		eventOrg, evtRepo string
+		matches           bool
+	}{
+		{"", "", "octo", "", true},
+		{"ZhaojunLucky", "", "zhaojunlucky", "veda", true},
+		{"zhaojunlucky", "gh-*", "zhaojunlucky", "gh-webhook", true},
+		{"zhaojunlucky", "gh-*", "zhaojunlucky", "veda", false},
+		{"octo-*", "", "octo-labs", "x", true},
+		{"", "veda", "octo", "", false},
+	}
+	for _, test := range tests {
+		if scopeMatches(test.org, test.repo, test.eventOrg, test.evtRepo) != test.matches {
+			t.Errorf("%s/%s should match %s/%s: %v", test.org, test.repo, test.eventOrg, test.evtRepo, test.matches)
+		}
+	}
+	for _, invalid := range []string{"zhaojunlucky/veda", "[a"} {
+		if err := validateScope(invalid, ""); err == nil {
+			t.Errorf("%s should be invalid", invalid)
+		}
+	}
+}
+
+func TestScopeCandidates(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "gh_pr.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Init(db); err != nil {
+		t.Fatal(err)
+	}
+	receivers := []GHWebhookReceiver{
+		{Name: "all", GitHubId: 1},
+		{Name: "org", GitHubId: 1, Org: "ZhaojunLucky"},
+		{Name: "repo", GitHubId: 1, Org: "zhaojunlucky", Repo: "gh-*"},
+		{Name: "glob", GitHubId: 1, Org: "zhaojun*"},
+		{Name: "other org", GitHubId: 1, Org: "octo"},
+		{Name: "other github", GitHubId: 2},
+	}
+	for i := range receivers {
+		receivers[i].ReceiverConfig = NewGHWebhookReceiverConfig(&HTTPReceiverConfig{URL: "http://localhost",
+			Auth: ReceiverAuth{Type: NoneAuth}})
+		if err = db.Omit("GitHub").Create(&receivers[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var candidates []GHWebhookReceiver
+	event := GHWebhookEvent{GitHubId: 1, Org: "zhaojunlucky", Repo: "veda"}
+	ScopeCandidates(db, event.GitHubId, event.Org).Order("id").Find(&candidates)
+	var names []string
+	for _, candidate := range candidates {
+		names = append(names, candidate.Name)
+	}
+	if expected := []string{"all", "org", "repo", "glob"}; !slices.Equal(names, expected) {
+		t.Fatalf("candidates should be %v, got %v", expected, names)
+	}
+	if candidates[2].MatchesScope(event) || !candidates[3].MatchesScope(event) {
+		t.Fatal("the repo scope should be checked on the candidates")
+	}
+}
+
+func Test_migrateEventOrgRepo(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "gh_pr.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the events table before the org and repo columns
+	err = db.Exec("CREATE TABLE `gh_webhook_events` (`id` integer PRIMARY KEY AUTOINCREMENT, " +
+		"`created_at` datetime, `updated_at` datetime, `deleted_at` datetime, `hook_meta` text, `payload` text, " +
+		"`event` text, `action` text, `org_repo` text, `hook_id` text, `payload_id` text, `git_hub_id` integer)").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Exec("INSERT INTO `gh_webhook_events` (`event`, `payload`) VALUES (?, ?), (?, ?), (?, ?)",
+		"push", `{"repository": {"full_name": "zhaojunlucky/veda"}}`,
+		"organization", `{"organization": {"login": "octo"}}`,
+		"ping", `not json`)
+
+	if err = Init(db); err != nil {
+		t.Fatal(err)
+	}
+	var events []GHWebhookEvent
+	db.Order("id").Find(&events)
+	if len(events) != 3 || events[0].Org != "zhaojunlucky" || events[0].Repo != "veda" ||
+		events[0].OrgRepo != "zhaojunlucky/veda" || events[1].OrgRepo != "octo" || len(events[2].Org) != 0 {
+		t.Fatalf("the org and repo of the events should be migrated, got %+v", events)
+	}
+}
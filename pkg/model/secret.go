@@ -0,0 +1,214 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gh-webhook/pkg/secret"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"sort"
+)
+
+func init() {
+	schema.RegisterSerializer("secret_json", SecretJSONSerializer{})
+}
+
+// SecretHolder has secret fields which are encrypted at rest
+type SecretHolder interface {
+	Secrets() map[string]*string // json path to secret field
+}
+
+// SecretJSONSerializer json serializer which encrypts the secrets of SecretHolder by the keyring
+type SecretJSONSerializer struct{}
+
+func (SecretJSONSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	fieldValue := reflect.New(field.FieldType)
+
+	if dbValue != nil {
+		var bytes []byte
+		switch v := dbValue.(type) {
+		case []byte:
+			bytes = v
+		case string:
+			bytes = []byte(v)
+		default:
+			return fmt.Errorf("failed to unmarshal JSONB value: %#v", dbValue)
+		}
+
+		if len(bytes) > 0 {
+			if err := json.Unmarshal(bytes, fieldValue.Interface()); err != nil {
+				return err
+			}
+			if holder, ok := fieldValue.Interface().(SecretHolder); ok {
+				if err := decryptSecrets(holder); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	field.ReflectValueOf(ctx, dst).Set(fieldValue.Elem())
+	return nil
+}
+
+func (SecretJSONSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	result, err := json.Marshal(fieldValue)
+	if err != nil {
+		return nil, err
+	}
+	if string(result) == "null" {
+		if field.TagSettings["NOT NULL"] != "" {
+			return "", nil
+		}
+		return nil, nil
+	}
+
+	// encrypt a copy, the model keeps the plain secrets
+	copied := reflect.New(field.FieldType)
+	if err = json.Unmarshal(result, copied.Interface()); err != nil {
+		return nil, err
+	}
+	if holder, ok := copied.Interface().(SecretHolder); ok {
+		if err = encryptSecrets(holder); err != nil {
+			return nil, err
+		}
+		if result, err = json.Marshal(copied.Interface()); err != nil {
+			return nil, err
+		}
+	}
+	return string(result), nil
+}
+
+// encryptSecrets the model only holds plain secrets, so a secret is encrypted even if it looks encrypted, otherwise
+// it would be decrypted when it's read
+func encryptSecrets(holder SecretHolder) error {
+	keyring := secret.GetKeyring()
+	for path, value := range holder.Secrets() {
+		if len(*value) == 0 {
+			continue
+		}
+		if keyring == nil {
+			if secret.IsEncrypted(*value) {
+				return fmt.Errorf("failed to store %s: the plain secret looks encrypted, secret keys are not configured",
+					path)
+			}
+			continue
+		}
+		encrypted, err := keyring.Encrypt(*value)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt %s: %v", path, err)
+		}
+		*value = encrypted
+	}
+	return nil
+}
+
+func decryptSecrets(holder SecretHolder) error {
+	keyring := secret.GetKeyring()
+	for path, value := range holder.Secrets() {
+		if !secret.IsEncrypted(*value) {
+			continue
+		}
+		if keyring == nil {
+			return fmt.Errorf("failed to decrypt %s: secret keys are not configured", path)
+		}
+		plaintext, err := keyring.Decrypt(*value)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %s: %v", path, err)
+		}
+		*value = plaintext
+	}
+	return nil
+}
+
+// ReEncryptResult the re-encrypted secret fields of a receiver, it never contains secret values
+type ReEncryptResult struct {
+	ReceiverID uint
+	Fields     []string // json paths of the re-encrypted secrets
+	FromKeys   []string // key ids which encrypted the secrets before, plain for unencrypted secrets
+	ToKey      string
+}
+
+// ReEncryptReceiverSecrets re-encrypt the receiver secrets which are plain or not encrypted by the primary key
+func ReEncryptReceiverSecrets(db *gorm.DB, keyring *secret.Keyring, dryRun bool) ([]ReEncryptResult, error) {
+	var results []ReEncryptResult
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var rows []struct {
+			ID             uint
+			ReceiverConfig string
+		}
+		err := tx.Table("gh_webhook_receivers").Select("id", "receiver_config").
+			Where("receiver_config IS NOT NULL").Scan(&rows).Error
+		if err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			var config GHWebhookReceiverConfig
+			if err = json.Unmarshal([]byte(row.ReceiverConfig), &config); err != nil {
+				return fmt.Errorf("failed to parse receiver %d config: %v", row.ID, err)
+			}
+
+			result, err := reEncryptSecrets(keyring, &config)
+			if err != nil {
+				return fmt.Errorf("failed to re-encrypt receiver %d: %v", row.ID, err)
+			}
+			if len(result.Fields) == 0 {
+				continue
+			}
+			result.ReceiverID = row.ID
+			results = append(results, result)
+			log.Infof("receiver %d: re-encrypted %v from %v to key %s", row.ID, result.Fields, result.FromKeys,
+				result.ToKey)
+			if dryRun {
+				continue
+			}
+
+			data, err := json.Marshal(config)
+			if err != nil {
+				return err
+			}
+			err = tx.Table("gh_webhook_receivers").Where("id = ?", row.ID).
+				Update("receiver_config", string(data)).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return results, err
+}
+
+func reEncryptSecrets(keyring *secret.Keyring, holder SecretHolder) (ReEncryptResult, error) {
+	result := ReEncryptResult{ToKey: keyring.Primary()}
+	secrets := holder.Secrets()
+	paths := make([]string, 0, len(secrets))
+	for path := range secrets {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		value := secrets[path]
+		if !keyring.NeedsReEncrypt(*value) {
+			continue
+		}
+		fromKey, ok := secret.KeyID(*value)
+		if !ok {
+			fromKey = "plain"
+		}
+		plaintext, err := keyring.Decrypt(*value)
+		if err != nil {
+			return result, fmt.Errorf("%s: %v", path, err)
+		}
+		if *value, err = keyring.Encrypt(plaintext); err != nil {
+			return result, fmt.Errorf("%s: %v", path, err)
+		}
+		result.Fields = append(result.Fields, path)
+		result.FromKeys = append(result.FromKeys, fromKey)
+	}
+	return result, nil
+}
@@ -0,0 +1,173 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"gh-webhook/pkg/secret"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func setTestKeyring(t *testing.T, primary string) *secret.Keyring {
+	keyring, err := secret.NewKeyring(primary, map[string][]byte{
+		"k1": bytes.Repeat([]byte{1}, 32),
+		"k2": bytes.Repeat([]byte{2}, 32),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret.SetKeyring(keyring)
+	t.Cleanup(func() {
+		secret.SetKeyring(nil)
+	})
+	return keyring
+}
+
+func newTestSecretConfig() GHWebhookReceiverConfig {
+	return NewGHWebhookReceiverConfig(&HTTPReceiverConfig{
+		URL:  "http://localhost:8080",
+		Auth: ReceiverAuth{Type: BasicAuth, Username: "user", Password: "password"},
+	})
+}
+
+func TestSecretJSONSerializer_Value(t *testing.T) {
+	setTestKeyring(t, "k1")
+	cfg := newTestSecretConfig()
+
+	field := &schema.Field{FieldType: reflect.TypeOf(cfg), TagSettings: map[string]string{}}
+	value, err := SecretJSONSerializer{}.Value(context.Background(), field, reflect.Value{}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := value.(string)
+	if strings.Contains(data, `"password":"password"`) || !strings.Contains(data, "enc:v1:k1:") {
+		t.Fatalf("password should be encrypted: %s", data)
+	}
+	if cfg.GetHTTPConfig().Auth.Password != "password" {
+		t.Fatal("model should keep the plain password")
+	}
+
+	var stored GHWebhookReceiverConfig
+	if err = json.Unmarshal([]byte(data), &stored); err != nil {
+		t.Fatal(err)
+	}
+	if err = decryptSecrets(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.GetHTTPConfig().Auth.Password != "password" {
+		t.Fatal("password should be decrypted")
+	}
+
+	// the plain secret which looks encrypted is encrypted too, so it's read back as is
+	cfg.GetHTTPConfig().Auth.Password = "enc:v1:k1:a:b"
+	if value, err = (SecretJSONSerializer{}).Value(context.Background(), field, reflect.Value{}, cfg); err != nil {
+		t.Fatal(err)
+	}
+	stored = GHWebhookReceiverConfig{}
+	if err = json.Unmarshal([]byte(value.(string)), &stored); err != nil {
+		t.Fatal(err)
+	}
+	if err = decryptSecrets(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.GetHTTPConfig().Auth.Password != "enc:v1:k1:a:b" {
+		t.Fatalf("the plain secret should be kept, got %s", stored.GetHTTPConfig().Auth.Password)
+	}
+}
+
+func TestSecretJSONSerializer_NoKeyring(t *testing.T) {
+	cfg := newTestSecretConfig()
+	if err := encryptSecrets(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.GetHTTPConfig().Auth.Password != "password" {
+		t.Fatal("password should be kept without keyring")
+	}
+
+	cfg.GetHTTPConfig().Auth.Password = "enc:v1:k1:a:b"
+	if err := decryptSecrets(&cfg); err == nil {
+		t.Fatal("encrypted password should fail without keyring")
+	}
+	if err := encryptSecrets(&cfg); err == nil {
+		t.Fatal("the plain password which looks encrypted should not be stored without keyring")
+	}
+}
+
+func TestGHWebhookReceiverConfig_Redacted(t *testing.T) {
+	cfg := newTestSecretConfig()
+	fields, err := cfg.Redacted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := fields["auth"].(map[string]interface{})
+	if _, ok := auth["password"]; ok {
+		t.Fatal("password should be redacted")
+	}
+	if auth["passwordSet"] != true || auth["tokenSet"] != false || auth["username"] != "user" {
+		t.Fatalf("unexpected redacted auth %v", auth)
+	}
+}
+
+func TestReEncryptSecrets(t *testing.T) {
+	oldKeyring := setTestKeyring(t, "k1")
+	cfg := newTestSecretConfig()
+	encrypted, err := oldKeyring.Encrypt("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.GetHTTPConfig().Auth.Password = encrypted
+
+	keyring := setTestKeyring(t, "k2")
+	result, err := reEncryptSecrets(keyring, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Fields, []string{"auth.password"}) || !reflect.DeepEqual(result.FromKeys, []string{"k1"}) {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if keyId, _ := secret.KeyID(cfg.GetHTTPConfig().Auth.Password); keyId != "k2" {
+		t.Fatal("password should be encrypted by k2")
+	}
+
+	result, err = reEncryptSecrets(keyring, &cfg)
+	if err != nil || len(result.Fields) != 0 {
+		t.Fatal("nothing should be re-encrypted")
+	}
+}
+
+func TestGHWebhookReceiverConfig_SecretReference(t *testing.T) {
+	cfg := NewGHWebhookReceiverConfig(&HTTPReceiverConfig{
+		URL:  "http://localhost:8080",
+		Auth: ReceiverAuth{Type: TokenAuth, Header: "X-Token", Token: "vault:kv/ci"},
+	})
+	if err := cfg.IsValid(); err == nil {
+		t.Fatal("invalid vault reference should fail")
+	}
+
+	cfg.GetHTTPConfig().Auth.Token = "vault:kv/ci#token"
+	if err := cfg.IsValid(); err != nil {
+		t.Fatal(err)
+	}
+
+	fields, err := cfg.Redacted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := fields["auth"].(map[string]interface{})
+	if auth["tokenSet"] != true || auth["tokenRef"] != "vault:kv/ci#token" {
+		t.Fatalf("unexpected redacted auth %v", auth)
+	}
+
+	cloned, err := cfg.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cloned.GetHTTPConfig().Auth.Token = "resolved"
+	if cfg.GetHTTPConfig().Auth.Token != "vault:kv/ci#token" {
+		t.Fatal("clone should not change the config")
+	}
+}
@@ -0,0 +1,215 @@
+package model
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// the author associations of GitHub, e.g. pull_request.author_association
+var authorAssociations = []string{"COLLABORATOR", "CONTRIBUTOR", "FIRST_TIMER", "FIRST_TIME_CONTRIBUTOR", "MANNEQUIN",
+	"MEMBER", "NONE", "OWNER"}
+
+// LabelFilter labels of the pull request or issue, the labels are case-insensitive like GitHub
+type LabelFilter struct {
+	Include []string `json:"include"` // one of them must be labeled, or all of them if All
+	All     bool     `json:"all"`
+	Exclude []string `json:"exclude"` // none of them must be labeled
+}
+
+func (f *LabelFilter) IsValid() error {
+	for _, label := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if len(strings.TrimSpace(label)) == 0 {
+			return fmt.Errorf("label must not be empty")
+		}
+	}
+	return nil
+}
+
+// matches nil if the labels match, the first excluded label or the missing included labels tell why not
+func (f *LabelFilter) matches(labels []string) error {
+	has := func(label string) bool {
+		return slices.ContainsFunc(labels, func(l string) bool { return strings.EqualFold(l, label) })
+	}
+	for _, label := range f.Exclude {
+		if has(label) {
+			return fmt.Errorf("label %s is excluded", label)
+		}
+	}
+	if len(f.Include) == 0 {
+		return nil
+	}
+	var missing []string
+	for _, label := range f.Include {
+		if !has(label) {
+			missing = append(missing, label)
+		}
+	}
+	if len(missing) > 0 && (f.All || len(missing) == len(f.Include)) {
+		return fmt.Errorf("labels %v don't include %v", labels, missing)
+	}
+	return nil
+}
+
+// LoginFilter GitHub logins, the login must be one of Allow if any and none of Deny, the logins are case-insensitive
+type LoginFilter struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+func (f *LoginFilter) IsValid() error {
+	for _, login := range append(append([]string{}, f.Allow...), f.Deny...) {
+		if len(strings.TrimSpace(login)) == 0 {
+			return fmt.Errorf("login must not be empty")
+		}
+	}
+	return nil
+}
+
+func (f *LoginFilter) matches(login string) error {
+	equal := func(l string) bool { return strings.EqualFold(l, login) }
+	if len(login) == 0 && (len(f.Allow) > 0 || len(f.Deny) > 0) {
+		return fmt.Errorf("login not found in payload")
+	}
+	if slices.ContainsFunc(f.Deny, equal) {
+		return fmt.Errorf("login %s is denied", login)
+	}
+	if len(f.Allow) > 0 && !slices.ContainsFunc(f.Allow, equal) {
+		return fmt.Errorf("login %s isn't allowed", login)
+	}
+	return nil
+}
+
+func validateAuthorAssociations(associations []string) error {
+	for _, association := range associations {
+		if !slices.Contains(authorAssociations, strings.ToUpper(association)) {
+			return fmt.Errorf("invalid author association %s, it should be one of %v", association,
+				authorAssociations)
+		}
+	}
+	return nil
+}
+
+// parseTeam the org and the team slug of org/team
+func parseTeam(team string) (string, string, error) {
+	org, slug, found := strings.Cut(team, "/")
+	if !found || len(org) == 0 || len(slug) == 0 || strings.Contains(slug, "/") {
+		return "", "", fmt.Errorf("invalid team %s, it should be org/team-slug", team)
+	}
+	return org, slug, nil
+}
+
+// eventLabels the label names of the pull request or issue of the payload
+func eventLabels(payload map[string]interface{}) []string {
+	var labels []string
+	for _, key := range []string{"pull_request", "issue"} {
+		obj, ok := payload[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		items, _ := obj["labels"].([]interface{})
+		for _, item := range items {
+			label, _ := item.(map[string]interface{})
+			if name, ok := label["name"].(string); ok {
+				labels = append(labels, name)
+			}
+		}
+		return labels
+	}
+	return labels
+}
+
+// eventSender the login of the user who triggered the event
+func eventSender(payload map[string]interface{}) string {
+	sender, _ := payload["sender"].(map[string]interface{})
+	login, _ := sender["login"].(string)
+	return login
+}
+
+// eventAuthor the login and the author association of the comment, review, pull request or issue of the payload,
+// the first one found, e.g. the commenter of an issue_comment event, not the author of the issue
+func eventAuthor(payload map[string]interface{}) (string, string) {
+	for _, key := range []string{"comment", "review", "pull_request", "issue"} {
+		obj, ok := payload[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		user, _ := obj["user"].(map[string]interface{})
+		login, _ := user["login"].(string)
+		association, _ := obj["author_association"].(string)
+		return login, association
+	}
+	return "", ""
+}
+
+// matchAuthor the labels, the sender, the author and the author association, all of them are evaluated when tracing
+func (m *SubscribeMatcher) matchAuthor(payload map[string]interface{}, trace *MatchTrace) error {
+	var firstErr error
+	check := func(where string, kind string, pattern string, value interface{}, err error) {
+		trace.add(MatchStep{Where: where, Kind: kind, Pattern: pattern, Value: value, Matched: err == nil,
+			Error: errorString(err)})
+		if err != nil && firstErr == nil {
+			firstErr = &MatchError{Where: where, Err: err}
+		}
+	}
+	if m.labels != nil {
+		labels := eventLabels(payload)
+		check("labels", TraceLabel, fmt.Sprintf("include %v, exclude %v", m.labels.Include, m.labels.Exclude),
+			labels, m.labels.matches(labels))
+	}
+	if firstErr != nil && trace == nil {
+		return firstErr
+	}
+	if m.senders != nil {
+		sender := eventSender(payload)
+		check("senders", TraceLogin, fmt.Sprintf("allow %v, deny %v", m.senders.Allow, m.senders.Deny), sender,
+			m.senders.matches(sender))
+	}
+	if firstErr != nil && trace == nil {
+		return firstErr
+	}
+	login, association := eventAuthor(payload)
+	if m.authors != nil {
+		check("authors", TraceLogin, fmt.Sprintf("allow %v, deny %v", m.authors.Allow, m.authors.Deny), login,
+			m.authors.matches(login))
+	}
+	if firstErr != nil && trace == nil {
+		return firstErr
+	}
+	if len(m.associations) > 0 {
+		var err error
+		if !slices.ContainsFunc(m.associations, func(a string) bool { return strings.EqualFold(a, association) }) {
+			err = fmt.Errorf("author association %s isn't one of %v", association, m.associations)
+		}
+		check("authorAssociations", TraceAssociation, strings.Join(m.associations, ","), association, err)
+	}
+	return firstErr
+}
+
+// matchTeams the author must be an active member of one of the teams
+func (m *SubscribeMatcher) matchTeams(payload map[string]interface{}, lookup EventLookup, trace *MatchTrace) error {
+	if len(m.teams) == 0 {
+		return nil
+	}
+	login, _ := eventAuthor(payload)
+	if len(login) == 0 {
+		err := fmt.Errorf("author not found in payload")
+		trace.add(MatchStep{Where: "teams", Kind: TraceTeam, Pattern: strings.Join(m.teams, ","),
+			Error: err.Error()})
+		return &MatchError{Where: "teams", Err: err}
+	}
+	for _, team := range m.teams {
+		org, slug, _ := parseTeam(team)
+		member, err := lookup.TeamMember(org, slug, login)
+		trace.add(MatchStep{Where: "teams", Kind: TraceTeam, Pattern: team, Value: login, Matched: member,
+			Error: errorString(err)})
+		if err != nil {
+			return &MatchError{Where: "teams", Err: fmt.Errorf("failed to check membership of team %s: %v", team,
+				err)}
+		}
+		if member {
+			return nil
+		}
+	}
+	return &MatchError{Where: "teams", Err: fmt.Errorf("author %s isn't a member of %v", login, m.teams)}
+}
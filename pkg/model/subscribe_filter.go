@@ -0,0 +1,261 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"github.com/PaesslerAG/jsonpath"
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+	log "github.com/sirupsen/logrus"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+type fieldMatcher struct {
+	key          string
+	path         func(context.Context, interface{}) (interface{}, error)
+	missingMatch bool
+	positive     []*regexp.Regexp
+	negative     []*regexp.Regexp
+	expr         string
+	program      *vm.Program
+	child        []*fieldMatcher
+}
+
+type matchNode struct {
+	filters []*fieldMatcher
+	all     []*matchNode
+	any     []*matchNode
+	not     *matchNode
+}
+
+func compileFilters(filters map[string]GHWebhookField, prefix string) ([]*fieldMatcher, error) {
+	fields := make([]*fieldMatcher, 0, len(filters))
+	for _, k := range sortedKeys(filters) {
+		v := filters[k]
+		field, err := compileField(&v, prefix+k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter %s: %v", prefix+k, err)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+func compileField(f *GHWebhookField, key string) (*fieldMatcher, error) {
+	path, err := jsonpath.New(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter key %s: %v", key, err)
+	}
+	field := &fieldMatcher{
+		key:          key,
+		path:         path,
+		missingMatch: f.GetMissing() == MissingMatch,
+		expr:         f.Expr,
+	}
+	for _, pattern := range f.PositiveMatches {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %s: %v", pattern, err)
+		}
+		field.positive = append(field.positive, re)
+	}
+	for _, pattern := range f.NegativeMatches {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %s: %v", pattern, err)
+		}
+		field.negative = append(field.negative, re)
+	}
+	if len(f.Expr) > 0 {
+		if field.program, err = expr.Compile(f.Expr, expr.AsBool()); err != nil {
+			log.Errorf("failed to compile expr %s: %v", f.Expr, err)
+			return nil, err
+		}
+	}
+	if field.child, err = compileFilters(f.Child, key+"."); err != nil {
+		return nil, err
+	}
+	return field, nil
+}
+
+func compileMatch(m *GHWebhookMatch) (*matchNode, error) {
+	filters, err := compileFilters(m.Filters, "")
+	if err != nil {
+		return nil, err
+	}
+	node := &matchNode{filters: filters}
+	for i := range m.All {
+		child, err := compileMatch(&m.All[i])
+		if err != nil {
+			return nil, fmt.Errorf("all[%d]: %v", i, err)
+		}
+		node.all = append(node.all, child)
+	}
+	for i := range m.Any {
+		child, err := compileMatch(&m.Any[i])
+		if err != nil {
+			return nil, fmt.Errorf("any[%d]: %v", i, err)
+		}
+		node.any = append(node.any, child)
+	}
+	if m.Not != nil {
+		if node.not, err = compileMatch(m.Not); err != nil {
+			return nil, fmt.Errorf("not: %v", err)
+		}
+	}
+	return node, nil
+}
+
+func (n *matchNode) matches(payload map[string]interface{}, ghEvent GHWebhookEvent, trace *MatchTrace,
+	where string) error {
+	if err := matchFields(n.filters, payload, ghEvent, trace, where+".filters"); err != nil {
+		return err
+	}
+	for i, child := range n.all {
+		if err := child.matches(payload, ghEvent, trace, fmt.Sprintf("%s.all[%d]", where, i)); err != nil {
+			return fmt.Errorf("all[%d] doesn't match: %w", i, err)
+		}
+	}
+	if len(n.any) > 0 {
+		var errs []string
+		for i, child := range n.any {
+			err := child.matches(payload, ghEvent, trace, fmt.Sprintf("%s.any[%d]", where, i))
+			if err == nil {
+				errs = nil
+				break
+			}
+			errs = append(errs, fmt.Sprintf("any[%d]: %v", i, err))
+		}
+		if len(errs) > 0 {
+			return &MatchError{Where: where + ".any",
+				Err: fmt.Errorf("none of any matches: %s", strings.Join(errs, "; "))}
+		}
+	}
+	if n.not != nil {
+		notMatched := n.not.matches(payload, ghEvent, trace, where+".not") == nil
+		trace.add(MatchStep{Where: where + ".not", Kind: TraceNot, Matched: !notMatched})
+		if notMatched {
+			return &MatchError{Where: where + ".not", Err: fmt.Errorf("event[%d] matches not", ghEvent.ID)}
+		}
+	}
+	return nil
+}
+
+// matchFields all fields must match, all of them are evaluated when tracing
+func matchFields(fields []*fieldMatcher, payload map[string]interface{}, ghEvent GHWebhookEvent, trace *MatchTrace,
+	where string) error {
+	var firstErr error
+	for _, field := range fields {
+		fieldWhere := fmt.Sprintf("%s[%s]", where, field.key)
+		if err := field.matches(payload, ghEvent, trace, fieldWhere); err != nil {
+			if len(FailedCondition(err)) == 0 {
+				// the child filters tell the innermost condition themselves
+				err = &MatchError{Where: fieldWhere, Err: err}
+			}
+			if trace == nil {
+				return fmt.Errorf("filter %s doesn't match: %w", field.key, err)
+			} else if firstErr == nil {
+				firstErr = fmt.Errorf("filter %s doesn't match: %w", field.key, err)
+			}
+		}
+	}
+	return firstErr
+}
+
+func (f *fieldMatcher) matches(payload map[string]interface{}, ghEvent GHWebhookEvent, trace *MatchTrace,
+	where string) error {
+	curObj, err := f.path(context.Background(), payload)
+
+	if err != nil || curObj == nil {
+		trace.add(MatchStep{Where: where, Kind: TraceKey, Pattern: f.key, Matched: f.missingMatch,
+			Error: fmt.Sprintf("key %s not found in payload", f.key)})
+		if f.missingMatch {
+			return nil
+		}
+		return fmt.Errorf("key %s not found in payload", f.key)
+	}
+	trace.add(MatchStep{Where: where, Kind: TraceKey, Pattern: f.key, Value: curObj, Matched: true})
+
+	if len(f.negative) > 0 || len(f.positive) > 0 {
+		values, err := scalarValues(curObj)
+		if err != nil {
+			trace.add(MatchStep{Where: where, Kind: TraceKey, Pattern: f.key, Error: err.Error()})
+			return err
+		}
+
+		for _, negativeMatch := range f.negative {
+			str, matched := matchAny(negativeMatch, values)
+			trace.add(MatchStep{Where: where, Kind: TraceNegative, Pattern: negativeMatch.String(), Value: str,
+				Matched: !matched})
+			if matched {
+				log.Infof("event[%d] negative matched %s with %s", ghEvent.ID, negativeMatch, str)
+				return fmt.Errorf("event[%d] negative matched %s with %s", ghEvent.ID, negativeMatch, str)
+			}
+		}
+
+		positiveMatched := len(f.positive) == 0
+		for _, positiveMatch := range f.positive {
+			str, matched := matchAny(positiveMatch, values)
+			trace.add(MatchStep{Where: where, Kind: TracePositive, Pattern: positiveMatch.String(), Value: str,
+				Matched: matched})
+			if matched {
+				log.Infof("event[%d] positive matched %s with %s", ghEvent.ID, positiveMatch, str)
+				positiveMatched = true
+				break
+			}
+		}
+		if !positiveMatched {
+			return fmt.Errorf("event[%d] %v doesn't match any of %v", ghEvent.ID, values, f.positive)
+		}
+	}
+
+	if f.program != nil {
+		env := map[string]interface{}{
+			"cur":  curObj,
+			"root": payload,
+		}
+		output, err := expr.Run(f.program, env)
+		if err != nil {
+			trace.add(MatchStep{Where: where, Kind: TraceExpr, Pattern: f.expr, Error: err.Error()})
+			log.Errorf("event[%d] failed to run expr %s: %v", ghEvent.ID, f.expr, err)
+			return err
+		}
+		trace.add(MatchStep{Where: where, Kind: TraceExpr, Pattern: f.expr, Value: output, Matched: output == true})
+
+		switch reflect.TypeOf(output).Kind() {
+		case reflect.Bool:
+			if !output.(bool) {
+				log.Infof("event[%d] failed to match expr", ghEvent.ID)
+				return fmt.Errorf("event[%d] failed to match expr %s", ghEvent.ID, f.expr)
+			}
+		default:
+			log.Errorf("invalid return type %v for expr %s", reflect.TypeOf(output), f.expr)
+			return fmt.Errorf("invalid return type %v for expr %s", reflect.TypeOf(output), f.expr)
+		}
+	}
+
+	if len(f.child) > 0 {
+		fieldVal := GHWebhookFieldVal{Value: curObj, Type: reflect.TypeOf(curObj)}
+		if !fieldVal.IsMap() {
+			trace.add(MatchStep{Where: where, Kind: TraceKey, Pattern: f.key,
+				Error: fmt.Sprintf("unsupported type %T for Child", curObj)})
+			return fmt.Errorf("unsupported type %T for Child", curObj)
+		}
+		if err = matchFields(f.child, payload, ghEvent, trace, where+".child"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func matchAny(re *regexp.Regexp, values []string) (string, bool) {
+	for _, value := range values {
+		if re.MatchString(value) {
+			return value, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,232 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SubscribeMatcher the subscribe compiled with its jsonpaths, regexes and expr programs, it's immutable, so the
+// deliveries of the same subscribe version share it
+type SubscribeMatcher struct {
+	ID      uint
+	Version time.Time // UpdatedAt of the compiled subscribe
+	events  []string
+	actions []string
+	org     string
+	repo    string
+	filters []*fieldMatcher
+	match   *matchNode
+	paths   []*regexp.Regexp
+	ignored []*regexp.Regexp
+
+	branches       []refPattern
+	branchesIgnore []refPattern
+	tags           []refPattern
+	tagsIgnore     []refPattern
+
+	labels       *LabelFilter
+	senders      *LoginFilter
+	authors      *LoginFilter
+	associations []string
+	teams        []string
+}
+
+// EventLookup the GitHub API lookups of an event, they are only called when the conditions needing them are
+// evaluated
+type EventLookup interface {
+	ChangedFiles() ([]string, error)
+	TeamMember(org string, team string, login string) (bool, error)
+}
+
+// MatchError why the event doesn't match, Where is the condition which doesn't match like the trace steps
+type MatchError struct {
+	Where string
+	Err   error
+}
+
+func (e *MatchError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *MatchError) Unwrap() error {
+	return e.Err
+}
+
+// FailedCondition where the innermost condition which doesn't match is, empty if unknown
+func FailedCondition(err error) string {
+	var matchErr *MatchError
+	if errors.As(err, &matchErr) {
+		return matchErr.Where
+	}
+	return ""
+}
+
+// CompileSubscribe compile the filters and the match tree of the subscribe
+func CompileSubscribe(s *GHWebHookSubscribe) (*SubscribeMatcher, error) {
+	filters, err := compileFilters(s.Filters, "")
+	if err != nil {
+		return nil, err
+	}
+	m := &SubscribeMatcher{
+		ID:      s.ID,
+		Version: s.UpdatedAt,
+		events:  s.Events,
+		actions: s.Actions,
+		org:     s.Org,
+		repo:    s.Repo,
+		filters: filters,
+
+		labels:       s.Labels,
+		senders:      s.Senders,
+		authors:      s.Authors,
+		associations: s.AuthorAssociations,
+		teams:        s.Teams,
+	}
+	for _, team := range s.Teams {
+		if _, _, err = parseTeam(team); err != nil {
+			return nil, err
+		}
+	}
+	if s.Match != nil {
+		if m.match, err = compileMatch(s.Match); err != nil {
+			return nil, err
+		}
+	}
+	if m.branches, err = compileRefPatterns(s.Branches, branchPrefix); err != nil {
+		return nil, err
+	}
+	if m.branchesIgnore, err = compileRefPatterns(s.BranchesIgnore, branchPrefix); err != nil {
+		return nil, err
+	}
+	if m.tags, err = compileRefPatterns(s.Tags, tagPrefix); err != nil {
+		return nil, err
+	}
+	if m.tagsIgnore, err = compileRefPatterns(s.TagsIgnore, tagPrefix); err != nil {
+		return nil, err
+	}
+	if m.paths, err = compileGlobs(s.Paths); err != nil {
+		return nil, err
+	}
+	if m.ignored, err = compileGlobs(s.PathsIgnore); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// matchers subscribe id -> *SubscribeMatcher, shared by the deliveries and the subscribe API which evicts the
+// deleted subscribes
+var matchers sync.Map
+
+// GetSubscribeMatcher the compiled subscribe, it's compiled again when the subscribe is updated
+func GetSubscribeMatcher(s *GHWebHookSubscribe) (*SubscribeMatcher, error) {
+	if matcher, ok := matchers.Load(s.ID); ok && matcher.(*SubscribeMatcher).Version.Equal(s.UpdatedAt) {
+		return matcher.(*SubscribeMatcher), nil
+	}
+	matcher, err := CompileSubscribe(s)
+	if err != nil {
+		return nil, err
+	}
+	matchers.Store(s.ID, matcher)
+	return matcher, nil
+}
+
+// EvictSubscribeMatcher remove the compiled subscribe, e.g. it's deleted
+func EvictSubscribeMatcher(id uint) {
+	matchers.Delete(id)
+}
+
+// HasPaths whether the subscribe needs the changed files of the event
+func (m *SubscribeMatcher) HasPaths() bool {
+	return len(m.paths) > 0 || len(m.ignored) > 0
+}
+
+// Matches nil if the event matches the subscribe, otherwise the error tells why it doesn't match, the teams and the
+// paths are evaluated last, so the GitHub API is only called when everything else matches
+func (m *SubscribeMatcher) Matches(payload map[string]interface{}, ghEvent GHWebhookEvent, lookup EventLookup) error {
+	err := m.matches(payload, ghEvent, nil)
+	if err == nil {
+		err = m.matchLookups(payload, lookup, nil)
+	}
+	return err
+}
+
+// Trace evaluate the event like Matches and record every evaluated condition, the filters of the same level and the
+// match tree are evaluated even after a filter doesn't match
+func (m *SubscribeMatcher) Trace(payload map[string]interface{}, ghEvent GHWebhookEvent,
+	lookup EventLookup) *MatchTrace {
+	trace := &MatchTrace{SubscribeID: m.ID, Steps: []MatchStep{}}
+	err := m.matches(payload, ghEvent, trace)
+	if err == nil {
+		err = m.matchLookups(payload, lookup, trace)
+	}
+	trace.Matched = err == nil
+	trace.Reason = errorString(err)
+	return trace
+}
+
+func (m *SubscribeMatcher) matches(payload map[string]interface{}, ghEvent GHWebhookEvent, trace *MatchTrace) error {
+	sub := GHWebHookSubscribe{Events: m.events, Actions: m.actions}
+	err := sub.MatchesEvent(ghEvent)
+	trace.add(MatchStep{Where: "events", Kind: TraceEvent, Pattern: strings.Join(m.events, ","), Value: ghEvent.Event,
+		Matched: eventMatches(m.events, ghEvent.Event)})
+	if len(m.actions) > 0 {
+		trace.add(MatchStep{Where: "actions", Kind: TraceAction, Pattern: strings.Join(m.actions, ","),
+			Value: ghEvent.Action, Matched: slices.Contains(m.actions, ghEvent.Action)})
+	}
+	if err != nil {
+		log.Infof("%v", err)
+		if eventMatches(m.events, ghEvent.Event) {
+			return &MatchError{Where: "actions", Err: err}
+		}
+		return &MatchError{Where: "events", Err: err}
+	}
+	if err = m.matchScope(payload, ghEvent, trace); err != nil {
+		return err
+	}
+	if err = m.matchRef(payload, ghEvent, trace); err != nil {
+		return err
+	}
+	err = m.matchAuthor(payload, trace)
+	if err != nil && trace == nil {
+		return err
+	}
+	if filtersErr := matchFields(m.filters, payload, ghEvent, trace, "filters"); err == nil {
+		err = filtersErr
+	}
+	if err != nil && trace == nil {
+		return err
+	}
+	if m.match != nil {
+		// the match tree is traced even if the filters don't match
+		if matchErr := m.match.matches(payload, ghEvent, trace, "match"); err == nil {
+			err = matchErr
+		}
+	}
+	return err
+}
+
+// matchLookups the teams and the paths, they call the GitHub API
+func (m *SubscribeMatcher) matchLookups(payload map[string]interface{}, lookup EventLookup, trace *MatchTrace) error {
+	if len(m.teams) == 0 && !m.HasPaths() {
+		return nil
+	}
+	if lookup == nil {
+		err := fmt.Errorf("GitHub API lookups are not available")
+		where := "teams"
+		if len(m.teams) == 0 {
+			where = "paths"
+		}
+		trace.add(MatchStep{Where: where, Kind: TraceLookup, Error: err.Error()})
+		return &MatchError{Where: where, Err: err}
+	}
+	if err := m.matchTeams(payload, lookup, trace); err != nil {
+		return err
+	}
+	return m.matchPaths(lookup, trace)
+}
@@ -0,0 +1,430 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const benchSubscribe = `{"events": ["push"], "filters": {
+	"$.ref": {"positiveMatches": ["^refs/heads/(main|release/.+)$"]},
+	"$.commits[*].message": {"negativeMatches": ["\\[skip deploy\\]"]},
+	"$.repository": {"child": {"full_name": {"positiveMatches": ["^zhaojunlucky/"]}}}
+}, "match": {"any": [
+	{"filters": {"$.commits": {"expr": "len(filter(cur, {len(.modified) > 2})) > 10"}}},
+	{"filters": {"$.head_commit.message": {"positiveMatches": ["^Release"]}}}
+]}}`
+
+// largePush push payload with the commits
+func largePush(b testing.TB, commits int) map[string]interface{} {
+	payload := loadFixture(b, "push")
+	items := make([]interface{}, 0, commits)
+	for i := 0; i < commits; i++ {
+		items = append(items, map[string]interface{}{
+			"id":       fmt.Sprintf("%040d", i),
+			"message":  fmt.Sprintf("Change %d", i),
+			"added":    []interface{}{fmt.Sprintf("docs/%d.md", i)},
+			"modified": []interface{}{"go.mod", "go.sum", fmt.Sprintf("pkg/%d.go", i)},
+			"removed":  []interface{}{},
+		})
+	}
+	payload["commits"] = items
+	return payload
+}
+
+func loadSubscribe(tb testing.TB, subscribe string) *GHWebHookSubscribe {
+	var sub GHWebHookSubscribe
+	if err := json.Unmarshal([]byte(subscribe), &sub); err != nil {
+		tb.Fatal(err)
+	}
+	if err := sub.IsValid(); err != nil {
+		tb.Fatal(err)
+	}
+	return &sub
+}
+
+func TestCompileSubscribe(t *testing.T) {
+	sub := loadSubscribe(t, benchSubscribe)
+	sub.ID = 3
+	sub.UpdatedAt = time.Now()
+	matcher, err := CompileSubscribe(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matcher.ID != sub.ID || !matcher.Version.Equal(sub.UpdatedAt) {
+		t.Fatalf("unexpected matcher version %d %v", matcher.ID, matcher.Version)
+	}
+
+	// the matcher is shared by the deliveries
+	payload := largePush(t, 200)
+	ghEvent := GHWebhookEvent{Event: "push"}
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = matcher.Matches(payload, ghEvent, nil)
+		}(i)
+	}
+	wg.Wait()
+	for _, err = range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	payload["ref"] = "refs/heads/feature"
+	if err = matcher.Matches(payload, ghEvent, nil); err == nil {
+		t.Fatal("feature branch should not match")
+	}
+
+	sub.Filters["$.ref"] = GHWebhookField{PositiveMatches: []string{"(main"}}
+	if _, err = CompileSubscribe(sub); err == nil {
+		t.Fatal("invalid regex should fail to compile")
+	}
+}
+
+func benchmarkMatches(b *testing.B, commits int, match func(*GHWebHookSubscribe, map[string]interface{}) error) {
+	level := log.GetLevel()
+	log.SetLevel(log.WarnLevel)
+	defer log.SetLevel(level)
+
+	sub := loadSubscribe(b, benchSubscribe)
+	payload := largePush(b, commits)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := match(sub, payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSubscribeMatcher_Matches(b *testing.B) {
+	for _, commits := range []int{20, 200, 1000} {
+		b.Run(fmt.Sprintf("compiled/%d commits", commits), func(b *testing.B) {
+			var matcher *SubscribeMatcher
+			benchmarkMatches(b, commits, func(sub *GHWebHookSubscribe, payload map[string]interface{}) error {
+				if matcher == nil {
+					var err error
+					if matcher, err = CompileSubscribe(sub); err != nil {
+						return err
+					}
+				}
+				return matcher.Matches(payload, GHWebhookEvent{Event: "push"}, nil)
+			})
+		})
+		b.Run(fmt.Sprintf("uncompiled/%d commits", commits), func(b *testing.B) {
+			benchmarkMatches(b, commits, func(sub *GHWebHookSubscribe, payload map[string]interface{}) error {
+				return sub.Matches(payload, GHWebhookEvent{Event: "push"})
+			})
+		})
+	}
+}
+
+func TestSubscribeMatcher_Trace(t *testing.T) {
+	sub := loadSubscribe(t, `{"events": ["pull_request*"], "actions": ["opened"], "filters": {
+	"$.pull_request.base.ref": {"positiveMatches": ["^release/", "^main$"]},
+	"$.pull_request.draft": {"expr": "cur == true"}
+}, "match": {"not": {"filters": {"$.pull_request.labels[*].name": {"positiveMatches": ["^skip-ci$"]}}}}}`)
+	matcher, err := CompileSubscribe(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := loadFixture(t, "pull_request")
+	trace := matcher.Trace(payload, GHWebhookEvent{Event: "pull_request", Action: "opened"}, nil)
+	if trace.Matched || !strings.Contains(trace.Reason, "failed to match expr") {
+		t.Fatalf("draft expr should fail, got %+v", trace)
+	}
+
+	steps := map[string]MatchStep{}
+	for _, step := range trace.Steps {
+		steps[step.Where+" "+step.Kind+" "+step.Pattern] = step
+	}
+	expected := []struct {
+		step    string
+		matched bool
+	}{
+		{"events event pull_request*", true},
+		{"actions action opened", true},
+		{"filters[$.pull_request.base.ref] positive ^main$", true},
+		{"filters[$.pull_request.draft] expr cur == true", false},
+		{"match.not.filters[$.pull_request.labels[*].name] positive ^skip-ci$", false},
+		{"match.not not ", true},
+	}
+	for _, e := range expected {
+		step, ok := steps[e.step]
+		if !ok || step.Matched != e.matched {
+			t.Errorf("expected step %s matched %v, got %+v", e.step, e.matched, step)
+		}
+	}
+	if steps["filters[$.pull_request.base.ref] positive ^main$"].Value != "main" {
+		t.Errorf("the matched value should be traced")
+	}
+
+	trace = matcher.Trace(payload, GHWebhookEvent{Event: "issue_comment"}, nil)
+	if trace.Matched || len(trace.Steps) != 2 || trace.Steps[0].Matched {
+		t.Fatalf("event mismatch should stop the evaluation, got %+v", trace)
+	}
+}
+
+func TestFailedCondition(t *testing.T) {
+	tests := []struct {
+		subscribe string
+		where     string
+	}{
+		{`{"events": ["push"]}`, "events"},
+		{`{"events": ["pull_request"], "actions": ["closed"]}`, "actions"},
+		{`{"events": ["pull_request"], "org": "zhaojunlucky", "repo": "gh-*"}`, "scope"},
+		{`{"events": ["pull_request"], "filters": {"$.pull_request": {"child": {"draft": {"expr": "cur"}}}}}`,
+			"filters[$.pull_request].child[$.pull_request.draft]"},
+		{`{"events": ["pull_request"], "match": {"all": [{"any": [
+{"filters": {"$.action": {"positiveMatches": ["^closed$"]}}}]}]}}`, "match.all[0].any"},
+		{`{"events": ["pull_request"], "match": {"not": {"filters": {"$.action": {"positiveMatches": ["^opened$"]}}}}}`,
+			"match.not"},
+	}
+	payload := loadFixture(t, "pull_request")
+	for _, test := range tests {
+		matcher, err := CompileSubscribe(loadSubscribe(t, test.subscribe))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = matcher.Matches(payload, GHWebhookEvent{Event: "pull_request", Action: "opened"}, nil)
+		if err == nil || FailedCondition(err) != test.where {
+			t.Errorf("%s should fail at %s, got %s: %v", test.subscribe, test.where, FailedCondition(err), err)
+		}
+	}
+}
+
+// stubLookup the changed files and the members of the teams, org/team-slug
+type stubLookup struct {
+	files   []string
+	err     error
+	members map[string][]string
+}
+
+func (l *stubLookup) ChangedFiles() ([]string, error) {
+	return l.files, l.err
+}
+
+func (l *stubLookup) TeamMember(org string, team string, login string) (bool, error) {
+	if l.err != nil {
+		return false, l.err
+	}
+	return slices.Contains(l.members[org+"/"+team], login), nil
+}
+
+func TestSubscribeMatcher_Paths(t *testing.T) {
+	files := func(names ...string) EventLookup {
+		return &stubLookup{files: names}
+	}
+	tests := []struct {
+		subscribe string
+		files     EventLookup
+		matched   bool
+	}{
+		{`{"events": ["push"]}`, nil, true},
+		{`{"events": ["push"], "paths": ["services/api/**"]}`, files("services/api/main.go"), true},
+		{`{"events": ["push"], "paths": ["services/api/**"]}`, files("services/web/main.go"), false},
+		{`{"events": ["push"], "paths": ["services/*/main.go"]}`, files("services/api/v1/main.go"), false},
+		{`{"events": ["push"], "paths": ["services/**"], "pathsIgnore": ["**/*.md"]}`,
+			files("services/README.md", "services/api/README.md"), false},
+		{`{"events": ["push"], "paths": ["services/**"], "pathsIgnore": ["**/*.md"]}`,
+			files("services/README.md", "services/api/main.go"), true},
+		{`{"events": ["push"], "pathsIgnore": ["docs/**"]}`, files("docs/index.md"), false},
+		{`{"events": ["push"], "pathsIgnore": ["docs/**"]}`, files(), true},
+		{`{"events": ["push"], "paths": ["services/**"]}`, files(), false},
+		{`{"events": ["push"], "paths": ["services/**"]}`, nil, false},
+		{`{"events": ["push"], "paths": ["services/**"]}`,
+			&stubLookup{err: fmt.Errorf("rate limited")}, false},
+	}
+	for _, test := range tests {
+		matcher, err := CompileSubscribe(loadSubscribe(t, test.subscribe))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = matcher.Matches(map[string]interface{}{}, GHWebhookEvent{Event: "push"}, test.files)
+		if (err == nil) != test.matched {
+			t.Errorf("%s should match %v, got %v", test.subscribe, test.matched, err)
+		}
+		if err != nil && FailedCondition(err) != "paths" {
+			t.Errorf("%s should fail at paths, got %s", test.subscribe, FailedCondition(err))
+		}
+	}
+
+	if err := loadSubscribe(t, `{"events": ["push"]}`).IsValid(); err != nil {
+		t.Fatal(err)
+	}
+	invalid := GHWebHookSubscribe{Events: []string{"push"}, PathsIgnore: []string{"docs/[a"}}
+	if err := invalid.IsValid(); err == nil {
+		t.Fatal("invalid path glob should fail")
+	}
+}
+
+func TestSubscribeMatcher_Author(t *testing.T) {
+	lookup := &stubLookup{members: map[string][]string{"octo/maintainers": {"octocat"}}}
+	tests := []struct {
+		subscribe string
+		where     string // empty if it matches
+	}{
+		{`{"events": ["*"], "labels": {"include": ["BUG", "docs"]}}`, ""},
+		{`{"events": ["*"], "labels": {"include": ["bug", "docs"], "all": true}}`, "labels"},
+		{`{"events": ["*"], "labels": {"include": ["bug"], "exclude": ["area/webhook"]}}`, "labels"},
+		{`{"events": ["*"], "senders": {"allow": ["OctoCat"]}}`, ""},
+		{`{"events": ["*"], "senders": {"deny": ["octocat"]}}`, "senders"},
+		{`{"events": ["*"], "authors": {"allow": ["hubot"]}}`, "authors"},
+		{`{"events": ["*"], "authorAssociations": ["member", "owner"]}`, "authorAssociations"},
+		{`{"events": ["*"], "authorAssociations": ["CONTRIBUTOR"]}`, ""},
+		{`{"events": ["*"], "teams": ["octo/admins", "octo/maintainers"]}`, ""},
+		{`{"events": ["*"], "teams": ["octo/admins"]}`, "teams"},
+		{`{"events": ["*"], "authors": {"deny": ["octocat"]}, "teams": ["octo/maintainers"]}`, "authors"},
+	}
+	payload := loadFixture(t, "pull_request")
+	for _, test := range tests {
+		matcher, err := CompileSubscribe(loadSubscribe(t, test.subscribe))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = matcher.Matches(payload, GHWebhookEvent{Event: "pull_request", Action: "opened"}, lookup)
+		if FailedCondition(err) != test.where || (err == nil) != (len(test.where) == 0) {
+			t.Errorf("%s should fail at %q, got %s: %v", test.subscribe, test.where, FailedCondition(err), err)
+		}
+	}
+
+	// the commenter is the author of an issue_comment
+	matcher, err := CompileSubscribe(loadSubscribe(t, `{"events": ["issue_comment"], "authors": {"deny": ["octocat"]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	trace := matcher.Trace(loadFixture(t, "issue_comment"), GHWebhookEvent{Event: "issue_comment"}, nil)
+	if !trace.Matched || trace.Steps[1].Kind != TraceLogin {
+		t.Fatalf("the commenter isn't denied, got %+v", trace)
+	}
+
+	for _, invalid := range []string{`{"events": ["*"], "teams": ["maintainers"]}`,
+		`{"events": ["*"], "authorAssociations": ["ADMIN"]}`, `{"events": ["*"], "senders": {"deny": [" "]}}`} {
+		var sub GHWebHookSubscribe
+		if err = json.Unmarshal([]byte(invalid), &sub); err != nil {
+			t.Fatal(err)
+		}
+		if err = sub.IsValid(); err == nil {
+			t.Errorf("%s should be invalid", invalid)
+		}
+	}
+}
+
+func TestSubscribeMatcher_Ref(t *testing.T) {
+	push := func(ref string) (GHWebhookEvent, map[string]interface{}) {
+		return GHWebhookEvent{Event: "push"}, map[string]interface{}{"ref": ref}
+	}
+	pr := func(base string) (GHWebhookEvent, map[string]interface{}) {
+		return GHWebhookEvent{Event: "pull_request", Action: "opened"},
+			map[string]interface{}{"pull_request": map[string]interface{}{"base": map[string]interface{}{"ref": base}}}
+	}
+	create := func(refType string, ref string) (GHWebhookEvent, map[string]interface{}) {
+		return GHWebhookEvent{Event: "create"}, map[string]interface{}{"ref": ref, "ref_type": refType}
+	}
+	release := func(tag string) (GHWebhookEvent, map[string]interface{}) {
+		return GHWebhookEvent{Event: "release", Action: "published"},
+			map[string]interface{}{"release": map[string]interface{}{"tag_name": tag}}
+	}
+	tests := []struct {
+		subscribe string
+		event     func() (GHWebhookEvent, map[string]interface{})
+		where     string // empty if it matches
+	}{
+		{`{"events": ["*"], "branches": ["main"]}`, func() (GHWebhookEvent, map[string]interface{}) {
+			return push("refs/heads/main")
+		}, ""},
+		{`{"events": ["*"], "branches": ["refs/heads/release/*"]}`, func() (GHWebhookEvent, map[string]interface{}) {
+			return push("refs/heads/release/v1")
+		}, ""},
+		{`{"events": ["*"], "branches": ["release/*"]}`, func() (GHWebhookEvent, map[string]interface{}) {
+			return push("refs/heads/release/v1/hotfix")
+		}, "branches"},
+		{`{"events": ["*"], "branches": ["release/**", "!release/legacy"]}`,
+			func() (GHWebhookEvent, map[string]interface{}) { return pr("release/legacy") }, "branches"},
+		{`{"events": ["*"], "branches": ["release/**", "!release/legacy"]}`,
+			func() (GHWebhookEvent, map[string]interface{}) { return pr("release/v2") }, ""},
+		{`{"events": ["*"], "branchesIgnore": ["dependabot/**"]}`,
+			func() (GHWebhookEvent, map[string]interface{}) { return pr("dependabot/npm/x") }, "branchesIgnore"},
+		{`{"events": ["*"], "branches": ["main"]}`, func() (GHWebhookEvent, map[string]interface{}) {
+			return push("refs/tags/v1.0.0")
+		}, "branches"},
+		{`{"events": ["*"], "branches": ["main"], "tags": ["v*"]}`, func() (GHWebhookEvent, map[string]interface{}) {
+			return push("refs/tags/v1.0.0")
+		}, ""},
+		{`{"events": ["*"], "tags": ["v*"], "tagsIgnore": ["*-rc*"]}`,
+			func() (GHWebhookEvent, map[string]interface{}) { return release("v2.0.0-rc1") }, "tagsIgnore"},
+		{`{"events": ["*"], "tags": ["refs/tags/v*"]}`,
+			func() (GHWebhookEvent, map[string]interface{}) { return create("tag", "v2.0.0") }, ""},
+		{`{"events": ["*"], "tags": ["v*"]}`,
+			func() (GHWebhookEvent, map[string]interface{}) { return create("branch", "v2") }, "tags"},
+		{`{"events": ["*"], "branches": ["main"]}`, func() (GHWebhookEvent, map[string]interface{}) {
+			return GHWebhookEvent{Event: "issues"}, map[string]interface{}{}
+		}, "branches"},
+	}
+	for _, test := range tests {
+		matcher, err := CompileSubscribe(loadSubscribe(t, test.subscribe))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ghEvent, payload := test.event()
+		err = matcher.Matches(payload, ghEvent, nil)
+		if FailedCondition(err) != test.where || (err == nil) != (len(test.where) == 0) {
+			t.Errorf("%s %v should fail at %q, got %s: %v", test.subscribe, payload, test.where,
+				FailedCondition(err), err)
+		}
+	}
+
+	for _, invalid := range []string{`{"events": ["*"], "branches": ["refs/tags/v1"]}`,
+		`{"events": ["*"], "tags": ["!"]}`, `{"events": ["*"], "tagsIgnore": ["v[1"]}`} {
+		var sub GHWebHookSubscribe
+		if err := json.Unmarshal([]byte(invalid), &sub); err != nil {
+			t.Fatal(err)
+		}
+		if err := sub.IsValid(); err == nil {
+			t.Errorf("%s should be invalid", invalid)
+		}
+	}
+}
+
+func Test_GetSubscribeMatcher(t *testing.T) {
+	sub := GHWebHookSubscribe{
+		Model:   gorm.Model{ID: 2, UpdatedAt: time.Now()},
+		Events:  []string{"push"},
+		Filters: map[string]GHWebhookField{"$.ref": {PositiveMatches: []string{"^refs/heads/main$"}}},
+	}
+	matcher, err := GetSubscribeMatcher(&sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached, _ := GetSubscribeMatcher(&sub); cached != matcher {
+		t.Fatal("the matcher of the same version should be cached")
+	}
+
+	sub.UpdatedAt = sub.UpdatedAt.Add(time.Second)
+	sub.Filters["$.ref"] = GHWebhookField{PositiveMatches: []string{"^refs/tags/"}}
+	updated, err := GetSubscribeMatcher(&sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated == matcher {
+		t.Fatal("the updated subscribe should be compiled again")
+	}
+	payload := map[string]interface{}{"ref": "refs/tags/v1.0.0"}
+	if err = updated.Matches(payload, GHWebhookEvent{Event: "push"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	EvictSubscribeMatcher(sub.ID)
+	if evicted, _ := GetSubscribeMatcher(&sub); evicted == updated {
+		t.Fatal("the evicted subscribe should be compiled again")
+	}
+}
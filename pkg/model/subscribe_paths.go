@@ -0,0 +1,52 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+func compileGlobs(patterns []string) ([]*regexp.Regexp, error) {
+	var globs []*regexp.Regexp
+	for _, pattern := range patterns {
+		glob, err := compileGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		globs = append(globs, glob)
+	}
+	return globs, nil
+}
+
+// matchPaths one of the changed files must match the paths if any and must not match the ignored paths, an event
+// without changed files only matches the ignored paths
+func (m *SubscribeMatcher) matchPaths(lookup EventLookup, trace *MatchTrace) error {
+	if !m.HasPaths() {
+		return nil
+	}
+	changed, err := lookup.ChangedFiles()
+	if err != nil {
+		err = fmt.Errorf("failed to get changed files: %v", err)
+		trace.add(MatchStep{Where: "paths", Kind: TracePaths, Error: err.Error()})
+		return &MatchError{Where: "paths", Err: err}
+	}
+	if len(changed) == 0 && len(m.paths) == 0 {
+		trace.add(MatchStep{Where: "paths", Kind: TracePaths, Matched: true})
+		return nil
+	}
+
+	for _, file := range changed {
+		if len(m.paths) > 0 && !slices.ContainsFunc(m.paths, func(glob *regexp.Regexp) bool {
+			return glob.MatchString(file)
+		}) {
+			continue
+		}
+		if slices.ContainsFunc(m.ignored, func(glob *regexp.Regexp) bool { return glob.MatchString(file) }) {
+			continue
+		}
+		trace.add(MatchStep{Where: "paths", Kind: TracePaths, Value: file, Matched: true})
+		return nil
+	}
+	trace.add(MatchStep{Where: "paths", Kind: TracePaths, Value: len(changed)})
+	return &MatchError{Where: "paths", Err: fmt.Errorf("none of %d changed files matches the paths", len(changed))}
+}
@@ -0,0 +1,146 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	RefBranch = "branch"
+	RefTag    = "tag"
+
+	branchPrefix = "refs/heads/"
+	tagPrefix    = "refs/tags/"
+)
+
+// refPattern a branch or tag glob, a pattern starting with ! excludes the refs matched by the patterns before it
+type refPattern struct {
+	pattern  string
+	glob     *regexp.Regexp
+	negative bool
+}
+
+// compileRefPatterns compile the globs of the branches or tags, the prefix like refs/heads/ is optional
+func compileRefPatterns(patterns []string, prefix string) ([]refPattern, error) {
+	var refs []refPattern
+	for _, pattern := range patterns {
+		ref := refPattern{pattern: pattern}
+		glob := pattern
+		if strings.HasPrefix(glob, "!") {
+			ref.negative = true
+			glob = glob[1:]
+		}
+		glob = strings.TrimPrefix(glob, prefix)
+		if strings.HasPrefix(glob, "refs/") {
+			return nil, fmt.Errorf("invalid ref %s, only %s is supported", pattern, prefix)
+		}
+		var err error
+		if ref.glob, err = compileGlob(glob); err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// refMatches the last pattern matching the name decides, like the branches of GitHub Actions
+func refMatches(patterns []refPattern, name string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		if pattern.glob.MatchString(name) {
+			matched = !pattern.negative
+		}
+	}
+	return matched
+}
+
+func refPatternsString(patterns []refPattern) string {
+	var strs []string
+	for _, pattern := range patterns {
+		strs = append(strs, pattern.pattern)
+	}
+	return strings.Join(strs, ",")
+}
+
+// eventRef the kind and the short name of the ref of the event, empty if the event has no branch or tag:
+//   - push and the events of the schedules: ref, e.g. refs/heads/main or refs/tags/v1.0.0
+//   - pull_request*: the base branch of the pull request
+//   - create and delete: ref with ref_type
+//   - release: the tag of the release
+func eventRef(ghEvent GHWebhookEvent, payload map[string]interface{}) (string, string) {
+	switch {
+	case ghEvent.Event == "push" || ghEvent.ScheduleId > 0:
+		ref, _ := payload["ref"].(string)
+		if name, found := strings.CutPrefix(ref, branchPrefix); found {
+			return RefBranch, name
+		} else if name, found = strings.CutPrefix(ref, tagPrefix); found {
+			return RefTag, name
+		}
+	case strings.HasPrefix(ghEvent.Event, "pull_request"):
+		pr, _ := payload["pull_request"].(map[string]interface{})
+		base, _ := pr["base"].(map[string]interface{})
+		if ref, ok := base["ref"].(string); ok {
+			return RefBranch, strings.TrimPrefix(ref, branchPrefix)
+		}
+	case ghEvent.Event == "create" || ghEvent.Event == "delete":
+		ref, _ := payload["ref"].(string)
+		refType, _ := payload["ref_type"].(string)
+		switch refType {
+		case RefBranch:
+			return RefBranch, strings.TrimPrefix(ref, branchPrefix)
+		case RefTag:
+			return RefTag, strings.TrimPrefix(ref, tagPrefix)
+		}
+	case ghEvent.Event == "release":
+		release, _ := payload["release"].(map[string]interface{})
+		if tag, ok := release["tag_name"].(string); ok {
+			return RefTag, strings.TrimPrefix(tag, tagPrefix)
+		}
+	}
+	return "", ""
+}
+
+// matchRef the branch must match the branches and not the ignored ones, so must the tag, an event without a ref
+// doesn't match
+func (m *SubscribeMatcher) matchRef(payload map[string]interface{}, ghEvent GHWebhookEvent, trace *MatchTrace) error {
+	hasBranches := len(m.branches) > 0 || len(m.branchesIgnore) > 0
+	hasTags := len(m.tags) > 0 || len(m.tagsIgnore) > 0
+	if !hasBranches && !hasTags {
+		return nil
+	}
+	kind, name := eventRef(ghEvent, payload)
+	fail := func(where string, patterns []refPattern, err error) error {
+		trace.add(MatchStep{Where: where, Kind: TraceRef, Pattern: refPatternsString(patterns), Value: name,
+			Error: errorString(err)})
+		return &MatchError{Where: where, Err: err}
+	}
+	switch {
+	case kind == RefBranch && hasBranches:
+		if len(m.branches) > 0 && !refMatches(m.branches, name) {
+			return fail("branches", m.branches, fmt.Errorf("branch %s doesn't match the branches", name))
+		}
+		if refMatches(m.branchesIgnore, name) {
+			return fail("branchesIgnore", m.branchesIgnore, fmt.Errorf("branch %s is ignored", name))
+		}
+	case kind == RefTag && hasTags:
+		if len(m.tags) > 0 && !refMatches(m.tags, name) {
+			return fail("tags", m.tags, fmt.Errorf("tag %s doesn't match the tags", name))
+		}
+		if refMatches(m.tagsIgnore, name) {
+			return fail("tagsIgnore", m.tagsIgnore, fmt.Errorf("tag %s is ignored", name))
+		}
+	case kind == RefBranch:
+		return fail("tags", m.tags, fmt.Errorf("branch %s doesn't match, only tags are filtered", name))
+	case kind == RefTag:
+		return fail("branches", m.branches, fmt.Errorf("tag %s doesn't match, only branches are filtered", name))
+	default:
+		where := "branches"
+		if !hasBranches {
+			where = "tags"
+		}
+		return fail(where, nil, fmt.Errorf("event[%d] %s has no branch or tag", ghEvent.ID, ghEvent.Event))
+	}
+	trace.add(MatchStep{Where: "ref", Kind: TraceRef, Value: kind + " " + name, Matched: true})
+	return nil
+}
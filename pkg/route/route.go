@@ -9,10 +9,15 @@ import (
 var routers = []core.RouterRegister{
 	&webhook.GHWebhookHandler{},
 	&webhook.GHWebhookDeliverHandler{},
+	&webhook.GHWebhookScheduler{},
 	&api.GHWebhookEventAPIHandler{},
+	&api.GHWebhookEventDeliverAPIHandler{},
+	&api.GHWebhookEventReceiverDeliverAPIHandler{},
 	&api.GHWebhookReceiverAPIHandler{},
 	&api.GHWebhookSubscribeAPIHandler{},
+	&api.GHWebhookSubscribeMatchAPIHandler{},
 	&api.GitHubAPIHandler{},
+	&api.GHWebhookScheduleAPIHandler{},
 }
 
 func Init(ctx *core.GHPRContext) error {
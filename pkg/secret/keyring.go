@@ -0,0 +1,181 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"gh-webhook/pkg/config"
+	"io"
+	"strings"
+	"sync"
+)
+
+// encryptedPrefix marks a value encrypted by the keyring
+// format: enc:v1:<key id>:<base64 wrapped data key>:<base64 ciphertext>
+const encryptedPrefix = "enc:v1:"
+
+// Keyring envelope encryption, every value is encrypted by a random data key and the data key is wrapped
+// by the primary key. Old keys are kept to decrypt values until they are re-encrypted.
+type Keyring struct {
+	primary string
+	keys    map[string][]byte
+}
+
+func NewKeyring(primary string, keys map[string][]byte) (*Keyring, error) {
+	if strings.Contains(primary, ":") {
+		return nil, fmt.Errorf("invalid key id %s", primary)
+	}
+	if _, ok := keys[primary]; !ok {
+		return nil, fmt.Errorf("primary key %s not found", primary)
+	}
+	for id, key := range keys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %s must be 32 bytes", id)
+		}
+	}
+	return &Keyring{primary: primary, keys: keys}, nil
+}
+
+// NewKeyringFromConfig create keyring from config, nil is returned if no key is configured
+func NewKeyringFromConfig(cfg *config.SecretConfig) (*Keyring, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, nil
+	}
+	keys := make(map[string][]byte, len(cfg.Keys))
+	for id, key := range cfg.Keys {
+		data, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key %s: %v", id, err)
+		}
+		keys[id] = data
+	}
+	return NewKeyring(cfg.Primary, keys)
+}
+
+func (k *Keyring) Primary() string {
+	return k.primary
+}
+
+// Encrypt encrypt the value with a new data key wrapped by the primary key
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+
+	wrappedKey, err := seal(k.keys[k.primary], dataKey, []byte(k.primary))
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataKey, []byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%s:%s:%s", encryptedPrefix, k.primary, base64.StdEncoding.EncodeToString(wrappedKey),
+		base64.StdEncoding.EncodeToString(ciphertext)), nil
+}
+
+// Decrypt decrypt the value, plain values are returned as is
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(value, encryptedPrefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("malformed encrypted value")
+	}
+	key, ok := k.keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("key %s not found", parts[0])
+	}
+	wrappedKey, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+
+	dataKey, err := open(key, wrappedKey, []byte(parts[0]))
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key: %v", err)
+	}
+	plaintext, err := open(dataKey, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value: %v", err)
+	}
+	return string(plaintext), nil
+}
+
+// NeedsReEncrypt plain values and values not encrypted by the primary key need to be re-encrypted
+func (k *Keyring) NeedsReEncrypt(value string) bool {
+	if len(value) == 0 {
+		return false
+	}
+	keyId, ok := KeyID(value)
+	return !ok || keyId != k.primary
+}
+
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// KeyID id of the key which encrypted the value
+func KeyID(value string) (string, bool) {
+	if !IsEncrypted(value) {
+		return "", false
+	}
+	keyId, _, found := strings.Cut(strings.TrimPrefix(value, encryptedPrefix), ":")
+	return keyId, found
+}
+
+func seal(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key []byte, data []byte, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+var keyring *Keyring
+var keyringMutex sync.RWMutex
+
+// SetKeyring set the keyring used to encrypt the secrets stored in db
+func SetKeyring(k *Keyring) {
+	keyringMutex.Lock()
+	defer keyringMutex.Unlock()
+	keyring = k
+}
+
+func GetKeyring() *Keyring {
+	keyringMutex.RLock()
+	defer keyringMutex.RUnlock()
+	return keyring
+}
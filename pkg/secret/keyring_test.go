@@ -0,0 +1,107 @@
+package secret
+
+import (
+	"bytes"
+	"encoding/base64"
+	"gh-webhook/pkg/config"
+	"strings"
+	"testing"
+)
+
+func newTestKeyring(t *testing.T, primary string) *Keyring {
+	k, err := NewKeyring(primary, map[string][]byte{
+		"k1": bytes.Repeat([]byte{1}, 32),
+		"k2": bytes.Repeat([]byte{2}, 32),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestKeyring_EncryptDecrypt(t *testing.T) {
+	k := newTestKeyring(t, "k1")
+
+	value, err := k.Encrypt("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(value) || strings.Contains(value, "password") {
+		t.Fatalf("value should be encrypted: %s", value)
+	}
+	if keyId, _ := KeyID(value); keyId != "k1" {
+		t.Fatalf("key id should be k1, got %s", keyId)
+	}
+
+	plaintext, err := k.Decrypt(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext != "password" {
+		t.Fatal("plaintext should be password")
+	}
+
+	plaintext, err = k.Decrypt("plain")
+	if err != nil || plaintext != "plain" {
+		t.Fatal("plain value should be returned as is")
+	}
+}
+
+func TestKeyring_Rotate(t *testing.T) {
+	value, err := newTestKeyring(t, "k1").Encrypt("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := newTestKeyring(t, "k2")
+	if !rotated.NeedsReEncrypt(value) || !rotated.NeedsReEncrypt("plain") || rotated.NeedsReEncrypt("") {
+		t.Fatal("value encrypted by old key should be re-encrypted")
+	}
+	plaintext, err := rotated.Decrypt(value)
+	if err != nil || plaintext != "password" {
+		t.Fatal("old key should still decrypt")
+	}
+
+	removed, err := NewKeyring("k2", map[string][]byte{"k2": bytes.Repeat([]byte{2}, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = removed.Decrypt(value); err == nil || !strings.Contains(err.Error(), "key k1 not found") {
+		t.Fatal("removed key should fail to decrypt")
+	}
+}
+
+func TestKeyring_Tampered(t *testing.T) {
+	k := newTestKeyring(t, "k1")
+	value, err := k.Encrypt("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// wrapped data key is bound to its key id
+	if _, err = k.Decrypt(strings.Replace(value, ":k1:", ":k2:", 1)); err == nil {
+		t.Fatal("tampered value should fail to decrypt")
+	}
+}
+
+func TestNewKeyringFromConfig(t *testing.T) {
+	k, err := NewKeyringFromConfig(&config.SecretConfig{})
+	if err != nil || k != nil {
+		t.Fatal("keyring should be nil without keys")
+	}
+
+	_, err = NewKeyringFromConfig(&config.SecretConfig{
+		Primary: "k1",
+		Keys:    map[string]string{"k1": base64.StdEncoding.EncodeToString([]byte("short"))},
+	})
+	if err == nil {
+		t.Fatal("short key should be rejected")
+	}
+
+	k, err = NewKeyringFromConfig(&config.SecretConfig{
+		Primary: "k1",
+		Keys:    map[string]string{"k1": base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))},
+	})
+	if err != nil || k.Primary() != "k1" {
+		t.Fatal("keyring should be created")
+	}
+}
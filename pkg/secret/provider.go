@@ -0,0 +1,214 @@
+package secret
+
+import (
+	"fmt"
+	"gh-webhook/pkg/config"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	EnvScheme   = "env"   // env:JENKINS_TOKEN
+	FileScheme  = "file"  // file:/run/secrets/jenkins
+	VaultScheme = "vault" // vault:kv/ci#token
+)
+
+var referenceSchemes = []string{EnvScheme, FileScheme, VaultScheme}
+
+// PlainPrefix escape a plain secret which looks like a reference, e.g. plain:env:literal is the secret env:literal
+const PlainPrefix = "plain:"
+
+// Unescape the plain secret without PlainPrefix
+func Unescape(value string) string {
+	return strings.TrimPrefix(value, PlainPrefix)
+}
+
+// SecretProvider resolve the secret of a reference
+type SecretProvider interface {
+	// Resolve the secret by the reference path, e.g. JENKINS_TOKEN of env:JENKINS_TOKEN
+	Resolve(path string) (string, error)
+}
+
+// ParseReference split the secret reference into scheme and path, plain secrets are not references
+func ParseReference(value string) (scheme string, refPath string, ok bool) {
+	scheme, refPath, found := strings.Cut(value, ":")
+	if !found || !slices.Contains(referenceSchemes, scheme) {
+		return "", "", false
+	}
+	return scheme, refPath, true
+}
+
+func IsReference(value string) bool {
+	_, _, ok := ParseReference(value)
+	return ok
+}
+
+// ValidateReference check the syntax of the secret reference
+func ValidateReference(value string) error {
+	scheme, refPath, ok := ParseReference(value)
+	if !ok {
+		return fmt.Errorf("invalid secret reference %s", value)
+	}
+	switch scheme {
+	case EnvScheme:
+		if len(refPath) == 0 {
+			return fmt.Errorf("env name is required in secret reference %s", value)
+		}
+	case FileScheme:
+		if !filepath.IsAbs(refPath) {
+			return fmt.Errorf("absolute file path is required in secret reference %s", value)
+		}
+	case VaultScheme:
+		if _, _, _, err := parseVaultPath(refPath); err != nil {
+			return fmt.Errorf("invalid secret reference %s: %v", value, err)
+		}
+	}
+	return nil
+}
+
+// EnvProvider resolve secrets from env vars matching the allowed patterns
+type EnvProvider struct {
+	Allow []string
+}
+
+func (p *EnvProvider) Resolve(name string) (string, error) {
+	allowed := false
+	for _, pattern := range p.Allow {
+		if matched, _ := path.Match(pattern, name); matched {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return "", fmt.Errorf("env %s is not allowed", name)
+	}
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("env %s not found", name)
+	}
+	return value, nil
+}
+
+// FileProvider resolve secrets from files in the allowed dirs, the trailing newline is trimmed
+type FileProvider struct {
+	Dirs []string
+}
+
+func (p *FileProvider) Resolve(file string) (string, error) {
+	file = filepath.Clean(file)
+	allowed := false
+	for _, dir := range p.Dirs {
+		if rel, err := filepath.Rel(filepath.Clean(dir), file); err == nil && rel != ".." &&
+			!strings.HasPrefix(rel, "../") {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return "", fmt.Errorf("file %s is not allowed", file)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+type cachedSecret struct {
+	value     string
+	expiresAt time.Time
+}
+
+// Resolver resolve secret references by the providers of their schemes and cache the secrets
+type Resolver struct {
+	providers map[string]SecretProvider
+	ttl       time.Duration
+	cache     map[string]cachedSecret
+	mutex     sync.Mutex
+	now       func() time.Time
+}
+
+func NewResolver(ttl time.Duration) *Resolver {
+	return &Resolver{
+		providers: map[string]SecretProvider{},
+		ttl:       ttl,
+		cache:     map[string]cachedSecret{},
+		now:       time.Now,
+	}
+}
+
+// NewResolverFromConfig create resolver with the providers enabled in config
+func NewResolverFromConfig(cfg *config.SecretConfig) *Resolver {
+	ttl := cfg.CacheTTL
+	if ttl == 0 {
+		ttl = 5 * time.Minute
+	}
+	r := NewResolver(ttl)
+	if len(cfg.EnvAllow) > 0 {
+		r.Register(EnvScheme, &EnvProvider{Allow: cfg.EnvAllow})
+	}
+	if len(cfg.FileDirs) > 0 {
+		r.Register(FileScheme, &FileProvider{Dirs: cfg.FileDirs})
+	}
+	if len(cfg.Vault.Addr) > 0 {
+		r.Register(VaultScheme, NewVaultProvider(&cfg.Vault))
+	}
+	return r
+}
+
+func (r *Resolver) Register(scheme string, provider SecretProvider) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.providers[scheme] = provider
+}
+
+// Resolve the secret of the reference, plain secrets are returned as is without PlainPrefix, a nil resolver fails
+// the references
+func (r *Resolver) Resolve(value string) (string, error) {
+	scheme, refPath, ok := ParseReference(value)
+	if !ok {
+		return Unescape(value), nil
+	}
+	if r == nil {
+		return "", fmt.Errorf("secret provider %s is not configured", scheme)
+	}
+
+	r.mutex.Lock()
+	provider, found := r.providers[scheme]
+	cached, hit := r.cache[value]
+	r.mutex.Unlock()
+
+	if !found {
+		return "", fmt.Errorf("secret provider %s is not configured", scheme)
+	}
+	if hit && r.now().Before(cached.expiresAt) {
+		return cached.value, nil
+	}
+
+	resolved, err := provider.Resolve(refPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve secret %s: %v", value, err)
+	}
+
+	r.mutex.Lock()
+	r.cache[value] = cachedSecret{value: resolved, expiresAt: r.now().Add(r.ttl)}
+	r.mutex.Unlock()
+	return resolved, nil
+}
+
+// ResolveAll replace the secret references with their secrets
+func (r *Resolver) ResolveAll(secrets map[string]*string) error {
+	for name, value := range secrets {
+		resolved, err := r.Resolve(*value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		*value = resolved
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package secret
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type countingProvider struct {
+	count int
+}
+
+func (p *countingProvider) Resolve(path string) (string, error) {
+	p.count++
+	return path + "-secret", nil
+}
+
+func TestParseReference(t *testing.T) {
+	scheme, refPath, ok := ParseReference("vault:kv/ci#token")
+	if !ok || scheme != VaultScheme || refPath != "kv/ci#token" {
+		t.Fatal("should be vault reference")
+	}
+	if IsReference("password") || IsReference("http://localhost") {
+		t.Fatal("plain secret should not be reference")
+	}
+
+	if err := ValidateReference("vault:kv/ci"); err == nil {
+		t.Fatal("vault reference without key should be invalid")
+	}
+	if err := ValidateReference("file:run/secrets/jenkins"); err == nil {
+		t.Fatal("relative file reference should be invalid")
+	}
+	if err := ValidateReference("env:JENKINS_TOKEN"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolver_Cache(t *testing.T) {
+	now := time.Now()
+	provider := &countingProvider{}
+	r := NewResolver(time.Minute)
+	r.now = func() time.Time { return now }
+	r.Register(EnvScheme, provider)
+
+	for i := 0; i < 2; i++ {
+		value, err := r.Resolve("env:TOKEN")
+		if err != nil || value != "TOKEN-secret" {
+			t.Fatal("should resolve env reference")
+		}
+	}
+	if provider.count != 1 {
+		t.Fatal("secret should be cached")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := r.Resolve("env:TOKEN"); err != nil {
+		t.Fatal(err)
+	}
+	if provider.count != 2 {
+		t.Fatal("expired secret should be resolved again")
+	}
+
+	value, err := r.Resolve("plain")
+	if err != nil || value != "plain" {
+		t.Fatal("plain secret should be returned as is")
+	}
+
+	if _, err = r.Resolve("vault:kv/ci#token"); err == nil || !strings.Contains(err.Error(), "not configured") {
+		t.Fatal("unregistered provider should fail")
+	}
+}
+
+func TestResolver_ResolveAll(t *testing.T) {
+	r := NewResolver(time.Minute)
+	r.Register(EnvScheme, &countingProvider{})
+	password := "env:PASSWORD"
+	token := "token"
+	err := r.ResolveAll(map[string]*string{"password": &password, "token": &token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password != "PASSWORD-secret" || token != "token" {
+		t.Fatal("references should be resolved")
+	}
+}
+
+func TestResolver_Plain(t *testing.T) {
+	r := NewResolver(time.Minute)
+	r.Register(EnvScheme, &countingProvider{})
+	// a literal secret which looks like a reference is escaped by the plain prefix
+	for value, expected := range map[string]string{
+		"plain:env:PASSWORD": "env:PASSWORD",
+		"plain:plain:x":      "plain:x",
+		"plain:":             "",
+		"env:PASSWORD":       "PASSWORD-secret",
+	} {
+		if IsReference(value) != strings.HasPrefix(value, "env:") {
+			t.Fatalf("%s should only be a reference without the plain prefix", value)
+		}
+		if resolved, err := r.Resolve(value); err != nil || resolved != expected {
+			t.Fatalf("%s should be resolved to %s, got %s %v", value, expected, resolved, err)
+		}
+	}
+	var nilResolver *Resolver
+	if value, err := nilResolver.Resolve("plain:vault:kv/ci#token"); err != nil || value != "vault:kv/ci#token" {
+		t.Fatalf("the escaped secret should not need a resolver, got %s %v", value, err)
+	}
+}
+
+func TestResolver_Nil(t *testing.T) {
+	var r *Resolver
+	if value, err := r.Resolve("plain"); err != nil || value != "plain" {
+		t.Fatalf("plain secret should be returned as is, got %s %v", value, err)
+	}
+	token := "env:TOKEN"
+	if err := r.ResolveAll(map[string]*string{"token": &token}); err == nil || token != "env:TOKEN" {
+		t.Fatal("the reference should fail without resolver")
+	}
+}
+
+func TestEnvProvider(t *testing.T) {
+	t.Setenv("JENKINS_TOKEN", "jenkins")
+	t.Setenv("DB_PASSWORD", "db")
+	p := &EnvProvider{Allow: []string{"JENKINS_*"}}
+
+	value, err := p.Resolve("JENKINS_TOKEN")
+	if err != nil || value != "jenkins" {
+		t.Fatal("should resolve allowed env")
+	}
+	if _, err = p.Resolve("DB_PASSWORD"); err == nil {
+		t.Fatal("env not allowed should fail")
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "jenkins")
+	if err := os.WriteFile(file, []byte("jenkins\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p := &FileProvider{Dirs: []string{dir}}
+
+	value, err := p.Resolve(file)
+	if err != nil || value != "jenkins" {
+		t.Fatal("should resolve file in allowed dir")
+	}
+	if _, err = p.Resolve(filepath.Join(dir, "..", "other")); err == nil {
+		t.Fatal("file outside allowed dir should fail")
+	}
+}
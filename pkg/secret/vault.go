@@ -0,0 +1,102 @@
+package secret
+
+import (
+	"encoding/json"
+	"fmt"
+	"gh-webhook/pkg/config"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// VaultProvider resolve secrets from HashiCorp Vault KV v2 engines, vault:<mount>/<path>#<key>
+type VaultProvider struct {
+	config *config.VaultConfig
+	client *http.Client
+}
+
+func NewVaultProvider(cfg *config.VaultConfig) *VaultProvider {
+	return &VaultProvider{
+		config: cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func parseVaultPath(refPath string) (mount string, secretPath string, key string, err error) {
+	location, key, found := strings.Cut(refPath, "#")
+	if !found || len(key) == 0 {
+		return "", "", "", fmt.Errorf("secret key is required, e.g. kv/ci#token")
+	}
+	mount, secretPath, found = strings.Cut(strings.Trim(location, "/"), "/")
+	if !found || len(mount) == 0 || len(secretPath) == 0 {
+		return "", "", "", fmt.Errorf("mount and path are required, e.g. kv/ci#token")
+	}
+	return mount, secretPath, key, nil
+}
+
+func (p *VaultProvider) token() (string, error) {
+	if len(p.config.TokenFile) > 0 {
+		data, err := os.ReadFile(p.config.TokenFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	if len(p.config.Token) > 0 {
+		return p.config.Token, nil
+	}
+	return "", fmt.Errorf("vault token is not configured")
+}
+
+func (p *VaultProvider) Resolve(refPath string) (string, error) {
+	mount, secretPath, key, err := parseVaultPath(refPath)
+	if err != nil {
+		return "", err
+	}
+	token, err := p.token()
+	if err != nil {
+		return "", err
+	}
+
+	vaultUrl, err := url.JoinPath(p.config.Addr, "v1", mount, "data", secretPath)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodGet, vaultUrl, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if len(p.config.Namespace) > 0 {
+		req.Header.Set("X-Vault-Namespace", p.config.Namespace)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to read vault secret %s/%s: %s", mount, secretPath, resp.Status)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	value, ok := body.Data.Data[key]
+	if !ok {
+		return "", fmt.Errorf("key %s not found in vault secret %s/%s", key, mount, secretPath)
+	}
+	str, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("key %s of vault secret %s/%s is not a string", key, mount, secretPath)
+	}
+	return str, nil
+}
@@ -0,0 +1,50 @@
+package secret
+
+import (
+	"fmt"
+	"gh-webhook/pkg/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func vaultHandler(writer http.ResponseWriter, request *http.Request) {
+	if request.Header.Get("X-Vault-Token") != "root" {
+		writer.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if request.URL.Path != "/v1/kv/data/ci" {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	fmt.Fprint(writer, `{"data":{"data":{"token":"jenkins","port":8080},"metadata":{"version":1}}}`)
+}
+
+func TestVaultProvider_Resolve(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(vaultHandler))
+	defer ts.Close()
+
+	p := NewVaultProvider(&config.VaultConfig{Addr: ts.URL, Token: "root"})
+	value, err := p.Resolve("kv/ci#token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "jenkins" {
+		t.Fatal("token should be jenkins")
+	}
+
+	if _, err = p.Resolve("kv/ci#missing"); err == nil {
+		t.Fatal("missing key should fail")
+	}
+	if _, err = p.Resolve("kv/ci#port"); err == nil {
+		t.Fatal("non string value should fail")
+	}
+	if _, err = p.Resolve("kv/other#token"); err == nil {
+		t.Fatal("missing secret should fail")
+	}
+
+	p = NewVaultProvider(&config.VaultConfig{Addr: ts.URL, Token: "other"})
+	if _, err = p.Resolve("kv/ci#token"); err == nil {
+		t.Fatal("invalid token should fail")
+	}
+}
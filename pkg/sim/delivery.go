@@ -0,0 +1,175 @@
+package sim
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"gh-webhook/pkg/fixture"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// Delivery a webhook request to send, the headers of HookMeta like X-GitHub-Hook-ID are kept, except the delivery
+// id and the signatures which are regenerated on every send
+type Delivery struct {
+	Event    string
+	Payload  []byte
+	HookMeta map[string]string
+	At       time.Time // when GitHub sent it, zero for the fixtures
+}
+
+// exportedEvent the event of GET gh-webhook-event
+type exportedEvent struct {
+	HookMeta  map[string]string `json:"hookMeta"`
+	Payload   string            `json:"payload"`
+	Event     string            `json:"event"`
+	Synthetic bool              `json:"synthetic"` // created by gh-webhook, e.g. by a schedule, it's not replayed
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+// LoadFixtures the deliveries of the bundled payloads of the events
+func LoadFixtures(events []string) ([]Delivery, error) {
+	var deliveries []Delivery
+	for _, event := range events {
+		payload, err := fixture.Load(event)
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, Delivery{Event: event, Payload: data})
+	}
+	return deliveries, nil
+}
+
+// LoadExport the deliveries of the events exported by GET gh-webhook-event, either the list response or the array
+// of its entries, in the order they were received, the synthetic events aren't sent by GitHub so they're skipped
+func LoadExport(file string) ([]Delivery, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var events []exportedEvent
+	if err = json.Unmarshal(data, &events); err != nil {
+		var list struct {
+			Entries []exportedEvent `json:"entries"`
+		}
+		if listErr := json.Unmarshal(data, &list); listErr != nil {
+			return nil, fmt.Errorf("invalid export %s: %v", file, err)
+		}
+		events = list.Entries
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].CreatedAt.Before(events[j].CreatedAt) })
+
+	var deliveries []Delivery
+	for i, event := range events {
+		if len(event.Event) == 0 || !json.Valid([]byte(event.Payload)) {
+			return nil, fmt.Errorf("invalid event %d of export %s", i, file)
+		}
+		if event.Synthetic {
+			continue
+		}
+		deliveries = append(deliveries, Delivery{
+			Event:    event.Event,
+			Payload:  []byte(event.Payload),
+			HookMeta: event.HookMeta,
+			At:       event.CreatedAt,
+		})
+	}
+	return deliveries, nil
+}
+
+// regeneratedHeaders the headers of HookMeta which are stale on replay, they're regenerated by Send
+var regeneratedHeaders = map[string]bool{
+	"X-Github-Delivery":   true,
+	"X-Hub-Signature":     true,
+	"X-Hub-Signature-256": true,
+}
+
+// Sender send the deliveries to gh-webhook like GitHub
+type Sender struct {
+	URL    string // e.g. http://localhost:8080/api/v1/gh-webhook
+	Host   string // the Host header, gh-webhook finds the GitHub server by it, e.g. api.github.com
+	Secret string // the webhook secret of X-Hub-Signature-256, not signed if empty
+	HookId string // X-GitHub-Hook-ID if the delivery doesn't have one
+	Client *http.Client
+}
+
+// Sign the X-Hub-Signature-256 of the body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send the delivery with a new X-GitHub-Delivery, the status code is returned
+func (s *Sender) Send(delivery Delivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	if len(s.Host) > 0 {
+		req.Host = s.Host
+	}
+	for key, value := range delivery.HookMeta {
+		if regeneratedHeaders[http.CanonicalHeaderKey(key)] {
+			continue
+		}
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "GitHub-Hookshot/gh-webhook-sim")
+	req.Header.Set("X-GitHub-Event", delivery.Event)
+	req.Header.Set("X-GitHub-Delivery", newGUID())
+	if len(req.Header.Get("X-GitHub-Hook-ID")) == 0 {
+		req.Header.Set("X-GitHub-Hook-ID", s.HookId)
+	}
+	if len(req.Header.Get("X-GitHub-Hook-Installation-Target-Type")) == 0 {
+		req.Header.Set("X-GitHub-Hook-Installation-Target-Type", "repository")
+	}
+	if len(req.Header.Get("X-GitHub-Hook-Installation-Target-ID")) == 0 {
+		req.Header.Set("X-GitHub-Hook-Installation-Target-ID", repositoryId(delivery.Payload))
+	}
+	if len(s.Secret) > 0 {
+		req.Header.Set("X-Hub-Signature-256", Sign(s.Secret, delivery.Payload))
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+// repositoryId the id of the repository of the payload, empty if it has no repository
+func repositoryId(payload []byte) string {
+	var obj struct {
+		Repository struct {
+			Id json.Number `json:"id"`
+		} `json:"repository"`
+	}
+	_ = json.Unmarshal(payload, &obj)
+	return obj.Repository.Id.String()
+}
+
+// newGUID a random GUID like the X-GitHub-Delivery of GitHub
+func newGUID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,96 @@
+package sim
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stats the results of the sent deliveries
+type Stats struct {
+	mutex      sync.Mutex
+	Sent       int
+	Failed     int         // errors and non 2xx responses
+	Statuses   map[int]int // status code to count, 0 for the errors
+	Latency    time.Duration
+	MaxLatency time.Duration
+	Elapsed    time.Duration
+}
+
+func (s *Stats) record(status int, err error, latency time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Sent++
+	if err != nil || status < 200 || status >= 300 {
+		s.Failed++
+	}
+	s.Statuses[status]++
+	s.Latency += latency
+	s.MaxLatency = max(s.MaxLatency, latency)
+}
+
+func (s *Stats) String() string {
+	if s.Sent == 0 {
+		return "sent 0 events"
+	}
+	var statuses []string
+	for status, count := range s.Statuses {
+		statuses = append(statuses, fmt.Sprintf("%d: %d", status, count))
+	}
+	sort.Strings(statuses)
+	return fmt.Sprintf("sent %d events in %s (%.2f/s), failed %d, statuses {%s}, latency avg %s max %s", s.Sent,
+		s.Elapsed.Round(time.Millisecond), float64(s.Sent)/s.Elapsed.Seconds(), s.Failed,
+		strings.Join(statuses, ", "), (s.Latency / time.Duration(s.Sent)).Round(time.Microsecond),
+		s.MaxLatency.Round(time.Microsecond))
+}
+
+// Replay send the deliveries once at their original timing accelerated by speed, e.g. 60 replays an hour in a
+// minute, as fast as possible if speed is 0. One worker keeps the order of the deliveries.
+func Replay(sender *Sender, deliveries []Delivery, speed float64, workers int) *Stats {
+	return run(sender, workers, len(deliveries), func(i int) (Delivery, time.Duration) {
+		if speed <= 0 || deliveries[i].At.IsZero() || deliveries[0].At.IsZero() {
+			return deliveries[i], 0
+		}
+		return deliveries[i], time.Duration(float64(deliveries[i].At.Sub(deliveries[0].At)) / speed)
+	})
+}
+
+// Load send count deliveries at rate per second, the deliveries are sent in turn
+func Load(sender *Sender, deliveries []Delivery, rate float64, count int, workers int) *Stats {
+	return run(sender, workers, count, func(i int) (Delivery, time.Duration) {
+		return deliveries[i%len(deliveries)], time.Duration(float64(i) / rate * float64(time.Second))
+	})
+}
+
+// run send n deliveries, each at its offset from the start, by at most workers concurrent requests, a delivery is
+// late if all workers are busy
+func run(sender *Sender, workers int, n int, next func(i int) (Delivery, time.Duration)) *Stats {
+	stats := &Stats{Statuses: map[int]int{}}
+	slots := make(chan struct{}, max(workers, 1))
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		delivery, offset := next(i)
+		if wait := time.Until(start.Add(offset)); wait > 0 {
+			time.Sleep(wait)
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			sendStart := time.Now()
+			status, err := sender.Send(delivery)
+			if err != nil {
+				log.Warningf("failed to send %s event: %v", delivery.Event, err)
+			}
+			stats.record(status, err, time.Since(sendStart))
+		}()
+	}
+	wg.Wait()
+	stats.Elapsed = time.Since(start)
+	return stats
+}
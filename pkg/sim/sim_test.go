@@ -0,0 +1,118 @@
+package sim
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testServer struct {
+	mutex    sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newTestServer(t *testing.T) (*testServer, *httptest.Server) {
+	ts := &testServer{}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+		ts.mutex.Lock()
+		ts.requests = append(ts.requests, request)
+		ts.bodies = append(ts.bodies, body)
+		ts.mutex.Unlock()
+		writer.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return ts, server
+}
+
+func TestReplay_Fixtures(t *testing.T) {
+	ts, server := newTestServer(t)
+	deliveries, err := LoadFixtures([]string{"push", "pull_request"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := &Sender{URL: server.URL, Host: "api.github.com", Secret: "It's a Secret to Everybody", HookId: "7"}
+	stats := Replay(sender, deliveries, 1, 1)
+	if stats.Sent != 2 || stats.Failed != 0 || stats.Statuses[http.StatusOK] != 2 {
+		t.Fatalf("unexpected stats %s", stats)
+	}
+
+	for i, event := range []string{"push", "pull_request"} {
+		req := ts.requests[i]
+		if req.Header.Get("X-GitHub-Event") != event || req.Host != "api.github.com" ||
+			req.Header.Get("X-GitHub-Hook-ID") != "7" || len(req.Header.Get("X-GitHub-Delivery")) != 36 ||
+			req.Header.Get("X-GitHub-Hook-Installation-Target-ID") != "123456789" {
+			t.Fatalf("unexpected headers of %s: %v", event, req.Header)
+		}
+		if req.Header.Get("X-Hub-Signature-256") != Sign(sender.Secret, ts.bodies[i]) {
+			t.Fatalf("invalid signature of %s", event)
+		}
+	}
+
+	// the example of the GitHub docs
+	want := "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+	if got := Sign("It's a Secret to Everybody", []byte("Hello, World!")); got != want {
+		t.Fatalf("Sign() = %s, want %s", got, want)
+	}
+}
+
+func TestReplay_Export(t *testing.T) {
+	ts, server := newTestServer(t)
+	export := filepath.Join(t.TempDir(), "events.json")
+	err := os.WriteFile(export, []byte(`{"entryCount": 3, "entries": [
+{"event": "issues", "payload": "{\"action\": \"closed\"}", "hookMeta": {"X-GitHub-Hook-ID": "3",
+ "X-GitHub-Delivery": "72d3162e-cc78-11e3-81ab-4c9367dc0958", "X-Hub-Signature-256": "sha256=stale"},
+ "createdAt": "2024-05-12T10:00:02Z"},
+{"event": "schedule", "payload": "{\"action\": \"nightly\"}", "synthetic": true,
+ "createdAt": "2024-05-12T10:00:01Z"},
+{"event": "push", "payload": "{\"ref\": \"refs/heads/main\"}", "createdAt": "2024-05-12T10:00:00Z"}
+]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliveries, err := LoadExport(export)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2s apart at speed 20
+	stats := Replay(&Sender{URL: server.URL, HookId: "1"}, deliveries, 20, 1)
+	if stats.Sent != 2 || stats.Elapsed < 100*time.Millisecond {
+		t.Fatalf("should replay at the accelerated timing, got %s", stats)
+	}
+	if ts.requests[0].Header.Get("X-GitHub-Event") != "push" || ts.requests[1].Header.Get("X-GitHub-Hook-ID") != "3" {
+		t.Fatal("should replay in the received order with the recorded headers")
+	}
+	if header := ts.requests[1].Header; header.Get("X-GitHub-Delivery") == "72d3162e-cc78-11e3-81ab-4c9367dc0958" ||
+		len(header.Get("X-Hub-Signature-256")) > 0 {
+		t.Fatalf("the delivery id and the signature should be regenerated, got %v", header)
+	}
+
+	if err = os.WriteFile(export, []byte(`[{"event": "push", "payload": "{"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadExport(export); err == nil {
+		t.Fatal("invalid payload should fail")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	ts, server := newTestServer(t)
+	deliveries, err := LoadFixtures([]string{"push", "issues"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := Load(&Sender{URL: server.URL}, deliveries, 100, 10, 4)
+	if stats.Sent != 10 || stats.Failed != 0 || stats.Elapsed < 90*time.Millisecond {
+		t.Fatalf("should send 10 events at 100/s, got %s", stats)
+	}
+	if len(ts.requests) != 10 {
+		t.Fatalf("should receive 10 requests, got %d", len(ts.requests))
+	}
+}